@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Settings returns the runtime settings.
+func (c *Client) Settings(ctx context.Context) (Settings, error) {
+	var settings Settings
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/config"}, &settings)
+	return settings, err
+}
+
+// UpdateSettings changes the runtime settings set in update and returns them
+// all as they are now.
+func (c *Client) UpdateSettings(ctx context.Context, update SettingsUpdate) (Settings, error) {
+	var settings Settings
+	err := c.call(ctx, request{method: http.MethodPatch, path: "/admin/config", body: update}, &settings)
+	return settings, err
+}
+
+// AdminOrders lists every stored order. A positive staleAfter overrides how
+// long a courier may be silent before being flagged.
+func (c *Client) AdminOrders(ctx context.Context, staleAfter time.Duration) ([]OrderSummary, error) {
+	query := url.Values{}
+	if staleAfter > 0 {
+		query.Set("stale_after", staleAfter.String())
+	}
+	var orders []OrderSummary
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/orders", query: query}, &orders)
+	return orders, err
+}
+
+// DeleteOrder deletes an order and its history.
+func (c *Client) DeleteOrder(ctx context.Context, orderID string) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: "/admin/orders/" + url.PathEscape(orderID)}, nil)
+}
+
+// History returns how an order got to its current state.
+func (c *Client) History(ctx context.Context, orderID string) ([]Entry, error) {
+	var history []Entry
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/orders/" + url.PathEscape(orderID) + "/history"}, &history)
+	return history, err
+}
+
+// Timeline tells what happened to an order, oldest first.
+func (c *Client) Timeline(ctx context.Context, orderID string) ([]TimelineEvent, error) {
+	var timeline []TimelineEvent
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID, "/timeline")}, &timeline)
+	return timeline, err
+}
+
+// Providers reports today's route provider calls of the instance that
+// answered, against the quota.
+func (c *Client) Providers(ctx context.Context) ([]ProviderUsage, error) {
+	var providers []ProviderUsage
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/providers"}, &providers)
+	return providers, err
+}
+
+// Rejections counts the courier locations the instance that answered
+// rejected as outliers.
+func (c *Client) Rejections(ctx context.Context) (Rejections, error) {
+	var rejections Rejections
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/rejections"}, &rejections)
+	return rejections, err
+}
+
+// Workers reports the travel time queue of the instance that answered. It
+// fails with a 404 if workers are not enabled.
+func (c *Client) Workers(ctx context.Context) (PoolStats, error) {
+	var stats PoolStats
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/workers"}, &stats)
+	return stats, err
+}
+
+// Billing exports the distance travelled per order on a UTC day, given as
+// YYYY-MM-DD or empty for today, optionally for one driver only.
+func (c *Client) Billing(ctx context.Context, day, driverID string) ([]BillingLine, error) {
+	query := url.Values{"format": {"json"}}
+	if day != "" {
+		query.Set("day", day)
+	}
+	if driverID != "" {
+		query.Set("driver", driverID)
+	}
+	var lines []BillingLine
+	err := c.call(ctx, request{method: http.MethodGet, path: "/admin/billing", query: query}, &lines)
+	return lines, err
+}
+
+// Period is a range of UTC days for the analytics, from and to included.
+// Zero times leave the server's default of the last seven days.
+type Period struct {
+	From, To time.Time
+}
+
+func (p Period) query() url.Values {
+	query := url.Values{}
+	if !p.From.IsZero() {
+		query.Set("from", p.From.UTC().Format(time.DateOnly))
+	}
+	if !p.To.IsZero() {
+		query.Set("to", p.To.UTC().Format(time.DateOnly))
+	}
+	return query
+}
+
+// AnalyticsDeliveries counts the orders delivered per day of the period.
+func (c *Client) AnalyticsDeliveries(ctx context.Context, p Period) ([]DayStats, error) {
+	var days []DayStats
+	err := c.call(ctx, request{method: http.MethodGet, path: "/analytics/deliveries", query: p.query()}, &days)
+	return days, err
+}
+
+// AnalyticsETAError describes how far predicted arrivals were from actual
+// ones over the period.
+func (c *Client) AnalyticsETAError(ctx context.Context, p Period) (ErrorStats, error) {
+	var stats ErrorStats
+	err := c.call(ctx, request{method: http.MethodGet, path: "/analytics/eta-error", query: p.query()}, &stats)
+	return stats, err
+}
+
+// AnalyticsTrips averages the trips of the period per travel mode, or per
+// configured zone if byZone is set.
+func (c *Client) AnalyticsTrips(ctx context.Context, p Period, byZone bool) ([]TripStats, error) {
+	query := p.query()
+	if byZone {
+		query.Set("by", "zone")
+	}
+	var trips []TripStats
+	err := c.call(ctx, request{method: http.MethodGet, path: "/analytics/trips", query: query}, &trips)
+	return trips, err
+}
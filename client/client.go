@@ -0,0 +1,245 @@
+// Package client is the Go client of the location API. It gives services
+// typed methods for every endpoint instead of hand-rolled HTTP calls, retries
+// requests that failed on the way, and follows the live event streams.
+//
+// The payload types are aliases of the ones the server encodes, so the two
+// cannot drift apart.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls one location API base URL. Its fields may be changed until
+// it is first used.
+type Client struct {
+	// BaseURL is where the API is served, such as "http://location:8080".
+	BaseURL string
+	// Token is sent as a bearer credential: the admin token or a JWT.
+	Token string
+	// HTTPClient sends the requests. It should not set a Timeout, which
+	// would cut event streams short; calls are bounded by their context.
+	HTTPClient *http.Client
+	// Retries is how many times a failed request is sent again, and Backoff
+	// the wait before the first retry, doubled for every one after it.
+	Retries int
+	Backoff time.Duration
+}
+
+// Default retry policy of New.
+const (
+	DefaultRetries = 3
+	DefaultBackoff = 200 * time.Millisecond
+)
+
+// maxBackoff caps the wait between attempts.
+const maxBackoff = 30 * time.Second
+
+// New returns a client of the API at baseURL that authenticates with token,
+// which may be empty for public endpoints.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{},
+		Retries:    DefaultRetries,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// Error is a response with an error status. Message is the body the server
+// sent along, usually a short explanation.
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// StatusCode returns the HTTP status of an *Error anywhere in err's chain,
+// or 0 if there is none.
+func StatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is a 404, such as for an unknown order.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// request is one API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// body is encoded as JSON, unless it is a string sent as contentType.
+	body        interface{}
+	contentType string
+	// once marks calls that must not be repeated after they may have
+	// reached the server, such as confirming a delivery.
+	once bool
+}
+
+// do sends a request, retrying it as the policy allows, and returns the body
+// of the successful response.
+func (c *Client) do(ctx context.Context, req request) ([]byte, error) {
+	var payload []byte
+	contentType := req.contentType
+	switch body := req.body.(type) {
+	case nil:
+	case string:
+		payload = []byte(body)
+	default:
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("encoding %s %s: %v", req.method, req.path, err)
+		}
+		contentType = "application/json"
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, payload, contentType)
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode >= 300 {
+				err = &Error{Method: req.method, Path: req.path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+			}
+		}
+		if err == nil {
+			return data, nil
+		}
+		if attempt >= c.Retries || !retryable(req, resp, err) || ctx.Err() != nil {
+			return nil, err
+		}
+		if err := sleep(ctx, c.wait(attempt, resp)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// send makes one attempt at a request.
+func (c *Client) send(ctx context.Context, req request, payload []byte, contentType string) (*http.Response, error) {
+	u := c.BaseURL + req.path
+	if len(req.query) > 0 {
+		u += "?" + req.query.Encode()
+	}
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if req.method == http.MethodGet {
+		r.Header.Set("Accept", "application/json")
+	}
+	return c.httpClient().Do(r)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// retryable reports whether a failed attempt is worth repeating: the server
+// was unreachable, overloaded or timed out. Calls marked once are only
+// repeated when the server turned them away without acting on them.
+func retryable(req request, resp *http.Response, err error) bool {
+	if resp == nil {
+		return !req.once
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return !req.once
+	}
+	return false
+}
+
+// wait is how long to back off before the retry after attempt, honoring a
+// Retry-After in seconds from the server. The exponential wait is jittered
+// so that clients failing together do not retry together.
+func (c *Client) wait(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			return min(time.Duration(s)*time.Second, maxBackoff)
+		}
+	}
+	d := c.Backoff << attempt
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// call sends a request and decodes the JSON response into out, if given.
+func (c *Client) call(ctx context.Context, req request, out interface{}) error {
+	data, err := c.do(ctx, req)
+	if err != nil || out == nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decoding %s %s: %v", req.method, req.path, err)
+	}
+	return nil
+}
+
+// duration sends a request answered with a travel time in plain text.
+func (c *Client) duration(ctx context.Context, req request) (time.Duration, error) {
+	data, err := c.do(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("decoding %s %s: %v", req.method, req.path, err)
+	}
+	return d, nil
+}
+
+func orderPath(orderID string, rest ...string) string {
+	return "/order/" + url.PathEscape(orderID) + strings.Join(rest, "")
+}
@@ -0,0 +1,155 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"location/client"
+)
+
+func newClient(t *testing.T, handler http.HandlerFunc) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, "secret")
+	c.Backoff = time.Millisecond
+	return c
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if calls.Add(1) < 3 {
+			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			return
+		}
+		json.NewEncoder(w).Encode(client.ETA{OrderID: "o1", ETA: 5 * time.Minute})
+	})
+
+	eta, err := c.ETA(context.Background(), "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eta.ETA != 5*time.Minute || calls.Load() != 3 {
+		t.Errorf("got %v after %d calls, want 5m after 3", eta.ETA, calls.Load())
+	}
+
+	calls.Store(-10)
+	c.Retries = 2
+	_, err = c.ETA(context.Background(), "o1")
+	if client.StatusCode(err) != http.StatusGatewayTimeout || calls.Load() != -7 {
+		t.Errorf("got %v after %d calls, want 504 after 3", err, calls.Load()+10)
+	}
+}
+
+func TestDeliveredNotRepeated(t *testing.T) {
+	var calls atomic.Int32
+	c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	})
+
+	_, err := c.Delivered(context.Background(), "o1", client.DeliveryConfirmation{Note: "porch"})
+	if client.StatusCode(err) != http.StatusBadGateway || calls.Load() != 1 {
+		t.Errorf("got %v after %d calls, want 502 after 1", err, calls.Load())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+	})
+
+	_, err := c.Order(context.Background(), "missing")
+	var e *client.Error
+	if !errors.As(err, &e) || !client.IsNotFound(err) || e.Message != "Order not found" || e.Path != "/order/missing" {
+		t.Errorf("got %#v", err)
+	}
+}
+
+func TestRequests(t *testing.T) {
+	c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /location/current":
+			var loc client.Location
+			json.NewDecoder(r.Body).Decode(&loc)
+			if loc.OrderID != "o1" || loc.Lat != 52.5 || loc.Speed == nil {
+				t.Errorf("location = %+v", loc)
+			}
+			fmt.Fprint(w, 7*time.Minute)
+		case "GET /orders/search":
+			if got := r.URL.RawQuery; got != "near=52.500000%2C13.400000&radius=500&status=en_route" {
+				t.Errorf("query = %s", got)
+			}
+			json.NewEncoder(w).Encode([]client.OrderSummary{{Order: client.Order{ID: "o1"}, Status: client.StatusEnRoute}})
+		case "POST /drivers/d1/nmea":
+			if r.Header.Get("Content-Type") != "text/plain" {
+				t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+			}
+			json.NewEncoder(w).Encode(client.DriverUpdate{DriverID: "d1"})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	ctx := context.Background()
+
+	speed := 8.0
+	eta, err := c.UpdateCurrent(ctx, client.Location{OrderID: "o1", Lat: 52.5, Lng: 13.4, Telemetry: client.Telemetry{Speed: &speed}})
+	if err != nil || eta != 7*time.Minute {
+		t.Errorf("UpdateCurrent = %v, %v", eta, err)
+	}
+	orders, err := c.SearchOrders(ctx, client.OrderQuery{Near: &client.Point{Lat: 52.5, Lng: 13.4}, Radius: 500, Status: client.StatusEnRoute})
+	if err != nil || len(orders) != 1 || orders[0].ID != "o1" {
+		t.Errorf("SearchOrders = %+v, %v", orders, err)
+	}
+	update, err := c.UpdateDriverNMEA(ctx, "d1", "$GPGGA,...")
+	if err != nil || update.DriverID != "d1" {
+		t.Errorf("UpdateDriverNMEA = %+v, %v", update, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var streams atomic.Int32
+	c := newClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/order/o1/events" {
+			http.NotFound(w, r)
+			return
+		}
+		// Every stream sends one update and ends, as on a server restart
+		n := streams.Add(1)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprintf(w, "data: {\"order_id\":\"o1\",\"eta\":%d}\n\n", time.Duration(n)*time.Minute)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []time.Duration
+	err := c.Subscribe(ctx, "o1", func(p client.Position) {
+		got = append(got, p.ETA)
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Subscribe = %v", err)
+	}
+	if len(got) != 3 || got[2] != 3*time.Minute {
+		t.Errorf("got %v, want one update per stream", got)
+	}
+
+	err = c.Subscribe(context.Background(), "missing", func(client.Position) {})
+	if !client.IsNotFound(err) {
+		t.Errorf("Subscribe to an unknown order = %v", err)
+	}
+}
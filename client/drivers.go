@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RegisterDriver creates a driver or replaces its status and orders, and
+// returns it as stored.
+func (c *Client) RegisterDriver(ctx context.Context, driver Driver) (Driver, error) {
+	var stored Driver
+	err := c.call(ctx, request{method: http.MethodPost, path: "/drivers", body: driver}, &stored)
+	return stored, err
+}
+
+// Driver returns a driver's status, position and orders.
+func (c *Client) Driver(ctx context.Context, driverID string) (Driver, error) {
+	var driver Driver
+	err := c.call(ctx, request{method: http.MethodGet, path: "/drivers/" + url.PathEscape(driverID)}, &driver)
+	return driver, err
+}
+
+// UpdateDriverLocation reports a driver's position, moving every order it
+// carries along with it.
+func (c *Client) UpdateDriverLocation(ctx context.Context, driverID string, p Point) (DriverUpdate, error) {
+	var update DriverUpdate
+	err := c.call(ctx, request{method: http.MethodPost, path: "/drivers/" + url.PathEscape(driverID) + "/location", body: p}, &update)
+	return update, err
+}
+
+// UpdateDriverNMEA reports a driver's position as raw NMEA 0183 sentences;
+// the last fix among them wins.
+func (c *Client) UpdateDriverNMEA(ctx context.Context, driverID, sentences string) (DriverUpdate, error) {
+	var update DriverUpdate
+	req := request{method: http.MethodPost, path: "/drivers/" + url.PathEscape(driverID) + "/nmea", body: sentences, contentType: "text/plain"}
+	err := c.call(ctx, req, &update)
+	return update, err
+}
+
+// Fleet returns the drivers and undelivered orders last seen inside box.
+func (c *Client) Fleet(ctx context.Context, box Box) (Fleet, error) {
+	var fleet Fleet
+	query := url.Values{"bbox": {bbox(box)}}
+	err := c.call(ctx, request{method: http.MethodGet, path: "/fleet", query: query}, &fleet)
+	return fleet, err
+}
+
+// Heatmap counts undelivered orders and drivers per geohash cell inside box,
+// or everywhere if box is nil. A precision of 0 uses the server's.
+func (c *Client) Heatmap(ctx context.Context, box *Box, precision int) ([]Cell, error) {
+	query := url.Values{}
+	if box != nil {
+		query.Set("bbox", bbox(*box))
+	}
+	if precision > 0 {
+		query.Set("precision", strconv.Itoa(precision))
+	}
+	var cells []Cell
+	err := c.call(ctx, request{method: http.MethodGet, path: "/heatmap", query: query}, &cells)
+	return cells, err
+}
+
+// Isochrone returns the area reachable from center by mode within the given
+// time, rounded down to whole minutes. An empty mode uses the default.
+func (c *Client) Isochrone(ctx context.Context, center Point, mode string, within time.Duration) (Isochrone, error) {
+	query := url.Values{
+		"lat":     {strconv.FormatFloat(center.Lat, 'f', -1, 64)},
+		"lng":     {strconv.FormatFloat(center.Lng, 'f', -1, 64)},
+		"minutes": {strconv.Itoa(int(within / time.Minute))},
+	}
+	if mode != "" {
+		query.Set("mode", mode)
+	}
+	var iso Isochrone
+	err := c.call(ctx, request{method: http.MethodGet, path: "/isochrone", query: query}, &iso)
+	return iso, err
+}
+
+// bbox formats a box in the minLng,minLat,maxLng,maxLat order of the API.
+func bbox(b Box) string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.Min.Lng, b.Min.Lat, b.Max.Lng, b.Max.Lat)
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpdateCurrent reports the courier location of an order, with whatever
+// telemetry is set, and returns the recalculated travel time.
+func (c *Client) UpdateCurrent(ctx context.Context, location Location) (time.Duration, error) {
+	return c.duration(ctx, request{method: http.MethodPost, path: "/location/current", body: location})
+}
+
+// UpdateTarget sets where an order goes, by coordinates or by one of
+// Address, What3Words or PlusCode, and returns the travel time if the
+// courier is known yet.
+func (c *Client) UpdateTarget(ctx context.Context, location Location) (time.Duration, error) {
+	return c.duration(ctx, request{method: http.MethodPost, path: "/location/target", body: location})
+}
+
+// UpdatePickup sets where the courier collects an order, and returns the
+// travel time via the pickup if the courier is known yet.
+func (c *Client) UpdatePickup(ctx context.Context, location Location) (time.Duration, error) {
+	return c.duration(ctx, request{method: http.MethodPost, path: "/location/pickup", body: location})
+}
+
+// SetMode sets the travel mode of an order.
+func (c *Client) SetMode(ctx context.Context, orderID, mode string) error {
+	body := struct {
+		OrderID string `json:"order_id"`
+		Mode    string `json:"mode"`
+	}{orderID, mode}
+	return c.call(ctx, request{method: http.MethodPost, path: "/transport", body: body}, nil)
+}
+
+// CreateOrder creates an order and returns it. Creating an order that exists
+// fails with a 409 Error.
+func (c *Client) CreateOrder(ctx context.Context, order NewOrder) (OrderSummary, error) {
+	var created OrderSummary
+	err := c.call(ctx, request{method: http.MethodPost, path: "/orders", body: order}, &created)
+	return created, err
+}
+
+// BatchOrders registers many orders at once. Orders succeed or fail on their
+// own; the response lists each outcome.
+func (c *Client) BatchOrders(ctx context.Context, orders []BatchOrder) (BatchResponse, error) {
+	var resp BatchResponse
+	body := struct {
+		Orders []BatchOrder `json:"orders"`
+	}{orders}
+	err := c.call(ctx, request{method: http.MethodPost, path: "/orders/batch", body: body}, &resp)
+	return resp, err
+}
+
+// ReadOrders reads the state and ETA of many orders at once. Orders that do
+// not exist are listed as missing rather than failing the call.
+func (c *Client) ReadOrders(ctx context.Context, orderIDs ...string) (BulkReadResponse, error) {
+	var resp BulkReadResponse
+	err := c.call(ctx, request{method: http.MethodPost, path: "/orders/query", body: BulkRead{OrderIDs: orderIDs}}, &resp)
+	return resp, err
+}
+
+// OrderQuery filters SearchOrders. Every set field must match.
+type OrderQuery struct {
+	// Near and Radius, in meters, match orders whose target is that close.
+	Near   *Point
+	Radius float64
+	// Within matches orders whose courier is inside the polygon.
+	Within []Point
+	Mode   string
+	Status string
+}
+
+// SearchOrders lists the orders matching q.
+func (c *Client) SearchOrders(ctx context.Context, q OrderQuery) ([]OrderSummary, error) {
+	query := url.Values{}
+	if q.Near != nil {
+		query.Set("near", q.Near.String())
+		query.Set("radius", strconv.FormatFloat(q.Radius, 'f', -1, 64))
+	}
+	if len(q.Within) > 0 {
+		corners := make([]string, len(q.Within))
+		for i, p := range q.Within {
+			corners[i] = p.String()
+		}
+		query.Set("within", strings.Join(corners, "|"))
+	}
+	if q.Mode != "" {
+		query.Set("mode", q.Mode)
+	}
+	if q.Status != "" {
+		query.Set("status", q.Status)
+	}
+	var orders []OrderSummary
+	err := c.call(ctx, request{method: http.MethodGet, path: "/orders/search", query: query}, &orders)
+	return orders, err
+}
+
+// Order returns the full tracking state of an order.
+func (c *Client) Order(ctx context.Context, orderID string) (OrderSummary, error) {
+	var order OrderSummary
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID)}, &order)
+	return order, err
+}
+
+// ETA returns the latest travel time of an order.
+func (c *Client) ETA(ctx context.Context, orderID string) (ETA, error) {
+	var eta ETA
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID, "/eta")}, &eta)
+	return eta, err
+}
+
+// WaitETA waits up to 30s for an ETA of an order newer than the sequence
+// since, for callers that cannot hold an event stream open. It reports false
+// when none came in time; poll again with the same sequence.
+func (c *Client) WaitETA(ctx context.Context, orderID string, since int64) (ETA, bool, error) {
+	var eta ETA
+	req := request{method: http.MethodGet, path: "/eta/" + url.PathEscape(orderID) + "/wait", query: url.Values{"since": {strconv.FormatInt(since, 10)}}}
+	data, err := c.do(ctx, req)
+	if err != nil || len(data) == 0 {
+		return eta, false, err
+	}
+	if err := json.Unmarshal(data, &eta); err != nil {
+		return eta, false, fmt.Errorf("decoding %s %s: %v", req.method, req.path, err)
+	}
+	return eta, true, nil
+}
+
+// Position estimates where an order's courier is right now.
+func (c *Client) Position(ctx context.Context, orderID string) (LivePosition, error) {
+	var pos LivePosition
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID, "/position")}, &pos)
+	return pos, err
+}
+
+// Share mints a link to track an order. A positive ttl shortens its
+// lifetime below the server's maximum.
+func (c *Client) Share(ctx context.Context, orderID string, ttl time.Duration) (ShareLink, error) {
+	query := url.Values{}
+	if ttl > 0 {
+		query.Set("ttl", ttl.String())
+	}
+	var link ShareLink
+	err := c.call(ctx, request{method: http.MethodPost, path: orderPath(orderID, "/share"), query: query}, &link)
+	return link, err
+}
+
+// SetGeofences replaces an order's own geofences.
+func (c *Client) SetGeofences(ctx context.Context, orderID string, fences []Fence) error {
+	if fences == nil {
+		fences = []Fence{}
+	}
+	return c.call(ctx, request{method: http.MethodPut, path: orderPath(orderID, "/geofences"), body: fences}, nil)
+}
+
+// Geofences returns an order's own geofences.
+func (c *Client) Geofences(ctx context.Context, orderID string) ([]Fence, error) {
+	var fences []Fence
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID, "/geofences")}, &fences)
+	return fences, err
+}
+
+// SetAlerts replaces an order's proximity alerts.
+func (c *Client) SetAlerts(ctx context.Context, orderID string, alerts []Alert) error {
+	if alerts == nil {
+		alerts = []Alert{}
+	}
+	return c.call(ctx, request{method: http.MethodPut, path: orderPath(orderID, "/alerts"), body: alerts}, nil)
+}
+
+// Schedule plans when an order's courier leaves, and returns the ETA
+// predicted for that departure.
+func (c *Client) Schedule(ctx context.Context, orderID string, departAt time.Time) (ETA, error) {
+	var eta ETA
+	body := struct {
+		DepartAt time.Time `json:"depart_at"`
+	}{departAt}
+	err := c.call(ctx, request{method: http.MethodPut, path: orderPath(orderID, "/schedule"), body: body}, &eta)
+	return eta, err
+}
+
+// SetDeadline records when an order was promised by.
+func (c *Client) SetDeadline(ctx context.Context, orderID string, deadline time.Time) error {
+	body := struct {
+		Deadline time.Time `json:"deadline"`
+	}{deadline}
+	return c.call(ctx, request{method: http.MethodPut, path: orderPath(orderID, "/deadline"), body: body}, nil)
+}
+
+// Delivered finalizes an order as delivered and returns the stored proof.
+// It is not retried once it may have reached the server, since a second
+// delivery is a conflict.
+func (c *Client) Delivered(ctx context.Context, orderID string, confirmation DeliveryConfirmation) (Delivery, error) {
+	var delivery Delivery
+	err := c.call(ctx, request{method: http.MethodPost, path: orderPath(orderID, "/delivered"), body: confirmation, once: true}, &delivery)
+	return delivery, err
+}
+
+// Pause stops calculating ETAs and notifying about an order until Resume.
+// Courier locations sent meanwhile are stored, and answered with 0.
+func (c *Client) Pause(ctx context.Context, orderID string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: orderPath(orderID, "/pause")}, nil)
+}
+
+// Resume restarts tracking of a paused order.
+func (c *Client) Resume(ctx context.Context, orderID string) error {
+	return c.call(ctx, request{method: http.MethodPost, path: orderPath(orderID, "/resume")}, nil)
+}
+
+// SetPreferences replaces an order's notification preferences.
+func (c *Client) SetPreferences(ctx context.Context, orderID string, prefs Preferences) error {
+	return c.call(ctx, request{method: http.MethodPut, path: orderPath(orderID, "/preferences"), body: prefs}, nil)
+}
+
+// Preferences returns an order's notification preferences. It fails with a
+// 404 if none were set.
+func (c *Client) Preferences(ctx context.Context, orderID string) (Preferences, error) {
+	var prefs Preferences
+	err := c.call(ctx, request{method: http.MethodGet, path: orderPath(orderID, "/preferences")}, &prefs)
+	return prefs, err
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errStreamClosed is returned by follow when the server ends a stream, as it
+// does when shutting down.
+var errStreamClosed = errors.New("stream closed by server")
+
+// Subscribe follows the live position and ETA of an order, calling fn with
+// every update, starting with the current state. Streams the server ends or
+// that break are reopened with backoff, so fn may see the same state twice.
+// It returns when ctx is done, or with an *Error if the server refuses the
+// stream, such as for an unknown order.
+func (c *Client) Subscribe(ctx context.Context, orderID string, fn func(Position)) error {
+	failures := 0
+	for {
+		delivered, err := c.follow(ctx, orderID, fn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var e *Error
+		if errors.As(err, &e) && e.StatusCode != http.StatusTooManyRequests && e.StatusCode < 500 {
+			return err
+		}
+		if delivered {
+			failures = 0
+		}
+		if err := sleep(ctx, c.wait(min(failures, 16), nil)); err != nil {
+			return err
+		}
+		failures++
+	}
+}
+
+// follow reads one event stream until it ends, reporting whether any update
+// came through.
+func (c *Client) follow(ctx context.Context, orderID string, fn func(Position)) (bool, error) {
+	req := request{method: http.MethodGet, path: orderPath(orderID, "/events")}
+	resp, err := c.send(ctx, req, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, &Error{Method: req.method, Path: req.path, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	delivered := false
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			// Comments such as keep-alives and other fields are ignored
+			if v, ok := strings.CutPrefix(line, "data:"); ok {
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(strings.TrimPrefix(v, " "))
+			}
+			continue
+		}
+		if data.Len() == 0 {
+			continue
+		}
+		var p Position
+		if err := json.Unmarshal([]byte(data.String()), &p); err == nil {
+			fn(p)
+			delivered = true
+		}
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return delivered, err
+	}
+	return delivered, errStreamClosed
+}
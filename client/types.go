@@ -0,0 +1,89 @@
+package client
+
+import (
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/handlers"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// Coordinates and areas.
+type (
+	Point = geo.Point
+	Box   = geo.Box
+	Fence = geo.Fence
+)
+
+// Orders and what is attached to them.
+type (
+	Order                = store.Order
+	OrderSummary         = handlers.OrderSummary
+	Location             = handlers.Location
+	Telemetry            = store.Telemetry
+	ETA                  = handlers.ETA
+	LivePosition         = handlers.LivePosition
+	Position             = handlers.Position
+	ShareLink            = handlers.ShareLink
+	Alert                = store.Alert
+	Preferences          = store.Preferences
+	QuietHours           = store.QuietHours
+	DeliveryConfirmation = handlers.DeliveryConfirmation
+	Delivery             = store.Delivery
+	Entry                = store.Entry
+	TimelineEvent        = tracking.TimelineEvent
+	NewOrder             = handlers.NewOrder
+	BatchOrder           = handlers.BatchOrder
+	BatchResponse        = handlers.BatchResponse
+	BatchResult          = handlers.BatchResult
+	BulkRead             = handlers.BulkRead
+	OrderState           = handlers.OrderState
+	BulkReadResponse     = handlers.BulkReadResponse
+)
+
+// Drivers.
+type (
+	Driver       = store.Driver
+	DriverUpdate = tracking.DriverUpdate
+)
+
+// Operations views.
+type (
+	Fleet       = handlers.Fleet
+	FleetOrder  = handlers.FleetOrder
+	FleetDriver = handlers.FleetDriver
+	Cell        = tracking.Cell
+	Isochrone   = handlers.Isochrone
+	DayStats    = tracking.DayStats
+	ErrorStats  = tracking.ErrorStats
+	TripStats   = tracking.TripStats
+)
+
+// Administration.
+type (
+	Settings       = config.Settings
+	SettingsUpdate = config.SettingsUpdate
+	ProviderUsage  = handlers.ProviderUsage
+	Usage          = routing.Usage
+	Rejections     = tracking.Rejections
+	PoolStats      = routing.PoolStats
+	BillingLine    = handlers.BillingLine
+)
+
+// Order statuses, as filtered on by SearchOrders.
+const (
+	StatusScheduled = store.StatusScheduled
+	StatusWaiting   = store.StatusWaiting
+	StatusEnRoute   = store.StatusEnRoute
+	StatusStale     = store.StatusStale
+	StatusPaused    = store.StatusPaused
+	StatusDelivered = store.StatusDelivered
+)
+
+// Driver statuses.
+const (
+	DriverAvailable = store.DriverAvailable
+	DriverBusy      = store.DriverBusy
+	DriverOffline   = store.DriverOffline
+)
@@ -0,0 +1,820 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/net/netutil"
+	"golang.org/x/sync/errgroup"
+
+	"location/client"
+	"location/internal/adminrpc"
+	"location/internal/auth"
+	"location/internal/chaos"
+	"location/internal/config"
+	"location/internal/discovery"
+	"location/internal/egress"
+	"location/internal/geo"
+	"location/internal/geocode"
+	"location/internal/handlers"
+	"location/internal/ingest"
+	"location/internal/notify"
+	"location/internal/publish"
+	"location/internal/recorder"
+	"location/internal/routing"
+	"location/internal/server"
+	"location/internal/simulate"
+	"location/internal/store"
+	"location/internal/tracking"
+	"location/internal/weather"
+)
+
+// commonFlags registers the flags shared by every command that talks to
+// storage and returns the config file path.
+func commonFlags(fs *flag.FlagSet) *string {
+	return fs.String("config", "conf.json", "path to the configuration file (.json, .yaml or .toml)")
+}
+
+// setup loads configuration and connects to storage.
+func setup(path string) (store.Store, error) {
+	conf, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(conf)
+}
+
+// openStore connects to the configured storage backend.
+func openStore(conf config.Configuration) (store.Store, error) {
+	if !store.Known(conf.Server.Storage) {
+		return nil, fmt.Errorf("unsupported storage backend: %s", conf.Server.Storage)
+	}
+	if conf.Server.Storage == "memory" {
+		log.Println("WARNING: using in-memory storage, state is lost on restart")
+		return store.NewMemory(), nil
+	}
+
+	// Initialize Redis client
+	client, err := store.Dial(context.Background(), conf.Redis.URL)
+	if err != nil {
+		return nil, err
+	}
+	replicas := make([]*redis.Client, len(conf.Redis.Replicas))
+	for i, url := range conf.Redis.Replicas {
+		if replicas[i], err = store.Dial(context.Background(), url); err != nil {
+			return nil, fmt.Errorf("redis.replicas: %v", err)
+		}
+	}
+	if conf.Chaos.Enabled {
+		for _, c := range append([]*redis.Client{client}, replicas...) {
+			c.AddHook(chaos.Hook{Rates: conf.Chaos.Redis})
+		}
+	}
+	return store.NewRedis(client, replicas...).InNamespace(conf.Redis.KeyPrefix).WithLegacyPoints(conf.Redis.LegacyPoints), nil
+}
+
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := commonFlags(fs)
+	listen := fs.String("listen", "", "listen address, overriding the configuration")
+	level := fs.String("log-level", "", "log level (debug, info, warn, error)")
+	storage := fs.String("storage", "", "storage backend (redis, memory)")
+	provider := fs.String("provider", "", "route provider (google, haversine)")
+	simulated := fs.Int("simulate", 0, "simulate this many drivers through the API, as configured under simulation")
+	fs.Parse(args)
+
+	// Flags take precedence over both the file and the environment
+	load := func() (config.Configuration, error) {
+		conf, err := config.Load(*configPath)
+		if err != nil {
+			return conf, err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "listen":
+				conf.Server.ListenAddr = *listen
+			case "log-level":
+				conf.Server.LogLevel = *level
+			case "storage":
+				conf.Server.Storage = *storage
+			case "provider":
+				conf.Maps.Provider = *provider
+			}
+		})
+		return conf, nil
+	}
+
+	conf, err := load()
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	if problems := conf.Validate(); len(problems) > 0 {
+		log.Printf("Invalid configuration, %d problem(s):", len(problems))
+		for _, p := range problems {
+			log.Printf("  - %v", p)
+		}
+		return 1
+	}
+
+	// Before any provider client is made
+	if err := egress.Configure(conf.Egress.Options()); err != nil {
+		log.Printf("error: egress: %v", err)
+		return 1
+	}
+
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	// Initialize logging with a runtime-adjustable level
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: rt.LogLevel()})))
+
+	st, err := openStore(conf)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+
+	authn, err := auth.New(conf.Auth, func() string { return rt.Config().Auth.AdminToken })
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	if conf.Auth.OIDC.IssuerURL != "" {
+		o, err := auth.NewOIDC(context.Background(), conf.Auth.OIDC)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		authn.UseOIDC(o)
+	}
+	if conf.Auth.ShareSecret != "" {
+		authn.UseShares(auth.NewShares(conf.Auth.ShareSecret, conf.Auth.ShareTTL.Duration))
+	}
+	authn.UseTenants(func() config.TenancyConfig { return rt.Config().Tenancy })
+
+	sh := shared{authn: authn}
+	if conf.Chaos.Enabled {
+		log.Printf("WARNING: chaos mode enabled (redis: %+v, provider: %+v)", conf.Chaos.Redis, conf.Chaos.Provider)
+	}
+	if conf.Weather.Provider == weather.OpenWeatherMap {
+		ow := weather.NewOpenWeatherMap(func() string { return rt.Config().Weather.APIKey })
+		sh.weather = &weather.Cached{Next: ow, TTL: conf.Weather.CacheTTL.Duration}
+	}
+	if conf.Maps.Geocoder == geocode.NominatimGeocoder {
+		sh.nominatim = geocode.NewNominatim(conf.Maps.NominatimURL, conf.Maps.NominatimInterval.Duration)
+	}
+	if w := conf.Workers; w.Count > 0 {
+		sh.pool = routing.NewPool(w.Count, w.QueueSize, w.Backpressure == config.BackpressureShed)
+	}
+	if sh.publisher, err = newPublisher(conf, rt); err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+
+	// Every tenant gets its own tracker, so its state, events and provider
+	// usage stay apart, and its own notifier and provider watch; the
+	// default tenant's have the empty name
+	names := []string{""}
+	for name := range conf.Tenancy.Tenants {
+		names = append(names, name)
+	}
+	trackers := map[string]*tracking.Tracker{}
+	watches := map[string]*notify.Providers{}
+	for _, name := range names {
+		name := name
+		channels := conf.ForTenant(name).Notify.Channels
+		notifier := &notify.Notifier{}
+		for _, ch := range channels {
+			notifier.Route(ch.Name, notify.NewWebhook(ch.Kind, ch.WebhookURL), ch.Events)
+		}
+		trackers[name], err = newTracker(name, tenantStore(st, name), conf, rt, sh, notifier)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		if len(channels) > 0 {
+			watches[name] = &notify.Providers{
+				Notifier: notifier,
+				Tenant:   name,
+				Usage:    trackers[name].Usage,
+				Quota:    func() int { return rt.Config().ForTenant(name).Maps.DailyQuota },
+				Interval: conf.Notify.CheckInterval.Duration,
+			}
+		}
+	}
+	tracker := trackers[""]
+	delete(trackers, "")
+	h := handlers.New(tracker, rt, authn)
+	h.UseTenants(trackers)
+
+	// Optionally record incoming traffic for later replay
+	var middleware []func(http.Handler) http.Handler
+	if conf.Server.RecordFile != "" {
+		rec, err := recorder.New(conf.Server.RecordFile)
+		if err != nil {
+			log.Fatalf("Failed to start request recording: %v", err)
+		}
+		middleware = append(middleware, rec.Middleware)
+		log.Printf("Recording requests to %s", conf.Server.RecordFile)
+	}
+	srv, err := server.New(conf.Server, h, middleware...)
+	if err != nil {
+		log.Fatalf("Failed to set up the HTTP server: %v", err)
+	}
+	lis, err := server.Listen(conf.Server.ListenAddr, conf.Server.Socket())
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", conf.Server.ListenAddr, err)
+	}
+	if n := conf.Server.MaxConnections; n > 0 {
+		lis = netutil.LimitListener(lis, n)
+	}
+
+	// Every long-running component shares one context: a signal or a fatal
+	// error in any of them stops all the others.
+	components := map[string]func(ctx context.Context) error{
+		"http": func(ctx context.Context) error {
+			return server.Run(ctx, srv, lis, conf.Server.ShutdownTimeout.Duration)
+		},
+		"reload": func(ctx context.Context) error {
+			return config.WatchReload(ctx, rt, conf, load)
+		},
+		"watchdog":  tracker.Watchdog,
+		"scheduler": tracker.Scheduler,
+	}
+	if sh.pool != nil {
+		components["workers"] = sh.pool.Run
+	}
+	for name, t := range trackers {
+		components["watchdog:"+name] = t.Watchdog
+		components["scheduler:"+name] = t.Scheduler
+	}
+	if b, ok := st.(publish.Backplane); ok && conf.Redis.Backplane {
+		components["backplane"] = func(ctx context.Context) error { return tracker.Relay(ctx, b) }
+		for name, t := range trackers {
+			t, b := t, tenantStore(st, name).(publish.Backplane)
+			components["backplane:"+name] = func(ctx context.Context) error { return t.Relay(ctx, b) }
+		}
+	}
+	if conf.Server.GRPCListenAddr != "" {
+		grpcSrv := adminrpc.New(tracker, rt, authn)
+		components["grpc"] = func(ctx context.Context) error {
+			return adminrpc.Run(ctx, grpcSrv, conf.Server.GRPCListenAddr, conf.Server.ShutdownTimeout.Duration)
+		}
+	}
+	for name, watch := range watches {
+		if name != "" {
+			name = ":" + name
+		}
+		components["notify"+name] = watch.Run
+	}
+	if in := conf.Ingest; in.TCPListenAddr != "" || in.UDPListenAddr != "" {
+		lis := &ingest.Listener{
+			Protocol: ingest.Protocols[in.Protocol],
+			Handle:   trackerFixes(tracker, rt),
+		}
+		if in.TCPListenAddr != "" {
+			components["ingest-tcp"] = func(ctx context.Context) error { return lis.ServeTCP(ctx, in.TCPListenAddr) }
+		}
+		if in.UDPListenAddr != "" {
+			components["ingest-udp"] = func(ctx context.Context) error { return lis.ServeUDP(ctx, in.UDPListenAddr) }
+		}
+	}
+	if *simulated > 0 {
+		api, err := selfClient(conf)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		sim, err := newSimulator(conf, api, *simulated, func() string { return rt.Config().Maps.APIKey })
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		components["simulator"] = sim.Run
+	}
+	if d := conf.Discovery; d.Registry != "" {
+		r, reg, err := newRegistration(conf, rt)
+		if err != nil {
+			log.Fatalf("Failed to set up service discovery: %v", err)
+		}
+		health := func(context.Context) error { return nil }
+		if p, ok := st.(store.Pinger); ok {
+			health = p.Ping
+		}
+		components["discovery"] = func(ctx context.Context) error {
+			return discovery.Run(ctx, r, reg, d.TTL.Duration/3, health)
+		}
+	}
+	return runComponents(components)
+}
+
+// newRegistration connects the configured registry and describes the
+// instance to it.
+func newRegistration(conf config.Configuration, rt *config.Runtime) (discovery.Registry, discovery.Registration, error) {
+	d := conf.Discovery
+	host, port, err := net.SplitHostPort(conf.Server.ListenAddr)
+	if err != nil {
+		return nil, discovery.Registration{}, fmt.Errorf("server.listen_addr: %v", err)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, discovery.Registration{}, fmt.Errorf("server.listen_addr: invalid port %q", port)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, discovery.Registration{}, err
+	}
+	reg := discovery.Registration{
+		ID:      d.ID,
+		Service: d.Service,
+		Address: d.Address,
+		Port:    portNum,
+		Tags:    d.Tags,
+		Meta:    d.Meta,
+	}
+	if reg.ID == "" {
+		reg.ID = net.JoinHostPort(hostname, port)
+	}
+	if reg.Address == "" {
+		reg.Address = host
+		if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+			reg.Address = hostname
+		}
+	}
+
+	token := func() string { return rt.Config().Discovery.Token }
+	switch d.Registry {
+	case discovery.KindConsul:
+		if d.URL == "" {
+			d.URL = discovery.ConsulURL
+		}
+		return discovery.NewConsul(d.URL, token, d.TTL.Duration), reg, nil
+	case discovery.KindEtcd:
+		if d.URL == "" {
+			d.URL = discovery.EtcdURL
+		}
+		return discovery.NewEtcd(d.URL, d.Prefix, token, d.TTL.Duration), reg, nil
+	}
+	return nil, reg, fmt.Errorf("unknown registry %q", d.Registry)
+}
+
+// shared are the parts of the service every tenant uses alike.
+type shared struct {
+	authn   *auth.Authenticator
+	weather weather.Provider
+	// nominatim is shared so its rate limit holds for the whole deployment
+	nominatim geocode.Resolver
+	// publisher is the cloud publisher events go to, nil for tenants'
+	// own WebSocket publishers
+	publisher publish.Publisher
+	// pool is shared so the worker count bounds provider calls across
+	// tenants
+	pool *routing.Pool
+}
+
+// newPublisher connects the configured cloud publisher, if any.
+func newPublisher(conf config.Configuration, rt *config.Runtime) (publish.Publisher, error) {
+	versions := func() []int { return rt.Config().Publisher.SchemaVersions }
+	switch p := conf.Publisher; p.Kind {
+	case publish.KindEventBridge:
+		eb := p.EventBridge
+		return publish.NewEventBridge(context.Background(), eb.Bus, eb.Source, eb.DetailType, eb.Region, versions)
+	case publish.KindPubSub:
+		ps := p.PubSub
+		return publish.NewPubSub(context.Background(), ps.Topic, ps.CredentialsFile, ps.Endpoint, versions)
+	case publish.KindServiceBus:
+		sb := p.ServiceBus
+		return publish.NewServiceBus(sb.ConnectionString, sb.Namespace, sb.Topic, sb.ClientID, versions)
+	}
+	return nil, nil
+}
+
+// newTracker builds the tracker of the named tenant around its store, with
+// the tenant's own Maps key, providers, publisher and notifier.
+func newTracker(name string, st store.Store, conf config.Configuration, rt *config.Runtime, sh shared, notifier *notify.Notifier) (*tracking.Tracker, error) {
+	tenant := func() config.Configuration { return rt.Config().ForTenant(name) }
+	providers := map[string]routing.Provider{
+		routing.Google:    routing.NewGoogleMaps(func() string { return tenant().Maps.APIKey }),
+		routing.Haversine: routing.HaversineEstimate{},
+	}
+	if conf.Chaos.Enabled {
+		for provider, p := range providers {
+			providers[provider] = chaos.Provider{Next: p, Rates: conf.Chaos.Provider}
+		}
+	}
+	publisher := sh.publisher
+	if publisher == nil {
+		publisher = publish.NewWebSocket(func() string { return tenant().Publisher.URL },
+			func() []int { return rt.Config().Publisher.SchemaVersions })
+	}
+	if len(conf.ForTenant(name).Notify.Channels) > 0 {
+		publisher = notify.Publisher{Next: publisher, Notifier: notifier}
+	}
+	senders := map[string]notify.Sender{}
+	if email := conf.Notify.Email; email.SMTPAddr != "" {
+		senders[store.ChannelEmail] = notify.Email{Mailer: notify.SMTP{
+			Addr:     email.SMTPAddr,
+			From:     email.From,
+			Username: email.Username,
+			Password: func() string { return rt.Config().Notify.Email.Password },
+		}}
+	}
+	if sms := conf.Notify.SMS; sms.AccountSID != "" {
+		senders[store.ChannelSMS] = notify.NewTwilio(sms.AccountSID,
+			func() string { return rt.Config().Notify.SMS.AuthToken }, sms.From)
+	}
+	if push := conf.Notify.Push; push.Enabled {
+		fcm, err := notify.NewFCM(context.Background(), push.ProjectID, push.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		senders[store.ChannelPush] = fcm
+	}
+	if len(senders) > 0 {
+		customers, err := newCustomers(conf.Notify)
+		if err != nil {
+			return nil, err
+		}
+		publicURL := conf.Notify.CustomerURL()
+		customers.Next = publisher
+		customers.Orders = st
+		customers.Senders = senders
+		customers.TrackingLink = func(orderID string) string {
+			shares := sh.authn.Shares()
+			if shares == nil || publicURL == "" {
+				return ""
+			}
+			token, _, err := shares.Mint(name, orderID, 0)
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSuffix(publicURL, "/") + "/track/" + token
+		}
+		customers.Obscure = func(orderID string, p geo.Point) geo.Point {
+			return rt.Config().Privacy.Obscure(orderID, p)
+		}
+		publisher = customers
+	}
+
+	tracker := tracking.New(st, providers, publisher, rt)
+	if name != "" {
+		tracker.UseTenant(name)
+	}
+	if sh.weather != nil {
+		tracker.UseWeather(sh.weather)
+	}
+	if sh.pool != nil {
+		tracker.UsePool(sh.pool)
+	}
+	if conf.Maps.IsochroneProvider != "" {
+		tracker.UseIsochrones(routing.NewIsochroner(conf.Maps.IsochroneProvider, conf.Maps.IsochroneURL,
+			func() string { return rt.Config().Maps.IsochroneAPIKey }))
+	}
+	addresses := map[string]geocode.Resolver{
+		geocode.PlusCode: &geocode.Cached{
+			Next: geocode.NewPlusCodes(func() string { return tenant().Maps.APIKey }),
+			TTL:  conf.Maps.AddressCacheTTL.Duration,
+		},
+	}
+	if conf.Maps.What3WordsAPIKey != "" {
+		addresses[geocode.What3Words] = &geocode.Cached{
+			Next: geocode.NewWhat3Words(func() string { return rt.Config().Maps.What3WordsAPIKey }),
+			TTL:  conf.Maps.AddressCacheTTL.Duration,
+		}
+	}
+	geocoder := sh.nominatim
+	if geocoder == nil {
+		geocoder = geocode.NewGoogle(func() string { return tenant().Maps.APIKey })
+	}
+	addresses[geocode.Address] = &geocode.Cached{Next: geocoder, TTL: conf.Maps.AddressCacheTTL.Duration}
+	tracker.UseAddresses(addresses)
+	return tracker, nil
+}
+
+// tenantStore keeps the state of the named tenant apart from every other
+// tenant's. In-memory storage simply gets a store of its own.
+func tenantStore(st store.Store, name string) store.Store {
+	if name == "" {
+		return st
+	}
+	if r, ok := st.(*store.Redis); ok {
+		return r.ForTenant(name)
+	}
+	return store.NewMemory()
+}
+
+// trackerFixes feeds fixes from hardware trackers through the same
+// pipeline as driver location requests, publishing the ETAs they change
+// except degraded ones. The listeners authenticate nothing, so only the
+// trackers mapped to drivers in the configuration are heard.
+func trackerFixes(tracker *tracking.Tracker, rt *config.Runtime) func(ctx context.Context, f ingest.Fix) error {
+	return func(ctx context.Context, f ingest.Fix) error {
+		driverID, ok := rt.Config().Ingest.Devices[f.Device]
+		if !ok {
+			return fmt.Errorf("unknown tracker %q", f.Device)
+		}
+		var tel store.Telemetry
+		if !f.At.IsZero() {
+			// Fixes buffered while out of coverage arrive late
+			tel.RecordedAt = &f.At
+		}
+		update, err := tracker.UpdateDriverLocation(ctx, driverID, f.Point, tel)
+		if errors.Is(err, store.ErrDriverNotFound) {
+			return fmt.Errorf("no driver %q registered", driverID)
+		}
+		if err != nil {
+			return err
+		}
+		for orderID, travelTime := range update.ETAs {
+			if slices.Contains(update.Degraded, orderID) {
+				continue
+			}
+			if err := tracker.PublishTravelTime(ctx, orderID, travelTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// runComponents runs every component until one fails or the process is
+// asked to stop, then waits for all of them to return.
+func runComponents(components map[string]func(ctx context.Context) error) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	g, ctx := errgroup.WithContext(ctx)
+	for name, run := range components {
+		name, run := name, run
+		g.Go(func() error {
+			if err := run(ctx); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			// A component returning early without an error still takes
+			// the rest down, otherwise the process would run half-alive.
+			if ctx.Err() == nil {
+				return fmt.Errorf("%s: stopped unexpectedly", name)
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		log.Printf("Shutting down: %v", err)
+		return 1
+	}
+	log.Println("Shut down cleanly")
+	return 0
+}
+
+// runSimulate drives simulated drivers through the API of a running
+// instance, such as for a load test, or plays a scripted scenario.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configPath := commonFlags(fs)
+	drivers := fs.Int("drivers", 10, "how many drivers to simulate")
+	baseURL := fs.String("url", "", "base URL of the API, by default the one the configuration serves")
+	token := fs.String("token", "", "token to call the API with, by default the configured admin token")
+	scenarioPath := fs.String("scenario", "", "play the scenario in this YAML file instead, failing if it misses an expectation")
+	fs.Parse(args)
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	if err := egress.Configure(conf.Egress.Options()); err != nil {
+		log.Printf("error: egress: %v", err)
+		return 1
+	}
+	if *token != "" {
+		conf.Auth.AdminToken = *token
+	}
+	api := client.New(*baseURL, conf.Auth.AdminToken)
+	if *baseURL == "" {
+		if api, err = selfClient(conf); err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+	}
+	sim, err := newSimulator(conf, api, *drivers, func() string { return conf.Maps.APIKey })
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	if *scenarioPath == "" {
+		return runComponents(map[string]func(ctx context.Context) error{"simulator": sim.Run})
+	}
+
+	sc, err := simulate.LoadScenario(*scenarioPath)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Playing scenario %q, %d trips", sc.Name, len(sc.Trips))
+	if err := sim.Play(ctx, sc); err != nil {
+		log.Printf("Scenario %q failed:\n%v", sc.Name, err)
+		return 1
+	}
+	log.Printf("Scenario %q passed", sc.Name)
+	return 0
+}
+
+// newSimulator simulates drivers through api as conf says, routing with
+// Google Maps under apiKey if it chooses Google.
+func newSimulator(conf config.Configuration, api *client.Client, drivers int, apiKey func() string) (*simulate.Simulator, error) {
+	sim := conf.Simulation
+	if sim.Center == "" {
+		return nil, errors.New("simulation.center is required to simulate drivers")
+	}
+	if api.Token == "" {
+		return nil, errors.New("simulated drivers call the API as admin, which takes auth.admin_token")
+	}
+	center, err := geo.Parse(sim.Center)
+	if err != nil {
+		return nil, fmt.Errorf("simulation.center: %v", err)
+	}
+	var router routing.Router
+	switch {
+	case sim.Router == routing.Google, sim.Router == "" && apiKey() != "":
+		router = routing.NewGoogleMaps(apiKey)
+	case sim.Router == routing.Haversine:
+		router = routing.HaversineEstimate{}
+	default:
+		url := sim.RouterURL
+		if url == "" {
+			url = routing.OSRMURL
+		}
+		router = routing.NewOSRM(url)
+	}
+	return simulate.New(api, router, simulate.Options{
+		Drivers:  drivers,
+		Center:   center,
+		Radius:   sim.Radius,
+		Interval: sim.Interval.Duration,
+		Mode:     sim.Mode,
+		Speedup:  sim.Speedup,
+		Prefix:   sim.Prefix,
+	}), nil
+}
+
+// selfClient returns an admin client of the API the configuration serves.
+func selfClient(conf config.Configuration) (*client.Client, error) {
+	addr := conf.Server.ListenAddr
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		api := client.New("http://location", conf.Auth.AdminToken)
+		api.HTTPClient = &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}}
+		return api, nil
+	}
+	if !conf.Server.ListensOnTCP() {
+		return nil, errors.New("the address of a socket systemd passes is unknown, give the API's URL")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("server.listen_addr: %v", err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return client.New("http://"+net.JoinHostPort(host, port), conf.Auth.AdminToken), nil
+}
+
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := commonFlags(fs)
+	moveKeys := fs.Bool("move-keys", false, "first move the keys kept under the -from prefix into redis.key_prefix; stop every instance before")
+	from := fs.String("from", "", "the key prefix to move keys from, empty for none")
+	fs.Parse(args)
+
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	st, err := openStore(conf)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+	ctx := context.Background()
+	if *moveKeys {
+		r, ok := st.(*store.Redis)
+		if !ok {
+			log.Println("error: only Redis keys can be moved")
+			return 1
+		}
+		n, err := r.MoveKeys(ctx, *from)
+		if err != nil {
+			log.Printf("moving keys failed after %d: %v", n, err)
+			return 1
+		}
+		log.Printf("Moved %d keys from %q to %q", n, *from, conf.Redis.KeyPrefix)
+	}
+	if _, ok := st.(store.Migrator); !ok {
+		log.Println("No migrations to apply")
+		return 0
+	}
+
+	// Orders read meanwhile are upgraded as they are, so this may run
+	// while the service is serving
+	names := []string{""}
+	for name := range conf.Tenancy.Tenants {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		n, err := tenantStore(st, name).(store.Migrator).Migrate(ctx)
+		if err != nil {
+			log.Printf("migrating tenant %q failed after %d keys: %v", name, n, err)
+			return 1
+		}
+		log.Printf("Upgraded %d keys of tenant %q to schema version %d", n, name, store.SchemaVersion)
+	}
+	return 0
+}
+
+// runExport writes every stored order as a JSON object per line.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := commonFlags(fs)
+	output := fs.String("o", "-", "output file, - for stdout")
+	fs.Parse(args)
+
+	st, err := setup(*configPath)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Printf("failed to create %s: %v", *output, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+
+	count := 0
+	err = st.ForEachOrder(context.Background(), func(order store.Order) error {
+		count++
+		return enc.Encode(order)
+	})
+	if err != nil {
+		log.Printf("export failed: %v", err)
+		return 1
+	}
+	log.Printf("Exported %d orders", count)
+	return 0
+}
+
+// newCustomers parses the customer message templates and rules, checking
+// that every rule has a template.
+func newCustomers(conf config.NotifyConfig) (notify.Customers, error) {
+	templates, err := template.New("customers").Parse(notify.DefaultTemplates)
+	if err == nil && conf.CustomerTemplates() != "" {
+		templates, err = templates.ParseFiles(conf.CustomerTemplates())
+	}
+	if err != nil {
+		return notify.Customers{}, fmt.Errorf("notify templates: %v", err)
+	}
+	rules := notify.DefaultRules
+	if len(conf.Rules) > 0 {
+		rules = nil
+		for _, r := range conf.Rules {
+			rules = append(rules, notify.Rule{Events: r.Events, Template: r.Template, Channels: r.Channels})
+		}
+	}
+	for _, r := range rules {
+		if templates.Lookup(r.Template) == nil {
+			return notify.Customers{}, fmt.Errorf("notify rules: no template named %q", r.Template)
+		}
+	}
+	return notify.Customers{Rules: rules, Templates: templates}, nil
+}
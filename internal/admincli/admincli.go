@@ -0,0 +1,304 @@
+// Package admincli implements the admin command, which drives a running
+// instance through its admin API so on-call never has to touch Redis.
+package admincli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"location/client"
+)
+
+// command is one admin subcommand. setup registers its flags and returns
+// the function that runs it on the remaining arguments.
+type command struct {
+	usage string
+	help  string
+	setup func(fs *flag.FlagSet) func(c *client.Client, args []string) int
+}
+
+var commands = map[string]command{
+	"orders":  {"orders [-stale-after d]", "list every order, flagging silent couriers", listOrders},
+	"show":    {"show <order>", "print the stored state of an order", showOrder},
+	"delete":  {"delete <order>...", "delete orders and their history", deleteOrders},
+	"mode":    {"mode <order> <mode>", "set the travel mode of an order", setMode},
+	"history": {"history [-o file] <order>", "export the history of an order as JSON lines", exportHistory},
+	"tail":    {"tail <order>...", "follow position and ETA updates of orders", tailOrders},
+	"quota":   {"quota", "show today's route provider calls against the quota", showQuota},
+}
+
+// Run implements the admin command.
+func Run(args []string) int {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	server := fs.String("server", envOr("LOCATION_SERVER", "http://localhost:8080"), "base URL of the instance (env LOCATION_SERVER)")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token or admin JWT (env ADMIN_TOKEN)")
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: location admin [flags] <command> [command flags] [args]")
+		fmt.Fprintln(out, "\ncommands:")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(tw, "  %s\t%s\n", commands[name].usage, commands[name].help)
+		}
+		tw.Flush()
+		fmt.Fprintln(out, "\nflags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown admin command %q\n", name)
+		fs.Usage()
+		return 2
+	}
+	sub := flag.NewFlagSet("admin "+name, flag.ExitOnError)
+	sub.Usage = func() {
+		fmt.Fprintf(sub.Output(), "usage: location admin %s\n", cmd.usage)
+		sub.PrintDefaults()
+	}
+	run := cmd.setup(sub)
+	sub.Parse(fs.Args()[1:])
+
+	return run(client.New(*server, *token), sub.Args())
+}
+
+// timeout bounds the calls of a command, retries included.
+const timeout = 30 * time.Second
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func listOrders(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	staleAfter := fs.Duration("stale-after", 0, "flag couriers silent for longer than this (default: the server's)")
+	return func(c *client.Client, args []string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		orders, err := c.AdminOrders(ctx, *staleAfter)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ORDER\tMODE\tETA\tLAST SEEN\tSTALE")
+		for _, o := range orders {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", o.ID, dash(o.Mode), eta(o.ETA), ago(o.SeenAt), yes(o.Stale))
+		}
+		tw.Flush()
+		return 0
+	}
+}
+
+func showOrder(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	return func(c *client.Client, args []string) int {
+		if len(args) != 1 {
+			fs.Usage()
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		order, err := c.Order(ctx, args[0])
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(order.Order)
+		return 0
+	}
+}
+
+func deleteOrders(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	return func(c *client.Client, args []string) int {
+		if len(args) == 0 {
+			fs.Usage()
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		code := 0
+		for _, id := range args {
+			if err := c.DeleteOrder(ctx, id); err != nil {
+				log.Printf("failed to delete %s: %v", id, err)
+				code = 1
+				continue
+			}
+			fmt.Printf("Deleted %s\n", id)
+		}
+		return code
+	}
+}
+
+func setMode(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	return func(c *client.Client, args []string) int {
+		if len(args) != 2 {
+			fs.Usage()
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := c.SetMode(ctx, args[0], args[1]); err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+		return 0
+	}
+}
+
+func exportHistory(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	output := fs.String("o", "-", "output file, - for stdout")
+	return func(c *client.Client, args []string) int {
+		if len(args) != 1 {
+			fs.Usage()
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		history, err := c.History(ctx, args[0])
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+
+		out := os.Stdout
+		if *output != "-" {
+			f, err := os.Create(*output)
+			if err != nil {
+				log.Printf("failed to create %s: %v", *output, err)
+				return 1
+			}
+			defer f.Close()
+			out = f
+		}
+		enc := json.NewEncoder(out)
+		for _, e := range history {
+			if err := enc.Encode(e); err != nil {
+				log.Printf("export failed: %v", err)
+				return 1
+			}
+		}
+		return 0
+	}
+}
+
+func tailOrders(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	return func(c *client.Client, args []string) int {
+		if len(args) == 0 {
+			fs.Usage()
+			return 2
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		var (
+			wg   sync.WaitGroup
+			mu   sync.Mutex
+			code int
+		)
+		for _, id := range args {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				err := c.Subscribe(ctx, id, func(p client.Position) {
+					mu.Lock()
+					defer mu.Unlock()
+					fmt.Printf("%s  %-12s courier %-24s eta %s\n", time.Now().Format(time.TimeOnly), p.OrderID, point(p), eta(p.ETA))
+				})
+				if err != nil && ctx.Err() == nil {
+					log.Printf("stopped following %s: %v", id, err)
+					mu.Lock()
+					code = 1
+					mu.Unlock()
+				}
+			}(id)
+		}
+		wg.Wait()
+		return code
+	}
+}
+
+func showQuota(fs *flag.FlagSet) func(c *client.Client, args []string) int {
+	return func(c *client.Client, args []string) int {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		providers, err := c.Providers(ctx)
+		if err != nil {
+			log.Printf("error: %v", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PROVIDER\tSELECTED\tDAY\tCALLS\tERRORS\tQUOTA\tREMAINING")
+		for _, p := range providers {
+			quota, remaining := "-", "-"
+			if p.DailyQuota > 0 {
+				quota, remaining = fmt.Sprint(p.DailyQuota), fmt.Sprint(p.Remaining)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", p.Provider, yes(p.Selected), p.Day, p.Calls, p.Errors, quota, remaining)
+		}
+		tw.Flush()
+		fmt.Println("\nCounts are for the instance that answered; add them up across replicas.")
+		return 0
+	}
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func yes(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
+
+func eta(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() || t.Unix() <= 0 {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func point(p client.Position) string {
+	if p.Courier == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.5f,%.5f", p.Courier.Lat, p.Courier.Lng)
+}
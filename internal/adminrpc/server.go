@@ -0,0 +1,254 @@
+// Package adminrpc serves the admin operations over gRPC, for internal
+// tooling that would rather use generated clients than the JSON API. The
+// service is defined in api/location/admin/v1/admin.proto; regenerate
+// adminv1 with "buf generate api" after changing it.
+package adminrpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"location/internal/adminrpc/adminv1"
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// Server implements adminv1.AdminServiceServer.
+type Server struct {
+	adminv1.UnimplementedAdminServiceServer
+	tracker *tracking.Tracker
+	runtime *config.Runtime
+}
+
+// New creates a gRPC server offering the admin service. Every call must
+// carry admin credentials, checked by authn.
+func New(tracker *tracking.Tracker, rt *config.Runtime, authn *auth.Authenticator) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(authorize(authn)))
+	adminv1.RegisterAdminServiceServer(srv, &Server{tracker: tracker, runtime: rt})
+	return srv
+}
+
+// Run serves on addr until ctx is done, then stops gracefully, cutting off
+// calls still running after shutdownTimeout.
+func Run(ctx context.Context, srv *grpc.Server, addr string, shutdownTimeout time.Duration) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("gRPC admin server listening on %s", addr)
+		errc <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down gRPC server")
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		srv.Stop()
+	}
+	return <-errc
+}
+
+// authorize rejects calls without an admin credential in the
+// "authorization" metadata.
+func authorize(authn *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get("authorization")
+		if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+			return nil, status.Error(codes.Unauthenticated, "missing bearer credential")
+		}
+		p, err := authn.VerifyBearer(strings.TrimPrefix(values[0], "Bearer "))
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid credential")
+		}
+		if !p.Has(auth.ScopeAdmin) {
+			return nil, status.Error(codes.PermissionDenied, "admin scope required")
+		}
+		// The service only reaches the default tenant
+		if p.Tenant != "" {
+			return nil, status.Error(codes.PermissionDenied, "tenant credentials cannot use the admin service")
+		}
+		return handler(auth.WithPrincipal(ctx, p), req)
+	}
+}
+
+// callContext applies the configured request timeout to a call.
+func (s *Server) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.runtime.Config().Server.RequestTimeout.Duration
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// failure maps an error onto a gRPC status.
+func failure(ctx context.Context, err error, msg string) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return status.Error(codes.NotFound, "order not found")
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	default:
+		log.Printf("%s: %v", msg, err)
+		return status.Error(codes.Internal, msg)
+	}
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *adminv1.ListOrdersRequest) (*adminv1.ListOrdersResponse, error) {
+	staleAfter := s.tracker.StaleAfter()
+	if d := req.GetStaleAfter(); d != nil {
+		if d.AsDuration() <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "stale_after must be positive")
+		}
+		staleAfter = d.AsDuration()
+	}
+
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	resp := &adminv1.ListOrdersResponse{}
+	err := s.tracker.ForEachOrder(ctx, func(o store.Order) error {
+		resp.Orders = append(resp.Orders, toOrder(o, staleAfter))
+		return nil
+	})
+	if err != nil {
+		return nil, failure(ctx, err, "failed to list orders")
+	}
+	return resp, nil
+}
+
+func (s *Server) GetOrder(ctx context.Context, req *adminv1.GetOrderRequest) (*adminv1.Order, error) {
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	o, err := s.tracker.Order(ctx, req.GetOrderId())
+	if err != nil {
+		return nil, failure(ctx, err, "failed to get order")
+	}
+	return toOrder(o, s.tracker.StaleAfter()), nil
+}
+
+func (s *Server) DeleteOrder(ctx context.Context, req *adminv1.DeleteOrderRequest) (*adminv1.DeleteOrderResponse, error) {
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	if err := s.tracker.DeleteOrder(ctx, req.GetOrderId()); err != nil {
+		return nil, failure(ctx, err, "failed to delete order")
+	}
+	return &adminv1.DeleteOrderResponse{}, nil
+}
+
+func (s *Server) GetStats(ctx context.Context, req *adminv1.GetStatsRequest) (*adminv1.Stats, error) {
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	stats := &adminv1.Stats{}
+	staleAfter := s.tracker.StaleAfter()
+	err := s.tracker.ForEachOrder(ctx, func(o store.Order) error {
+		stats.Orders++
+		if o.Stale(staleAfter) {
+			stats.StaleOrders++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, failure(ctx, err, "failed to count orders")
+	}
+
+	selected := s.runtime.Settings().Provider
+	quota := int64(s.runtime.Config().Maps.DailyQuota)
+	for name, usage := range s.tracker.Usage() {
+		p := &adminv1.ProviderUsage{
+			Provider: name,
+			Selected: name == selected,
+			Day:      usage.Day,
+			Calls:    usage.Calls,
+			Errors:   usage.Errors,
+		}
+		if name == routing.Google && quota > 0 {
+			p.DailyQuota = quota
+			p.Remaining = max(quota-usage.Calls, 0)
+		}
+		stats.Providers = append(stats.Providers, p)
+	}
+	sort.Slice(stats.Providers, func(i, j int) bool { return stats.Providers[i].Provider < stats.Providers[j].Provider })
+	return stats, nil
+}
+
+func (s *Server) GetSettings(ctx context.Context, req *adminv1.GetSettingsRequest) (*adminv1.Settings, error) {
+	return toSettings(s.runtime.Settings()), nil
+}
+
+func (s *Server) UpdateSettings(ctx context.Context, req *adminv1.UpdateSettingsRequest) (*adminv1.Settings, error) {
+	settings, err := s.runtime.Update(config.SettingsUpdate{
+		LogLevel:   req.LogLevel,
+		Caching:    req.Caching,
+		Debouncing: req.Debouncing,
+		Provider:   req.Provider,
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toSettings(settings), nil
+}
+
+func toOrder(o store.Order, staleAfter time.Duration) *adminv1.Order {
+	return &adminv1.Order{
+		OrderId: o.ID,
+		Current: toPoint(o.Current),
+		Target:  toPoint(o.Target),
+		Mode:    o.Mode,
+		Eta:     durationpb.New(o.ETA),
+		EtaAt:   toTimestamp(o.ETAAt),
+		SeenAt:  toTimestamp(o.SeenAt),
+		Stale:   o.Stale(staleAfter),
+	}
+}
+
+func toPoint(p *geo.Point) *adminv1.Point {
+	if p == nil {
+		return nil
+	}
+	return &adminv1.Point{Lat: p.Lat, Lng: p.Lng}
+}
+
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func toSettings(s config.Settings) *adminv1.Settings {
+	return &adminv1.Settings{
+		LogLevel:   s.LogLevel,
+		Caching:    s.Caching,
+		Debouncing: s.Debouncing,
+		Provider:   s.Provider,
+	}
+}
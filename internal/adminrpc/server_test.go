@@ -0,0 +1,92 @@
+package adminrpc_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"location/internal/adminrpc"
+	"location/internal/adminrpc/adminv1"
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+const adminToken = "secret"
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestAdminService(t *testing.T) {
+	conf := config.Default()
+	conf.Server.Storage = "memory"
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := tracking.New(store.NewMemory(), map[string]routing.Provider{routing.Google: &routing.Scripted{Default: 5 * time.Minute}}, &publish.Capture{}, rt)
+	ctx := context.Background()
+	tracker.CreateOrder(ctx, tracking.NewOrder{ID: "o1", Target: geo.Point{Lat: 1.30, Lng: 103.80}})
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.35, Lng: 103.85})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := adminrpc.New(tracker, rt, auth.NewWithKeyfunc(nil, "", "", func() string { return adminToken }))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := adminv1.NewAdminServiceClient(conn)
+
+	if _, err := client.ListOrders(ctx, &adminv1.ListOrdersRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous call: got %v, want Unauthenticated", err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+adminToken)
+	list, err := client.ListOrders(ctx, &adminv1.ListOrdersRequest{})
+	if err != nil || len(list.Orders) != 1 {
+		t.Fatalf("ListOrders: %v %v", list, err)
+	}
+	if o := list.Orders[0]; o.OrderId != "o1" || o.Eta.AsDuration() != 5*time.Minute || o.Stale {
+		t.Errorf("order = %v", o)
+	}
+
+	stats, err := client.GetStats(ctx, &adminv1.GetStatsRequest{})
+	if err != nil || stats.Orders != 1 || len(stats.Providers) != 1 || stats.Providers[0].Calls != 1 {
+		t.Errorf("GetStats: %v %v", stats, err)
+	}
+
+	provider := routing.Haversine
+	settings, err := client.UpdateSettings(ctx, &adminv1.UpdateSettingsRequest{Provider: &provider})
+	if err != nil || settings.Provider != routing.Haversine || rt.Settings().Provider != routing.Haversine {
+		t.Errorf("UpdateSettings: %v %v", settings, err)
+	}
+
+	if _, err := client.DeleteOrder(ctx, &adminv1.DeleteOrderRequest{OrderId: "o1"}); err != nil {
+		t.Errorf("DeleteOrder: %v", err)
+	}
+	if _, err := client.GetOrder(ctx, &adminv1.GetOrderRequest{OrderId: "o1"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetOrder after delete: got %v, want NotFound", err)
+	}
+}
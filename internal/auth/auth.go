@@ -0,0 +1,365 @@
+// Package auth authenticates API callers and decides what they may do.
+//
+// Callers present either a bearer JWT signed by a key from the configured
+// JWKS, or the static admin token. JWTs carry space-separated scopes in the
+// "scope" claim and the orders the caller is assigned to in "orders":
+//
+//   - driver: may post locations and modes for its assigned orders, and the
+//     position of the driver named by the token's subject
+//   - customer: may read the ETA of its assigned orders
+//   - dispatcher: may read its assigned orders, with the customers' details
+//     and the couriers' telemetry
+//   - admin: may do anything, including /admin/*
+//
+// Without a JWKS URL, JWT authentication is off and only the admin surface
+// is protected, by the static token, as before JWTs were supported.
+//
+// Customers may also follow a share link, whose token grants read-only
+// access to a single order until it expires.
+//
+// Operators using a browser can instead log in through OIDC; their session
+// cookie carries roles mapped from their identity provider groups.
+//
+// In a deployment shared by several tenants, every request acts for one of
+// them: the tenant of its API key, of the "tenant" claim of its JWT or share
+// token, or else the one named by the tenancy header.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+
+	"location/internal/config"
+)
+
+// Scopes granted by tokens.
+const (
+	ScopeDriver     = "driver"
+	ScopeCustomer   = "customer"
+	ScopeDispatcher = "dispatcher"
+	ScopeAdmin      = "admin"
+)
+
+// Claims are the JWT claims the service understands.
+type Claims struct {
+	Scope  string   `json:"scope"`
+	Orders []string `json:"orders"`
+	Tenant string   `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Subject string
+	Scopes  []string
+	Orders  []string
+	// Tenant is the tenant the caller belongs to, empty for the default
+	// tenant and for operators who may act for any.
+	Tenant string
+}
+
+// Has reports whether the principal was granted scope.
+func (p *Principal) Has(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Assigned reports whether the principal is assigned to the order.
+func (p *Principal) Assigned(orderID string) bool {
+	for _, id := range p.Orders {
+		if id == orderID {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// FromContext returns the authenticated caller, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// WithPrincipal attaches a caller to ctx.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+type tenantKey struct{}
+
+// TenantFromContext returns the tenant a request acts for, empty for the
+// default tenant.
+func TenantFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(tenantKey{}).(string)
+	return name
+}
+
+// WithTenant makes ctx act for the named tenant.
+func WithTenant(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, name)
+}
+
+// Authenticator verifies credentials on incoming requests.
+type Authenticator struct {
+	keyfunc    jwt.Keyfunc
+	parser     *jwt.Parser
+	adminToken func() string
+	oidc       *OIDC
+	shares     *Shares
+	tenancy    func() config.TenancyConfig
+}
+
+// New creates an Authenticator. adminToken returns the current static admin
+// token, which may change on reload.
+func New(conf config.AuthConfig, adminToken func() string) (*Authenticator, error) {
+	a := &Authenticator{adminToken: adminToken}
+	if conf.JWKSURL == "" {
+		return a, nil
+	}
+
+	jwks, err := keyfunc.Get(conf.JWKSURL, keyfunc.Options{
+		RefreshInterval:   time.Hour,
+		RefreshRateLimit:  time.Minute,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			log.Printf("failed to refresh JWKS: %v", err)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS from %s: %v", conf.JWKSURL, err)
+	}
+	return NewWithKeyfunc(jwks.Keyfunc, conf.Issuer, conf.Audience, adminToken), nil
+}
+
+// NewWithKeyfunc creates an Authenticator that verifies JWTs with keyfunc
+// instead of a remote JWKS.
+func NewWithKeyfunc(kf jwt.Keyfunc, issuer, audience string, adminToken func() string) *Authenticator {
+	var opts []jwt.ParserOption
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	return &Authenticator{
+		keyfunc:    kf,
+		parser:     jwt.NewParser(opts...),
+		adminToken: adminToken,
+	}
+}
+
+// UseOIDC makes the authenticator accept operator sessions from o.
+func (a *Authenticator) UseOIDC(o *OIDC) {
+	a.oidc = o
+}
+
+// OIDC returns the operator login flow, or nil when it is not configured.
+func (a *Authenticator) OIDC() *OIDC {
+	return a.oidc
+}
+
+// UseShares makes the authenticator accept share links signed by s.
+func (a *Authenticator) UseShares(s *Shares) {
+	a.shares = s
+}
+
+// Shares returns the share link signer, or nil when links are not enabled.
+func (a *Authenticator) Shares() *Shares {
+	return a.shares
+}
+
+// UseTenants makes the authenticator accept the API keys of the tenants
+// tenancy returns, and resolve the tenant of every request.
+func (a *Authenticator) UseTenants(tenancy func() config.TenancyConfig) {
+	a.tenancy = tenancy
+}
+
+// Enabled reports whether JWT authentication is configured.
+func (a *Authenticator) Enabled() bool {
+	return a.keyfunc != nil
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// authenticate resolves the bearer credential of r, if any.
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if token := r.URL.Query().Get(shareParam); token != "" && a.shares != nil {
+		return a.shares.Verify(token)
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		if a.oidc != nil {
+			return a.oidc.principal(r)
+		}
+		return nil, nil
+	}
+	return a.VerifyBearer(strings.TrimPrefix(header, "Bearer "))
+}
+
+// VerifyBearer checks a bearer credential: the static admin token or a JWT.
+// It serves callers that do not come in over HTTP, such as gRPC.
+func (a *Authenticator) VerifyBearer(token string) (*Principal, error) {
+	if admin := a.adminToken(); admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(admin)) == 1 {
+		return &Principal{Subject: "admin-token", Scopes: []string{ScopeAdmin}}, nil
+	}
+	if a.tenancy != nil {
+		for name, t := range a.tenancy().Tenants {
+			if t.APIKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.APIKey)) == 1 {
+				return &Principal{Subject: "tenant:" + name, Scopes: []string{ScopeAdmin}, Tenant: name}, nil
+			}
+		}
+	}
+	if !a.Enabled() {
+		return nil, errInvalidToken
+	}
+
+	var claims Claims
+	_, err := a.parser.ParseWithClaims(token, &claims, a.keyfunc)
+	if err != nil {
+		log.Printf("rejected token: %v", err)
+		return nil, errInvalidToken
+	}
+	return &Principal{
+		Subject: claims.Subject,
+		Scopes:  strings.Fields(claims.Scope),
+		Orders:  claims.Orders,
+		Tenant:  claims.Tenant,
+	}, nil
+}
+
+// Middleware attaches the caller to the request context. Requests with
+// invalid credentials are rejected; requests without any pass through as
+// anonymous and are judged by Authorize.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.authenticate(r)
+		if errors.Is(err, errBadSession) {
+			// A stale session cookie just means logging in again
+			p, err = nil, nil
+		}
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if p == nil && a.oidc != nil && isBrowserAdminRequest(r) {
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		tenant, status := a.tenant(r, p)
+		switch status {
+		case http.StatusForbidden:
+			http.Error(w, "Forbidden", status)
+			return
+		case http.StatusNotFound:
+			http.Error(w, "Unknown tenant", status)
+			return
+		}
+		ctx := WithTenant(r.Context(), tenant)
+		if p != nil {
+			ctx = WithPrincipal(ctx, p)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tenant resolves the tenant r acts for, or the status to refuse it with.
+// Callers tied to a tenant may not name another; the header is only up to
+// operators, and to everyone while JWT authentication is off.
+func (a *Authenticator) tenant(r *http.Request, p *Principal) (string, int) {
+	if a.tenancy == nil {
+		return "", http.StatusOK
+	}
+	tenancy := a.tenancy()
+	name := r.Header.Get(tenancy.Header)
+	switch {
+	case p != nil && p.Tenant != "":
+		if name != "" && name != p.Tenant {
+			return "", http.StatusForbidden
+		}
+		name = p.Tenant
+	case p != nil && !p.Has(ScopeAdmin) && a.Enabled():
+		if name != "" {
+			return "", http.StatusForbidden
+		}
+	}
+	if _, ok := tenancy.Tenants[name]; name != "" && !ok {
+		return "", http.StatusNotFound
+	}
+	return name, http.StatusOK
+}
+
+// AuthorizeOperator reports whether the caller of r is an admin of the
+// whole deployment rather than of a single tenant, as changes to settings
+// shared by every tenant require.
+func (a *Authenticator) AuthorizeOperator(r *http.Request) bool {
+	p, ok := FromContext(r.Context())
+	return ok && p.Has(ScopeAdmin) && p.Tenant == ""
+}
+
+// Authorize reports whether the caller of r holds one of scopes and, for
+// non-admin scopes, is assigned to orderID. Admins may do anything. When JWT
+// authentication is off every caller is allowed, as before it existed.
+func (a *Authenticator) Authorize(r *http.Request, orderID string, scopes ...string) bool {
+	if !a.Enabled() {
+		return true
+	}
+	p, ok := FromContext(r.Context())
+	if !ok {
+		return false
+	}
+	if p.Has(ScopeAdmin) {
+		return true
+	}
+	for _, s := range scopes {
+		if s != ScopeAdmin && p.Has(s) && p.Assigned(orderID) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeDriver reports whether the caller of r is the driver driverID,
+// identified by the subject of its driver token, or an admin. When JWT
+// authentication is off every caller is allowed, as in Authorize.
+func (a *Authenticator) AuthorizeDriver(r *http.Request, driverID string) bool {
+	if !a.Enabled() {
+		return true
+	}
+	p, ok := FromContext(r.Context())
+	if !ok {
+		return false
+	}
+	return p.Has(ScopeAdmin) || (p.Has(ScopeDriver) && p.Subject == driverID)
+}
+
+// AuthorizeAdmin reports whether the caller of r is an admin, through
+// either an admin JWT or the static admin token.
+func (a *Authenticator) AuthorizeAdmin(r *http.Request) bool {
+	p, ok := FromContext(r.Context())
+	return ok && p.Has(ScopeAdmin)
+}
+
+// isBrowserAdminRequest reports whether r is a page load of the admin
+// surface, which should send the user to the login page rather than fail.
+func isBrowserAdminRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		strings.HasPrefix(r.URL.Path, "/admin/") &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
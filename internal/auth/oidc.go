@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"location/internal/config"
+)
+
+const (
+	sessionCookie = "location_session"
+	stateCookie   = "location_oidc_state"
+)
+
+// OIDC logs operators in against the corporate identity provider and keeps
+// them logged in with a signed session cookie. The roles in the session are
+// derived from the user's groups through the configured mapping.
+type OIDC struct {
+	conf     config.OIDCConfig
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	secret   []byte
+}
+
+// session is the payload of the session cookie.
+type session struct {
+	Subject string    `json:"sub"`
+	Roles   []string  `json:"roles"`
+	Expires time.Time `json:"exp"`
+}
+
+// NewOIDC discovers the provider's endpoints and prepares the login flow.
+func NewOIDC(ctx context.Context, conf config.OIDCConfig) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, conf.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider %s: %v", conf.IssuerURL, err)
+	}
+	return &OIDC{
+		conf: conf,
+		oauth: oauth2.Config{
+			ClientID:     conf.ClientID,
+			ClientSecret: conf.ClientSecret,
+			RedirectURL:  conf.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: conf.ClientID}),
+		secret:   []byte(conf.SessionSecret),
+	}, nil
+}
+
+// Login redirects the browser to the identity provider.
+func (o *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	state := base64.RawURLEncoding.EncodeToString(nonce)
+
+	// Remember where to send the user back to after logging in
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/admin/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state + "|" + next,
+		Path:     "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.oauth.AuthCodeURL(state), http.StatusFound)
+}
+
+// Callback completes the login and issues the session cookie.
+func (o *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	state, next, _ := strings.Cut(c.Value, "|")
+	if r.URL.Query().Get("state") != state {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	token, err := o.oauth.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	rawID, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := o.verifier.Verify(r.Context(), rawID)
+	if err != nil {
+		log.Printf("OIDC token verification failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	roles := o.roles(claims)
+	if len(roles) == 0 {
+		log.Printf("OIDC user %s has no mapped role", idToken.Subject)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	s := session{Subject: idToken.Subject, Roles: roles, Expires: time.Now().Add(o.conf.SessionTTL.Duration)}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    o.sign(s),
+		Path:     "/",
+		Expires:  s.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/auth/", MaxAge: -1})
+	log.Printf("OIDC login for %s with roles %v", s.Subject, s.Roles)
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// Logout clears the session cookie.
+func (o *OIDC) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// roles maps the user's groups onto service roles.
+func (o *OIDC) roles(claims map[string]interface{}) []string {
+	groups, _ := claims[o.conf.GroupsClaim].([]interface{})
+	seen := map[string]bool{}
+	var roles []string
+	for _, g := range groups {
+		name, _ := g.(string)
+		if role, ok := o.conf.GroupRoles[name]; ok && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func (o *OIDC) sign(s session) string {
+	payload, _ := json.Marshal(s)
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var errBadSession = errors.New("invalid session")
+
+// principal returns the operator logged in on r, if any.
+func (o *OIDC) principal(r *http.Request) (*Principal, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+	payloadPart, sigPart, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return nil, errBadSession
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(payloadPart)
+	sig, err2 := base64.RawURLEncoding.DecodeString(sigPart)
+	if err1 != nil || err2 != nil {
+		return nil, errBadSession
+	}
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errBadSession
+	}
+	var s session
+	if err := json.Unmarshal(payload, &s); err != nil || time.Now().After(s.Expires) {
+		return nil, errBadSession
+	}
+	return &Principal{Subject: s.Subject, Scopes: s.Roles}, nil
+}
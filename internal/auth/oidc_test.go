@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"location/internal/config"
+)
+
+func newTestOIDC() *OIDC {
+	return &OIDC{
+		conf: config.OIDCConfig{
+			GroupsClaim: "groups",
+			GroupRoles:  map[string]string{"ops": "admin", "ops-oncall": "admin", "dispatch": "dispatcher"},
+			SessionTTL:  config.Duration{Duration: time.Hour},
+		},
+		secret: []byte(strings.Repeat("s", 32)),
+	}
+}
+
+func TestRolesFromGroups(t *testing.T) {
+	o := newTestOIDC()
+	for _, tc := range []struct {
+		groups interface{}
+		want   []string
+	}{
+		{[]interface{}{"ops", "eng", "ops-oncall", "dispatch"}, []string{"admin", "dispatcher"}},
+		{[]interface{}{"eng", 42}, nil},
+		{"ops", nil},
+		{nil, nil},
+	} {
+		if got := o.roles(map[string]interface{}{"groups": tc.groups}); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("roles of %v = %v, want %v", tc.groups, got, tc.want)
+		}
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	o := newTestOIDC()
+	principal := func(value string) (*Principal, error) {
+		r := httptest.NewRequest(http.MethodGet, "/admin/", nil)
+		if value != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+		}
+		return o.principal(r)
+	}
+
+	valid := o.sign(session{Subject: "ann", Roles: []string{"admin"}, Expires: time.Now().Add(time.Hour)})
+	if p, err := principal(valid); err != nil || p.Subject != "ann" || !p.Has(ScopeAdmin) {
+		t.Errorf("valid session gave %+v, %v", p, err)
+	}
+	if p, err := principal(""); p != nil || err != nil {
+		t.Errorf("no session gave %+v, %v", p, err)
+	}
+
+	payload, _, _ := strings.Cut(valid, ".")
+	other := &OIDC{secret: []byte(strings.Repeat("t", 32))}
+	for name, value := range map[string]string{
+		"expired":  o.sign(session{Subject: "ann", Roles: []string{"admin"}, Expires: time.Now().Add(-time.Second)}),
+		"unsigned": payload,
+		"tampered": strings.Replace(valid, payload, payload[:len(payload)-2]+"xx", 1),
+		"foreign":  other.sign(session{Subject: "ann", Roles: []string{"admin"}, Expires: time.Now().Add(time.Hour)}),
+	} {
+		if p, err := principal(value); p != nil || err != errBadSession {
+			t.Errorf("%s session gave %+v, %v", name, p, err)
+		}
+	}
+}
+
+func TestCallbackChecksState(t *testing.T) {
+	o := newTestOIDC()
+	login := httptest.NewRecorder()
+	o.Login(login, httptest.NewRequest(http.MethodGet, "/auth/login?next=//evil.example", nil))
+	state := login.Result().Cookies()[0]
+	if !strings.HasSuffix(state.Value, "|/admin/") {
+		t.Errorf("state cookie %q sends users off-site", state.Value)
+	}
+
+	callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/auth/callback?"+query, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		o.Callback(w, r)
+		return w
+	}
+	nonce, _, _ := strings.Cut(state.Value, "|")
+	if w := callback("code=c&state="+nonce, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("without the state cookie got %d", w.Code)
+	}
+	if w := callback("code=c&state=forged", state); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid login state") {
+		t.Errorf("with a forged state got %d %q", w.Code, w.Body)
+	}
+	// With the state matching, the code goes to the provider, which is not
+	// there
+	if w := callback("code=c&state="+nonce, state); w.Code != http.StatusUnauthorized {
+		t.Errorf("with the state got %d", w.Code)
+	}
+}
+
+func TestOperatorRolesAreScopes(t *testing.T) {
+	if want := []string{ScopeDriver, ScopeCustomer, ScopeDispatcher, ScopeAdmin}; !reflect.DeepEqual(config.OperatorRoles, want) {
+		t.Errorf("operator roles %v, want the scopes %v", config.OperatorRoles, want)
+	}
+}
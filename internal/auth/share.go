@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ScopeShare is granted by share links: read-only access to a single order.
+const ScopeShare = "share"
+
+// shareParam is the query parameter share links carry their token in, so
+// they work as plain URLs sent to customers.
+const shareParam = "share"
+
+// Shares mints and verifies share links. A link is an HMAC-signed JWT for a
+// single order that expires, so a customer can follow their delivery
+// without an account and without seeing any other order.
+type Shares struct {
+	secret []byte
+	maxTTL time.Duration
+	parser *jwt.Parser
+}
+
+// NewShares signs links with secret. Links live for at most maxTTL.
+func NewShares(secret string, maxTTL time.Duration) *Shares {
+	return &Shares{
+		secret: []byte(secret),
+		maxTTL: maxTTL,
+		parser: jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired()),
+	}
+}
+
+// MaxTTL is the longest a link may live, and the default lifetime.
+func (s *Shares) MaxTTL() time.Duration {
+	return s.maxTTL
+}
+
+// Mint returns a token for orderID of the named tenant that expires after
+// ttl, capped at MaxTTL.
+func (s *Shares) Mint(tenant, orderID string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 || ttl > s.maxTTL {
+		ttl = s.maxTTL
+	}
+	now := time.Now()
+	expires := now.Add(ttl).Truncate(time.Second)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		Scope:  ScopeShare,
+		Orders: []string{orderID},
+		Tenant: tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "share:" + orderID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}).SignedString(s.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign share token: %v", err)
+	}
+	return token, expires, nil
+}
+
+// Verify checks a share token and returns the caller it stands for. Only the
+// share scope is ever granted, whatever else the claims may say.
+func (s *Shares) Verify(token string) (*Principal, error) {
+	var claims Claims
+	_, err := s.parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	})
+	if err != nil || claims.Scope != ScopeShare || len(claims.Orders) != 1 {
+		log.Printf("rejected share token: %v", err)
+		return nil, errInvalidToken
+	}
+	return &Principal{
+		Subject: claims.Subject,
+		Scopes:  []string{ScopeShare},
+		Orders:  claims.Orders,
+		Tenant:  claims.Tenant,
+	}, nil
+}
@@ -0,0 +1,71 @@
+// Package chaos injects faults into Redis and route provider calls so the
+// service's failure handling can be exercised on purpose. It must never be
+// enabled in production.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// ErrInjected is returned by calls the injector decided to fail.
+var ErrInjected = errors.New("chaos: injected fault")
+
+// inject sleeps and/or fails according to the rates. It honours ctx so an
+// injected delay never outlives the caller.
+func inject(ctx context.Context, f config.FaultRates) error {
+	if f.DelayRate > 0 && rand.Float64() < f.DelayRate && f.MaxDelay.Duration > 0 {
+		delay := time.Duration(rand.Int63n(int64(f.MaxDelay.Duration)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.FailRate > 0 && rand.Float64() < f.FailRate {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Hook injects faults into every Redis command.
+type Hook struct {
+	Rates config.FaultRates
+}
+
+func (h Hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, inject(ctx, h.Rates)
+}
+
+func (h Hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h Hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, inject(ctx, h.Rates)
+}
+
+func (h Hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// Provider injects faults in front of a route provider.
+type Provider struct {
+	Next  routing.Provider
+	Rates config.FaultRates
+}
+
+func (p Provider) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	if err := inject(ctx, p.Rates); err != nil {
+		return 0, err
+	}
+	return p.Next.TravelTime(ctx, origin, destination, mode)
+}
@@ -0,0 +1,1358 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+
+	"location/internal/discovery"
+	"location/internal/egress"
+	"location/internal/geo"
+	"location/internal/geocode"
+	"location/internal/ingest"
+	"location/internal/notify"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/weather"
+)
+
+// Configuration is read from a JSON, YAML or TOML file (chosen by extension)
+// and then overridden by environment variables and command-line flags.
+type Configuration struct {
+	Server     ServerConfig     `json:"server" yaml:"server" toml:"server"`
+	Redis      RedisConfig      `json:"redis" yaml:"redis" toml:"redis"`
+	Maps       MapsConfig       `json:"maps" yaml:"maps" toml:"maps"`
+	Publisher  PublisherConfig  `json:"publisher" yaml:"publisher" toml:"publisher"`
+	Cache      CacheConfig      `json:"cache" yaml:"cache" toml:"cache"`
+	Workers    WorkersConfig    `json:"workers" yaml:"workers" toml:"workers"`
+	Tracking   TrackingConfig   `json:"tracking" yaml:"tracking" toml:"tracking"`
+	Weather    WeatherConfig    `json:"weather" yaml:"weather" toml:"weather"`
+	Ingest     IngestConfig     `json:"ingest" yaml:"ingest" toml:"ingest"`
+	Notify     NotifyConfig     `json:"notify" yaml:"notify" toml:"notify"`
+	Auth       AuthConfig       `json:"auth" yaml:"auth" toml:"auth"`
+	Privacy    PrivacyConfig    `json:"privacy" yaml:"privacy" toml:"privacy"`
+	Tenancy    TenancyConfig    `json:"tenancy" yaml:"tenancy" toml:"tenancy"`
+	Vault      VaultConfig      `json:"vault" yaml:"vault" toml:"vault"`
+	Chaos      ChaosConfig      `json:"chaos" yaml:"chaos" toml:"chaos"`
+	Discovery  DiscoveryConfig  `json:"discovery" yaml:"discovery" toml:"discovery"`
+	Egress     EgressConfig     `json:"egress" yaml:"egress" toml:"egress"`
+	Simulation SimulationConfig `json:"simulation" yaml:"simulation" toml:"simulation"`
+}
+
+// SimulationConfig shapes the synthetic drivers of "serve -simulate" and
+// the simulate command.
+type SimulationConfig struct {
+	// Center, as "lat,lng", and Radius, in meters, bound where drivers
+	// start and orders are delivered.
+	Center string  `json:"center" yaml:"center" toml:"center"`
+	Radius float64 `json:"radius" yaml:"radius" toml:"radius"`
+	// Interval is how often drivers post their position, and Speedup how
+	// many times faster than real time trips are played.
+	Interval Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Speedup  float64  `json:"speedup" yaml:"speedup" toml:"speedup"`
+	Mode     string   `json:"mode" yaml:"mode" toml:"mode"`
+	// Router finds the roads driven: "google", with maps.api_key; "osrm"
+	// at RouterURL, the public demo server by default; or "haversine" for
+	// straight lines. Empty is Google with an API key and OSRM otherwise.
+	Router    string `json:"router" yaml:"router" toml:"router"`
+	RouterURL string `json:"router_url" yaml:"router_url" toml:"router_url"`
+	// Prefix starts the IDs of simulated drivers and orders.
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+}
+
+// EgressConfig shapes the HTTP clients the service calls map, geocoding
+// and weather providers, webhooks and other services with.
+type EgressConfig struct {
+	// Proxy is the URL of the proxy calls go through, except to the hosts
+	// in NoProxy, written as in NO_PROXY; without one, HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY are used.
+	Proxy   string `json:"proxy" yaml:"proxy" toml:"proxy"`
+	NoProxy string `json:"no_proxy" yaml:"no_proxy" toml:"no_proxy"`
+	// CAFile is a PEM bundle of certificate authorities to trust besides
+	// the system's, such as the proxy's.
+	CAFile string `json:"ca_file" yaml:"ca_file" toml:"ca_file"`
+	// Connection pool: idle connections kept in all and to each host,
+	// connections to each host in all, 0 for no limit, and how long idle
+	// ones are kept.
+	MaxIdleConns        int      `json:"max_idle_conns" yaml:"max_idle_conns" toml:"max_idle_conns"`
+	MaxIdleConnsPerHost int      `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int      `json:"max_conns_per_host" yaml:"max_conns_per_host" toml:"max_conns_per_host"`
+	IdleConnTimeout     Duration `json:"idle_conn_timeout" yaml:"idle_conn_timeout" toml:"idle_conn_timeout"`
+	// Timeouts bound each call by what it calls: "maps", "geocode",
+	// "weather", "notify", "publish" or "discovery". Calls to the maps
+	// APIs are otherwise only bounded by the request or latency budget.
+	Timeouts map[string]Duration `json:"timeouts" yaml:"timeouts" toml:"timeouts"`
+}
+
+// Options returns the options of the egress clients.
+func (e EgressConfig) Options() egress.Options {
+	o := egress.Options{
+		Proxy:               e.Proxy,
+		NoProxy:             e.NoProxy,
+		CAFile:              e.CAFile,
+		MaxIdleConns:        e.MaxIdleConns,
+		MaxIdleConnsPerHost: e.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     e.MaxConnsPerHost,
+		IdleConnTimeout:     e.IdleConnTimeout.Duration,
+		Timeouts:            map[string]time.Duration{},
+	}
+	for purpose, d := range e.Timeouts {
+		o.Timeouts[purpose] = d.Duration
+	}
+	return o
+}
+
+// DiscoveryConfig registers the instance with Consul or etcd while it
+// runs, with its health, so a service mesh finds it.
+type DiscoveryConfig struct {
+	// Registry is "consul" or "etcd"; empty registers nowhere. URL is the
+	// Consul agent or etcd endpoint, the local one by default, and Token
+	// its ACL or auth token.
+	Registry string `json:"registry" yaml:"registry" toml:"registry"`
+	URL      string `json:"url" yaml:"url" toml:"url"`
+	Token    string `json:"token" yaml:"token" toml:"token"`
+	// Service names the service; ID the instance, by default the host name
+	// and port; and Address where it is reached, by default the listen
+	// address's host or else the host name.
+	Service string            `json:"service" yaml:"service" toml:"service"`
+	ID      string            `json:"id" yaml:"id" toml:"id"`
+	Address string            `json:"address" yaml:"address" toml:"address"`
+	Tags    []string          `json:"tags" yaml:"tags" toml:"tags"`
+	Meta    map[string]string `json:"meta" yaml:"meta" toml:"meta"`
+	// TTL is how long the registration lasts without a health report;
+	// reports go every third of it.
+	TTL Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+	// Prefix starts the etcd keys of instances, which are the prefix,
+	// service and ID joined by slashes.
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+}
+
+// ChaosConfig enables fault injection for resilience testing. It must never
+// be enabled in production.
+type ChaosConfig struct {
+	Enabled  bool       `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Redis    FaultRates `json:"redis" yaml:"redis" toml:"redis"`
+	Provider FaultRates `json:"provider" yaml:"provider" toml:"provider"`
+}
+
+// FaultRates describes how often calls are delayed or failed. Rates are
+// probabilities between 0 and 1; delays are uniformly random up to MaxDelay.
+type FaultRates struct {
+	DelayRate float64  `json:"delay_rate" yaml:"delay_rate" toml:"delay_rate"`
+	MaxDelay  Duration `json:"max_delay" yaml:"max_delay" toml:"max_delay"`
+	FailRate  float64  `json:"fail_rate" yaml:"fail_rate" toml:"fail_rate"`
+}
+
+type ServerConfig struct {
+	// ListenAddr is a TCP host:port, a Unix socket as "unix:" and its path,
+	// or "systemd" for the socket systemd activated the service with, or
+	// "systemd:" and the FileDescriptorName= of one of several.
+	ListenAddr string `json:"listen_addr" yaml:"listen_addr" toml:"listen_addr"`
+	// SocketMode is the octal permissions of a Unix socket, such as "0660"
+	// for a reverse proxy in the service's group.
+	SocketMode string `json:"socket_mode" yaml:"socket_mode" toml:"socket_mode"`
+	LogLevel   string `json:"log_level" yaml:"log_level" toml:"log_level"`
+	Storage    string `json:"storage" yaml:"storage" toml:"storage"`
+	RecordFile string `json:"record_file" yaml:"record_file" toml:"record_file"`
+	// RequestTimeout bounds the Redis and provider work done for one request.
+	RequestTimeout Duration `json:"request_timeout" yaml:"request_timeout" toml:"request_timeout"`
+	// LatencyBudgets replace RequestTimeout for the endpoints named by
+	// their route, such as "/location/current" or "/order/{id}/eta". Of a
+	// budget, ProviderShare is left to routing and the rest to Redis; ETAs
+	// not routed in time are answered degraded, from the last ETA or a
+	// straight-line estimate, instead of failing.
+	LatencyBudgets map[string]Duration `json:"latency_budgets" yaml:"latency_budgets" toml:"latency_budgets"`
+	ProviderShare  float64             `json:"provider_share" yaml:"provider_share" toml:"provider_share"`
+	// ShutdownTimeout is how long in-flight work may take to finish on exit.
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// GRPCListenAddr enables the gRPC admin service on this address.
+	GRPCListenAddr string `json:"grpc_listen_addr" yaml:"grpc_listen_addr" toml:"grpc_listen_addr"`
+	// H2C serves HTTP/2 without TLS, as well as HTTP/1.1, for a load
+	// balancer that speaks it to the service. MaxConcurrentStreams bounds
+	// the requests in flight on one HTTP/2 connection.
+	H2C                  bool   `json:"h2c" yaml:"h2c" toml:"h2c"`
+	MaxConcurrentStreams uint32 `json:"max_concurrent_streams" yaml:"max_concurrent_streams" toml:"max_concurrent_streams"`
+	// IdleTimeout is how long a kept-alive connection waits for its next
+	// request; longer than drivers take between updates, they keep theirs.
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`
+	// ReadHeaderTimeout bounds reading the headers of a request.
+	ReadHeaderTimeout Duration `json:"read_header_timeout" yaml:"read_header_timeout" toml:"read_header_timeout"`
+	// MaxConnections bounds the connections open at once; more wait to be
+	// accepted. 0 is no limit.
+	MaxConnections int `json:"max_connections" yaml:"max_connections" toml:"max_connections"`
+}
+
+// ListensOnTCP reports whether the service listens on a TCP host:port of
+// its own, rather than a Unix socket or one passed by systemd.
+func (s ServerConfig) ListensOnTCP() bool {
+	return !strings.HasPrefix(s.ListenAddr, "unix:") && s.ListenAddr != "systemd" && !strings.HasPrefix(s.ListenAddr, "systemd:")
+}
+
+// Socket returns the permissions of a Unix socket, 0 for the default.
+func (s ServerConfig) Socket() os.FileMode {
+	mode, _ := strconv.ParseUint(s.SocketMode, 8, 32)
+	return os.FileMode(mode)
+}
+
+type RedisConfig struct {
+	URL string `json:"url" yaml:"url" toml:"url"`
+	// KeyPrefix starts every key the service keeps, such as "esd:", to
+	// share a server with other applications. Existing keys are moved into
+	// it with "location migrate -move-keys".
+	KeyPrefix string `json:"key_prefix" yaml:"key_prefix" toml:"key_prefix"`
+	// Replicas are the URLs of read replicas of URL. Reads of orders,
+	// drivers and histories for GET requests, such as customers polling
+	// ETAs, go to them in turn, and may lag the primary slightly.
+	Replicas []string `json:"replicas" yaml:"replicas" toml:"replicas"`
+	// Backplane relays order changes between instances over Redis pub/sub,
+	// so event streams see updates whichever replica handled them.
+	Backplane bool `json:"backplane" yaml:"backplane" toml:"backplane"`
+	// LegacyPoints keeps writing points as the "lat,lng" fields versions
+	// before numeric point fields read, for those still running during a
+	// rolling deploy. Turn it off once none is left, then run "location
+	// migrate".
+	LegacyPoints bool `json:"legacy_points" yaml:"legacy_points" toml:"legacy_points"`
+}
+
+type MapsConfig struct {
+	APIKey   string `json:"api_key" yaml:"api_key" toml:"api_key"`
+	Provider string `json:"provider" yaml:"provider" toml:"provider"`
+	// DailyQuota is the number of Google requests the API key may make per
+	// day, shown to operators next to the usage; zero means unknown.
+	DailyQuota int `json:"daily_quota" yaml:"daily_quota" toml:"daily_quota"`
+	// Vehicle is what couriers who drive run on, for estimating route
+	// costs: gasoline, diesel, hybrid or electric. EnergyPerKm is the
+	// consumption of electric vehicles in kWh.
+	Vehicle     string  `json:"vehicle" yaml:"vehicle" toml:"vehicle"`
+	EnergyPerKm float64 `json:"energy_per_km" yaml:"energy_per_km" toml:"energy_per_km"`
+	// IsochroneProvider enables GET /isochrone through "openrouteservice"
+	// or "valhalla" at IsochroneURL; OpenRouteService defaults to its
+	// public API, which needs IsochroneAPIKey.
+	IsochroneProvider string `json:"isochrone_provider" yaml:"isochrone_provider" toml:"isochrone_provider"`
+	IsochroneURL      string `json:"isochrone_url" yaml:"isochrone_url" toml:"isochrone_url"`
+	IsochroneAPIKey   string `json:"isochrone_api_key" yaml:"isochrone_api_key" toml:"isochrone_api_key"`
+	// What3WordsAPIKey enables targets given as what3words addresses.
+	// Plus Codes need no key, except short ones, which are resolved with
+	// APIKey.
+	What3WordsAPIKey string `json:"what3words_api_key" yaml:"what3words_api_key" toml:"what3words_api_key"`
+	// Geocoder resolves targets given as street addresses: "google", with
+	// APIKey, or "nominatim" at NominatimURL, which is free but takes at
+	// most one request every NominatimInterval.
+	Geocoder          string   `json:"geocoder" yaml:"geocoder" toml:"geocoder"`
+	NominatimURL      string   `json:"nominatim_url" yaml:"nominatim_url" toml:"nominatim_url"`
+	NominatimInterval Duration `json:"nominatim_interval" yaml:"nominatim_interval" toml:"nominatim_interval"`
+	// AddressCacheTTL is how long resolved addresses are remembered.
+	AddressCacheTTL Duration `json:"address_cache_ttl" yaml:"address_cache_ttl" toml:"address_cache_ttl"`
+	// Modes tune how orders of each travel mode, such as "walking", are
+	// recalculated.
+	Modes map[string]ModeConfig `json:"modes" yaml:"modes" toml:"modes"`
+	// TrafficProfiles corrects the haversine estimates, which know no
+	// traffic, with how fast couriers were seen to move.
+	TrafficProfiles TrafficProfilesConfig `json:"traffic_profiles" yaml:"traffic_profiles" toml:"traffic_profiles"`
+}
+
+// TrafficProfilesConfig learns courier speeds per area, travel mode and hour
+// of the week from their locations.
+type TrafficProfilesConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// Precision is the length of the geohash cells speeds are learned in,
+	// from 1 to 12; 5 is about 5km across.
+	Precision int `json:"precision" yaml:"precision" toml:"precision"`
+	// MinObserved is how long couriers must have been seen moving in a
+	// cell at an hour of the week before its speed is trusted.
+	MinObserved Duration `json:"min_observed" yaml:"min_observed" toml:"min_observed"`
+}
+
+// ModeConfig overrides the provider and caching for one travel mode. Unset
+// fields keep the general setting.
+type ModeConfig struct {
+	// Provider routes the mode in place of the selected provider and of a
+	// tenant's providers, such as "haversine" for walking.
+	Provider string `json:"provider" yaml:"provider" toml:"provider"`
+	// RecomputeInterval is how long an ETA is counted down before the
+	// courier's locations recalculate it, even with debouncing off.
+	RecomputeInterval Duration `json:"recompute_interval" yaml:"recompute_interval" toml:"recompute_interval"`
+	// CacheTTL is how long travel times are cached, even with caching off.
+	CacheTTL Duration `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+	// HandlingTime replaces tracking.handling_time for the mode when set,
+	// such as a parking buffer for driving.
+	HandlingTime Duration `json:"handling_time" yaml:"handling_time" toml:"handling_time"`
+}
+
+type PublisherConfig struct {
+	// Kind is where events go: "websocket" to URL, "eventbridge", "pubsub"
+	// or "servicebus".
+	Kind string `json:"kind" yaml:"kind" toml:"kind"`
+	URL  string `json:"url" yaml:"url" toml:"url"`
+	// SchemaVersions are the payload versions every event is sent in, such
+	// as [1, 2] while consumers move from one to the other.
+	SchemaVersions []int             `json:"schema_versions" yaml:"schema_versions" toml:"schema_versions"`
+	EventBridge    EventBridgeConfig `json:"eventbridge" yaml:"eventbridge" toml:"eventbridge"`
+	PubSub         PubSubConfig      `json:"pubsub" yaml:"pubsub" toml:"pubsub"`
+	ServiceBus     ServiceBusConfig  `json:"servicebus" yaml:"servicebus" toml:"servicebus"`
+}
+
+// EventBridgeConfig puts events on an Amazon EventBridge bus, signed with
+// the default AWS credential chain.
+type EventBridgeConfig struct {
+	Bus    string `json:"bus" yaml:"bus" toml:"bus"`
+	Source string `json:"source" yaml:"source" toml:"source"`
+	// DetailType is a text/template of the event, such as
+	// "Order {{.Type}}", for rules to match on.
+	DetailType string `json:"detail_type" yaml:"detail_type" toml:"detail_type"`
+	// Region defaults to the one of the environment.
+	Region string `json:"region" yaml:"region" toml:"region"`
+}
+
+// PubSubConfig publishes events to a Google Cloud Pub/Sub topic, keyed by
+// order for ordered delivery.
+type PubSubConfig struct {
+	// Topic is the full name, projects/PROJECT/topics/TOPIC.
+	Topic string `json:"topic" yaml:"topic" toml:"topic"`
+	// CredentialsFile is a service account key; application default
+	// credentials are used without one.
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file" toml:"credentials_file"`
+	// Endpoint overrides the global API endpoint, such as with a regional
+	// one for ordered delivery.
+	Endpoint string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
+}
+
+// ServiceBusConfig sends events to an Azure Service Bus topic, with the
+// order as session.
+type ServiceBusConfig struct {
+	Topic string `json:"topic" yaml:"topic" toml:"topic"`
+	// ConnectionString authenticates with a shared access key. Without one
+	// the host's managed identity is used for Namespace, such as
+	// acme.servicebus.windows.net, and ClientID picks a user-assigned one.
+	ConnectionString string `json:"connection_string" yaml:"connection_string" toml:"connection_string"`
+	Namespace        string `json:"namespace" yaml:"namespace" toml:"namespace"`
+	ClientID         string `json:"client_id" yaml:"client_id" toml:"client_id"`
+}
+
+type CacheConfig struct {
+	Enabled          bool     `json:"enabled" yaml:"enabled" toml:"enabled"`
+	TTL              Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+	Debounce         bool     `json:"debounce" yaml:"debounce" toml:"debounce"`
+	DebounceInterval Duration `json:"debounce_interval" yaml:"debounce_interval" toml:"debounce_interval"`
+	// CoalesceWindow enables sharing travel times between orders going to
+	// the same destination from origins within CoalesceRadius meters, such
+	// as a food court's, for this long after one is calculated.
+	CoalesceWindow Duration `json:"coalesce_window" yaml:"coalesce_window" toml:"coalesce_window"`
+	CoalesceRadius float64  `json:"coalesce_radius" yaml:"coalesce_radius" toml:"coalesce_radius"`
+	// ResponseTTL keeps rendered order, ETA and route reads in the store
+	// for this long, per order revision, so polling clients are answered
+	// without loading the order; 0 renders every read.
+	ResponseTTL Duration `json:"response_ttl" yaml:"response_ttl" toml:"response_ttl"`
+	// LocalEntries keeps up to this many hot values in process for at most
+	// LocalTTL in front of the store: order revisions, cached responses
+	// and travel times, so polling reads skip Redis. Revisions are dropped
+	// when their order changes, on every instance if changes are relayed
+	// over the backplane. 0 turns this off; both are read at startup.
+	LocalEntries int      `json:"local_entries" yaml:"local_entries" toml:"local_entries"`
+	LocalTTL     Duration `json:"local_ttl" yaml:"local_ttl" toml:"local_ttl"`
+}
+
+// Backpressure policies of the travel time workers.
+const (
+	BackpressureBlock = "block"
+	BackpressureShed  = "shed"
+)
+
+// WorkersConfig moves travel time calculations off request goroutines onto
+// a fixed pool of workers, keeping provider concurrency, and so tail
+// latency, bounded under bursts.
+type WorkersConfig struct {
+	// Count is the number of workers; 0 calculates on the request
+	// goroutines.
+	Count int `json:"count" yaml:"count" toml:"count"`
+	// QueueSize bounds the calculations waiting for a worker.
+	QueueSize int `json:"queue_size" yaml:"queue_size" toml:"queue_size"`
+	// Backpressure is what a full queue does: "block" waits for room, up
+	// to the request timeout, and "shed" fails at once with a 503.
+	Backpressure string `json:"backpressure" yaml:"backpressure" toml:"backpressure"`
+	// Urgent orders are calculated ahead of routine refreshes while the
+	// queue is busy: those whose metadata sets PriorityKey to "high", and
+	// those arriving within UrgentWithin.
+	PriorityKey  string   `json:"priority_key" yaml:"priority_key" toml:"priority_key"`
+	UrgentWithin Duration `json:"urgent_within" yaml:"urgent_within" toml:"urgent_within"`
+}
+
+// TrackingConfig controls how silent couriers are detected.
+type TrackingConfig struct {
+	// StaleAfter is how long a courier may go without reporting before its
+	// order's ETA is marked stale and tracking is reported lost.
+	StaleAfter Duration `json:"stale_after" yaml:"stale_after" toml:"stale_after"`
+	// WatchdogInterval is how often orders are checked for silent couriers.
+	WatchdogInterval Duration `json:"watchdog_interval" yaml:"watchdog_interval" toml:"watchdog_interval"`
+	// ScheduleInterval is how often the ETAs of deliveries planned ahead
+	// are reconsidered; closer to departure they are refreshed more often.
+	ScheduleInterval Duration `json:"schedule_interval" yaml:"schedule_interval" toml:"schedule_interval"`
+	// WarmLead is how long before departure the route of a planned
+	// delivery is calculated ahead and kept, so the first live update after
+	// it sets off needs no provider call; 0 turns warming off. Routes are
+	// only warmed within the OffPeak windows, such as "22:00-06:00" in the
+	// server's local time, when there are any.
+	WarmLead Duration `json:"warm_lead" yaml:"warm_lead" toml:"warm_lead"`
+	OffPeak  []string `json:"off_peak" yaml:"off_peak" toml:"off_peak"`
+	// PickupRadius is how close in metres a courier must come to the pickup
+	// for the order to move on to its dropoff phase.
+	PickupRadius float64 `json:"pickup_radius" yaml:"pickup_radius" toml:"pickup_radius"`
+	// ArrivalRadius is how close in metres a courier must come to the
+	// target to count as arrived in ETAs and events.
+	ArrivalRadius float64 `json:"arrival_radius" yaml:"arrival_radius" toml:"arrival_radius"`
+	// StationaryRadius counts the ETA down instead of routing again while
+	// the courier stays within this many metres of where it was last
+	// routed from, such as when parked; 0 always routes.
+	StationaryRadius float64 `json:"stationary_radius" yaml:"stationary_radius" toml:"stationary_radius"`
+	// HandlingTime is added to every routed trip, as raw provider times
+	// leave out parking and walking to the door; orders may add their own,
+	// such as loading dock time.
+	HandlingTime Duration `json:"handling_time" yaml:"handling_time" toml:"handling_time"`
+	// DwellAfter reports couriers that stay within DwellRadius metres for
+	// longer mid-route, away from the pickup and target, with a dwell
+	// event; 0 turns this off.
+	DwellAfter  Duration `json:"dwell_after" yaml:"dwell_after" toml:"dwell_after"`
+	DwellRadius float64  `json:"dwell_radius" yaml:"dwell_radius" toml:"dwell_radius"`
+	// ETASpread is the share of the ETA by which the courier may be late;
+	// it may be early by half as much. Windows are never narrower than
+	// ETAMinSpread, nor than recent predictions have drifted.
+	ETASpread    float64  `json:"eta_spread" yaml:"eta_spread" toml:"eta_spread"`
+	ETAMinSpread Duration `json:"eta_min_spread" yaml:"eta_min_spread" toml:"eta_min_spread"`
+	// MaxAccuracy rejects courier locations whose reported accuracy, in
+	// metres, is worse; 0 accepts all of them.
+	MaxAccuracy float64 `json:"max_accuracy" yaml:"max_accuracy" toml:"max_accuracy"`
+	// MaxSpeed rejects courier locations that could only have been reached
+	// from the last one at a higher speed, in m/s; 0 accepts all of them.
+	MaxSpeed float64 `json:"max_speed" yaml:"max_speed" toml:"max_speed"`
+	// Geofences apply to every order, on top of the order's own.
+	Geofences []geo.Fence `json:"geofences" yaml:"geofences" toml:"geofences"`
+	// HeatmapPrecision is the length of the geohash cells the heatmap
+	// counts orders and drivers in, from 1 to 12.
+	HeatmapPrecision int `json:"heatmap_precision" yaml:"heatmap_precision" toml:"heatmap_precision"`
+	// ImplicitOrders creates orders with their first location update, as
+	// before orders were created with POST /orders, for backends still
+	// migrating; otherwise updates of unknown orders are refused.
+	ImplicitOrders bool `json:"implicit_orders" yaml:"implicit_orders" toml:"implicit_orders"`
+	// Lenient accepts locations of orders still missing the courier or
+	// target location with 202 and no ETA, calculating it once both are
+	// known; otherwise such updates fail.
+	Lenient bool `json:"lenient" yaml:"lenient" toml:"lenient"`
+}
+
+// OffPeakAt reports whether at falls within one of the off-peak windows,
+// or there are none.
+func (c TrackingConfig) OffPeakAt(at time.Time) bool {
+	if len(c.OffPeak) == 0 {
+		return true
+	}
+	now := time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+	for _, w := range c.OffPeak {
+		from, to, err := parseWindow(w)
+		if err == nil && inWindow(from, to, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// InWindow reports whether at falls within a daily window such as
+// "22:00-06:00", in at's location.
+func InWindow(w string, at time.Time) (bool, error) {
+	from, to, err := parseWindow(w)
+	if err != nil {
+		return false, err
+	}
+	return inWindow(from, to, time.Duration(at.Hour())*time.Hour+time.Duration(at.Minute())*time.Minute), nil
+}
+
+func inWindow(from, to, now time.Duration) bool {
+	// Windows such as 22:00-06:00 run past midnight
+	return from <= now && now < to || to < from && (now >= from || now < to)
+}
+
+// parseWindow parses a daily window such as "22:00-06:00" into its times
+// after midnight.
+func parseWindow(w string) (from, to time.Duration, err error) {
+	start, end, ok := strings.Cut(w, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", w)
+	}
+	var times [2]time.Duration
+	for i, s := range []string{start, end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(s))
+		if err != nil {
+			return 0, 0, fmt.Errorf("window %q is not HH:MM-HH:MM", w)
+		}
+		times[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return times[0], times[1], nil
+}
+
+// WeatherConfig enables stretching ETAs in bad weather.
+type WeatherConfig struct {
+	// Provider is "openweathermap", or empty to leave ETAs as routed.
+	Provider string `json:"provider" yaml:"provider" toml:"provider"`
+	APIKey   string `json:"api_key" yaml:"api_key" toml:"api_key"`
+	// CacheTTL is how long the conditions in an area, about 5km across,
+	// are reused.
+	CacheTTL Duration `json:"cache_ttl" yaml:"cache_ttl" toml:"cache_ttl"`
+	// Multipliers scale ETAs by conditions ("rain" or "snow") and then by
+	// travel mode. Modes without a multiplier are not adjusted.
+	Multipliers map[string]map[string]float64 `json:"multipliers" yaml:"multipliers" toml:"multipliers"`
+}
+
+// IngestConfig enables listeners for hardware GPS trackers that cannot
+// make HTTP requests.
+type IngestConfig struct {
+	// TCPListenAddr and UDPListenAddr accept tracker packets on these
+	// addresses. The text and nmea protocols work over UDP, teltonika only
+	// over TCP.
+	TCPListenAddr string `json:"tcp_listen_addr" yaml:"tcp_listen_addr" toml:"tcp_listen_addr"`
+	UDPListenAddr string `json:"udp_listen_addr" yaml:"udp_listen_addr" toml:"udp_listen_addr"`
+	// Protocol is how packets are framed: "text", "nmea" or "teltonika".
+	Protocol string `json:"protocol" yaml:"protocol" toml:"protocol"`
+	// Devices maps tracker IDs, usually IMEIs, to the drivers carrying
+	// them. Fixes from unmapped trackers are dropped, as anyone who can
+	// reach the listeners can send them.
+	Devices map[string]string `json:"devices" yaml:"devices" toml:"devices"`
+}
+
+// NotifyConfig posts operational events to chat channels.
+type NotifyConfig struct {
+	Channels []ChannelConfig `json:"channels" yaml:"channels" toml:"channels"`
+	// CheckInterval is how often route provider usage is checked for
+	// failures and the quota running low.
+	CheckInterval Duration `json:"check_interval" yaml:"check_interval" toml:"check_interval"`
+
+	// Email, SMS and Push enable messaging customers on the channels they
+	// chose; by default an arrival confirmation and delay notices.
+	Email EmailConfig `json:"email" yaml:"email" toml:"email"`
+	SMS   SMSConfig   `json:"sms" yaml:"sms" toml:"sms"`
+	Push  PushConfig  `json:"push" yaml:"push" toml:"push"`
+	// Templates is a file of text/template definitions adding to or
+	// replacing the built-in "delivered" and "delay" messages; the first
+	// line of each is the title. Definitions such as "sms:delay" or
+	// "delay.de" are preferred on that channel or for that locale.
+	Templates string `json:"templates" yaml:"templates" toml:"templates"`
+	// Rules say which events customers are messaged about, with which
+	// template. They default to "delivered" on delivered, and "delay" on
+	// sla_at_risk and sla_breached.
+	Rules []RuleConfig `json:"rules" yaml:"rules" toml:"rules"`
+	// PublicURL is where customers reach this service, for tracking links
+	// to /track pages. Links need auth.share_secret.
+	PublicURL string `json:"public_url" yaml:"public_url" toml:"public_url"`
+}
+
+// RuleConfig messages customers about events with a template, on any of
+// their channels or only those listed.
+type RuleConfig struct {
+	Events   []string `json:"events" yaml:"events" toml:"events"`
+	Template string   `json:"template" yaml:"template" toml:"template"`
+	Channels []string `json:"channels" yaml:"channels" toml:"channels"`
+}
+
+// SMSConfig enables texting customers who chose SMS through Twilio.
+type SMSConfig struct {
+	AccountSID string `json:"account_sid" yaml:"account_sid" toml:"account_sid"`
+	AuthToken  string `json:"auth_token" yaml:"auth_token" toml:"auth_token"`
+	// From is the sending number, or a messaging service SID.
+	From string `json:"from" yaml:"from" toml:"from"`
+}
+
+// PushConfig enables push notifications to customers who chose push
+// through Firebase Cloud Messaging.
+type PushConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled" toml:"enabled"`
+	// ProjectID defaults to the project of the credentials.
+	ProjectID string `json:"project_id" yaml:"project_id" toml:"project_id"`
+	// CredentialsFile is a service account key; without one, Application
+	// Default Credentials are used.
+	CredentialsFile string `json:"credentials_file" yaml:"credentials_file" toml:"credentials_file"`
+}
+
+// EmailConfig enables emailing customers who chose the email channel.
+type EmailConfig struct {
+	// SMTPAddr is the host:port of the submission server.
+	SMTPAddr string `json:"smtp_addr" yaml:"smtp_addr" toml:"smtp_addr"`
+	From     string `json:"from" yaml:"from" toml:"from"`
+	Username string `json:"username" yaml:"username" toml:"username"`
+	Password string `json:"password" yaml:"password" toml:"password"`
+
+	// Deprecated: Templates and PublicURL are used only when the notify
+	// settings of the same name are unset.
+	Templates string `json:"templates" yaml:"templates" toml:"templates"`
+	PublicURL string `json:"public_url" yaml:"public_url" toml:"public_url"`
+}
+
+// CustomerTemplates returns the templates file, falling back to the one
+// set under email.
+func (n NotifyConfig) CustomerTemplates() string {
+	if n.Templates == "" {
+		return n.Email.Templates
+	}
+	return n.Templates
+}
+
+// CustomerURL returns the public URL, falling back to the one set under
+// email.
+func (n NotifyConfig) CustomerURL() string {
+	if n.PublicURL == "" {
+		return n.Email.PublicURL
+	}
+	return n.PublicURL
+}
+
+// ChannelConfig is a Slack or Teams incoming webhook and the events posted
+// to it: tracking_lost, sla_at_risk, sla_breached, provider_failing or
+// quota_low. A channel without events gets all of them.
+type ChannelConfig struct {
+	Name       string   `json:"name" yaml:"name" toml:"name"`
+	Kind       string   `json:"kind" yaml:"kind" toml:"kind"`
+	WebhookURL string   `json:"webhook_url" yaml:"webhook_url" toml:"webhook_url"`
+	Events     []string `json:"events" yaml:"events" toml:"events"`
+}
+
+type AuthConfig struct {
+	AdminToken string `json:"admin_token" yaml:"admin_token" toml:"admin_token"`
+	// JWKSURL enables bearer JWT authentication with keys from this URL.
+	JWKSURL  string     `json:"jwks_url" yaml:"jwks_url" toml:"jwks_url"`
+	Issuer   string     `json:"issuer" yaml:"issuer" toml:"issuer"`
+	Audience string     `json:"audience" yaml:"audience" toml:"audience"`
+	OIDC     OIDCConfig `json:"oidc" yaml:"oidc" toml:"oidc"`
+	// ShareSecret enables signed links giving read-only access to one order.
+	ShareSecret string   `json:"share_secret" yaml:"share_secret" toml:"share_secret"`
+	ShareTTL    Duration `json:"share_ttl" yaml:"share_ttl" toml:"share_ttl"`
+}
+
+// PrivacyConfig limits how exactly couriers are shown to customers: callers
+// with customer tokens or share links, or with no credential under JWT
+// authentication. Positions are kept and routed at full precision either
+// way.
+type PrivacyConfig struct {
+	// Precision rounds courier coordinates to this many decimal places;
+	// zero keeps them as they are. Three places are about 100m.
+	Precision int `json:"precision" yaml:"precision" toml:"precision"`
+	// FuzzRadius moves courier positions by up to this many meters, the
+	// same way for every read of an order. FuzzSecret keeps which way from
+	// being worked out from the order ID.
+	FuzzRadius float64 `json:"fuzz_radius" yaml:"fuzz_radius" toml:"fuzz_radius"`
+	FuzzSecret string  `json:"fuzz_secret" yaml:"fuzz_secret" toml:"fuzz_secret"`
+	// OffDuty stops keeping the positions of drivers who carry no
+	// undelivered order or are outside their shift, and forgets the last
+	// one kept. ShiftKey names the driver metadata holding the shift, such
+	// as "08:00-17:00" in local time; drivers without one are on shift.
+	OffDuty  bool   `json:"off_duty" yaml:"off_duty" toml:"off_duty"`
+	ShiftKey string `json:"shift_key" yaml:"shift_key" toml:"shift_key"`
+}
+
+// Obscure returns where customers are shown a courier of the order that is
+// at p.
+func (c PrivacyConfig) Obscure(orderID string, p geo.Point) geo.Point {
+	if c.FuzzRadius > 0 {
+		p = geo.Fuzz(p, c.FuzzSecret+orderID, c.FuzzRadius)
+	}
+	if c.Precision > 0 {
+		p = geo.Round(p, c.Precision)
+	}
+	return p
+}
+
+// OperatorRoles are the roles groups may be mapped to: the scopes of
+// tokens.
+var OperatorRoles = []string{"driver", "customer", "dispatcher", "admin"}
+
+// OIDCConfig enables browser login for the admin surface.
+type OIDCConfig struct {
+	IssuerURL    string `json:"issuer_url" yaml:"issuer_url" toml:"issuer_url"`
+	ClientID     string `json:"client_id" yaml:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string `json:"redirect_url" yaml:"redirect_url" toml:"redirect_url"`
+	GroupsClaim  string `json:"groups_claim" yaml:"groups_claim" toml:"groups_claim"`
+	// GroupRoles maps identity provider groups to OperatorRoles.
+	GroupRoles    map[string]string `json:"group_roles" yaml:"group_roles" toml:"group_roles"`
+	SessionSecret string            `json:"session_secret" yaml:"session_secret" toml:"session_secret"`
+	SessionTTL    Duration          `json:"session_ttl" yaml:"session_ttl" toml:"session_ttl"`
+}
+
+// TenancyConfig runs one deployment for several brands. Each tenant's
+// orders, drivers, events and provider usage are kept apart from every
+// other's; requests naming no tenant belong to the default one, configured
+// by the rest of the file.
+type TenancyConfig struct {
+	// Header names the tenant of a request made with the admin token, an
+	// operator session or, without JWT authentication, no credential.
+	// Tenant API keys and JWTs with a "tenant" claim imply their tenant.
+	Header  string                  `json:"header" yaml:"header" toml:"header"`
+	Tenants map[string]TenantConfig `json:"tenants" yaml:"tenants" toml:"tenants"`
+}
+
+// TenantConfig is what sets a tenant apart. Empty settings fall back to
+// the default tenant's.
+type TenantConfig struct {
+	// APIKey authenticates the tenant's backend as an admin of the tenant,
+	// and of nothing else.
+	APIKey       string `json:"api_key" yaml:"api_key" toml:"api_key"`
+	MapsAPIKey   string `json:"maps_api_key" yaml:"maps_api_key" toml:"maps_api_key"`
+	PublisherURL string `json:"publisher_url" yaml:"publisher_url" toml:"publisher_url"`
+	// Providers are tried in order for every travel time, in place of the
+	// provider selected in the runtime settings; list haversine last to
+	// keep answering when the others fail.
+	Providers []string `json:"providers" yaml:"providers" toml:"providers"`
+	// DailyQuota caps the tenant's Google calls per day and instance, so
+	// one tenant spending its budget leaves the others theirs. Past it the
+	// next of its providers answers.
+	DailyQuota int `json:"daily_quota" yaml:"daily_quota" toml:"daily_quota"`
+	// Channels receive the tenant's operational events in place of
+	// notify.channels.
+	Channels []ChannelConfig `json:"channels" yaml:"channels" toml:"channels"`
+	// RateLimit is how many requests per second the tenant may make, with
+	// bursts of up to RateBurst; 0 leaves it unlimited.
+	RateLimit float64 `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	RateBurst int     `json:"rate_burst" yaml:"rate_burst" toml:"rate_burst"`
+}
+
+// ForTenant returns the configuration as it applies to the named tenant,
+// with its own Maps key, publisher, quota and channels in place of the
+// default tenant's. Unknown names get the configuration unchanged.
+func (c Configuration) ForTenant(name string) Configuration {
+	t, ok := c.Tenancy.Tenants[name]
+	if !ok {
+		return c
+	}
+	if t.MapsAPIKey != "" {
+		c.Maps.APIKey = t.MapsAPIKey
+	}
+	if t.PublisherURL != "" {
+		c.Publisher.URL = t.PublisherURL
+	}
+	if t.DailyQuota > 0 {
+		c.Maps.DailyQuota = t.DailyQuota
+	}
+	if len(t.Channels) > 0 {
+		c.Notify.Channels = t.Channels
+	}
+	return c
+}
+
+// tenantName restricts tenant names to what is safe in Redis keys and key
+// patterns.
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// pubsubTopic matches full Pub/Sub topic names.
+var pubsubTopic = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// Duration is a time.Duration written as a string such as "30s" in config files.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// envOverrides maps environment variables to the settings they override.
+// Environment values win over the config file so containers can inject
+// secrets without writing them to disk.
+var envOverrides = map[string]func(*Configuration, string){
+	"REDIS_URL":                    func(c *Configuration, v string) { c.Redis.URL = v },
+	"MAPS_API_KEY":                 func(c *Configuration, v string) { c.Maps.APIKey = v },
+	"WEATHER_API_KEY":              func(c *Configuration, v string) { c.Weather.APIKey = v },
+	"ISOCHRONE_API_KEY":            func(c *Configuration, v string) { c.Maps.IsochroneAPIKey = v },
+	"WHAT3WORDS_API_KEY":           func(c *Configuration, v string) { c.Maps.What3WordsAPIKey = v },
+	"LISTEN_ADDR":                  func(c *Configuration, v string) { c.Server.ListenAddr = v },
+	"LOG_LEVEL":                    func(c *Configuration, v string) { c.Server.LogLevel = v },
+	"STORAGE":                      func(c *Configuration, v string) { c.Server.Storage = v },
+	"PROVIDER":                     func(c *Configuration, v string) { c.Maps.Provider = v },
+	"PUBLISHER_URL":                func(c *Configuration, v string) { c.Publisher.URL = v },
+	"PUBLISHER_KIND":               func(c *Configuration, v string) { c.Publisher.Kind = v },
+	"SERVICEBUS_CONNECTION_STRING": func(c *Configuration, v string) { c.Publisher.ServiceBus.ConnectionString = v },
+	"ADMIN_TOKEN":                  func(c *Configuration, v string) { c.Auth.AdminToken = v },
+	"JWKS_URL":                     func(c *Configuration, v string) { c.Auth.JWKSURL = v },
+	"OIDC_CLIENT_SECRET":           func(c *Configuration, v string) { c.Auth.OIDC.ClientSecret = v },
+	"OIDC_SESSION_SECRET":          func(c *Configuration, v string) { c.Auth.OIDC.SessionSecret = v },
+	"SHARE_SECRET":                 func(c *Configuration, v string) { c.Auth.ShareSecret = v },
+	"PRIVACY_FUZZ_SECRET":          func(c *Configuration, v string) { c.Privacy.FuzzSecret = v },
+	"GRPC_LISTEN_ADDR":             func(c *Configuration, v string) { c.Server.GRPCListenAddr = v },
+	"RECORD_FILE":                  func(c *Configuration, v string) { c.Server.RecordFile = v },
+	"SMTP_PASSWORD":                func(c *Configuration, v string) { c.Notify.Email.Password = v },
+	"TWILIO_AUTH_TOKEN":            func(c *Configuration, v string) { c.Notify.SMS.AuthToken = v },
+	"VAULT_ADDR":                   func(c *Configuration, v string) { c.Vault.Address = v },
+	"DISCOVERY_TOKEN":              func(c *Configuration, v string) { c.Discovery.Token = v },
+	"VAULT_ROLE":                   func(c *Configuration, v string) { c.Vault.Role = v },
+}
+
+func Default() Configuration {
+	return Configuration{
+		Redis: RedisConfig{LegacyPoints: true},
+		Server: ServerConfig{
+			ListenAddr:           ":8080",
+			SocketMode:           "0660",
+			MaxConcurrentStreams: 250,
+			IdleTimeout:          Duration{2 * time.Minute},
+			ReadHeaderTimeout:    Duration{10 * time.Second},
+			LogLevel:             "info",
+			Storage:              "redis",
+			RequestTimeout:       Duration{10 * time.Second},
+			ProviderShare:        0.6,
+			ShutdownTimeout:      Duration{15 * time.Second},
+		},
+		Maps: MapsConfig{
+			Provider:          "google",
+			Vehicle:           routing.Gasoline,
+			EnergyPerKm:       0.2,
+			Geocoder:          geocode.GoogleGeocoder,
+			NominatimURL:      geocode.NominatimURL,
+			NominatimInterval: Duration{time.Second},
+			// Addresses hardly ever move
+			AddressCacheTTL: Duration{24 * time.Hour},
+			TrafficProfiles: TrafficProfilesConfig{Precision: 5, MinObserved: Duration{10 * time.Minute}},
+		},
+		Publisher: PublisherConfig{
+			Kind:           publish.KindWebSocket,
+			URL:            "ws://localhost:5000/eta",
+			SchemaVersions: []int{publish.SchemaV1},
+			EventBridge: EventBridgeConfig{
+				Bus:        "default",
+				Source:     "location",
+				DetailType: "Order {{.Type}}",
+			},
+		},
+		Tenancy: TenancyConfig{
+			Header: "X-Tenant",
+		},
+		Egress: EgressConfig{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     Duration{90 * time.Second},
+		},
+		Simulation: SimulationConfig{
+			Radius:   3000,
+			Interval: Duration{5 * time.Second},
+			Speedup:  1,
+			Mode:     "driving",
+			Prefix:   "sim-",
+		},
+		Discovery: DiscoveryConfig{
+			Service: "location",
+			TTL:     Duration{30 * time.Second},
+			Prefix:  "/services",
+		},
+		Auth: AuthConfig{
+			OIDC: OIDCConfig{
+				GroupsClaim: "groups",
+				SessionTTL:  Duration{8 * time.Hour},
+			},
+			ShareTTL: Duration{24 * time.Hour},
+		},
+		Cache: CacheConfig{
+			TTL:              Duration{time.Minute},
+			DebounceInterval: Duration{10 * time.Second},
+			CoalesceRadius:   100,
+			LocalTTL:         Duration{time.Second},
+		},
+		Workers: WorkersConfig{
+			QueueSize:    256,
+			Backpressure: BackpressureBlock,
+			PriorityKey:  "priority",
+			UrgentWithin: Duration{5 * time.Minute},
+		},
+		Tracking: TrackingConfig{
+			StaleAfter:       Duration{2 * time.Minute},
+			WatchdogInterval: Duration{30 * time.Second},
+			ScheduleInterval: Duration{time.Minute},
+			PickupRadius:     50,
+			ArrivalRadius:    50,
+			DwellRadius:      30,
+			ETASpread:        0.2,
+			ETAMinSpread:     Duration{2 * time.Minute},
+			MaxAccuracy:      100,
+			MaxSpeed:         70,
+			HeatmapPrecision: 6,
+		},
+		Ingest: IngestConfig{
+			Protocol: "text",
+		},
+		Privacy: PrivacyConfig{
+			ShiftKey: "shift",
+		},
+		Notify: NotifyConfig{
+			CheckInterval: Duration{time.Minute},
+		},
+		Weather: WeatherConfig{
+			CacheTTL: Duration{10 * time.Minute},
+			// Cyclists and pedestrians slow down the most
+			Multipliers: map[string]map[string]float64{
+				weather.Rain: {"bicycling": 1.25, "walking": 1.15, "driving": 1.1, "transit": 1.05},
+				weather.Snow: {"bicycling": 1.6, "walking": 1.4, "driving": 1.3, "transit": 1.15},
+			},
+		},
+	}
+}
+
+// Load reads the config file at path, if present, applies
+// environment overrides on top and finally resolves secrets from cloud
+// secret managers and Vault.
+func Load(path string) (Configuration, error) {
+	conf := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Printf("No %s found, using environment configuration only", path)
+	case err != nil:
+		return conf, fmt.Errorf("failed to read %s: %v", path, err)
+	default:
+		err = decodeConfig(path, data, &conf)
+		if err != nil {
+			return conf, fmt.Errorf("failed to decode %s: %v", path, err)
+		}
+	}
+
+	for name, apply := range envOverrides {
+		if v, ok := os.LookupEnv(name); ok {
+			apply(&conf, v)
+		}
+	}
+
+	err = resolveSecretRefs(&conf)
+	if err != nil {
+		return conf, err
+	}
+	err = resolveVaultSecrets(&conf)
+	if err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+func decodeConfig(path string, data []byte, conf *Configuration) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, conf)
+	case ".toml":
+		return toml.Unmarshal(data, conf)
+	default:
+		return json.Unmarshal(data, conf)
+	}
+}
+
+// Validate checks every setting the server needs and reports all problems
+// at once, so a broken deployment can be fixed in a single pass.
+func (c Configuration) Validate() []error {
+	var problems []error
+
+	if c.Server.ListenAddr == "" {
+		problems = append(problems, errors.New("server.listen_addr is required"))
+	} else if c.Server.ListenAddr == "unix:" {
+		problems = append(problems, errors.New("server.listen_addr: missing socket path"))
+	} else if c.Server.ListensOnTCP() {
+		if _, _, err := net.SplitHostPort(c.Server.ListenAddr); err != nil {
+			problems = append(problems, fmt.Errorf("server.listen_addr: %v", err))
+		}
+	}
+	if c.Server.IdleTimeout.Duration < 0 || c.Server.ReadHeaderTimeout.Duration < 0 {
+		problems = append(problems, errors.New("server.idle_timeout and server.read_header_timeout must not be negative"))
+	}
+	if c.Server.MaxConnections < 0 {
+		problems = append(problems, errors.New("server.max_connections must not be negative"))
+	}
+	if mode, err := strconv.ParseUint(c.Server.SocketMode, 8, 32); c.Server.SocketMode != "" && (err != nil || mode > 0o777) {
+		problems = append(problems, fmt.Errorf("server.socket_mode: invalid permissions %q", c.Server.SocketMode))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Server.LogLevel)); err != nil {
+		problems = append(problems, fmt.Errorf("server.log_level: %v", err))
+	}
+	if c.Server.RequestTimeout.Duration < 0 {
+		problems = append(problems, errors.New("server.request_timeout must not be negative"))
+	}
+	for route, budget := range c.Server.LatencyBudgets {
+		if budget.Duration <= 0 {
+			problems = append(problems, fmt.Errorf("server.latency_budgets: budget of %s must be positive", route))
+		}
+	}
+	if c.Server.ProviderShare <= 0 || c.Server.ProviderShare > 1 {
+		problems = append(problems, errors.New("server.provider_share must be above 0 and at most 1"))
+	}
+	if !store.Known(c.Server.Storage) {
+		problems = append(problems, fmt.Errorf("server.storage: unsupported backend %q", c.Server.Storage))
+	}
+
+	if c.Server.Storage == "redis" {
+		if c.Redis.URL == "" {
+			problems = append(problems, errors.New("redis.url is required"))
+		} else if _, err := redis.ParseURL(c.Redis.URL); err != nil {
+			problems = append(problems, fmt.Errorf("redis.url: %v", err))
+		}
+	} else if c.Redis.Backplane {
+		problems = append(problems, errors.New("redis.backplane needs redis storage"))
+	}
+
+	if !routing.Known(c.Maps.Provider) {
+		problems = append(problems, fmt.Errorf("maps.provider: unknown provider %q", c.Maps.Provider))
+	}
+	for mode, m := range c.Maps.Modes {
+		if m.Provider != "" && !routing.Known(m.Provider) {
+			problems = append(problems, fmt.Errorf("maps.modes.%s.provider: unknown provider %q", mode, m.Provider))
+		}
+		if m.RecomputeInterval.Duration < 0 || m.CacheTTL.Duration < 0 || m.HandlingTime.Duration < 0 {
+			problems = append(problems, fmt.Errorf("maps.modes.%s: recompute_interval, cache_ttl and handling_time must not be negative", mode))
+		}
+	}
+	if p := c.Maps.TrafficProfiles; p.Enabled && (p.Precision < 1 || p.Precision > 12 || p.MinObserved.Duration < 0) {
+		problems = append(problems, errors.New("maps.traffic_profiles: precision must be from 1 to 12, and min_observed must not be negative"))
+	}
+	if c.Maps.DailyQuota < 0 {
+		problems = append(problems, errors.New("maps.daily_quota must not be negative"))
+	}
+	if c.Maps.Provider == routing.Google && c.Maps.APIKey == "" {
+		problems = append(problems, errors.New("maps.api_key is required for the google provider"))
+	}
+
+	switch p := c.Publisher; p.Kind {
+	case publish.KindWebSocket:
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+			problems = append(problems, fmt.Errorf("publisher.url must be a ws:// or wss:// URL, got %q", p.URL))
+		}
+	case publish.KindEventBridge:
+		if p.EventBridge.Bus == "" || p.EventBridge.Source == "" {
+			problems = append(problems, errors.New("publisher.eventbridge: bus and source are required"))
+		}
+		if _, err := template.New("detail_type").Parse(p.EventBridge.DetailType); err != nil || p.EventBridge.DetailType == "" {
+			problems = append(problems, fmt.Errorf("publisher.eventbridge.detail_type must be a template, got %q", p.EventBridge.DetailType))
+		}
+	case publish.KindPubSub:
+		if !pubsubTopic.MatchString(p.PubSub.Topic) {
+			problems = append(problems, fmt.Errorf("publisher.pubsub.topic must be projects/PROJECT/topics/TOPIC, got %q", p.PubSub.Topic))
+		}
+	case publish.KindServiceBus:
+		if p.ServiceBus.Topic == "" {
+			problems = append(problems, errors.New("publisher.servicebus.topic is required"))
+		}
+		if p.ServiceBus.ConnectionString == "" && p.ServiceBus.Namespace == "" {
+			problems = append(problems, errors.New("publisher.servicebus needs a connection_string or, for managed identity, a namespace"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("publisher.kind must be one of %s, got %q", strings.Join(publish.Kinds, ", "), p.Kind))
+	}
+	if len(c.Publisher.SchemaVersions) == 0 {
+		problems = append(problems, errors.New("publisher.schema_versions must not be empty"))
+	}
+	for _, v := range c.Publisher.SchemaVersions {
+		if !slices.Contains(publish.SchemaVersions, v) {
+			problems = append(problems, fmt.Errorf("publisher.schema_versions: unknown version %d", v))
+		}
+	}
+
+	if c.Cache.Enabled && c.Cache.TTL.Duration <= 0 {
+		problems = append(problems, errors.New("cache.ttl must be positive when caching is enabled"))
+	}
+	if c.Cache.Debounce && c.Cache.DebounceInterval.Duration <= 0 {
+		problems = append(problems, errors.New("cache.debounce_interval must be positive when debouncing is enabled"))
+	}
+	if c.Workers.Count < 0 || (c.Workers.Count > 0 && c.Workers.QueueSize <= 0) {
+		problems = append(problems, errors.New("workers: count must not be negative, and queue_size must be positive with workers"))
+	}
+	if b := c.Workers.Backpressure; b != BackpressureBlock && b != BackpressureShed {
+		problems = append(problems, fmt.Errorf("workers.backpressure must be %s or %s, not %q", BackpressureBlock, BackpressureShed, b))
+	}
+	if c.Workers.UrgentWithin.Duration < 0 {
+		problems = append(problems, errors.New("workers.urgent_within must not be negative"))
+	}
+	if c.Cache.LocalEntries < 0 {
+		problems = append(problems, errors.New("cache.local_entries must not be negative"))
+	}
+	if c.Cache.LocalEntries > 0 && c.Cache.LocalTTL.Duration <= 0 {
+		problems = append(problems, errors.New("cache.local_ttl must be positive when local_entries is set"))
+	}
+	if c.Cache.ResponseTTL.Duration < 0 {
+		problems = append(problems, errors.New("cache.response_ttl must not be negative"))
+	}
+	if c.Cache.CoalesceWindow.Duration < 0 {
+		problems = append(problems, errors.New("cache.coalesce_window must not be negative"))
+	}
+	if c.Cache.CoalesceWindow.Duration > 0 && c.Cache.CoalesceRadius <= 0 {
+		problems = append(problems, errors.New("cache.coalesce_radius must be positive when coalescing is enabled"))
+	}
+
+	if c.Tracking.StaleAfter.Duration <= 0 || c.Tracking.WatchdogInterval.Duration <= 0 || c.Tracking.ScheduleInterval.Duration <= 0 {
+		problems = append(problems, errors.New("tracking: stale_after, watchdog_interval and schedule_interval must be positive"))
+	}
+	if c.Tracking.DwellAfter.Duration < 0 || (c.Tracking.DwellAfter.Duration > 0 && c.Tracking.DwellRadius <= 0) {
+		problems = append(problems, errors.New("tracking: dwell_after must not be negative, and dwell_radius must be positive with it"))
+	}
+	if c.Tracking.HandlingTime.Duration < 0 {
+		problems = append(problems, errors.New("tracking.handling_time must not be negative"))
+	}
+	if c.Tracking.StationaryRadius < 0 {
+		problems = append(problems, errors.New("tracking.stationary_radius must not be negative"))
+	}
+	if c.Tracking.WarmLead.Duration < 0 {
+		problems = append(problems, errors.New("tracking.warm_lead must not be negative"))
+	}
+	for _, w := range c.Tracking.OffPeak {
+		if _, _, err := parseWindow(w); err != nil {
+			problems = append(problems, fmt.Errorf("tracking.off_peak: %v", err))
+		}
+	}
+	if c.Tracking.PickupRadius <= 0 {
+		problems = append(problems, errors.New("tracking.pickup_radius must be positive"))
+	}
+	if c.Tracking.ArrivalRadius <= 0 {
+		problems = append(problems, errors.New("tracking.arrival_radius must be positive"))
+	}
+	if c.Tracking.ETASpread < 0 || c.Tracking.ETAMinSpread.Duration < 0 {
+		problems = append(problems, errors.New("tracking: eta_spread and eta_min_spread must not be negative"))
+	}
+	if c.Tracking.MaxAccuracy < 0 || c.Tracking.MaxSpeed < 0 {
+		problems = append(problems, errors.New("tracking: max_accuracy and max_speed must not be negative"))
+	}
+	if !routing.KnownVehicle(c.Maps.Vehicle) {
+		problems = append(problems, fmt.Errorf("unknown maps.vehicle %q", c.Maps.Vehicle))
+	}
+	if c.Maps.IsochroneProvider != "" && !routing.KnownIsochrone(c.Maps.IsochroneProvider) {
+		problems = append(problems, fmt.Errorf("unknown maps.isochrone_provider %q", c.Maps.IsochroneProvider))
+	}
+	if c.Maps.IsochroneProvider == routing.Valhalla && c.Maps.IsochroneURL == "" {
+		problems = append(problems, errors.New("maps.isochrone_url is required for valhalla"))
+	}
+	if !geocode.KnownGeocoder(c.Maps.Geocoder) {
+		problems = append(problems, fmt.Errorf("unknown maps.geocoder %q", c.Maps.Geocoder))
+	}
+	if c.Maps.Geocoder == geocode.NominatimGeocoder && c.Maps.NominatimInterval.Duration <= 0 {
+		problems = append(problems, errors.New("maps.nominatim_interval must be positive"))
+	}
+	if c.Maps.EnergyPerKm < 0 {
+		problems = append(problems, errors.New("maps.energy_per_km must not be negative"))
+	}
+	if c.Weather.Provider != "" && !weather.Known(c.Weather.Provider) {
+		problems = append(problems, fmt.Errorf("unknown weather.provider %q", c.Weather.Provider))
+	}
+	if c.Weather.Provider != "" && c.Weather.APIKey == "" {
+		problems = append(problems, errors.New("weather.api_key is required with a weather provider"))
+	}
+	for conditions, modes := range c.Weather.Multipliers {
+		for mode, m := range modes {
+			if m <= 0 {
+				problems = append(problems, fmt.Errorf("weather.multipliers.%s.%s must be positive", conditions, mode))
+			}
+		}
+	}
+	if protocol, ok := ingest.Protocols[c.Ingest.Protocol]; !ok {
+		problems = append(problems, fmt.Errorf("unknown ingest.protocol %q", c.Ingest.Protocol))
+	} else if c.Ingest.UDPListenAddr != "" && !protocol.Datagrams {
+		problems = append(problems, fmt.Errorf("ingest.protocol %q does not work over UDP", c.Ingest.Protocol))
+	}
+	if (c.Ingest.TCPListenAddr != "" || c.Ingest.UDPListenAddr != "") && len(c.Ingest.Devices) == 0 {
+		problems = append(problems, errors.New("ingest.devices must map the trackers to hear to drivers"))
+	}
+	problems = append(problems, validateChannels("notify.channels", c.Notify.Channels)...)
+	if e := c.Notify.Email; e.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(e.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Errorf("notify.email.smtp_addr: %v", err))
+		}
+		if _, err := mail.ParseAddress(e.From); err != nil {
+			problems = append(problems, fmt.Errorf("notify.email.from: %v", err))
+		}
+	}
+	if s := c.Notify.SMS; s.AccountSID != "" && (s.AuthToken == "" || s.From == "") {
+		problems = append(problems, errors.New("notify.sms: auth_token and from are required"))
+	}
+	for i, r := range c.Notify.Rules {
+		if len(r.Events) == 0 || r.Template == "" {
+			problems = append(problems, fmt.Errorf("notify.rules[%d]: events and template are required", i))
+		}
+		for _, ch := range r.Channels {
+			if ch != store.ChannelEmail && ch != store.ChannelSMS && ch != store.ChannelPush {
+				problems = append(problems, fmt.Errorf("notify.rules[%d]: unknown channel %q", i, ch))
+			}
+		}
+	}
+	channels := len(c.Notify.Channels)
+	for _, t := range c.Tenancy.Tenants {
+		channels += len(t.Channels)
+	}
+	if channels > 0 && c.Notify.CheckInterval.Duration <= 0 {
+		problems = append(problems, errors.New("notify.check_interval must be positive"))
+	}
+	if c.Tracking.HeatmapPrecision < 1 || c.Tracking.HeatmapPrecision > geo.MaxGeohashPrecision {
+		problems = append(problems, fmt.Errorf("tracking.heatmap_precision must be from 1 to %d", geo.MaxGeohashPrecision))
+	}
+	fences := map[string]bool{}
+	for _, f := range c.Tracking.Geofences {
+		if err := f.Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("tracking.geofences: %v", err))
+		}
+		if fences[f.Name] {
+			problems = append(problems, fmt.Errorf("tracking.geofences: duplicate name %q", f.Name))
+		}
+		fences[f.Name] = true
+	}
+
+	if o := c.Auth.OIDC; o.IssuerURL != "" {
+		if o.ClientID == "" || o.RedirectURL == "" {
+			problems = append(problems, errors.New("auth.oidc: client_id and redirect_url are required"))
+		}
+		if len(o.SessionSecret) < 32 {
+			problems = append(problems, errors.New("auth.oidc.session_secret must be at least 32 characters"))
+		}
+		if len(o.GroupRoles) == 0 {
+			problems = append(problems, errors.New("auth.oidc.group_roles must map at least one group, or nobody can log in"))
+		}
+		for group, role := range o.GroupRoles {
+			if !slices.Contains(OperatorRoles, role) {
+				problems = append(problems, fmt.Errorf("auth.oidc.group_roles.%s: unknown role %q, want one of %s", group, role, strings.Join(OperatorRoles, ", ")))
+			}
+		}
+	}
+
+	if c.Auth.ShareSecret != "" {
+		if len(c.Auth.ShareSecret) < 32 {
+			problems = append(problems, errors.New("auth.share_secret must be at least 32 characters"))
+		}
+		if c.Auth.ShareTTL.Duration <= 0 {
+			problems = append(problems, errors.New("auth.share_ttl must be positive when share links are enabled"))
+		}
+	}
+	if c.Privacy.Precision < 0 || c.Privacy.Precision > 15 {
+		problems = append(problems, errors.New("privacy.precision must be from 0 to 15"))
+	}
+	if c.Privacy.FuzzRadius < 0 {
+		problems = append(problems, errors.New("privacy.fuzz_radius must not be negative"))
+	}
+	if c.Privacy.FuzzRadius > 0 && len(c.Privacy.FuzzSecret) < 32 {
+		problems = append(problems, errors.New("privacy.fuzz_secret must be at least 32 characters with fuzz_radius"))
+	}
+
+	keys := map[string]string{}
+	for name, t := range c.Tenancy.Tenants {
+		if !tenantName.MatchString(name) {
+			problems = append(problems, fmt.Errorf("tenancy.tenants: name %q must be lowercase letters, digits, - and _", name))
+		}
+		if t.APIKey != "" {
+			other, ok := keys[t.APIKey]
+			if t.APIKey == c.Auth.AdminToken {
+				other, ok = "auth.admin_token", true
+			}
+			if ok {
+				problems = append(problems, fmt.Errorf("tenancy.tenants.%s.api_key is also used by %s", name, other))
+			}
+			keys[t.APIKey] = name
+		}
+		if t.PublisherURL != "" {
+			if u, err := url.Parse(t.PublisherURL); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+				problems = append(problems, fmt.Errorf("tenancy.tenants.%s.publisher_url must be a ws:// or wss:// URL", name))
+			}
+		}
+		for _, p := range t.Providers {
+			if !routing.Known(p) {
+				problems = append(problems, fmt.Errorf("tenancy.tenants.%s.providers: unknown provider %q", name, p))
+			}
+		}
+		problems = append(problems, validateChannels("tenancy.tenants."+name+".channels", t.Channels)...)
+		if t.DailyQuota < 0 || t.RateLimit < 0 || t.RateBurst < 0 {
+			problems = append(problems, fmt.Errorf("tenancy.tenants.%s: daily_quota, rate_limit and rate_burst must not be negative", name))
+		}
+	}
+	if len(c.Tenancy.Tenants) > 0 && c.Tenancy.Header == "" {
+		problems = append(problems, errors.New("tenancy.header is required with tenants"))
+	}
+
+	if d := c.Discovery; d.Registry != "" {
+		if !slices.Contains(discovery.Kinds, d.Registry) {
+			problems = append(problems, fmt.Errorf("discovery.registry: unknown registry %q", d.Registry))
+		}
+		if d.Service == "" {
+			problems = append(problems, errors.New("discovery.service is required with a registry"))
+		}
+		if d.TTL.Duration < 3*time.Second {
+			problems = append(problems, errors.New("discovery.ttl must be at least 3s"))
+		}
+		if !c.Server.ListensOnTCP() {
+			problems = append(problems, errors.New("discovery needs server.listen_addr to be a TCP host:port"))
+		}
+	}
+
+	if e := c.Egress; e.Proxy != "" {
+		if u, err := url.Parse(e.Proxy); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Errorf("egress.proxy: invalid URL %q", e.Proxy))
+		}
+	}
+	if e := c.Egress; e.MaxIdleConns < 0 || e.MaxIdleConnsPerHost < 0 || e.MaxConnsPerHost < 0 || e.IdleConnTimeout.Duration < 0 {
+		problems = append(problems, errors.New("egress connection pool settings must not be negative"))
+	}
+	for purpose, d := range c.Egress.Timeouts {
+		if !slices.Contains(egress.Purposes, purpose) {
+			problems = append(problems, fmt.Errorf("egress.timeouts: unknown client %q, want one of %s", purpose, strings.Join(egress.Purposes, ", ")))
+		} else if d.Duration < 0 {
+			problems = append(problems, fmt.Errorf("egress.timeouts.%s must not be negative", purpose))
+		}
+	}
+
+	if sim := c.Simulation; sim.Center != "" {
+		if _, err := geo.Parse(sim.Center); err != nil {
+			problems = append(problems, fmt.Errorf("simulation.center: %v", err))
+		}
+	}
+	if sim := c.Simulation; sim.Radius <= 0 || sim.Interval.Duration <= 0 || sim.Speedup <= 0 {
+		problems = append(problems, errors.New("simulation.radius, simulation.interval and simulation.speedup must be positive"))
+	}
+	if r := c.Simulation.Router; r != "" && !routing.KnownRouter(r) {
+		problems = append(problems, fmt.Errorf("simulation.router: unknown router %q", r))
+	}
+
+	for name, rates := range map[string]FaultRates{"chaos.redis": c.Chaos.Redis, "chaos.provider": c.Chaos.Provider} {
+		if rates.DelayRate < 0 || rates.DelayRate > 1 || rates.FailRate < 0 || rates.FailRate > 1 {
+			problems = append(problems, fmt.Errorf("%s: rates must be between 0 and 1", name))
+		}
+	}
+
+	return problems
+}
+
+// validateChannels checks the notification channels configured at path.
+func validateChannels(path string, channels []ChannelConfig) []error {
+	var problems []error
+	for i, ch := range channels {
+		if !notify.KnownKind(ch.Kind) {
+			problems = append(problems, fmt.Errorf("%s[%d]: unknown kind %q", path, i, ch.Kind))
+		}
+		if ch.WebhookURL == "" {
+			problems = append(problems, fmt.Errorf("%s[%d]: webhook_url is required", path, i))
+		}
+		for _, e := range ch.Events {
+			if !slices.Contains(notify.Events, e) {
+				problems = append(problems, fmt.Errorf("%s[%d]: unknown event %q", path, i, e))
+			}
+		}
+	}
+	return problems
+}
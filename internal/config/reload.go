@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// WatchReload re-reads the configuration on SIGHUP and applies the settings
+// that can change without a restart. load must return the configuration
+// exactly as at startup, including command-line overrides. It returns when
+// ctx is done.
+func WatchReload(ctx context.Context, rt *Runtime, started Configuration, load func() (Configuration, error)) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+		}
+		log.Println("Received SIGHUP, reloading configuration")
+		conf, err := load()
+		if err != nil {
+			log.Printf("Reload failed, keeping current configuration: %v", err)
+			continue
+		}
+		if problems := conf.Validate(); len(problems) > 0 {
+			log.Printf("Reload failed, keeping current configuration: %v", problems)
+			continue
+		}
+		if conf.Server.ListenAddr != started.Server.ListenAddr ||
+			conf.Server.SocketMode != started.Server.SocketMode ||
+			conf.Server.H2C != started.Server.H2C ||
+			conf.Server.MaxConcurrentStreams != started.Server.MaxConcurrentStreams ||
+			conf.Server.IdleTimeout != started.Server.IdleTimeout ||
+			conf.Server.ReadHeaderTimeout != started.Server.ReadHeaderTimeout ||
+			conf.Server.MaxConnections != started.Server.MaxConnections ||
+			conf.Server.Storage != started.Server.Storage ||
+			conf.Server.RecordFile != started.Server.RecordFile ||
+			conf.Redis.URL != started.Redis.URL ||
+			conf.Redis.LegacyPoints != started.Redis.LegacyPoints ||
+			conf.Chaos != started.Chaos ||
+			!reflect.DeepEqual(conf.Egress, started.Egress) {
+			log.Println("Server, storage, Redis, chaos and egress settings changed; they only take effect after a restart")
+		}
+		if err := rt.Apply(conf); err != nil {
+			log.Printf("Reload failed: %v", err)
+			continue
+		}
+		log.Println("Configuration reloaded")
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"location/internal/routing"
+)
+
+// Settings holds the behaviour that can be changed at runtime through
+// /admin/config without restarting the service.
+type Settings struct {
+	LogLevel   string `json:"log_level"`
+	Caching    bool   `json:"caching"`
+	Debouncing bool   `json:"debouncing"`
+	Provider   string `json:"provider"`
+}
+
+// SettingsUpdate is a partial update; omitted fields are left unchanged.
+type SettingsUpdate struct {
+	LogLevel   *string `json:"log_level"`
+	Caching    *bool   `json:"caching"`
+	Debouncing *bool   `json:"debouncing"`
+	Provider   *string `json:"provider"`
+}
+
+// Runtime holds the configuration that may change while the service runs,
+// either through /admin/config or a reload. Readers take a snapshot so an
+// in-flight request keeps a consistent view even if a reload happens
+// halfway through.
+type Runtime struct {
+	level slog.LevelVar
+
+	mu       sync.RWMutex
+	conf     Configuration
+	settings Settings
+}
+
+// NewRuntime creates a Runtime initialised from conf.
+func NewRuntime(conf Configuration) (*Runtime, error) {
+	rt := &Runtime{}
+	return rt, rt.Apply(conf)
+}
+
+// LogLevel is the level variable to install in the slog handler.
+func (rt *Runtime) LogLevel() *slog.LevelVar {
+	return &rt.level
+}
+
+func (rt *Runtime) Config() Configuration {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.conf
+}
+
+func (rt *Runtime) Settings() Settings {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.settings
+}
+
+// Apply installs a freshly loaded configuration. Settings changed through
+// /admin/config are reset to the configured values.
+func (rt *Runtime) Apply(conf Configuration) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	err := rt.update(SettingsUpdate{
+		LogLevel:   &conf.Server.LogLevel,
+		Provider:   &conf.Maps.Provider,
+		Caching:    &conf.Cache.Enabled,
+		Debouncing: &conf.Cache.Debounce,
+	})
+	if err != nil {
+		return err
+	}
+	rt.conf = conf
+	return nil
+}
+
+// Update applies a partial settings change and returns the result.
+func (rt *Runtime) Update(update SettingsUpdate) (Settings, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	err := rt.update(update)
+	return rt.settings, err
+}
+
+func (rt *Runtime) update(update SettingsUpdate) error {
+	next := rt.settings
+	if update.LogLevel != nil {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(*update.LogLevel)); err != nil {
+			return err
+		}
+		next.LogLevel = strings.ToLower(level.String())
+	}
+	if update.Provider != nil {
+		if !routing.Known(*update.Provider) {
+			return fmt.Errorf("unknown provider: %s", *update.Provider)
+		}
+		next.Provider = *update.Provider
+	}
+	if update.Caching != nil {
+		next.Caching = *update.Caching
+	}
+	if update.Debouncing != nil {
+		next.Debouncing = *update.Debouncing
+	}
+
+	if next.LogLevel != rt.settings.LogLevel {
+		var level slog.Level
+		level.UnmarshalText([]byte(next.LogLevel))
+		rt.level.Set(level)
+	}
+	rt.settings = next
+	log.Printf("Runtime settings updated: %+v", rt.settings)
+	return nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"golang.org/x/oauth2/google"
+)
+
+// Any string setting may hold a reference to a cloud secret instead of the
+// value itself, for example
+//
+//	maps:
+//	  api_key: aws-sm://prod/location#maps_api_key
+//	redis:
+//	  url: gcp-sm://projects/acme/secrets/location-redis-url
+//
+// The optional #key selects a field when the secret is a JSON object.
+// GCP references without a version use the latest one.
+var secretResolvers = map[string]func(ctx context.Context, name string) (string, error){
+	"aws-sm://": fetchAWSSecret,
+	"gcp-sm://": fetchGCPSecret,
+}
+
+// resolveSecretRefs replaces every secret reference in conf with its value.
+func resolveSecretRefs(conf *Configuration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	fetched := map[string]string{}
+	return walkStrings(reflect.ValueOf(conf).Elem(), func(s *string) error {
+		for prefix, fetch := range secretResolvers {
+			if !strings.HasPrefix(*s, prefix) {
+				continue
+			}
+			name, key, _ := strings.Cut(strings.TrimPrefix(*s, prefix), "#")
+			raw, ok := fetched[prefix+name]
+			if !ok {
+				var err error
+				raw, err = fetch(ctx, name)
+				if err != nil {
+					return fmt.Errorf("failed to resolve %s%s: %v", prefix, name, err)
+				}
+				fetched[prefix+name] = raw
+			}
+			if key == "" {
+				*s = raw
+				return nil
+			}
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+				return fmt.Errorf("secret %s%s is not a JSON object: %v", prefix, name, err)
+			}
+			v, ok := fields[key].(string)
+			if !ok {
+				return fmt.Errorf("secret %s%s has no string field %q", prefix, name, key)
+			}
+			*s = v
+			return nil
+		}
+		return nil
+	})
+}
+
+// walkStrings calls fn for every settable string reachable from v, map
+// values included.
+func walkStrings(v reflect.Value, fn func(*string) error) error {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			return fn(v.Addr().Interface().(*string))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if err := walkStrings(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// Map values cannot be set in place, so each is walked as a copy
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			if err := walkStrings(elem, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	}
+	return nil
+}
+
+// fetchAWSSecret reads a secret from AWS Secrets Manager using the default
+// credential chain. The region is taken from the ARN when one is given.
+func fetchAWSSecret(ctx context.Context, id string) (string, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		opts = append(opts, awsconfig.WithRegion(parts[3]))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return "", err
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	return string(out.SecretBinary), nil
+}
+
+// fetchGCPSecret reads a secret version from GCP Secret Manager using
+// application default credentials.
+func fetchGCPSecret(ctx context.Context, name string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret manager returned %s", resp.Status)
+	}
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(body.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// VaultConfig points the service at a KV secret holding its credentials.
+// The secret may contain maps_api_key, redis_username and redis_password;
+// any value found there overrides the file and environment.
+type VaultConfig struct {
+	Address    string `json:"address" yaml:"address" toml:"address"`
+	AuthMethod string `json:"auth_method" yaml:"auth_method" toml:"auth_method"`
+	Role       string `json:"role" yaml:"role" toml:"role"`
+	SecretPath string `json:"secret_path" yaml:"secret_path" toml:"secret_path"`
+}
+
+// kubernetesTokenPath is where the pod's service account token is mounted.
+const kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultRetryInterval spaces out login attempts while Vault is unreachable.
+const vaultRetryInterval = 30 * time.Second
+
+// vaultSession keeps one logged-in client for the life of the process so
+// reloads reuse it, and renews its token in the background.
+var (
+	vaultMu      sync.Mutex
+	vaultSession *vault.Client
+)
+
+// resolveVaultSecrets fills credentials in conf from Vault, if configured.
+func resolveVaultSecrets(conf *Configuration) error {
+	if conf.Vault.Address == "" {
+		return nil
+	}
+	client, err := vaultClient(conf.Vault)
+	if err != nil {
+		return err
+	}
+
+	secret, err := client.Logical().Read(conf.Vault.SecretPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from Vault: %v", conf.Vault.SecretPath, err)
+	}
+	if secret == nil {
+		return fmt.Errorf("no secret found at %s", conf.Vault.SecretPath)
+	}
+	data := secret.Data
+	// KV version 2 nests the values one level deeper
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	if v, ok := data["maps_api_key"].(string); ok {
+		conf.Maps.APIKey = v
+	}
+	username, _ := data["redis_username"].(string)
+	password, hasPassword := data["redis_password"].(string)
+	if hasPassword {
+		u, err := url.Parse(conf.Redis.URL)
+		if err != nil {
+			return fmt.Errorf("cannot apply Vault Redis credentials to redis.url: %v", err)
+		}
+		u.User = url.UserPassword(username, password)
+		conf.Redis.URL = u.String()
+	}
+	return nil
+}
+
+func vaultClient(conf VaultConfig) (*vault.Client, error) {
+	vaultMu.Lock()
+	defer vaultMu.Unlock()
+	if vaultSession != nil {
+		return vaultSession, nil
+	}
+
+	vc := vault.DefaultConfig()
+	vc.Address = conf.Address
+	client, err := vault.NewClient(vc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault client: %v", err)
+	}
+
+	auth, err := vaultLogin(client, conf)
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		go renewVaultToken(client, conf, auth)
+	}
+	vaultSession = client
+	return client, nil
+}
+
+// vaultLogin authenticates with the configured method. The token method
+// relies on VAULT_TOKEN and returns no auth secret to renew.
+func vaultLogin(client *vault.Client, conf VaultConfig) (*vault.Secret, error) {
+	var path string
+	var data map[string]interface{}
+	switch conf.AuthMethod {
+	case "", "token":
+		if client.Token() == "" {
+			return nil, errors.New("vault: VAULT_TOKEN is not set")
+		}
+		return nil, nil
+	case "kubernetes":
+		jwt, err := os.ReadFile(kubernetesTokenPath)
+		if err != nil {
+			return nil, fmt.Errorf("vault: failed to read service account token: %v", err)
+		}
+		path = "auth/kubernetes/login"
+		data = map[string]interface{}{"role": conf.Role, "jwt": string(jwt)}
+	case "approle":
+		path = "auth/approle/login"
+		data = map[string]interface{}{"role_id": conf.Role, "secret_id": os.Getenv("VAULT_SECRET_ID")}
+	default:
+		return nil, fmt.Errorf("vault: unsupported auth method %q", conf.AuthMethod)
+	}
+
+	secret, err := client.Logical().Write(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s login failed: %v", conf.AuthMethod, err)
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("vault: %s login returned no token", conf.AuthMethod)
+	}
+	client.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// renewVaultToken keeps the login token alive. Once it reaches its maximum
+// TTL it logs in again so secret reads on reload keep working.
+func renewVaultToken(client *vault.Client, conf VaultConfig, auth *vault.Secret) {
+	for {
+		watcher, err := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: auth})
+		if err != nil {
+			log.Printf("vault: cannot renew token: %v", err)
+			return
+		}
+		go watcher.Start()
+
+	watch:
+		for {
+			select {
+			case err := <-watcher.DoneCh():
+				if err != nil {
+					log.Printf("vault: token renewal stopped: %v", err)
+				}
+				break watch
+			case <-watcher.RenewCh():
+				log.Println("vault: token renewed")
+			}
+		}
+		watcher.Stop()
+
+		for {
+			auth, err = vaultLogin(client, conf)
+			if err == nil {
+				break
+			}
+			log.Printf("vault: re-login failed, retrying in %v: %v", vaultRetryInterval, err)
+			time.Sleep(vaultRetryInterval)
+		}
+	}
+}
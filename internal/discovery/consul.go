@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+)
+
+// ConsulURL is the local Consul agent.
+const ConsulURL = "http://127.0.0.1:8500"
+
+// Consul registers the instance with the local Consul agent, as a service
+// with a TTL check the instance passes or fails itself. Instances whose
+// check stays critical for ten TTLs are deregistered by Consul. The ACL
+// token is looked up on every call so it can be rotated without a restart.
+type Consul struct {
+	addr   string
+	token  func() string
+	ttl    time.Duration
+	client *http.Client
+
+	id string
+}
+
+func NewConsul(addr string, token func() string, ttl time.Duration) *Consul {
+	return &Consul{addr: strings.TrimSuffix(addr, "/"), token: token, ttl: ttl, client: egress.Client(egress.Discovery, 5*time.Second)}
+}
+
+func (c *Consul) checkID() string {
+	return "service:" + c.id
+}
+
+func (c *Consul) Register(ctx context.Context, reg Registration) error {
+	c.id = reg.ID
+	body := map[string]interface{}{
+		"ID":      reg.ID,
+		"Name":    reg.Service,
+		"Address": reg.Address,
+		"Port":    reg.Port,
+		"Tags":    reg.Tags,
+		"Meta":    reg.Meta,
+		"Check": map[string]string{
+			"CheckID":                        c.checkID(),
+			"Name":                           reg.Service + " health",
+			"TTL":                            c.ttl.String(),
+			"DeregisterCriticalServiceAfter": (10 * c.ttl).String(),
+		},
+	}
+	_, err := c.put(ctx, "/v1/agent/service/register", body)
+	return err
+}
+
+func (c *Consul) Report(ctx context.Context, health error) error {
+	update := map[string]string{"Status": "passing", "Output": "ok"}
+	if health != nil {
+		update = map[string]string{"Status": "critical", "Output": health.Error()}
+	}
+	status, err := c.put(ctx, "/v1/agent/check/update/"+url.PathEscape(c.checkID()), update)
+	if status == http.StatusNotFound {
+		return ErrLost
+	}
+	return err
+}
+
+func (c *Consul) Deregister(ctx context.Context) error {
+	_, err := c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(c.id), nil)
+	return err
+}
+
+// put sends body to the agent, returning the response status.
+func (c *Consul) put(ctx context.Context, path string, body interface{}) (int, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if token := c.token(); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach Consul: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("Consul answered %s to %s", resp.Status, path)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,95 @@
+// Package discovery registers the instance with a service registry, such as
+// Consul or etcd, so that a service mesh finds it without configuration.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Registry kinds.
+const (
+	KindConsul = "consul"
+	KindEtcd   = "etcd"
+)
+
+// Kinds are the registries instances can register with.
+var Kinds = []string{KindConsul, KindEtcd}
+
+// Registration describes an instance to the registry.
+type Registration struct {
+	// ID identifies the instance among those of Service.
+	ID      string            `json:"id"`
+	Service string            `json:"service"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// ErrLost is returned by Report when the registry no longer knows the
+// instance, such as after it expired or the registry restarted.
+var ErrLost = errors.New("registration lost")
+
+// Registry keeps the registration of one instance.
+type Registry interface {
+	// Register adds the instance, healthy until told otherwise; it lapses
+	// unless reported on within the registry's TTL.
+	Register(ctx context.Context, reg Registration) error
+	// Report tells the registry the instance is healthy if health is nil,
+	// and why not otherwise.
+	Report(ctx context.Context, health error) error
+	// Deregister removes the instance.
+	Deregister(ctx context.Context) error
+}
+
+// deregisterTimeout bounds deregistering on shutdown, when the context of
+// Run is already done.
+const deregisterTimeout = 5 * time.Second
+
+// Run registers reg with r and reports the result of health every interval
+// until ctx is done, then deregisters it. A registry that cannot be reached
+// does not stop the service: registering is retried every interval, as it
+// is when the registry loses the instance.
+func Run(ctx context.Context, r Registry, reg Registration, interval time.Duration, health func(context.Context) error) error {
+	registered := false
+	beat := func() {
+		if !registered {
+			if err := r.Register(ctx, reg); err != nil {
+				log.Printf("failed to register instance %s: %v", reg.ID, err)
+				return
+			}
+			log.Printf("Registered instance %s of %s", reg.ID, reg.Service)
+			registered = true
+		}
+		err := r.Report(ctx, health(ctx))
+		if errors.Is(err, ErrLost) {
+			log.Printf("registration of instance %s lost, registering again", reg.ID)
+			registered = false
+		} else if err != nil && ctx.Err() == nil {
+			log.Printf("failed to report health of instance %s: %v", reg.ID, err)
+		}
+	}
+
+	beat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if !registered {
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+			defer cancel()
+			if err := r.Deregister(ctx); err != nil {
+				log.Printf("failed to deregister instance %s: %v", reg.ID, err)
+			}
+			return nil
+		case <-ticker.C:
+			beat()
+		}
+	}
+}
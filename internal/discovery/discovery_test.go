@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul records the calls of an agent that forgets the service after
+// the first health report.
+type fakeConsul struct {
+	mu    sync.Mutex
+	calls []string
+	known bool
+	body  map[string]interface{}
+	token string
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.URL.Path)
+	f.token = r.Header.Get("X-Consul-Token")
+	switch r.URL.Path {
+	case "/v1/agent/service/register":
+		f.body = nil
+		json.NewDecoder(r.Body).Decode(&f.body)
+		f.known = true
+	case "/v1/agent/check/update/service:loc-1":
+		if !f.known {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var update map[string]string
+		json.NewDecoder(r.Body).Decode(&update)
+		f.calls[len(f.calls)-1] += " " + update["Status"]
+		f.known = false
+	case "/v1/agent/service/deregister/loc-1":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (f *fakeConsul) seen() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func TestRunRegistersWithConsul(t *testing.T) {
+	agent := &fakeConsul{}
+	srv := httptest.NewServer(agent)
+	defer srv.Close()
+
+	c := NewConsul(srv.URL, func() string { return "acl" }, 30*time.Second)
+	reg := Registration{ID: "loc-1", Service: "location", Address: "10.0.0.7", Port: 8080, Meta: map[string]string{"zone": "a"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, c, reg, 5*time.Millisecond, func(context.Context) error { return errors.New("redis down") })
+	}()
+
+	// Register, report, lose the registration and register again
+	deadline := time.Now().Add(5 * time.Second)
+	for len(agent.seen()) < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	calls := agent.seen()
+	want := []string{
+		"/v1/agent/service/register",
+		"/v1/agent/check/update/service:loc-1 critical",
+		"/v1/agent/check/update/service:loc-1",
+		"/v1/agent/service/register",
+		"/v1/agent/check/update/service:loc-1 critical",
+	}
+	if len(calls) < len(want) {
+		t.Fatalf("got calls %q, want to start with %q", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("got calls %q, want to start with %q", calls, want)
+		}
+	}
+	if last := calls[len(calls)-1]; last != "/v1/agent/service/deregister/loc-1" {
+		t.Errorf("last call %s, want the deregistration", last)
+	}
+	if agent.token != "acl" {
+		t.Errorf("sent token %q", agent.token)
+	}
+	if agent.body["Name"] != "location" || agent.body["Address"] != "10.0.0.7" || agent.body["Port"] != 8080.0 {
+		t.Errorf("registered %v", agent.body)
+	}
+	if check, _ := agent.body["Check"].(map[string]interface{}); check["TTL"] != "30s" || check["CheckID"] != "service:loc-1" {
+		t.Errorf("registered check %v", agent.body["Check"])
+	}
+}
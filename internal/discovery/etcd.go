@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+)
+
+// EtcdURL is the local etcd member.
+const EtcdURL = "http://127.0.0.1:2379"
+
+// Etcd registers the instance in etcd, through its v3 JSON gateway, as a
+// key under prefix/service/ID held by a lease of the TTL. The value is the
+// registration as JSON, with a "status" of "passing" or "critical". The key
+// goes when the lease lapses. The auth token, if any, is looked up on every
+// call so it can be rotated without a restart.
+type Etcd struct {
+	addr   string
+	prefix string
+	token  func() string
+	ttl    time.Duration
+	client *http.Client
+
+	reg     Registration
+	lease   string
+	healthy *bool
+}
+
+func NewEtcd(addr, prefix string, token func() string, ttl time.Duration) *Etcd {
+	return &Etcd{addr: strings.TrimSuffix(addr, "/"), prefix: strings.TrimSuffix(prefix, "/"), token: token, ttl: ttl,
+		client: egress.Client(egress.Discovery, 5*time.Second)}
+}
+
+// instance is the value kept for an instance.
+type instance struct {
+	Registration
+	Status string `json:"status"`
+}
+
+func (e *Etcd) key() string {
+	return e.prefix + "/" + e.reg.Service + "/" + e.reg.ID
+}
+
+func (e *Etcd) Register(ctx context.Context, reg Registration) error {
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	if err := e.call(ctx, "/v3/lease/grant", map[string]int64{"TTL": int64(e.ttl.Seconds())}, &grant); err != nil {
+		return err
+	}
+	e.reg, e.lease, e.healthy = reg, grant.ID, nil
+	return e.put(ctx, true)
+}
+
+func (e *Etcd) Report(ctx context.Context, health error) error {
+	var alive struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+	}
+	if err := e.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": e.lease}, &alive); err != nil {
+		return err
+	}
+	if alive.Result.TTL == "" || alive.Result.TTL == "0" {
+		return ErrLost
+	}
+	if healthy := health == nil; e.healthy == nil || *e.healthy != healthy {
+		return e.put(ctx, healthy)
+	}
+	return nil
+}
+
+func (e *Etcd) Deregister(ctx context.Context) error {
+	return e.call(ctx, "/v3/lease/revoke", map[string]string{"ID": e.lease}, nil)
+}
+
+// put writes the instance with its health under the lease.
+func (e *Etcd) put(ctx context.Context, healthy bool) error {
+	status := "passing"
+	if !healthy {
+		status = "critical"
+	}
+	value, err := json.Marshal(instance{Registration: e.reg, Status: status})
+	if err != nil {
+		return err
+	}
+	err = e.call(ctx, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key())),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.lease,
+	}, nil)
+	if err == nil {
+		e.healthy = &healthy
+	}
+	return err
+}
+
+// call posts body to the gateway, decoding the response into out if given.
+func (e *Etcd) call(ctx context.Context, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := e.token(); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach etcd: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd answered %s to %s", resp.Status, path)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode etcd response to %s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package egress makes the HTTP clients the service calls providers,
+// webhooks and other services with. They share one transport, configured
+// once at startup, that can go through a proxy, trust the certificate
+// authorities of the network and pool connections to each host.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// What clients call, to set their timeouts by.
+const (
+	Maps      = "maps"      // routing, isochrone and Google geocoding APIs
+	Geocode   = "geocode"   // Nominatim and what3words
+	Weather   = "weather"   // OpenWeatherMap
+	Notify    = "notify"    // Slack and Teams webhooks, Twilio and FCM
+	Publish   = "publish"   // EventBridge, Service Bus, Pub/Sub and WebSocket publishers
+	Discovery = "discovery" // Consul and etcd
+)
+
+// Purposes are the names of what clients call.
+var Purposes = []string{Maps, Geocode, Weather, Notify, Publish, Discovery}
+
+// Options shape the transport and timeouts of the clients.
+type Options struct {
+	// Proxy is the URL of the proxy calls go through, except to the hosts
+	// in NoProxy, written as in NO_PROXY. Without one, HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY are used.
+	Proxy, NoProxy string
+	// CAFile is a PEM bundle of certificate authorities to trust besides
+	// the system's, such as a proxy's that inspects TLS.
+	CAFile string
+	// MaxIdleConns bounds the idle connections kept for reuse, and
+	// MaxIdleConnsPerHost those to one host; MaxConnsPerHost bounds all
+	// connections to one host, 0 for no limit. IdleConnTimeout is how long
+	// idle ones are kept.
+	MaxIdleConns, MaxIdleConnsPerHost, MaxConnsPerHost int
+	IdleConnTimeout                                    time.Duration
+	// Timeouts replace the default timeouts of clients by what they call.
+	Timeouts map[string]time.Duration
+}
+
+var (
+	mu        sync.RWMutex
+	transport http.RoundTripper = http.DefaultTransport
+	timeouts  map[string]time.Duration
+)
+
+// Configure sets the transport and timeouts of the clients made after it.
+func Configure(o Options) error {
+	t, err := NewTransport(o)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	transport, timeouts = t, o.Timeouts
+	return nil
+}
+
+// NewTransport returns a transport shaped by o.
+func NewTransport(o Options) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		if _, err := url.Parse(o.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		proxy := (&httpproxy.Config{HTTPProxy: o.Proxy, HTTPSProxy: o.Proxy, NoProxy: o.NoProxy}).ProxyFunc()
+		t.Proxy = func(r *http.Request) (*url.URL, error) { return proxy(r.URL) }
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA bundle")
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if o.MaxIdleConns > 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	t.MaxConnsPerHost = o.MaxConnsPerHost
+	if o.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	return t, nil
+}
+
+// Client returns a client for calls of the purpose, timing out after the
+// configured timeout for it, or timeout if none is; 0 leaves calls to the
+// deadlines of their contexts.
+func Client(purpose string, timeout time.Duration) *http.Client {
+	mu.RLock()
+	defer mu.RUnlock()
+	if d, ok := timeouts[purpose]; ok {
+		timeout = d
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// Proxy returns the proxy for requests, for clients that dial themselves,
+// such as for WebSockets.
+func Proxy(r *http.Request) (*url.URL, error) {
+	mu.RLock()
+	t, ok := transport.(*http.Transport)
+	mu.RUnlock()
+	if !ok || t.Proxy == nil {
+		return nil, nil
+	}
+	return t.Proxy(r)
+}
+
+// TLSConfig returns the TLS settings of the transport, nil for the
+// defaults, for clients that dial themselves.
+func TLSConfig() *tls.Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	if t, ok := transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		return t.TLSClientConfig.Clone()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package egress
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer direct.Close()
+
+	tr, err := NewTransport(Options{Proxy: proxy.URL, NoProxy: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: tr}
+	for _, u := range []string{"http://maps.example.com/directions", direct.URL + "/local"} {
+		resp, err := client.Get(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(proxied) != 1 || proxied[0] != "http://maps.example.com/directions" {
+		t.Errorf("proxied %q", proxied)
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&http.Client{Transport: http.DefaultTransport}).Get(srv.URL); err == nil {
+		t.Fatal("trusted the test server without its CA")
+	}
+	tr, err := NewTransport(Options{CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientTimeouts(t *testing.T) {
+	if err := Configure(Options{Timeouts: map[string]time.Duration{Maps: 3 * time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure(Options{})
+	if c := Client(Maps, 0); c.Timeout != 3*time.Second {
+		t.Errorf("maps timeout %v", c.Timeout)
+	}
+	if c := Client(Weather, 5*time.Second); c.Timeout != 5*time.Second {
+		t.Errorf("weather timeout %v", c.Timeout)
+	}
+}
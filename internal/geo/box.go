@@ -0,0 +1,78 @@
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Box is an area bounded by two meridians and two parallels. Boxes do not
+// cross the antimeridian.
+type Box struct {
+	Min Point `json:"min"`
+	Max Point `json:"max"`
+}
+
+// ParseBox reads a box in the "minLng,minLat,maxLng,maxLat" order used by
+// GeoJSON and most map libraries.
+func ParseBox(s string) (Box, error) {
+	var b Box
+	_, err := fmt.Sscanf(s, "%f,%f,%f,%f", &b.Min.Lng, &b.Min.Lat, &b.Max.Lng, &b.Max.Lat)
+	if err != nil {
+		return Box{}, err
+	}
+	return b, b.Validate()
+}
+
+// Validate checks that the corners are in range and in order.
+func (b Box) Validate() error {
+	switch {
+	case b.Min.Lat < -90 || b.Max.Lat > 90 || b.Min.Lng < -180 || b.Max.Lng > 180:
+		return errors.New("box is out of range")
+	case b.Min.Lat > b.Max.Lat || b.Min.Lng > b.Max.Lng:
+		return errors.New("box corners are swapped")
+	}
+	return nil
+}
+
+// Bounds returns the smallest box holding all the points.
+func Bounds(points []Point) Box {
+	if len(points) == 0 {
+		return Box{}
+	}
+	b := Box{Min: points[0], Max: points[0]}
+	for _, p := range points[1:] {
+		b.Min.Lat, b.Min.Lng = min(b.Min.Lat, p.Lat), min(b.Min.Lng, p.Lng)
+		b.Max.Lat, b.Max.Lng = max(b.Max.Lat, p.Lat), max(b.Max.Lng, p.Lng)
+	}
+	return b
+}
+
+// Contains reports whether p lies within the box, edges included.
+func (b Box) Contains(p Point) bool {
+	return p.Lat >= b.Min.Lat && p.Lat <= b.Max.Lat && p.Lng >= b.Min.Lng && p.Lng <= b.Max.Lng
+}
+
+// Center returns the point halfway between the corners.
+func (b Box) Center() Point {
+	return Point{Lat: (b.Min.Lat + b.Max.Lat) / 2, Lng: (b.Min.Lng + b.Max.Lng) / 2}
+}
+
+// Size returns the width and height of the box in meters. The width is
+// measured along the parallel nearest the equator, where the box is widest,
+// so a box of that size around the center covers the whole of b.
+func (b Box) Size() (width, height float64) {
+	lat := 0.0
+	switch {
+	case b.Min.Lat > 0:
+		lat = b.Min.Lat
+	case b.Max.Lat < 0:
+		lat = b.Max.Lat
+	}
+	width = (b.Max.Lng - b.Min.Lng) * math.Pi / 180 * earthRadius * math.Cos(lat*math.Pi/180)
+	height = (b.Max.Lat - b.Min.Lat) * math.Pi / 180 * earthRadius
+	return width, height
+}
+
+// World is the box covering every point.
+var World = Box{Min: Point{Lat: -90, Lng: -180}, Max: Point{Lat: 90, Lng: 180}}
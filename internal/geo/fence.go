@@ -0,0 +1,45 @@
+package geo
+
+import "errors"
+
+// Fence is a named area, either a circle around Center or a polygon.
+type Fence struct {
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Center and Radius, in meters, describe a circle.
+	Center *Point  `json:"center,omitempty" yaml:"center" toml:"center"`
+	Radius float64 `json:"radius,omitempty" yaml:"radius" toml:"radius"`
+	// Polygon lists the corners of a polygon; it is closed implicitly.
+	Polygon []Point `json:"polygon,omitempty" yaml:"polygon" toml:"polygon"`
+}
+
+// Validate checks that the fence has a name and exactly one usable shape.
+func (f Fence) Validate() error {
+	switch {
+	case f.Name == "":
+		return errors.New("geofence name is required")
+	case f.Center != nil && len(f.Polygon) > 0:
+		return errors.New("geofence " + f.Name + " is both a circle and a polygon")
+	case f.Center != nil && f.Radius <= 0:
+		return errors.New("geofence " + f.Name + " needs a positive radius")
+	case f.Center == nil && len(f.Polygon) < 3:
+		return errors.New("geofence " + f.Name + " needs a center or at least three corners")
+	}
+	return nil
+}
+
+// Contains reports whether p lies within the fence. Polygons are treated
+// as flat, which is accurate enough for the size of a delivery zone.
+func (f Fence) Contains(p Point) bool {
+	if f.Center != nil {
+		return Distance(*f.Center, p) <= f.Radius
+	}
+	inside := false
+	for i, j := 0, len(f.Polygon)-1; i < len(f.Polygon); j, i = i, i+1 {
+		a, b := f.Polygon[i], f.Polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
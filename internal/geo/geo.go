@@ -0,0 +1,62 @@
+// Package geo holds the coordinate type shared by the rest of the service.
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Point is a WGS84 coordinate.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// Parse reads a point in the "lat,lng" form used by String.
+func Parse(s string) (Point, error) {
+	lat, lng, ok := strings.Cut(s, ",")
+	if !ok {
+		return Point{}, fmt.Errorf("invalid point %q, want lat,lng", s)
+	}
+	var p Point
+	var err error
+	if p.Lat, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return Point{}, fmt.Errorf("invalid latitude in %q", s)
+	}
+	if p.Lng, err = strconv.ParseFloat(strings.TrimSpace(lng), 64); err != nil {
+		return Point{}, fmt.Errorf("invalid longitude in %q", s)
+	}
+	return p, nil
+}
+
+func (p Point) String() string {
+	var buf [32]byte
+	b := strconv.AppendFloat(buf[:0], p.Lat, 'f', 6, 64)
+	b = append(b, ',')
+	return string(strconv.AppendFloat(b, p.Lng, 'f', 6, 64))
+}
+
+const earthRadius = 6371000
+
+// Distance returns the great-circle distance between two points in meters.
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Toward returns the point meters along the straight line from a to b,
+// stopping at b. Lines are interpolated on the flat map, which is accurate
+// over the few kilometres of a delivery.
+func Toward(a, b Point, meters float64) Point {
+	d := Distance(a, b)
+	if d == 0 || meters >= d {
+		return b
+	}
+	f := max(meters, 0) / d
+	return Point{Lat: a.Lat + (b.Lat-a.Lat)*f, Lng: a.Lng + (b.Lng-a.Lng)*f}
+}
@@ -0,0 +1,20 @@
+package geo
+
+import "testing"
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse("1.345600,103.856700"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkString(b *testing.B) {
+	p := Point{Lat: 1.3456, Lng: 103.8567}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.String()
+	}
+}
@@ -0,0 +1,70 @@
+package geo
+
+// MaxGeohashPrecision is the longest geohash Geohash computes, about 4cm
+// across.
+const MaxGeohashPrecision = 12
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Geohash returns the geohash of p with the given number of characters.
+// Each character narrows the cell about 32 times: at 5 a cell is about
+// 5km across, at 6 about 1.2km by 0.6km and at 7 about 150m.
+func Geohash(p Point, precision int) string {
+	precision = min(max(precision, 1), MaxGeohashPrecision)
+	lat, lng := [2]float64{-90, 90}, [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	even, bits, ch := true, 0, 0
+	for len(hash) < precision {
+		// Bits alternate between longitude and latitude, longitude first
+		r, v := &lat, p.Lat
+		if even {
+			r, v = &lng, p.Lng
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bits++; bits == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// GeohashCenter returns the middle of a geohash cell, and false if hash is
+// not a valid geohash.
+func GeohashCenter(hash string) (Point, bool) {
+	lat, lng := [2]float64{-90, 90}, [2]float64{-180, 180}
+	even := true
+	for i := 0; i < len(hash); i++ {
+		ch := -1
+		for j := 0; j < len(geohashAlphabet); j++ {
+			if geohashAlphabet[j] == hash[i] {
+				ch = j
+			}
+		}
+		if ch < 0 {
+			return Point{}, false
+		}
+		for bit := 4; bit >= 0; bit-- {
+			r := &lat
+			if even {
+				r = &lng
+			}
+			mid := (r[0] + r[1]) / 2
+			if ch&(1<<bit) != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+	return Point{Lat: (lat[0] + lat[1]) / 2, Lng: (lng[0] + lng[1]) / 2}, len(hash) > 0
+}
@@ -0,0 +1,165 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// GeoJSONType is the media type of GeoJSON documents (RFC 7946).
+const GeoJSONType = "application/geo+json"
+
+// Geometry is a GeoJSON geometry. Coordinates are kept raw until asked for
+// as a specific shape.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// Feature is a GeoJSON feature. Properties are kept raw so that callers
+// can decode them into their own types.
+type Feature struct {
+	Type       string          `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Geometry   *Geometry       `json:"geometry"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+// FeatureCollection is a GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// position is a GeoJSON position, which puts longitude first.
+func position(p Point) [2]float64 {
+	return [2]float64{p.Lng, p.Lat}
+}
+
+func geometry(kind string, coordinates interface{}) *Geometry {
+	data, _ := json.Marshal(coordinates)
+	return &Geometry{Type: kind, Coordinates: data}
+}
+
+// NewPoint returns the Point geometry of p.
+func NewPoint(p Point) *Geometry {
+	return geometry("Point", position(p))
+}
+
+// NewLineString returns the LineString geometry through points.
+func NewLineString(points []Point) *Geometry {
+	line := make([][2]float64, len(points))
+	for i, p := range points {
+		line[i] = position(p)
+	}
+	return geometry("LineString", line)
+}
+
+// NewPolygon returns the Polygon geometry with the given corners, closing
+// the ring as GeoJSON requires.
+func NewPolygon(corners []Point) *Geometry {
+	ring := make([][2]float64, 0, len(corners)+1)
+	for _, p := range corners {
+		ring = append(ring, position(p))
+	}
+	if len(corners) > 0 && corners[0] != corners[len(corners)-1] {
+		ring = append(ring, position(corners[0]))
+	}
+	return geometry("Polygon", [][][2]float64{ring})
+}
+
+// NewFeature returns a feature with the given geometry and properties,
+// which are encoded as JSON.
+func NewFeature(id string, g *Geometry, properties interface{}) Feature {
+	props, _ := json.Marshal(properties)
+	return Feature{Type: "Feature", ID: id, Geometry: g, Properties: props}
+}
+
+// NewFeatureCollection returns a collection of the features.
+func NewFeatureCollection(features ...Feature) FeatureCollection {
+	if features == nil {
+		features = []Feature{}
+	}
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// Point returns the point of a Point geometry.
+func (g Geometry) Point() (Point, error) {
+	if g.Type != "Point" {
+		return Point{}, fmt.Errorf("want a Point geometry, got %q", g.Type)
+	}
+	var c []float64
+	if err := json.Unmarshal(g.Coordinates, &c); err != nil || len(c) < 2 {
+		return Point{}, errors.New("invalid Point coordinates")
+	}
+	return Point{Lat: c[1], Lng: c[0]}, nil
+}
+
+// Polygon returns the corners of the outer ring of a Polygon geometry,
+// without the closing repeat of the first corner. Holes are ignored.
+func (g Geometry) Polygon() ([]Point, error) {
+	if g.Type != "Polygon" {
+		return nil, fmt.Errorf("want a Polygon geometry, got %q", g.Type)
+	}
+	var rings [][][]float64
+	if err := json.Unmarshal(g.Coordinates, &rings); err != nil || len(rings) == 0 {
+		return nil, errors.New("invalid Polygon coordinates")
+	}
+	corners := make([]Point, 0, len(rings[0]))
+	for _, c := range rings[0] {
+		if len(c) < 2 {
+			return nil, errors.New("invalid Polygon coordinates")
+		}
+		corners = append(corners, Point{Lat: c[1], Lng: c[0]})
+	}
+	if n := len(corners); n > 1 && corners[0] == corners[n-1] {
+		corners = corners[:n-1]
+	}
+	return corners, nil
+}
+
+// Feature returns the fence as a GeoJSON feature named by its name. GeoJSON
+// has no circles, so those are a Point with a radius property, as most GIS
+// tools expect.
+func (f Fence) Feature() Feature {
+	if f.Center != nil {
+		return NewFeature(f.Name, NewPoint(*f.Center), map[string]interface{}{"name": f.Name, "radius": f.Radius})
+	}
+	return NewFeature(f.Name, NewPolygon(f.Polygon), map[string]interface{}{"name": f.Name})
+}
+
+// FenceFromFeature reads a fence written by Fence.Feature. The name comes
+// from the name property, or else the feature ID.
+func FenceFromFeature(feature Feature) (Fence, error) {
+	var props struct {
+		Name   string  `json:"name"`
+		Radius float64 `json:"radius"`
+	}
+	if len(feature.Properties) > 0 {
+		if err := json.Unmarshal(feature.Properties, &props); err != nil {
+			return Fence{}, fmt.Errorf("invalid geofence properties: %v", err)
+		}
+	}
+	f := Fence{Name: props.Name, Radius: props.Radius}
+	if f.Name == "" {
+		f.Name = feature.ID
+	}
+	if feature.Geometry == nil {
+		return Fence{}, errors.New("geofence " + f.Name + " has no geometry")
+	}
+	switch feature.Geometry.Type {
+	case "Point":
+		center, err := feature.Geometry.Point()
+		if err != nil {
+			return Fence{}, err
+		}
+		f.Center = &center
+	default:
+		corners, err := feature.Geometry.Polygon()
+		if err != nil {
+			return Fence{}, err
+		}
+		f.Polygon = corners
+	}
+	return f, f.Validate()
+}
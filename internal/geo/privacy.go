@@ -0,0 +1,27 @@
+package geo
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// Round returns p with its coordinates rounded to places decimal places.
+// Three places are about 100m apart.
+func Round(p Point, places int) Point {
+	scale := math.Pow(10, float64(places))
+	return Point{Lat: math.Round(p.Lat*scale) / scale, Lng: math.Round(p.Lng*scale) / scale}
+}
+
+// Fuzz moves p by up to meters in a direction and distance drawn from seed.
+// The same seed always moves points the same way, so that averaging many
+// fuzzed points of one order does not recover where they really were.
+func Fuzz(p Point, seed string, meters float64) Point {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	sum := h.Sum64()
+	bearing := float64(sum&0xffff) / 0x10000 * 2 * math.Pi
+	d := float64(sum>>16&0xffff) / 0xffff * meters
+	lat := p.Lat + d*math.Cos(bearing)/earthRadius*180/math.Pi
+	lng := p.Lng + d*math.Sin(bearing)/(earthRadius*math.Cos(p.Lat*math.Pi/180))*180/math.Pi
+	return Point{Lat: lat, Lng: lng}
+}
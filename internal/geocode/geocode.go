@@ -0,0 +1,84 @@
+// Package geocode resolves street addresses, and addressing systems for
+// places without one such as what3words and Plus Codes, to coordinates.
+package geocode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Addressing systems.
+const (
+	Address    = "address"
+	What3Words = "what3words"
+	PlusCode   = "plus_code"
+)
+
+// Street address geocoders.
+const (
+	GoogleGeocoder    = "google"
+	NominatimGeocoder = "nominatim"
+)
+
+// KnownGeocoder reports whether name is a supported street address
+// geocoder.
+func KnownGeocoder(name string) bool {
+	return name == GoogleGeocoder || name == NominatimGeocoder
+}
+
+// ErrNotFound is returned for addresses that are well formed but do not
+// resolve to a place.
+var ErrNotFound = errors.New("address not found")
+
+// Resolver resolves addresses of one system to coordinates.
+type Resolver interface {
+	Resolve(ctx context.Context, address string) (geo.Point, error)
+}
+
+// Cached remembers resolved addresses for TTL. Addresses in these systems
+// never move, so only memory bounds how long they could be kept.
+type Cached struct {
+	Next Resolver
+	TTL  time.Duration
+
+	mu        sync.Mutex
+	addresses map[string]cached
+}
+
+type cached struct {
+	point   geo.Point
+	expires time.Time
+}
+
+func (c *Cached) Resolve(ctx context.Context, address string) (geo.Point, error) {
+	key := strings.ToLower(strings.TrimSpace(address))
+	c.mu.Lock()
+	if e, ok := c.addresses[key]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.point, nil
+	}
+	c.mu.Unlock()
+
+	p, err := c.Next.Resolve(ctx, address)
+	if err != nil {
+		return geo.Point{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.addresses == nil {
+		c.addresses = map[string]cached{}
+	}
+	now := time.Now()
+	for k, e := range c.addresses {
+		if now.After(e.expires) {
+			delete(c.addresses, k)
+		}
+	}
+	c.addresses[key] = cached{point: p, expires: now.Add(c.TTL)}
+	return p, nil
+}
@@ -0,0 +1,126 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+func TestDecodePlusCode(t *testing.T) {
+	for _, tc := range []struct {
+		code string
+		want geo.Point
+	}{
+		{"849VCWC8+R9", geo.Point{Lat: 37.4220625, Lng: -122.0840625}},
+		{"849vcwc8+r9", geo.Point{Lat: 37.4220625, Lng: -122.0840625}},
+		{"7FG49Q00+", geo.Point{Lat: 20.375, Lng: 2.775}},
+		// Grid digits refine the area into 5 rows by 4 columns
+		{"849VCWC8+R92", geo.Point{Lat: 37.4220125, Lng: -122.084109375}},
+	} {
+		p, err := DecodePlusCode(tc.code)
+		if err != nil || math.Abs(p.Lat-tc.want.Lat) > 1e-9 || math.Abs(p.Lng-tc.want.Lng) > 1e-9 {
+			t.Errorf("%s: got %v, %v, want %v", tc.code, p, err, tc.want)
+		}
+	}
+	for _, code := range []string{"", "8FWC2345", "8FWC2345+G", "8FWC2345+G6+", "8FW0C000+", "7FG49Q00+22", "WC2345+G6", "8FWC2345+GI"} {
+		if _, err := DecodePlusCode(code); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%q: got %v, want ErrNotFound", code, err)
+		}
+	}
+}
+
+func TestShortPlusCode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("address") != "9G8F+6X Zurich" || r.URL.Query().Get("key") != "key" {
+			w.Write([]byte(`{"status":"ZERO_RESULTS","results":[]}`))
+			return
+		}
+		w.Write([]byte(`{"status":"OK","results":[{"geometry":{"location":{"lat":47.365562,"lng":8.524813}}}]}`))
+	}))
+	defer srv.Close()
+	c := NewPlusCodes(func() string { return "key" })
+	c.google.baseURL = srv.URL
+
+	p, err := c.Resolve(context.Background(), "9G8F+6X Zurich")
+	if err != nil || p != (geo.Point{Lat: 47.365562, Lng: 8.524813}) {
+		t.Errorf("got %v, %v", p, err)
+	}
+	if _, err := c.Resolve(context.Background(), "9G8F+6X Atlantis"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown locality: got %v, want ErrNotFound", err)
+	}
+	if _, err := c.Resolve(context.Background(), "9G8F+6X"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("no locality: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestWhat3WordsCached(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("words") != "filled.count.soap" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"BadWords","message":"Invalid or non-existent 3 word address"}}`))
+			return
+		}
+		w.Write([]byte(`{"country":"GB","coordinates":{"lng":-0.195543,"lat":51.520847},"words":"filled.count.soap"}`))
+	}))
+	defer srv.Close()
+	w3w := NewWhat3Words(func() string { return "key" })
+	w3w.baseURL = srv.URL
+	c := &Cached{Next: w3w, TTL: time.Minute}
+
+	for _, address := range []string{"///filled.count.soap", "///Filled.Count.Soap"} {
+		p, err := c.Resolve(context.Background(), address)
+		if err != nil || p != (geo.Point{Lat: 51.520847, Lng: -0.195543}) {
+			t.Errorf("%s: got %v, %v", address, p, err)
+		}
+	}
+	if _, err := c.Resolve(context.Background(), "filled.count.soup"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+}
+
+func TestNominatimRateLimited(t *testing.T) {
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times = append(times, time.Now())
+		if r.Header.Get("User-Agent") == "" || r.URL.Path != "/search" || r.URL.Query().Get("format") != "jsonv2" {
+			t.Errorf("request %s %v", r.URL, r.Header)
+		}
+		if r.URL.Query().Get("q") != "10 Downing Street, London" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"place_id":1,"lat":"51.5033635","lon":"-0.1276248","display_name":"10 Downing Street"}]`))
+	}))
+	defer srv.Close()
+	n := NewNominatim(srv.URL, 50*time.Millisecond)
+
+	p, err := n.Resolve(context.Background(), "10 Downing Street, London")
+	if err != nil || p != (geo.Point{Lat: 51.5033635, Lng: -0.1276248}) {
+		t.Errorf("got %v, %v", p, err)
+	}
+	if _, err := n.Resolve(context.Background(), "Nowhere Lane"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	if len(times) != 2 || times[1].Sub(times[0]) < 50*time.Millisecond {
+		t.Errorf("requests at %v, want them 50ms apart", times)
+	}
+
+	// A caller giving up while waiting for its turn makes no request
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	n.Resolve(context.Background(), "a")
+	if _, err := n.Resolve(ctx, "b"); !errors.Is(err, context.DeadlineExceeded) || len(times) != 3 {
+		t.Errorf("got %v after %d requests", err, len(times))
+	}
+}
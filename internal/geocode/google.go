@@ -0,0 +1,69 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// Google resolves street addresses, and anything else Google Maps
+// understands, with the Google Geocoding API. The API key is looked up on
+// every call so it can be rotated without a restart.
+type Google struct {
+	apiKey  func() string
+	baseURL string
+	client  *http.Client
+}
+
+func NewGoogle(apiKey func() string) *Google {
+	return &Google{
+		apiKey:  apiKey,
+		baseURL: "https://maps.googleapis.com/maps/api/geocode/json",
+		client:  egress.Client(egress.Maps, 5*time.Second),
+	}
+}
+
+func (g *Google) Resolve(ctx context.Context, address string) (geo.Point, error) {
+	key := g.apiKey()
+	if key == "" {
+		return geo.Point{}, fmt.Errorf("no maps API key configured for geocoding")
+	}
+	query := url.Values{"address": {address}, "key": {key}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return geo.Point{}, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return geo.Point{}, fmt.Errorf("failed to geocode: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return geo.Point{}, fmt.Errorf("failed to geocode: %s", resp.Status)
+	}
+
+	var body struct {
+		Status  string `json:"status"`
+		Results []struct {
+			Geometry struct {
+				Location geo.Point `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return geo.Point{}, fmt.Errorf("failed to decode geocoding response: %v", err)
+	}
+	switch {
+	case body.Status == "ZERO_RESULTS":
+		return geo.Point{}, fmt.Errorf("%w: %s", ErrNotFound, address)
+	case body.Status != "OK" || len(body.Results) == 0:
+		return geo.Point{}, fmt.Errorf("failed to geocode: %s", body.Status)
+	}
+	return body.Results[0].Geometry.Location, nil
+}
@@ -0,0 +1,98 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// NominatimURL is the public OpenStreetMap Nominatim instance, whose usage
+// policy allows at most one request a second.
+const NominatimURL = "https://nominatim.openstreetmap.org"
+
+// Nominatim resolves street addresses with an OpenStreetMap Nominatim
+// server, free of charge. Requests are spaced at least interval apart, as
+// every Nominatim instance expects, so callers wait their turn; wrap it in
+// Cached so repeated addresses do not.
+type Nominatim struct {
+	baseURL  string
+	interval time.Duration
+	client   *http.Client
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func NewNominatim(baseURL string, interval time.Duration) *Nominatim {
+	return &Nominatim{
+		baseURL:  baseURL,
+		interval: interval,
+		client:   egress.Client(egress.Geocode, 10*time.Second),
+	}
+}
+
+// wait blocks until the next request may be made, or ctx is done.
+func (n *Nominatim) wait(ctx context.Context) error {
+	n.mu.Lock()
+	now := time.Now()
+	at := n.next
+	if at.Before(now) {
+		at = now
+	}
+	n.next = at.Add(n.interval)
+	n.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *Nominatim) Resolve(ctx context.Context, address string) (geo.Point, error) {
+	if err := n.wait(ctx); err != nil {
+		return geo.Point{}, err
+	}
+	query := url.Values{"q": {address}, "format": {"jsonv2"}, "limit": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.baseURL+"/search?"+query.Encode(), nil)
+	if err != nil {
+		return geo.Point{}, err
+	}
+	// The usage policy requires identifying the application
+	req.Header.Set("User-Agent", "esd-location")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return geo.Point{}, fmt.Errorf("failed to geocode: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return geo.Point{}, fmt.Errorf("failed to geocode: %s", resp.Status)
+	}
+
+	// Nominatim writes coordinates as strings
+	var places []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&places); err != nil {
+		return geo.Point{}, fmt.Errorf("failed to decode geocoding response: %v", err)
+	}
+	if len(places) == 0 {
+		return geo.Point{}, fmt.Errorf("%w: %s", ErrNotFound, address)
+	}
+	lat, errLat := strconv.ParseFloat(places[0].Lat, 64)
+	lng, errLng := strconv.ParseFloat(places[0].Lon, 64)
+	if errLat != nil || errLng != nil {
+		return geo.Point{}, fmt.Errorf("failed to decode geocoding response: invalid coordinates")
+	}
+	return geo.Point{Lat: lat, Lng: lng}, nil
+}
@@ -0,0 +1,98 @@
+package geocode
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"location/internal/geo"
+)
+
+// Open Location Code alphabet and layout. The first ten digits are pairs
+// of latitude and longitude digits in base 20; later ones each split the
+// area into a grid of 5 rows and 4 columns.
+const (
+	olcAlphabet   = "23456789CFGHJMPQRVWX"
+	olcSeparator  = '+'
+	olcPadding    = '0'
+	olcPairDigits = 10
+	olcGridRows   = 5
+	olcGridCols   = 4
+)
+
+// PlusCodes resolves Open Location Codes. Full codes such as
+// 6PH57VP3+PR are decoded locally; short ones relative to a locality, such
+// as "7VP3+PR Singapore", are resolved with the Google Geocoding API.
+type PlusCodes struct {
+	google *Google
+}
+
+func NewPlusCodes(apiKey func() string) *PlusCodes {
+	return &PlusCodes{google: NewGoogle(apiKey)}
+}
+
+func (c *PlusCodes) Resolve(ctx context.Context, address string) (geo.Point, error) {
+	code, locality, _ := strings.Cut(strings.TrimSpace(address), " ")
+	// Full codes need no locality, so one given anyway is ignored
+	sep := strings.IndexByte(code, olcSeparator)
+	if sep == 8 {
+		return DecodePlusCode(code)
+	}
+	if sep < 2 || sep%2 != 0 {
+		return geo.Point{}, fmt.Errorf("%w: invalid plus code %q", ErrNotFound, code)
+	}
+	if strings.TrimSpace(locality) == "" {
+		return geo.Point{}, fmt.Errorf("%w: short plus code %q needs a locality", ErrNotFound, code)
+	}
+	return c.google.Resolve(ctx, address)
+}
+
+// DecodePlusCode returns the center of the area of a full Open Location
+// Code.
+func DecodePlusCode(code string) (geo.Point, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	invalid := fmt.Errorf("%w: invalid plus code %q", ErrNotFound, code)
+	if strings.IndexByte(code, olcSeparator) != 8 || strings.Count(code, string(olcSeparator)) != 1 {
+		return geo.Point{}, invalid
+	}
+	digits := strings.Replace(code, string(olcSeparator), "", 1)
+	// Padded codes stop at the first padding character, which must run to
+	// the separator in pairs.
+	if i := strings.IndexByte(digits, olcPadding); i >= 0 {
+		if i < 2 || i%2 != 0 || len(digits) != 8 || strings.Trim(digits[i:], string(olcPadding)) != "" {
+			return geo.Point{}, invalid
+		}
+		digits = digits[:i]
+	}
+	if len(digits) == 9 {
+		// A single digit after the separator is not allowed
+		return geo.Point{}, invalid
+	}
+
+	lat, lng := -90.0, -180.0
+	latStep, lngStep := 400.0, 400.0
+	for i := 0; i < len(digits); i++ {
+		v := strings.IndexByte(olcAlphabet, digits[i])
+		if v < 0 {
+			return geo.Point{}, invalid
+		}
+		if i < olcPairDigits {
+			if i%2 == 0 {
+				latStep /= 20
+				lat += float64(v) * latStep
+			} else {
+				lngStep /= 20
+				lng += float64(v) * lngStep
+			}
+			continue
+		}
+		latStep /= olcGridRows
+		lngStep /= olcGridCols
+		lat += float64(v/olcGridCols) * latStep
+		lng += float64(v%olcGridCols) * lngStep
+	}
+	if lat >= 90 || lng >= 180 {
+		return geo.Point{}, invalid
+	}
+	return geo.Point{Lat: lat + latStep/2, Lng: lng + lngStep/2}, nil
+}
@@ -0,0 +1,74 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// W3W converts three word addresses such as ///filled.count.soap with the
+// what3words API. The API key is looked up on every call so it can be
+// rotated without a restart.
+type W3W struct {
+	apiKey  func() string
+	baseURL string
+	client  *http.Client
+}
+
+func NewWhat3Words(apiKey func() string) *W3W {
+	return &W3W{
+		apiKey:  apiKey,
+		baseURL: "https://api.what3words.com/v3/convert-to-coordinates",
+		client:  egress.Client(egress.Geocode, 5*time.Second),
+	}
+}
+
+func (w *W3W) Resolve(ctx context.Context, address string) (geo.Point, error) {
+	key := w.apiKey()
+	if key == "" {
+		return geo.Point{}, fmt.Errorf("no what3words API key configured")
+	}
+	words := strings.TrimPrefix(strings.TrimSpace(address), "///")
+	if strings.Count(words, ".") != 2 {
+		return geo.Point{}, fmt.Errorf("%w: want three words separated by dots", ErrNotFound)
+	}
+	query := url.Values{"words": {words}, "key": {key}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return geo.Point{}, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return geo.Point{}, fmt.Errorf("failed to convert what3words address: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Coordinates *geo.Point `json:"coordinates"`
+		Error       *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return geo.Point{}, fmt.Errorf("failed to decode what3words response: %v", err)
+	}
+	if body.Error != nil {
+		// BadWords and the like describe the address, anything else the call
+		if strings.HasPrefix(body.Error.Code, "BadWords") {
+			return geo.Point{}, fmt.Errorf("%w: %s", ErrNotFound, body.Error.Message)
+		}
+		return geo.Point{}, fmt.Errorf("failed to convert what3words address: %s", body.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || body.Coordinates == nil {
+		return geo.Point{}, fmt.Errorf("failed to convert what3words address: %s", resp.Status)
+	}
+	return *body.Coordinates, nil
+}
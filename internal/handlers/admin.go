@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/routing"
+	"location/internal/store"
+)
+
+// AdminConfig shows and changes the runtime settings. Only operators of the
+// whole deployment may change them, not the admins of a tenant.
+func (h *Handler) AdminConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch, http.MethodPost:
+		// Settings are shared by every tenant
+		if !h.auth.AuthorizeOperator(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		var update config.SettingsUpdate
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		_, err = h.runtime.Update(update)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.writeJSON(w, r, h.runtime.Settings())
+}
+
+// OrderSummary is an order as listed to operators.
+type OrderSummary struct {
+	store.Order
+	Status string `json:"status"`
+	// Stale is set when the courier has stopped reporting its location.
+	Stale bool `json:"stale"`
+}
+
+// AdminOrders lists every stored order. The stale_after query parameter
+// overrides tracking.stale_after, how long a courier may be silent before
+// being flagged.
+func (h *Handler) AdminOrders(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	staleAfter := h.trackerFor(r.Context()).StaleAfter()
+	if v := r.URL.Query().Get("stale_after"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid stale_after", http.StatusBadRequest)
+			return
+		}
+		staleAfter = d
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	orders := []OrderSummary{}
+	err := h.trackerFor(r.Context()).ForEachOrder(ctx, func(o store.Order) error {
+		orders = append(orders, OrderSummary{
+			Order:  o,
+			Status: o.Status(staleAfter),
+			Stale:  o.Stale(staleAfter),
+		})
+		return nil
+	})
+	if err != nil {
+		failed(ctx, w, "Failed to list orders")
+		return
+	}
+	h.writeJSON(w, r, orders)
+}
+
+// AdminOrderHistory returns every recorded change to an order, oldest first.
+func (h *Handler) AdminOrderHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	if _, ok := h.loadOrder(w, r, orderID); !ok {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	history, err := h.trackerFor(r.Context()).History(ctx, orderID)
+	if err != nil {
+		failed(ctx, w, "Failed to get order history")
+		return
+	}
+	if wantsGeoJSON(r) {
+		h.writeGeoJSON(w, r, historyFeatures(history))
+		return
+	}
+	h.writeJSON(w, r, history)
+}
+
+// OrderTimeline tells support staff, in plain words, what happened to an
+// order from its creation on.
+func (h *Handler) OrderTimeline(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+	if _, ok := h.loadOrder(w, r, orderID); !ok {
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	timeline, err := h.trackerFor(r.Context()).Timeline(ctx, orderID)
+	if err != nil {
+		failed(ctx, w, "Failed to get order timeline")
+		return
+	}
+	h.writeJSON(w, r, timeline)
+}
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// Dashboard serves the operator dashboard. The page itself holds no data;
+// it fetches everything from the admin API, which checks credentials, so
+// that operators using the static admin token can load it too.
+func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// AdminDeleteOrder forgets an order and its history.
+func (h *Handler) AdminDeleteOrder(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orderID := mux.Vars(r)["id"]
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err := h.trackerFor(r.Context()).DeleteOrder(ctx, orderID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to delete order")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ProviderUsage is how much a route provider was used today by this
+// instance.
+type ProviderUsage struct {
+	Provider string `json:"provider"`
+	Selected bool   `json:"selected"`
+	routing.Usage
+	DailyQuota int `json:"daily_quota,omitempty"`
+	Remaining  int `json:"remaining,omitempty"`
+}
+
+// AdminProviders reports today's provider calls against the configured
+// quota. Counts are per instance, so add them up across replicas.
+func (h *Handler) AdminProviders(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	selected := h.runtime.Settings().Provider
+	quota := h.runtime.Config().ForTenant(auth.TenantFromContext(r.Context())).Maps.DailyQuota
+	providers := []ProviderUsage{}
+	for name, usage := range h.trackerFor(r.Context()).Usage() {
+		p := ProviderUsage{Provider: name, Selected: name == selected, Usage: usage}
+		if name == routing.Google && quota > 0 {
+			p.DailyQuota = quota
+			p.Remaining = max(quota-int(usage.Calls), 0)
+		}
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Provider < providers[j].Provider })
+	h.writeJSON(w, r, providers)
+}
+
+// AdminWorkers reports the queue depth of the travel time workers of this
+// instance, or 404 if they are not enabled.
+func (h *Handler) AdminWorkers(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	stats, ok := h.trackerFor(r.Context()).PoolStats()
+	if !ok {
+		http.Error(w, "Workers are not enabled", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, r, stats)
+}
+
+// AdminRejections reports how many courier locations were rejected as
+// outliers. Like provider usage, counts are per instance.
+func (h *Handler) AdminRejections(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.writeJSON(w, r, h.trackerFor(r.Context()).Rejections())
+}
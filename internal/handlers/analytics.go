@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"location/internal/tracking"
+)
+
+// analyze summarizes the deliveries over the days from the from query
+// parameter up to and including to, both YYYY-MM-DD in UTC. The period
+// defaults to the last seven days, today included.
+func (h *Handler) analyze(w http.ResponseWriter, r *http.Request) (tracking.Analytics, bool) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return tracking.Analytics{}, false
+	}
+	query := r.URL.Query()
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := query.Get("to"); v != "" {
+		var err error
+		if to, err = time.Parse(time.DateOnly, v); err != nil {
+			http.Error(w, "Invalid to, want YYYY-MM-DD", http.StatusBadRequest)
+			return tracking.Analytics{}, false
+		}
+	}
+	from := to.AddDate(0, 0, -6)
+	if v := query.Get("from"); v != "" {
+		var err error
+		if from, err = time.Parse(time.DateOnly, v); err != nil {
+			http.Error(w, "Invalid from, want YYYY-MM-DD", http.StatusBadRequest)
+			return tracking.Analytics{}, false
+		}
+	}
+	if from.After(to) || to.Sub(from) > 366*24*time.Hour {
+		http.Error(w, "from must be before to and at most a year apart", http.StatusBadRequest)
+		return tracking.Analytics{}, false
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	a, err := h.trackerFor(r.Context()).Analyze(ctx, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		failed(ctx, w, "Failed to analyze deliveries")
+		return tracking.Analytics{}, false
+	}
+	return a, true
+}
+
+// AnalyticsDeliveries counts the orders delivered per day.
+func (h *Handler) AnalyticsDeliveries(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.analyze(w, r)
+	if !ok {
+		return
+	}
+	h.writeJSON(w, r, a.Days)
+}
+
+// AnalyticsETAError reports the mean and 95th percentile error of the ETAs
+// given for the orders delivered.
+func (h *Handler) AnalyticsETAError(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.analyze(w, r)
+	if !ok {
+		return
+	}
+	h.writeJSON(w, r, a.ETAError)
+}
+
+// AnalyticsTrips averages trip distance and time per travel mode, or per
+// configured zone with by=zone.
+func (h *Handler) AnalyticsTrips(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by != "" && by != "mode" && by != "zone" {
+		http.Error(w, "Invalid by, want mode or zone", http.StatusBadRequest)
+		return
+	}
+	a, ok := h.analyze(w, r)
+	if !ok {
+		return
+	}
+	if by == "zone" {
+		h.writeJSON(w, r, a.Zones)
+		return
+	}
+	h.writeJSON(w, r, a.Modes)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/tracking"
+)
+
+// maxBatch caps the orders in one batch, keeping a request within what one
+// instance can work through before clients give up on it.
+const maxBatch = 5000
+
+// BatchOrder is one order in a batch registration.
+type BatchOrder struct {
+	OrderID  string            `json:"order_id"`
+	Lat      float64           `json:"lat"`
+	Lng      float64           `json:"lng"`
+	Mode     string            `json:"mode,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	DepartAt time.Time         `json:"depart_at,omitempty"`
+	Deadline time.Time         `json:"deadline,omitempty"`
+	PrepTime time.Duration     `json:"prep_time,omitempty"`
+	// HandlingTime is added to the order's routed trip.
+	HandlingTime time.Duration `json:"handling_time,omitempty"`
+}
+
+// BatchRequest registers many orders at once.
+type BatchRequest struct {
+	Orders []BatchOrder `json:"orders"`
+}
+
+// BatchResult is the outcome for one order of a batch, in request order.
+type BatchResult struct {
+	OrderID string `json:"order_id"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchResponse reports every order of a batch.
+type BatchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// BatchOrders registers the target, mode and metadata of many orders in one
+// call. Orders succeed or fail individually; the response is 200 either way
+// and lists the outcome of each.
+func (h *Handler) BatchOrders(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var batch BatchRequest
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(batch.Orders) > maxBatch {
+		http.Error(w, "Too many orders in one batch", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := BatchResponse{Results: make([]BatchResult, 0, len(batch.Orders))}
+	for _, o := range batch.Orders {
+		result := BatchResult{OrderID: o.OrderID}
+		if msg := h.registerBatchOrder(r, o); msg != "" {
+			result.Error = msg
+			resp.Failed++
+		} else {
+			result.OK = true
+			resp.Succeeded++
+		}
+		resp.Results = append(resp.Results, result)
+
+		if r.Context().Err() != nil {
+			// The client is gone; nobody will read the rest
+			return
+		}
+	}
+	h.writeJSON(w, r, resp)
+}
+
+// check returns why o cannot be registered, if it cannot.
+func (o BatchOrder) check() string {
+	switch {
+	case o.OrderID == "":
+		return "order_id is required"
+	case o.Lat < -90 || o.Lat > 90 || o.Lng < -180 || o.Lng > 180:
+		return "invalid coordinates"
+	case o.PrepTime < 0 || o.HandlingTime < 0:
+		return "invalid prep or handling time"
+	}
+	return checkMetadata(o.Metadata)
+}
+
+func (o BatchOrder) newOrder() tracking.NewOrder {
+	return tracking.NewOrder{
+		ID:           o.OrderID,
+		Target:       geo.Point{Lat: o.Lat, Lng: o.Lng},
+		Mode:         o.Mode,
+		Metadata:     o.Metadata,
+		DepartAt:     o.DepartAt,
+		Deadline:     o.Deadline,
+		PrepTime:     o.PrepTime,
+		HandlingTime: o.HandlingTime,
+	}
+}
+
+// registerBatchOrder registers one order of a batch, each within its own
+// request timeout, and returns why it failed, if it did.
+func (h *Handler) registerBatchOrder(r *http.Request, o BatchOrder) string {
+	if msg := o.check(); msg != "" {
+		return msg
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err := h.trackerFor(r.Context()).RegisterOrder(ctx, o.newOrder())
+	if ctx.Err() == context.DeadlineExceeded {
+		return "timed out"
+	}
+	if err != nil {
+		return "failed to store order"
+	}
+	return ""
+}
+
+// maxQuery caps the orders read in one query, about a screenful of orders
+// lists with room to spare.
+const maxQuery = 500
+
+// BulkRead names the orders to read at once.
+type BulkRead struct {
+	OrderIDs []string `json:"order_ids"`
+}
+
+// OrderState is the current state and ETA of one queried order.
+type OrderState struct {
+	Order OrderSummary `json:"order"`
+	ETA   ETA          `json:"eta"`
+}
+
+// BulkReadResponse holds the queried orders that exist, in request order,
+// and the IDs of those that do not.
+type BulkReadResponse struct {
+	Orders  []OrderState `json:"orders"`
+	Missing []string     `json:"missing,omitempty"`
+}
+
+// QueryOrders reads the state and ETA of many orders in one call, in place of
+// one GET per order.
+func (h *Handler) QueryOrders(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var read BulkRead
+	if err := json.NewDecoder(r.Body).Decode(&read); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(read.OrderIDs) > maxQuery {
+		http.Error(w, "Too many orders in one query", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	tracker := h.trackerFor(r.Context())
+	orders, err := tracker.Orders(ctx, read.OrderIDs)
+	if err != nil {
+		failed(ctx, w, "Failed to get orders")
+		return
+	}
+
+	staleAfter := tracker.StaleAfter()
+	resp := BulkReadResponse{Orders: make([]OrderState, 0, len(orders))}
+	found := make(map[string]bool, len(orders))
+	for _, order := range orders {
+		found[order.ID] = true
+		resp.Orders = append(resp.Orders, OrderState{
+			Order: OrderSummary{Order: order, Status: order.Status(staleAfter), Stale: order.Stale(staleAfter)},
+			ETA:   etaOf(tracker, order),
+		})
+	}
+	for _, id := range read.OrderIDs {
+		if !found[id] {
+			resp.Missing = append(resp.Missing, id)
+		}
+	}
+	h.writeJSON(w, r, resp)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"location/internal/store"
+)
+
+// BillingLine is the distance travelled with one order.
+type BillingLine struct {
+	OrderID  string `json:"order_id"`
+	DriverID string `json:"driver_id,omitempty"`
+	Day      string `json:"day"`
+	// Distance is in meters, summed over the courier's reported locations
+	// rather than taken from a routed estimate.
+	Distance    float64    `json:"distance"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// AdminBilling exports the distance travelled per order for invoicing. An
+// order belongs to the UTC day it was delivered on, or while undelivered,
+// the day its courier last reported. The day query parameter picks the day,
+// today by default; driver limits the export to one driver. The export is
+// CSV unless format=json.
+func (h *Handler) AdminBilling(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	day := time.Now().UTC().Format(time.DateOnly)
+	if v := query.Get("day"); v != "" {
+		if _, err := time.Parse(time.DateOnly, v); err != nil {
+			http.Error(w, "Invalid day, want YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = v
+	}
+	driver := query.Get("driver")
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	lines := []BillingLine{}
+	err := h.trackerFor(r.Context()).ForEachOrder(ctx, func(o store.Order) error {
+		line := BillingLine{OrderID: o.ID, DriverID: o.DriverID, Distance: o.Distance}
+		switch {
+		case o.Delivery != nil:
+			line.Day, line.DeliveredAt = o.Delivery.At.UTC().Format(time.DateOnly), &o.Delivery.At
+		case o.Distance > 0:
+			line.Day = o.SeenAt.UTC().Format(time.DateOnly)
+		default:
+			return nil
+		}
+		if line.Day == day && (driver == "" || driver == o.DriverID) {
+			lines = append(lines, line)
+		}
+		return nil
+	})
+	if err != nil {
+		failed(ctx, w, "Failed to list orders")
+		return
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].OrderID < lines[j].OrderID })
+
+	if query.Get("format") == "json" {
+		h.writeJSON(w, r, lines)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="billing-`+day+`.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"order_id", "driver_id", "day", "distance_km", "delivered_at"})
+	for _, l := range lines {
+		delivered := ""
+		if l.DeliveredAt != nil {
+			delivered = l.DeliveredAt.UTC().Format(time.RFC3339)
+		}
+		out.Write([]string{l.OrderID, l.DriverID, l.Day, strconv.FormatFloat(l.Distance/1000, 'f', 3, 64), delivered})
+	}
+	out.Flush()
+}
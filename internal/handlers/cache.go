@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"location/internal/store"
+)
+
+// revalidated is a read of an order started by revalidate.
+type revalidated struct {
+	orderID string
+	// rev is the revision the ETag was derived from, and key the one to
+	// keep the response under with respond
+	rev int64
+	key string
+}
+
+// revalidate starts a read of an order's state in the given representation,
+// such as "eta", by setting its ETag, derived from the order's revision,
+// which every change to it advances. Clients that have it already get a 304, and others the
+// response kept for it if there is one. Otherwise it returns the read to
+// go on with loadRevalidated and respond, and true.
+func (h *Handler) revalidate(w http.ResponseWriter, r *http.Request, orderID, representation, contentType string) (revalidated, bool) {
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	tracker := h.trackerFor(r.Context())
+	rev, err := tracker.Revision(ctx, orderID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return revalidated{}, false
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to get order")
+		return revalidated{}, false
+	}
+
+	// Audiences see different fields, and must not be served each other's,
+	// nor callers shown coarse positions those shown exact ones
+	representation += "." + h.audienceOf(r).String()
+	if h.coarse(r) {
+		representation += ".coarse"
+	}
+	tag := fmt.Sprintf(`"%d-%s"`, rev, representation)
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if matchesETag(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return revalidated{}, false
+	}
+
+	key := fmt.Sprintf("response:%s:%s:%d", representation, orderID, rev)
+	if h.runtime.Config().Cache.ResponseTTL.Duration > 0 {
+		if body, ok := tracker.CachedResponse(ctx, key); ok {
+			w.Header().Set("Content-Type", contentType)
+			w.Write(body)
+			return revalidated{}, false
+		}
+	}
+	return revalidated{orderID: orderID, rev: rev, key: key}, true
+}
+
+// loadRevalidated loads the order of a read started with revalidate. The
+// revision comes from the primary, so an order read from a replica lagging
+// behind it is read again from the primary, lest it be served, and kept,
+// under the ETag of a later state.
+func (h *Handler) loadRevalidated(w http.ResponseWriter, r *http.Request, rv revalidated) (store.Order, bool) {
+	order, ok := h.loadOrder(w, r, rv.orderID)
+	if !ok || order.Revision >= rv.rev {
+		return order, ok
+	}
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	order, err := h.trackerFor(r.Context()).Order(store.Primary(ctx), rv.orderID)
+	if err != nil {
+		failed(ctx, w, "Failed to get order")
+		return order, false
+	}
+	return order, true
+}
+
+// respond writes v, as the caller of r may see it, as the response of a read
+// started with revalidate, and keeps it under key if responses are cached.
+func (h *Handler) respond(w http.ResponseWriter, r *http.Request, key, contentType string, v interface{}) {
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(h.shape(r, v))
+	if ttl := h.runtime.Config().Cache.ResponseTTL.Duration; ttl > 0 {
+		ctx, cancel := h.requestContext(r)
+		defer cancel()
+		if err := h.trackerFor(r.Context()).CacheResponse(ctx, key, body.Bytes(), ttl); err != nil {
+			log.Printf("failed to cache response: %v", err)
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body.Bytes())
+}
+
+// matchesETag reports whether an If-None-Match header lists tag, comparing
+// weakly as RFC 9110 asks.
+func matchesETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/ingest"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// DriverPosition is the payload drivers report their position with.
+type DriverPosition struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+	// Accuracy is the radius of uncertainty in meters, 0 if unknown.
+	Accuracy float64 `json:"accuracy,omitempty"`
+}
+
+// RegisterDriver creates a driver or replaces its status, orders and
+// metadata. The ordering backend calls it when it dispatches orders.
+func (h *Handler) RegisterDriver(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var driver store.Driver
+	err := json.NewDecoder(r.Body).Decode(&driver)
+	if err != nil || driver.ID == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if driver.Status == "" {
+		driver.Status = store.DriverAvailable
+	}
+	switch driver.Status {
+	case store.DriverAvailable, store.DriverBusy, store.DriverOffline:
+	default:
+		http.Error(w, "Unknown driver status", http.StatusBadRequest)
+		return
+	}
+	if shift := driver.Metadata[h.runtime.Config().Privacy.ShiftKey]; shift != "" {
+		if _, err := config.InWindow(shift, time.Now()); err != nil {
+			http.Error(w, "Invalid shift, want HH:MM-HH:MM", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SaveDriver(ctx, driver)
+	if err != nil {
+		failed(ctx, w, "Failed to save driver")
+		return
+	}
+	driver, err = h.trackerFor(r.Context()).Driver(ctx, driver.ID)
+	if err != nil {
+		failed(ctx, w, "Failed to get driver")
+		return
+	}
+	h.writeJSON(w, r, driver)
+}
+
+// Driver returns a driver's status, position and orders.
+func (h *Handler) Driver(w http.ResponseWriter, r *http.Request) {
+	driverID := mux.Vars(r)["id"]
+	if !h.auth.AuthorizeDriver(r, driverID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	driver, err := h.trackerFor(r.Context()).Driver(ctx, driverID)
+	if errors.Is(err, store.ErrDriverNotFound) {
+		http.Error(w, "Driver not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to get driver")
+		return
+	}
+	h.writeJSON(w, r, driver)
+}
+
+// DriverLocation records a driver's position and recalculates and publishes
+// the travel time of every order the driver carries.
+func (h *Handler) DriverLocation(w http.ResponseWriter, r *http.Request) {
+	driverID := mux.Vars(r)["id"]
+	var pos DriverPosition
+	err := json.NewDecoder(r.Body).Decode(&pos)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !h.auth.AuthorizeDriver(r, driverID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if pos.Accuracy < 0 {
+		http.Error(w, "Invalid accuracy", http.StatusBadRequest)
+		return
+	}
+
+	h.moveDriver(w, r, driverID, geo.Point{Lat: pos.Lat, Lng: pos.Lng}, store.Telemetry{Accuracy: pos.Accuracy})
+}
+
+// DriverNMEA records a driver's position from NMEA 0183 sentences, one per
+// line, for GPS units that can post their raw output. The last GGA or RMC
+// sentence with a fix wins.
+func (h *Handler) DriverNMEA(w http.ResponseWriter, r *http.Request) {
+	driverID := mux.Vars(r)["id"]
+	if !h.auth.AuthorizeDriver(r, driverID) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var (
+		last   geo.Point
+		lastAt time.Time
+		found  bool
+	)
+	lines := bufio.NewScanner(io.LimitReader(r.Body, maxNMEABody))
+	for lines.Scan() {
+		if strings.TrimSpace(lines.Text()) == "" {
+			continue
+		}
+		p, at, ok, err := ingest.ParseNMEA(lines.Text())
+		if err != nil {
+			http.Error(w, "Invalid NMEA sentence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ok {
+			last, lastAt, found = p, at, true
+		}
+	}
+	if lines.Err() != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !found {
+		http.Error(w, "No GGA or RMC sentence with a fix", http.StatusBadRequest)
+		return
+	}
+	var tel store.Telemetry
+	if !lastAt.IsZero() {
+		tel.RecordedAt = &lastAt
+	}
+	h.moveDriver(w, r, driverID, last, tel)
+}
+
+// maxNMEABody bounds the sentences read from one request; units post a few
+// seconds of output at a time.
+const maxNMEABody = 64 << 10
+
+// moveDriver records a driver's position and publishes the travel times it
+// changes, except degraded ones.
+func (h *Handler) moveDriver(w http.ResponseWriter, r *http.Request, driverID string, p geo.Point, tel store.Telemetry) {
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	update, err := h.trackerFor(r.Context()).UpdateDriverLocation(ctx, driverID, p, tel)
+	if errors.Is(err, store.ErrDriverNotFound) {
+		http.Error(w, "Driver not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, tracking.ErrInaccurate) || errors.Is(err, tracking.ErrTeleport) {
+		http.Error(w, "Location rejected: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to update driver location")
+		return
+	}
+
+	for orderID, travelTime := range update.ETAs {
+		if slices.Contains(update.Degraded, orderID) {
+			continue
+		}
+		err = h.trackerFor(r.Context()).PublishTravelTime(ctx, orderID, travelTime)
+		if err != nil {
+			failed(ctx, w, "Failed to publish travel time")
+			return
+		}
+	}
+	h.writeJSON(w, r, update)
+}
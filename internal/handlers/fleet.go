@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// FleetOrder is an order as placed on the operations map.
+type FleetOrder struct {
+	OrderID  string        `json:"order_id"`
+	DriverID string        `json:"driver_id,omitempty"`
+	Position geo.Point     `json:"position"`
+	SeenAt   time.Time     `json:"seen_at"`
+	ETA      time.Duration `json:"eta"`
+	ETALow   time.Duration `json:"eta_low,omitempty"`
+	ETAHigh  time.Duration `json:"eta_high,omitempty"`
+	Phase    string        `json:"phase,omitempty"`
+	SLA      string        `json:"sla,omitempty"`
+	Stale    bool          `json:"stale"`
+}
+
+// FleetDriver is a driver as placed on the operations map.
+type FleetDriver struct {
+	store.Driver
+	Stale bool `json:"stale"`
+}
+
+// Fleet is everything known to be inside an area.
+type Fleet struct {
+	Drivers []FleetDriver `json:"drivers"`
+	Orders  []FleetOrder  `json:"orders"`
+}
+
+// Fleet returns the drivers and undelivered orders last seen inside the
+// bbox query parameter, given as minLng,minLat,maxLng,maxLat, so that the
+// operations map only loads what is in view.
+func (h *Handler) Fleet(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	box, err := geo.ParseBox(r.URL.Query().Get("bbox"))
+	if err != nil {
+		http.Error(w, "Invalid bbox, want minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	orders, drivers, err := h.trackerFor(r.Context()).Fleet(ctx, box)
+	if err != nil {
+		failed(ctx, w, "Failed to search fleet")
+		return
+	}
+	staleAfter := h.trackerFor(r.Context()).StaleAfter()
+	fleet := Fleet{Drivers: []FleetDriver{}, Orders: []FleetOrder{}}
+	for _, d := range drivers {
+		fleet.Drivers = append(fleet.Drivers, FleetDriver{Driver: d, Stale: time.Since(d.SeenAt) > staleAfter})
+	}
+	for _, o := range orders {
+		fleet.Orders = append(fleet.Orders, FleetOrder{
+			OrderID:  o.ID,
+			DriverID: o.DriverID,
+			Position: *o.Current,
+			SeenAt:   o.SeenAt,
+			ETA:      o.ETA,
+			ETALow:   o.ETALow,
+			ETAHigh:  o.ETAHigh,
+			Phase:    o.Phase,
+			SLA:      o.SLA,
+			Stale:    o.Stale(staleAfter),
+		})
+	}
+	if wantsGeoJSON(r) {
+		h.writeGeoJSON(w, r, fleetFeatures(fleet))
+		return
+	}
+	h.writeJSON(w, r, fleet)
+}
+
+// SearchOrders lists the orders matching every given filter, for zone
+// managers watching their area:
+//
+//   - near=lat,lng and radius, in meters: orders whose target is that close
+//   - within=lat,lng|lat,lng|...: orders whose courier is inside the polygon
+//   - mode: orders travelling by that mode
+//   - status: one of scheduled, waiting, en_route, stale or delivered
+func (h *Handler) SearchOrders(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	q := tracking.OrderQuery{Mode: query.Get("mode"), Status: query.Get("status")}
+	if v := query.Get("near"); v != "" {
+		p, err := geo.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid near, want lat,lng", http.StatusBadRequest)
+			return
+		}
+		q.Radius, err = strconv.ParseFloat(query.Get("radius"), 64)
+		if err != nil || q.Radius <= 0 {
+			http.Error(w, "near needs a positive radius", http.StatusBadRequest)
+			return
+		}
+		q.Near = &p
+	}
+	if v := query.Get("within"); v != "" {
+		for _, corner := range strings.Split(v, "|") {
+			p, err := geo.Parse(corner)
+			if err != nil {
+				http.Error(w, "Invalid within, want lat,lng|lat,lng|...", http.StatusBadRequest)
+				return
+			}
+			q.Within = append(q.Within, p)
+		}
+		if len(q.Within) < 3 {
+			http.Error(w, "within needs at least three corners", http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Status != "" && !slices.Contains(store.Statuses, q.Status) {
+		http.Error(w, "Unknown status", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	orders, err := h.trackerFor(r.Context()).SearchOrders(ctx, q)
+	if err != nil {
+		failed(ctx, w, "Failed to search orders")
+		return
+	}
+	staleAfter := h.trackerFor(r.Context()).StaleAfter()
+	summaries := []OrderSummary{}
+	for _, o := range orders {
+		summaries = append(summaries, OrderSummary{Order: o, Status: o.Status(staleAfter), Stale: o.Stale(staleAfter)})
+	}
+	h.writeJSON(w, r, summaries)
+}
+
+// Heatmap counts undelivered orders and drivers per geohash cell, for
+// rendering demand and supply. The bbox query parameter limits it to an
+// area, and precision overrides tracking.heatmap_precision.
+func (h *Handler) Heatmap(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	box := geo.World
+	if v := query.Get("bbox"); v != "" {
+		var err error
+		if box, err = geo.ParseBox(v); err != nil {
+			http.Error(w, "Invalid bbox, want minLng,minLat,maxLng,maxLat", http.StatusBadRequest)
+			return
+		}
+	}
+	precision := h.runtime.Config().Tracking.HeatmapPrecision
+	if v := query.Get("precision"); v != "" {
+		var err error
+		precision, err = strconv.Atoi(v)
+		if err != nil || precision < 1 || precision > geo.MaxGeohashPrecision {
+			http.Error(w, "Invalid precision", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	cells, err := h.trackerFor(r.Context()).Heatmap(ctx, box, precision)
+	if err != nil {
+		failed(ctx, w, "Failed to build heatmap")
+		return
+	}
+	h.writeJSON(w, r, cells)
+}
+
+// Isochrone is the area reachable from a point in a given time.
+type Isochrone struct {
+	Center  geo.Point `json:"center"`
+	Mode    string    `json:"mode"`
+	Minutes int       `json:"minutes"`
+	// Areas are the outlines of the reachable area, usually just one.
+	Areas [][]geo.Point `json:"areas"`
+}
+
+// maxIsochroneMinutes is the longest isochrone the providers compute.
+const maxIsochroneMinutes = 60
+
+// Isochrone returns the area reachable from lat,lng by mode within the
+// given minutes, for dispatchers to see which orders a driver can still
+// take on.
+func (h *Handler) Isochrone(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := r.URL.Query()
+	lat, errLat := strconv.ParseFloat(query.Get("lat"), 64)
+	lng, errLng := strconv.ParseFloat(query.Get("lng"), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		http.Error(w, "Invalid lat or lng", http.StatusBadRequest)
+		return
+	}
+	minutes, err := strconv.Atoi(query.Get("minutes"))
+	if err != nil || minutes < 1 || minutes > maxIsochroneMinutes {
+		http.Error(w, "minutes must be from 1 to 60", http.StatusBadRequest)
+		return
+	}
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = tracking.DefaultMode
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	center := geo.Point{Lat: lat, Lng: lng}
+	areas, err := h.trackerFor(r.Context()).Isochrone(ctx, center, mode, time.Duration(minutes)*time.Minute)
+	switch {
+	case errors.Is(err, tracking.ErrNoIsochrones):
+		http.Error(w, "No isochrone provider configured", http.StatusNotImplemented)
+		return
+	case errors.Is(err, routing.ErrUnsupportedMode):
+		http.Error(w, "Isochrones are not available for mode "+mode, http.StatusBadRequest)
+		return
+	case err != nil:
+		failed(ctx, w, "Failed to get isochrone")
+		return
+	}
+	iso := Isochrone{Center: center, Mode: mode, Minutes: minutes, Areas: areas}
+	if wantsGeoJSON(r) {
+		h.writeGeoJSON(w, r, isochroneFeatures(iso))
+		return
+	}
+	h.writeJSON(w, r, iso)
+}
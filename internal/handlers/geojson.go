@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"location/internal/geo"
+	"location/internal/store"
+)
+
+// wantsGeoJSON reports whether the client asked for GeoJSON rather than
+// the plain JSON every endpoint returns by default.
+func wantsGeoJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if t, _, err := mime.ParseMediaType(accept); err == nil && t == geo.GeoJSONType {
+			return true
+		}
+	}
+	return false
+}
+
+// sentGeoJSON reports whether the request body is GeoJSON.
+func sentGeoJSON(r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == geo.GeoJSONType
+}
+
+func (h *Handler) writeGeoJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", geo.GeoJSONType)
+	json.NewEncoder(w).Encode(h.shape(r, v))
+}
+
+// bodies hold the bodies of location updates being decoded, the most
+// frequent request by far.
+var bodies = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxLocationBody bounds the body read from one location update, which also
+// keeps the pooled buffers small.
+const maxLocationBody = 64 << 10
+
+// decodeLocation reads a location sent either as JSON or as a GeoJSON Point
+// feature whose properties hold the other fields, such as order_id.
+func decodeLocation(r *http.Request) (Location, error) {
+	var location Location
+	body := bodies.Get().(*bytes.Buffer)
+	body.Reset()
+	defer bodies.Put(body)
+	if _, err := body.ReadFrom(io.LimitReader(r.Body, maxLocationBody)); err != nil {
+		return location, err
+	}
+	if !sentGeoJSON(r) {
+		err := json.Unmarshal(body.Bytes(), &location)
+		return location, err
+	}
+	var feature geo.Feature
+	if err := json.Unmarshal(body.Bytes(), &feature); err != nil {
+		return location, err
+	}
+	if feature.Geometry == nil {
+		return location, errors.New("feature has no geometry")
+	}
+	p, err := feature.Geometry.Point()
+	if err != nil {
+		return location, err
+	}
+	if len(feature.Properties) > 0 && string(feature.Properties) != "null" {
+		if err := json.Unmarshal(feature.Properties, &location); err != nil {
+			return location, err
+		}
+	}
+	location.Lat, location.Lng = p.Lat, p.Lng
+	return location, nil
+}
+
+// orderFeatures returns the courier, target and pickup of an order as
+// Point features, each with a kind property, and the order as the
+// properties of the first.
+func orderFeatures(summary OrderSummary) geo.FeatureCollection {
+	var features []geo.Feature
+	for _, loc := range []struct {
+		kind  string
+		point *geo.Point
+	}{{store.Current, summary.Current}, {store.Target, summary.Target}, {store.Pickup, summary.Pickup}} {
+		if loc.point == nil {
+			continue
+		}
+		props := map[string]interface{}{"order_id": summary.ID, "kind": loc.kind}
+		if loc.kind == store.Current {
+			props["seen_at"] = summary.SeenAt
+			props["status"] = summary.Status
+			props["stale"] = summary.Stale
+		}
+		features = append(features, geo.NewFeature(loc.kind, geo.NewPoint(*loc.point), props))
+	}
+	return geo.NewFeatureCollection(features...)
+}
+
+// historyFeatures returns the trail of an order's courier as a LineString,
+// followed by every history entry with a point as a Point feature.
+func historyFeatures(history []store.Entry) geo.FeatureCollection {
+	var trail []geo.Point
+	var features []geo.Feature
+	for _, e := range history {
+		if e.Point == nil {
+			continue
+		}
+		if e.Kind == store.Current {
+			trail = append(trail, *e.Point)
+		}
+		features = append(features, geo.NewFeature("", geo.NewPoint(*e.Point), e))
+	}
+	if len(trail) > 1 {
+		features = append([]geo.Feature{geo.NewFeature("trail", geo.NewLineString(trail), map[string]string{"kind": "trail"})}, features...)
+	}
+	return geo.NewFeatureCollection(features...)
+}
+
+func fleetFeatures(fleet Fleet) geo.FeatureCollection {
+	var features []geo.Feature
+	for _, d := range fleet.Drivers {
+		features = append(features, geo.NewFeature("driver:"+d.ID, geo.NewPoint(*d.Position), d))
+	}
+	for _, o := range fleet.Orders {
+		features = append(features, geo.NewFeature("order:"+o.OrderID, geo.NewPoint(o.Position), o))
+	}
+	return geo.NewFeatureCollection(features...)
+}
+
+func isochroneFeatures(iso Isochrone) geo.FeatureCollection {
+	props := map[string]interface{}{"mode": iso.Mode, "minutes": iso.Minutes}
+	var features []geo.Feature
+	for _, area := range iso.Areas {
+		features = append(features, geo.NewFeature("", geo.NewPolygon(area), props))
+	}
+	return geo.NewFeatureCollection(features...)
+}
+
+func fenceFeatures(fences []geo.Fence) geo.FeatureCollection {
+	var features []geo.Feature
+	for _, f := range fences {
+		features = append(features, f.Feature())
+	}
+	return geo.NewFeatureCollection(features...)
+}
@@ -0,0 +1,474 @@
+// Package handlers implements the HTTP API.
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/geocode"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+type Location struct {
+	OrderID string  `json:"order_id"`
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	// Couriers may add speed, bearing, accuracy and recorded_at.
+	store.Telemetry
+	// Targets may be given as a street address, or as a what3words
+	// address or a Plus Code for places without one, instead of lat and lng.
+	Address    string `json:"address,omitempty"`
+	What3Words string `json:"what3words,omitempty"`
+	PlusCode   string `json:"plus_code,omitempty"`
+	// Targets may attach metadata, such as the customer's name, to be
+	// echoed in reads and events.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Pickups may give how long from now the order takes to prepare, and
+	// targets how long handing it over takes, such as at a loading dock.
+	PrepTime     time.Duration `json:"prep_time,omitempty"`
+	HandlingTime time.Duration `json:"handling_time,omitempty"`
+}
+
+// Metadata limits, keeping orders small enough to read on every update.
+const (
+	maxMetadataKeys = 32
+	maxMetadataSize = 4096
+)
+
+// checkMetadata returns why metadata is too big, if it is.
+func checkMetadata(metadata map[string]string) string {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Sprintf("metadata has more than %d keys", maxMetadataKeys)
+	}
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	if size > maxMetadataSize {
+		return fmt.Sprintf("metadata is larger than %d bytes", maxMetadataSize)
+	}
+	return ""
+}
+
+type Transport struct {
+	OrderID string `json:"order_id"`
+	Mode    string `json:"mode"`
+}
+
+// Handler serves the HTTP endpoints.
+type Handler struct {
+	tracker *tracking.Tracker
+	runtime *config.Runtime
+	auth    *auth.Authenticator
+	tenants map[string]*tracking.Tracker
+
+	limitMu  sync.Mutex
+	limiters map[string]*rate.Limiter
+
+	// requests and serverErrors are counted by Measure, and connections
+	// by ConnState
+	requests, serverErrors, connections atomic.Int64
+}
+
+func New(tracker *tracking.Tracker, rt *config.Runtime, authn *auth.Authenticator) *Handler {
+	return &Handler{tracker: tracker, runtime: rt, auth: authn}
+}
+
+// UseTenants serves each named tenant from its own tracker. The default
+// tenant keeps the one given to New.
+func (h *Handler) UseTenants(trackers map[string]*tracking.Tracker) {
+	h.tenants = trackers
+}
+
+// trackerFor returns the tracker of the tenant ctx acts for.
+func (h *Handler) trackerFor(ctx context.Context) *tracking.Tracker {
+	if t, ok := h.tenants[auth.TenantFromContext(ctx)]; ok {
+		return t
+	}
+	return h.tracker
+}
+
+// Auth returns the authenticator used to authorize requests.
+func (h *Handler) Auth() *auth.Authenticator {
+	return h.auth
+}
+
+// requestContext derives the context for a request's Redis and provider
+// calls: it ends when the client goes away or the configured deadline passes,
+// the latency budget of its route if it has one. GET requests may read from
+// Redis replicas.
+func (h *Handler) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	conf := h.runtime.Config().Server
+	ctx := r.Context()
+	if r.Method == http.MethodGet {
+		ctx = store.ReadOnly(ctx)
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			if budget, ok := conf.LatencyBudgets[tmpl]; ok {
+				return tracking.WithLatencyBudget(ctx, budget.Duration)
+			}
+		}
+	}
+	timeout := conf.RequestTimeout.Duration
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// failed reports an error, telling a timed-out request apart from other
+// failures. Nothing is written if the client has already disconnected.
+func failed(ctx context.Context, w http.ResponseWriter, msg string) {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+	case context.Canceled:
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) Transport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var transport Transport
+	err := json.NewDecoder(r.Body).Decode(&transport)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !h.auth.Authorize(r, transport.OrderID, auth.ScopeDriver) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SetMode(ctx, transport.OrderID, transport.Mode)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to update and calculate time")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CurrentLocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	location, err := decodeLocation(r)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if !h.auth.Authorize(r, location.OrderID, auth.ScopeDriver) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if b := location.Bearing; b != nil && (*b < 0 || *b >= 360) {
+		http.Error(w, "Invalid bearing", http.StatusBadRequest)
+		return
+	}
+	if (location.Speed != nil && *location.Speed < 0) || location.Accuracy < 0 {
+		http.Error(w, "Invalid speed or accuracy", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	travelTime, err := h.trackerFor(r.Context()).UpdateCurrent(ctx, location.OrderID, geo.Point{Lat: location.Lat, Lng: location.Lng}, location.Telemetry)
+	degraded := errors.Is(err, tracking.ErrDegraded)
+	if degraded {
+		err = nil
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, tracking.ErrInaccurate) || errors.Is(err, tracking.ErrTeleport) {
+		http.Error(w, "Location rejected: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if errors.Is(err, store.ErrDelivered) {
+		http.Error(w, "Order already delivered", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, tracking.ErrIncomplete) && h.runtime.Config().Tracking.Lenient {
+		pending(w, location.OrderID)
+		return
+	}
+	if errors.Is(err, tracking.ErrPaused) {
+		paused(w)
+		return
+	}
+	if errors.Is(err, routing.ErrOverloaded) {
+		overloaded(w)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to update and calculate time")
+		return
+	}
+
+	h.answer(ctx, w, r, location.OrderID, travelTime, degraded)
+}
+
+func (h *Handler) TargetLocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	location, err := decodeLocation(r)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Targets come from the ordering backend, never from devices
+	if !h.auth.Authorize(r, location.OrderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if msg := checkMetadata(location.Metadata); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if location.HandlingTime < 0 {
+		http.Error(w, "Invalid handling time", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	target, ok := h.resolveTarget(ctx, w, location)
+	if !ok {
+		return
+	}
+	if location.Metadata != nil {
+		err = h.trackerFor(r.Context()).SetMetadata(ctx, location.OrderID, location.Metadata)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			failed(ctx, w, "Failed to store metadata")
+			return
+		}
+	}
+	if location.HandlingTime > 0 {
+		err = h.trackerFor(r.Context()).SetHandlingTime(ctx, location.OrderID, location.HandlingTime)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			failed(ctx, w, "Failed to store handling time")
+			return
+		}
+	}
+	travelTime, err := h.trackerFor(r.Context()).UpdateLocation(ctx, location.OrderID, store.Target, target)
+	degraded := errors.Is(err, tracking.ErrDegraded)
+	if degraded {
+		err = nil
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, tracking.ErrIncomplete) && h.runtime.Config().Tracking.Lenient {
+		pending(w, location.OrderID)
+		return
+	}
+	if errors.Is(err, tracking.ErrPaused) {
+		paused(w)
+		return
+	}
+	if errors.Is(err, routing.ErrOverloaded) {
+		overloaded(w)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to update and calculate time")
+		return
+	}
+
+	h.answer(ctx, w, r, location.OrderID, travelTime, degraded)
+}
+
+// answer publishes a recalculated travel time and writes it as the response.
+// A degraded one, estimated because routing ran out of the latency budget,
+// is only answered, flagged by the X-Degraded header.
+func (h *Handler) answer(ctx context.Context, w http.ResponseWriter, r *http.Request, orderID string, travelTime time.Duration, degraded bool) {
+	if degraded {
+		w.Header().Set("X-Degraded", "latency-budget")
+	} else if err := h.trackerFor(r.Context()).PublishTravelTime(ctx, orderID, travelTime); err != nil {
+		failed(ctx, w, "Failed to publish travel time")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, travelTime)
+}
+
+// paused answers a location update of a paused order: the location was
+// stored, but no travel time was calculated from it.
+func paused(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprint(w, time.Duration(0))
+}
+
+// Pending answers a location stored for an order still missing the courier
+// or target location. Its ETA is calculated once both are known.
+type Pending struct {
+	OrderID string         `json:"order_id"`
+	ETA     *time.Duration `json:"eta"`
+}
+
+// pending answers a location update of an order that cannot be routed yet,
+// in lenient mode.
+func pending(w http.ResponseWriter, orderID string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(Pending{OrderID: orderID})
+}
+
+// overloaded answers a request whose travel time was shed by the full
+// worker queue.
+func overloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Too busy, try again shortly", http.StatusServiceUnavailable)
+}
+
+// resolveTarget returns the coordinates of a target, resolving a street
+// address, what3words address or Plus Code if one was given.
+func (h *Handler) resolveTarget(ctx context.Context, w http.ResponseWriter, location Location) (geo.Point, bool) {
+	given := 0
+	for _, v := range []string{location.Address, location.What3Words, location.PlusCode} {
+		if v != "" {
+			given++
+		}
+	}
+	system, address := "", ""
+	switch {
+	case given > 1:
+		http.Error(w, "Give only one of address, what3words or plus_code", http.StatusBadRequest)
+		return geo.Point{}, false
+	case location.Address != "":
+		system, address = geocode.Address, location.Address
+	case location.What3Words != "":
+		system, address = geocode.What3Words, location.What3Words
+	case location.PlusCode != "":
+		system, address = geocode.PlusCode, location.PlusCode
+	default:
+		return geo.Point{Lat: location.Lat, Lng: location.Lng}, true
+	}
+	p, err := h.trackerFor(ctx).ResolveAddress(ctx, system, address)
+	switch {
+	case errors.Is(err, tracking.ErrNoResolver):
+		http.Error(w, "Resolving "+system+" is not configured", http.StatusNotImplemented)
+		return geo.Point{}, false
+	case errors.Is(err, geocode.ErrNotFound):
+		http.Error(w, "Unknown "+system, http.StatusBadRequest)
+		return geo.Point{}, false
+	case err != nil:
+		log.Printf("failed to resolve %s %q: %v", system, address, err)
+		failed(ctx, w, "Failed to resolve "+system)
+		return geo.Point{}, false
+	}
+	return p, true
+}
+
+// PickupLocation sets where the courier collects an order, putting the order
+// in its pickup phase. Like targets, pickups come from the ordering backend.
+func (h *Handler) PickupLocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	location, err := decodeLocation(r)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if !h.auth.Authorize(r, location.OrderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if location.PrepTime < 0 {
+		http.Error(w, "Invalid prep time", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	if location.PrepTime > 0 {
+		err := h.trackerFor(r.Context()).SetPrepTime(ctx, location.OrderID, location.PrepTime)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			failed(ctx, w, "Failed to store prep time")
+			return
+		}
+	}
+	travelTime, err := h.trackerFor(r.Context()).UpdateLocation(ctx, location.OrderID, store.Pickup, geo.Point{Lat: location.Lat, Lng: location.Lng})
+	degraded := errors.Is(err, tracking.ErrDegraded)
+	if degraded {
+		err = nil
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, tracking.ErrIncomplete) && h.runtime.Config().Tracking.Lenient {
+		pending(w, location.OrderID)
+		return
+	}
+	if errors.Is(err, tracking.ErrPaused) {
+		paused(w)
+		return
+	}
+	if errors.Is(err, routing.ErrOverloaded) {
+		overloaded(w)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to update and calculate time")
+		return
+	}
+
+	h.answer(ctx, w, r, location.OrderID, travelTime, degraded)
+}
@@ -0,0 +1,2155 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/geocode"
+	"location/internal/handlers"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/server"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+const adminToken = "secret"
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+type harness struct {
+	srv       *httptest.Server
+	store     *store.Memory
+	provider  *routing.Scripted
+	publisher *publish.Capture
+	runtime   *config.Runtime
+}
+
+// newHarness serves the full route table backed by fakes. mutate may adjust
+// the configuration before it is applied.
+func newHarness(t testing.TB, mutate ...func(*config.Configuration)) *harness {
+	t.Helper()
+	conf := config.Default()
+	conf.Server.Storage = "memory"
+	conf.Auth.AdminToken = adminToken
+	// Tests move couriers across town in an instant
+	conf.Tracking.MaxSpeed = 0
+	// and mostly create orders with their first update
+	conf.Tracking.ImplicitOrders = true
+	for _, m := range mutate {
+		m(&conf)
+	}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{
+		store:     store.NewMemory(),
+		provider:  &routing.Scripted{Default: 5 * time.Minute},
+		publisher: &publish.Capture{},
+		runtime:   rt,
+	}
+	h.srv = httptest.NewServer(nil)
+	t.Cleanup(h.srv.Close)
+	authn, err := auth.New(conf.Auth, func() string { return adminToken })
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.serve(map[string]routing.Provider{routing.Google: h.provider, routing.Haversine: routing.HaversineEstimate{}}, authn)
+	return h
+}
+
+// serve (re)builds the handler chain with the given providers and
+// authenticator, keeping the harness store and publisher.
+func (h *harness) serve(providers map[string]routing.Provider, authn *auth.Authenticator) {
+	tracker := tracking.New(h.store, providers, h.publisher, h.runtime)
+	// Full Plus Codes resolve offline; what3words stays unconfigured
+	tracker.UseAddresses(map[string]geocode.Resolver{geocode.PlusCode: geocode.NewPlusCodes(func() string { return "" })})
+	h.srv.Config.Handler = server.Routes(handlers.New(tracker, h.runtime, authn))
+}
+
+func (h *harness) do(t *testing.T, method, path, body string, header ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, h.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func (h *harness) post(t *testing.T, path, body string) (int, string) {
+	t.Helper()
+	return h.do(t, http.MethodPost, path, body)
+}
+
+func TestCurrentLocationPublishesTravelTime(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+
+	status, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status != http.StatusOK || body != "5m0s" {
+		t.Fatalf("got %d %q, want 200 \"5m0s\"", status, body)
+	}
+
+	calls := h.provider.Calls()
+	last := calls[len(calls)-1]
+	if last.Origin != (geo.Point{Lat: 1.35, Lng: 103.85}) || last.Destination != (geo.Point{Lat: 1.30, Lng: 103.80}) {
+		t.Errorf("provider called with %+v", last)
+	}
+	if last.Mode != tracking.DefaultMode {
+		t.Errorf("mode = %q, want %q", last.Mode, tracking.DefaultMode)
+	}
+
+	events := h.publisher.Events()
+	if len(events) == 0 || !reflect.DeepEqual(events[len(events)-1], publish.Event{ID: "o1:1", Sequence: 1, Type: publish.EventETA, OrderID: "o1", ETA: 5 * time.Minute, ETALow: 4 * time.Minute, ETAHigh: 7 * time.Minute, Distance: geo.Distance(geo.Point{Lat: 1.35, Lng: 103.85}, geo.Point{Lat: 1.30, Lng: 103.80})}) {
+		t.Errorf("published %+v", events)
+	}
+}
+
+func TestLocationFailsUntilBothSidesAreKnown(t *testing.T) {
+	h := newHarness(t)
+	status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status != http.StatusInternalServerError {
+		t.Errorf("current without target: got %d, want 500", status)
+	}
+	status, _ = h.post(t, "/location/target", `{"order_id":"o2","lat":1.35,"lng":103.85}`)
+	if status != http.StatusInternalServerError {
+		t.Errorf("target without current: got %d, want 500", status)
+	}
+	if n := len(h.publisher.Events()); n != 0 {
+		t.Errorf("published %d events, want none", n)
+	}
+}
+
+func TestLenientLocationsWaitForBothSides(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Tracking.Lenient = true })
+	status, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status != http.StatusAccepted || body != `{"order_id":"o1","eta":null}` {
+		t.Errorf("current without target: got %d %q, want 202 with no ETA", status, body)
+	}
+	if n := len(h.publisher.Events()); n != 0 {
+		t.Errorf("published %d events before the target, want none", n)
+	}
+
+	status, body = h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	if status != http.StatusOK || body != "5m0s" {
+		t.Fatalf("target after current: got %d %q, want 200 \"5m0s\"", status, body)
+	}
+	if events := h.publisher.Events(); len(events) != 1 || events[0].ETA != 5*time.Minute {
+		t.Errorf("published %+v, want the ETA once both sides are known", events)
+	}
+}
+
+func TestLatencyBudgetDegradesToTheLastETA(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Tracking.Lenient = true
+		c.Server.LatencyBudgets = map[string]config.Duration{"/location/current": {Duration: 800 * time.Millisecond}}
+	})
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status, body := h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`); status != http.StatusOK {
+		t.Fatalf("target: got %d %q", status, body)
+	}
+
+	h.provider.Queue(routing.ScriptedResult{Err: context.DeadlineExceeded})
+	resp, err := http.Post(h.srv.URL+"/location/current", "application/json", strings.NewReader(`{"order_id":"o1","lat":1.34,"lng":103.84}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	eta, err := time.ParseDuration(string(body))
+	if resp.StatusCode != http.StatusOK || err != nil || eta <= 4*time.Minute || eta > 5*time.Minute {
+		t.Fatalf("got %d %q, want 200 with the last ETA counted down", resp.StatusCode, body)
+	}
+	if resp.Header.Get("X-Degraded") == "" {
+		t.Error("degraded answer not flagged")
+	}
+	if n := len(h.publisher.Events()); n != 1 {
+		t.Errorf("published %d events, want the degraded ETA left out", n)
+	}
+}
+
+func TestZeroTravelTimesArePublishedWithArrival(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 0}, routing.ScriptedResult{TravelTime: 0})
+
+	// A zero-length route a kilometre out is not an arrival
+	status, body := h.post(t, "/location/target", `{"order_id":"o1","lat":1.359,"lng":103.85}`)
+	if status != http.StatusOK || body != "0s" {
+		t.Fatalf("got %d %q, want 200 \"0s\"", status, body)
+	}
+	status, body = h.post(t, "/location/target", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status != http.StatusOK || body != "0s" {
+		t.Fatalf("got %d %q, want 200 \"0s\"", status, body)
+	}
+	events := h.publisher.Events()
+	if len(events) != 2 || events[0].ETA != 0 || events[0].Arrived || !events[1].Arrived {
+		t.Errorf("published %+v, want two zero ETAs, the second arrived", events)
+	}
+
+	var eta handlers.ETA
+	if _, body := h.do(t, http.MethodGet, "/order/o1/eta", ""); json.Unmarshal([]byte(body), &eta) != nil || !eta.Arrived {
+		t.Errorf("eta = %s, want arrived", body)
+	}
+}
+
+func TestTransportSetsMode(t *testing.T) {
+	h := newHarness(t)
+	status, _ := h.post(t, "/transport", `{"order_id":"o1","mode":"driving"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	calls := h.provider.Calls()
+	if len(calls) == 0 || calls[len(calls)-1].Mode != "driving" {
+		t.Errorf("provider calls %+v, want driving mode", calls)
+	}
+}
+
+func TestEndpointsRejectBadRequests(t *testing.T) {
+	h := newHarness(t)
+	for _, path := range []string{"/location/current", "/location/target", "/transport"} {
+		if status, _ := h.do(t, http.MethodGet, path, ""); status != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s: got %d, want 405", path, status)
+		}
+		if status, _ := h.post(t, path, `{not json`); status != http.StatusBadRequest {
+			t.Errorf("POST %s with bad JSON: got %d, want 400", path, status)
+		}
+	}
+}
+
+func TestProviderAndPublisherFailures(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+
+	h.provider.Queue(routing.ScriptedResult{Err: routing.ErrScripted})
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusInternalServerError {
+		t.Errorf("provider failure: got %d, want 500", status)
+	}
+
+	h.publisher.Err = errors.New("publisher down")
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusInternalServerError {
+		t.Errorf("publisher failure: got %d, want 500", status)
+	}
+}
+
+func TestDebouncingSkipsRecentRecalculation(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Cache.Debounce = true })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	before := len(h.provider.Calls())
+
+	status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+	if status != http.StatusOK {
+		t.Fatalf("got %d, want 200", status)
+	}
+	if after := len(h.provider.Calls()); after != before {
+		t.Errorf("provider called %d more times, want 0", after-before)
+	}
+}
+
+func TestCachingSharesTravelTimesBetweenOrders(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Cache.Enabled = true })
+	for _, id := range []string{"o1", "o2"} {
+		h.post(t, "/location/target", `{"order_id":"`+id+`","lat":1.30,"lng":103.80}`)
+		h.post(t, "/location/current", `{"order_id":"`+id+`","lat":1.35,"lng":103.85}`)
+	}
+	// o1 computes once for its current update; o2 hits the cache
+	if n := len(h.provider.Calls()); n != 1 {
+		t.Errorf("provider called %d times, want 1", n)
+	}
+}
+
+func TestModeStrategies(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Maps.Modes = map[string]config.ModeConfig{
+			"walking": {Provider: routing.Haversine},
+			"driving": {RecomputeInterval: config.Duration{Duration: time.Minute}},
+		}
+	})
+	// Walking orders are estimated without the provider
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if n := len(h.provider.Calls()); n != 0 {
+		t.Errorf("provider called %d times for walking", n)
+	}
+
+	// Driving ones are routed, then counted down although debouncing is off
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.30,"lng":103.80}`)
+	h.post(t, "/transport", `{"order_id":"o2","mode":"driving"}`)
+	h.post(t, "/location/current", `{"order_id":"o2","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o2","lat":1.34,"lng":103.84}`)
+	if n := len(h.provider.Calls()); n != 1 {
+		t.Errorf("provider called %d times for driving, want 1", n)
+	}
+}
+
+// blockingProvider never answers before the request context ends.
+type blockingProvider struct{}
+
+func (blockingProvider) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestRequestTimeout(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Server.RequestTimeout = config.Duration{Duration: 50 * time.Millisecond}
+	})
+	h.serve(map[string]routing.Provider{routing.Google: blockingProvider{}}, auth.NewWithKeyfunc(nil, "", "", func() string { return "" }))
+
+	h.store.SetLocation(context.Background(), "o1", store.Target, geo.Point{Lat: 1.30, Lng: 103.80})
+	status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if status != http.StatusGatewayTimeout {
+		t.Errorf("got %d, want 504", status)
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	h := newHarness(t)
+	auth := []string{"Authorization", "Bearer " + adminToken}
+
+	if status, _ := h.do(t, http.MethodGet, "/admin/config", ""); status != http.StatusUnauthorized {
+		t.Errorf("without token: got %d, want 401", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/admin/config", "", "Authorization", "Bearer wrong"); status != http.StatusUnauthorized {
+		t.Errorf("with wrong token: got %d, want 401", status)
+	}
+
+	status, body := h.do(t, http.MethodPatch, "/admin/config", `{"provider":"haversine","log_level":"DEBUG"}`, auth...)
+	if status != http.StatusOK {
+		t.Fatalf("PATCH: got %d %q, want 200", status, body)
+	}
+	var settings config.Settings
+	if err := json.Unmarshal([]byte(body), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings.Provider != routing.Haversine || settings.LogLevel != "debug" {
+		t.Errorf("settings = %+v", settings)
+	}
+
+	if status, _ := h.do(t, http.MethodPatch, "/admin/config", `{"provider":"carrier-pigeon"}`, auth...); status != http.StatusBadRequest {
+		t.Errorf("unknown provider: got %d, want 400", status)
+	}
+	if status, _ := h.do(t, http.MethodDelete, "/admin/config", "", auth...); status != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: got %d, want 405", status)
+	}
+}
+
+func TestOrderReads(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	status, body := h.do(t, http.MethodGet, "/order/o1", "")
+	var order store.Order
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &order) != nil {
+		t.Fatalf("GET /order/o1: got %d %q", status, body)
+	}
+	if order.ETA != 5*time.Minute || order.Target == nil || *order.Target != (geo.Point{Lat: 1.30, Lng: 103.80}) {
+		t.Errorf("order = %+v", order)
+	}
+
+	status, body = h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &eta) != nil || eta.ETA != 5*time.Minute {
+		t.Errorf("GET /order/o1/eta: got %d %q", status, body)
+	}
+
+	if status, _ := h.do(t, http.MethodGet, "/order/missing", ""); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d, want 404", status)
+	}
+}
+
+func TestJWTScopes(t *testing.T) {
+	h := newHarness(t)
+	key := []byte("test-signing-key")
+	h.serve(map[string]routing.Provider{routing.Google: h.provider}, auth.NewWithKeyfunc(
+		func(*jwt.Token) (interface{}, error) { return key, nil }, "", "", func() string { return adminToken }))
+	token := func(scope string, orders ...string) []string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{Scope: scope, Orders: orders}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []string{"Authorization", "Bearer " + signed}
+	}
+	h.do(t, http.MethodPost, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`, "Authorization", "Bearer "+adminToken)
+
+	tests := []struct {
+		name, method, path, body string
+		header                   []string
+		want                     int
+	}{
+		{"anonymous driver update", http.MethodPost, "/location/current", `{"order_id":"o1","lat":1,"lng":1}`, nil, http.StatusForbidden},
+		{"garbage token", http.MethodGet, "/order/o1/eta", "", []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized},
+		{"assigned driver", http.MethodPost, "/location/current", `{"order_id":"o1","lat":1,"lng":1}`, token("driver", "o1"), http.StatusOK},
+		{"unassigned driver", http.MethodPost, "/location/current", `{"order_id":"o1","lat":1,"lng":1}`, token("driver", "o2"), http.StatusForbidden},
+		{"driver sets target", http.MethodPost, "/location/target", `{"order_id":"o1","lat":1,"lng":1}`, token("driver", "o1"), http.StatusForbidden},
+		{"customer posts location", http.MethodPost, "/location/current", `{"order_id":"o1","lat":1,"lng":1}`, token("customer", "o1"), http.StatusForbidden},
+		{"customer reads eta", http.MethodGet, "/order/o1/eta", "", token("customer", "o1"), http.StatusOK},
+		{"customer reads other eta", http.MethodGet, "/order/o2/eta", "", token("customer", "o1"), http.StatusForbidden},
+		{"customer reads full order", http.MethodGet, "/order/o1", "", token("customer", "o1"), http.StatusForbidden},
+		{"admin jwt reads config", http.MethodGet, "/admin/config", "", token("admin"), http.StatusOK},
+		{"driver reads config", http.MethodGet, "/admin/config", "", token("driver", "o1"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if status, body := h.do(t, tt.method, tt.path, tt.body, tt.header...); status != tt.want {
+			t.Errorf("%s: got %d %q, want %d", tt.name, status, body, tt.want)
+		}
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	h := newHarness(t)
+	key := []byte("test-signing-key")
+	authn := auth.NewWithKeyfunc(func(*jwt.Token) (interface{}, error) { return key, nil }, "", "", func() string { return adminToken })
+	authn.UseShares(auth.NewShares("0123456789abcdef0123456789abcdef", time.Hour))
+	h.serve(map[string]routing.Provider{routing.Google: h.provider}, authn)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.do(t, http.MethodPost, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`, admin...)
+	h.do(t, http.MethodPost, "/location/target", `{"order_id":"o2","lat":1.30,"lng":103.80}`, admin...)
+
+	if status, _ := h.do(t, http.MethodPost, "/order/o1/share", ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous mint: got %d, want 401", status)
+	}
+	if status, _ := h.do(t, http.MethodPost, "/order/missing/share", "", admin...); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d, want 404", status)
+	}
+	status, body := h.do(t, http.MethodPost, "/order/o1/share?ttl=10m", "", admin...)
+	var link handlers.ShareLink
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &link) != nil {
+		t.Fatalf("mint: got %d %q", status, body)
+	}
+	if until := time.Until(link.ExpiresAt); until > 10*time.Minute || until < 9*time.Minute {
+		t.Errorf("link expires in %v, want about 10m", until)
+	}
+
+	tests := []struct {
+		name, method, path string
+		want               int
+	}{
+		{"read eta", http.MethodGet, link.Path, http.StatusOK},
+		{"read order", http.MethodGet, "/order/o1?share=" + link.Token, http.StatusOK},
+		{"read other order", http.MethodGet, "/order/o2/eta?share=" + link.Token, http.StatusForbidden},
+		{"post location", http.MethodPost, "/location/current?share=" + link.Token, http.StatusForbidden},
+		{"tampered token", http.MethodGet, "/order/o1/eta?share=" + link.Token + "x", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		body := ""
+		if tt.method == http.MethodPost {
+			body = `{"order_id":"o1","lat":1,"lng":1}`
+		}
+		if status, resp := h.do(t, tt.method, tt.path, body); status != tt.want {
+			t.Errorf("%s: got %d %q, want %d", tt.name, status, resp, tt.want)
+		}
+	}
+}
+
+func TestOrderEventsStreamUpdates(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+
+	resp, err := http.Get(h.srv.URL + "/order/o1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("got %d %q", resp.StatusCode, ct)
+	}
+	events := bufio.NewScanner(resp.Body)
+	next := func() handlers.Position {
+		t.Helper()
+		for events.Scan() {
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var pos handlers.Position
+				if err := json.Unmarshal([]byte(data), &pos); err != nil {
+					t.Fatal(err)
+				}
+				return pos
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return handlers.Position{}
+	}
+
+	if pos := next(); pos.Courier != nil || pos.Destination == nil {
+		t.Errorf("initial position = %+v", pos)
+	}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if pos := next(); pos.Courier == nil || *pos.Courier != (geo.Point{Lat: 1.35, Lng: 103.85}) || pos.ETA != 5*time.Minute {
+		t.Errorf("updated position = %+v", pos)
+	}
+}
+
+func TestOrderEventsSendDeltas(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+
+	resp, err := http.Get(h.srv.URL + "/order/o1/events?delta=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewScanner(resp.Body)
+	next := func() (string, map[string]json.RawMessage) {
+		t.Helper()
+		var event string
+		for events.Scan() {
+			if v, ok := strings.CutPrefix(events.Text(), "event: "); ok {
+				event = v
+			}
+			if data, ok := strings.CutPrefix(events.Text(), "data: "); ok {
+				var fields map[string]json.RawMessage
+				if err := json.Unmarshal([]byte(data), &fields); err != nil {
+					t.Fatal(err)
+				}
+				return event, fields
+			}
+		}
+		t.Fatalf("stream ended: %v", events.Err())
+		return "", nil
+	}
+
+	if event, fields := next(); event != "snapshot" || fields["destination"] == nil || fields["order_id"] == nil {
+		t.Errorf("first event %s = %s", event, fields)
+	}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	event, fields := next()
+	if event != "delta" || fields["courier"] == nil || fields["sequence"] == nil {
+		t.Fatalf("update %s = %s", event, fields)
+	}
+	if _, ok := fields["destination"]; ok {
+		t.Errorf("delta repeats the unchanged destination: %s", fields)
+	}
+}
+
+func TestWaitETALongPolls(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	since := strconv.FormatInt(eta.Sequence, 10)
+
+	type result struct {
+		status int
+		body   string
+	}
+	done := make(chan result)
+	go func() {
+		resp, err := http.Get(h.srv.URL + "/eta/o1/wait?since=" + since)
+		if err != nil {
+			done <- result{}
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		done <- result{resp.StatusCode, string(data)}
+	}()
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	got := <-done
+	var waited handlers.ETA
+	if got.status != http.StatusOK || json.Unmarshal([]byte(got.body), &waited) != nil {
+		t.Fatalf("got %d %q", got.status, got.body)
+	}
+	if waited.Sequence <= eta.Sequence || waited.ETA != 5*time.Minute {
+		t.Errorf("waited for %+v", waited)
+	}
+
+	// Nothing newer comes
+	since = strconv.FormatInt(waited.Sequence, 10)
+	if status, body := h.do(t, http.MethodGet, "/eta/o1/wait?wait=10ms&since="+since, ""); status != http.StatusNoContent || body != "" {
+		t.Errorf("no update: got %d %q", status, body)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/eta/o1/wait?since=x", ""); status != http.StatusBadRequest {
+		t.Errorf("invalid since: got %d, want 400", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/eta/missing/wait", ""); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d, want 404", status)
+	}
+}
+
+func TestAdminOrdersAndHistory(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/transport", `{"order_id":"o1","mode":"driving"}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	if status, _ := h.do(t, http.MethodGet, "/admin/orders", ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous list: got %d, want 401", status)
+	}
+	status, body := h.do(t, http.MethodGet, "/admin/orders", "", admin...)
+	var orders []handlers.OrderSummary
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &orders) != nil || len(orders) != 1 {
+		t.Fatalf("list: got %d %q", status, body)
+	}
+	if orders[0].ID != "o1" || orders[0].Stale || orders[0].SeenAt.IsZero() {
+		t.Errorf("summary = %+v", orders[0])
+	}
+	if _, body := h.do(t, http.MethodGet, "/admin/orders?stale_after=1ns", "", admin...); !strings.Contains(body, `"stale":true`) {
+		t.Errorf("short stale_after: %s", body)
+	}
+
+	status, body = h.do(t, http.MethodGet, "/admin/orders/o1/history", "", admin...)
+	var history []store.Entry
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &history) != nil {
+		t.Fatalf("history: got %d %q", status, body)
+	}
+	var kinds []string
+	for _, e := range history {
+		kinds = append(kinds, e.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "target,mode,current,eta" {
+		t.Errorf("history kinds = %s", got)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/admin/orders/missing/history", "", admin...); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d, want 404", status)
+	}
+
+	if status, _ := h.do(t, http.MethodDelete, "/admin/orders/o1", "", admin...); status != http.StatusNoContent {
+		t.Errorf("delete: got %d, want 204", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", ""); status != http.StatusNotFound {
+		t.Errorf("deleted order: got %d, want 404", status)
+	}
+	if status, _ := h.do(t, http.MethodDelete, "/admin/orders/o1", "", admin...); status != http.StatusNotFound {
+		t.Errorf("delete twice: got %d, want 404", status)
+	}
+}
+
+func TestOrderTimeline(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/transport", `{"order_id":"o1","mode":"driving"}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+
+	if status, _ := h.do(t, http.MethodGet, "/order/o1/timeline", ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d, want 401", status)
+	}
+	status, body := h.do(t, http.MethodGet, "/order/o1/timeline", "", admin...)
+	var timeline []tracking.TimelineEvent
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &timeline) != nil {
+		t.Fatalf("timeline: got %d %q", status, body)
+	}
+	var types []string
+	for _, e := range timeline {
+		types = append(types, e.Type)
+		if e.Summary == "" {
+			t.Errorf("%s has no summary", e.Type)
+		}
+	}
+	if got := strings.Join(types, ","); got != "created,target_set,mode_changed,courier_located,eta_updated,eta_updated" {
+		t.Errorf("timeline types = %s", got)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/missing/timeline", "", admin...); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d, want 404", status)
+	}
+}
+
+func TestMetricsExportSLIs(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Tracking.Lenient = true })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.provider.Queue(routing.ScriptedResult{Err: errors.New("provider down")})
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.36,"lng":103.86}`); status != http.StatusInternalServerError {
+		t.Fatalf("failed route: got %d, want 500", status)
+	}
+
+	status, body := h.do(t, http.MethodGet, "/metrics", "")
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q", status, body)
+	}
+	for _, want := range []string{
+		"# TYPE location_http_requests_total counter\nlocation_http_requests_total 3\n",
+		"location_http_server_errors_total 1\n",
+		`location_eta_computations_total{tenant=""} 2`,
+		`location_eta_computation_failures_total{tenant=""} 1`,
+		"# TYPE location_publish_lag_seconds histogram\n",
+		`location_publish_lag_seconds_bucket{tenant="",le="+Inf"} 1`,
+		`location_publish_lag_seconds_count{tenant=""} 1`,
+		`location_oldest_active_order_staleness_seconds{tenant=""} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestAdminProviders(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Maps.DailyQuota = 100 })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	status, body := h.do(t, http.MethodGet, "/admin/providers", "", "Authorization", "Bearer "+adminToken)
+	var providers []handlers.ProviderUsage
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &providers) != nil || len(providers) != 2 {
+		t.Fatalf("got %d %q", status, body)
+	}
+	google := providers[0]
+	if google.Provider != routing.Google || !google.Selected || google.Calls != 1 || google.Remaining != 99 {
+		t.Errorf("google usage = %+v", google)
+	}
+}
+
+func TestDriverLocationFansOutToOrders(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.31,"lng":103.81}`)
+
+	if status, _ := h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`); status != http.StatusNotFound {
+		t.Errorf("unregistered driver: got %d, want 404", status)
+	}
+	if status, _ := h.post(t, "/drivers", `{"driver_id":"d1","orders":["o1","o2"]}`); status != http.StatusUnauthorized {
+		t.Errorf("anonymous registration: got %d, want 401", status)
+	}
+	if status, body := h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","status":"lost"}`, admin...); status != http.StatusBadRequest {
+		t.Errorf("unknown status: got %d %q, want 400", status, body)
+	}
+	if status, body := h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","status":"busy","orders":["o1","o2"]}`, admin...); status != http.StatusOK {
+		t.Fatalf("register: got %d %q", status, body)
+	}
+
+	status, body := h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+	var update tracking.DriverUpdate
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &update) != nil {
+		t.Fatalf("driver location: got %d %q", status, body)
+	}
+	// o2 is nearer, so o1 is dropped off after it
+	if len(update.ETAs) != 2 || update.ETAs["o2"] != 5*time.Minute || update.ETAs["o1"] != 10*time.Minute || len(update.Failed) != 0 {
+		t.Errorf("update = %+v", update)
+	}
+	if events := h.publisher.Events(); len(events) != 2 {
+		t.Errorf("published %d events, want 2", len(events))
+	}
+	for _, id := range []string{"o1", "o2"} {
+		order, _ := h.store.GetOrder(context.Background(), id)
+		if order.Current == nil || *order.Current != (geo.Point{Lat: 1.35, Lng: 103.85}) {
+			t.Errorf("order %s current = %v", id, order.Current)
+		}
+	}
+
+	status, body = h.do(t, http.MethodGet, "/drivers/d1", "")
+	var driver store.Driver
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &driver) != nil {
+		t.Fatalf("get driver: got %d %q", status, body)
+	}
+	if driver.Status != store.DriverBusy || driver.Position == nil || driver.SeenAt.IsZero() {
+		t.Errorf("driver = %+v", driver)
+	}
+}
+
+func TestOffDutyDriversAreNotTracked(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Privacy.OffDuty = true })
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1"]}`, admin...)
+
+	position := func(id string) *geo.Point {
+		t.Helper()
+		var driver store.Driver
+		_, body := h.do(t, http.MethodGet, "/drivers/"+id, "")
+		json.Unmarshal([]byte(body), &driver)
+		return driver.Position
+	}
+	h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+	if position("d1") == nil {
+		t.Fatal("driver with an order is not tracked")
+	}
+
+	// Delivering the last order forgets where the driver was
+	h.post(t, "/order/o1/delivered", "")
+	_, body := h.post(t, "/drivers/d1/location", `{"lat":1.36,"lng":103.86}`)
+	if !strings.Contains(body, `"off_duty":true`) {
+		t.Errorf("update = %s", body)
+	}
+	if p := position("d1"); p != nil {
+		t.Errorf("off duty driver at %v", p)
+	}
+	if d, _ := h.store.GetDriver(context.Background(), "d1"); d.Position != nil || !d.SeenAt.IsZero() {
+		t.Errorf("stored %+v", d)
+	}
+
+	// So does a shift that is not on now
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.30,"lng":103.80}`)
+	later := time.Now().Add(2 * time.Hour)
+	shift := later.Format("15:04") + "-" + later.Add(time.Hour).Format("15:04")
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d2","orders":["o2"],"metadata":{"shift":"`+shift+`"}}`, admin...)
+	if _, body := h.post(t, "/drivers/d2/location", `{"lat":1.35,"lng":103.85}`); !strings.Contains(body, `"off_duty":true`) {
+		t.Errorf("off shift update = %s", body)
+	}
+	if order, _ := h.store.GetOrder(context.Background(), "o2"); order.Current != nil {
+		t.Errorf("off shift driver moved o2 to %v", order.Current)
+	}
+	if status, _ := h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d3","metadata":{"shift":"late"}}`, admin...); status != http.StatusBadRequest {
+		t.Errorf("invalid shift: got %d, want 400", status)
+	}
+}
+
+func TestDriverDistanceBilledToOrdersUnderWay(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	for _, id := range []string{"o1", "o2", "o3"} {
+		h.post(t, "/location/target", fmt.Sprintf(`{"order_id":%q,"lat":1.30,"lng":103.80}`, id))
+	}
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1","o2","o3"]}`, admin...)
+	h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+	h.do(t, http.MethodPost, "/order/o2/delivered", `{}`, admin...)
+	h.do(t, http.MethodPost, "/order/o3/pause", "", admin...)
+
+	h.post(t, "/drivers/d1/location", `{"lat":1.36,"lng":103.85}`)
+	want := map[string]float64{"o1": geo.Distance(geo.Point{Lat: 1.35, Lng: 103.85}, geo.Point{Lat: 1.36, Lng: 103.85}), "o2": 0, "o3": 0}
+	for id, distance := range want {
+		if order, _ := h.store.GetOrder(context.Background(), id); math.Abs(order.Distance-distance) > 0.01 {
+			t.Errorf("%s billed %.0fm, want %.0fm", id, order.Distance, distance)
+		}
+	}
+}
+
+func TestDriverNMEA(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":48.1,"lng":11.5}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1"]}`, "Authorization", "Bearer "+adminToken)
+
+	if status, body := h.post(t, "/drivers/d1/nmea", "$GPGSV,3,1,11,03,03,111,00*4A\n"); status != http.StatusBadRequest {
+		t.Errorf("no fix: got %d %q, want 400", status, body)
+	}
+	status, body := h.post(t, "/drivers/d1/nmea", "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n"+
+		"$GPRMC,123520,A,4807.538,N,01131.000,E,022.4,084.4,230394,003.1,W\r\n")
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q", status, body)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if want := (geo.Point{Lat: 48 + 7.538/60, Lng: 11 + 31.0/60}); order.Current == nil || geo.Distance(*order.Current, want) > 1 {
+		t.Errorf("current = %v, want the last fix %v", order.Current, want)
+	}
+	if want := time.Date(1994, 3, 23, 12, 35, 20, 0, time.UTC); order.Telemetry == nil || order.Telemetry.RecordedAt == nil || !order.Telemetry.RecordedAt.Equal(want) {
+		t.Errorf("telemetry = %+v, want the fix taken at %v", order.Telemetry, want)
+	}
+	if events := h.publisher.Events(); len(events) != 1 {
+		t.Errorf("published %d events, want 1", len(events))
+	}
+}
+
+func TestDriverWithSeveralOrdersGetsSequencedETAs(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	// o1 is furthest away, so it is dropped off last
+	h.post(t, "/location/target", `{"order_id":"o1","lat":0,"lng":0.03}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":0,"lng":0.01}`)
+	h.post(t, "/location/target", `{"order_id":"o3","lat":0,"lng":0.02}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1","o2","o3"]}`, admin...)
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 2 * time.Minute}, routing.ScriptedResult{TravelTime: 3 * time.Minute}, routing.ScriptedResult{TravelTime: 4 * time.Minute})
+
+	status, body := h.post(t, "/drivers/d1/location", `{"lat":0,"lng":0}`)
+	var update tracking.DriverUpdate
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &update) != nil {
+		t.Fatalf("got %d %q", status, body)
+	}
+	if got := strings.Join(update.Sequence, ","); got != "o2,o3,o1" {
+		t.Errorf("sequence = %s, want o2,o3,o1", got)
+	}
+	want := map[string]time.Duration{"o2": 2 * time.Minute, "o3": 5 * time.Minute, "o1": 9 * time.Minute}
+	for id, eta := range want {
+		if update.ETAs[id] != eta {
+			t.Errorf("ETA of %s = %v, want %v", id, update.ETAs[id], eta)
+		}
+	}
+	calls := h.provider.Calls()
+	if len(calls) != 3 || calls[1].Origin != (geo.Point{Lat: 0, Lng: 0.01}) {
+		t.Errorf("legs = %+v", calls)
+	}
+}
+
+func TestDriverWithSeveralOrdersPicksUpBeforeDroppingOff(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	// o1 is dropped off nearest, but is collected furthest away
+	h.post(t, "/location/target", `{"order_id":"o1","lat":0,"lng":0.01}`)
+	h.post(t, "/location/pickup", fmt.Sprintf(`{"order_id":"o1","lat":0,"lng":0.03,"prep_time":%d}`, 15*time.Minute))
+	h.post(t, "/location/target", `{"order_id":"o2","lat":0,"lng":0.02}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1","o2"]}`, admin...)
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 2 * time.Minute}, routing.ScriptedResult{TravelTime: 3 * time.Minute}, routing.ScriptedResult{TravelTime: 4 * time.Minute})
+
+	status, body := h.post(t, "/drivers/d1/location", `{"lat":0,"lng":0}`)
+	var update tracking.DriverUpdate
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &update) != nil {
+		t.Fatalf("got %d %q", status, body)
+	}
+	if got := strings.Join(update.Sequence, ","); got != "o2,o1" {
+		t.Errorf("sequence = %s, want o2,o1", got)
+	}
+	// The courier reaches the pickup 5m in and waits about 10m for o1
+	if eta := update.ETAs["o2"]; eta != 2*time.Minute {
+		t.Errorf("ETA of o2 = %v, want 2m", eta)
+	}
+	if eta := update.ETAs["o1"]; eta < 18*time.Minute || eta > 19*time.Minute {
+		t.Errorf("ETA of o1 = %v, want about 19m", eta)
+	}
+	calls := h.provider.Calls()
+	if len(calls) != 3 || calls[1].Destination != (geo.Point{Lat: 0, Lng: 0.03}) || calls[2].Origin != (geo.Point{Lat: 0, Lng: 0.03}) {
+		t.Errorf("legs = %+v", calls)
+	}
+
+	// Reaching the pickup moves o1 on to its dropoff
+	h.post(t, "/drivers/d1/location", `{"lat":0,"lng":0.03}`)
+	if order, _ := h.store.GetOrder(context.Background(), "o1"); order.Phase != store.PhaseDropoff {
+		t.Errorf("phase at the pickup = %q, want dropoff", order.Phase)
+	}
+}
+
+func TestBatchOrders(t *testing.T) {
+	h := newHarness(t)
+	body := `{"orders":[
+		{"order_id":"o1","lat":1.30,"lng":103.80,"mode":"driving","metadata":{"customer":"Ann"}},
+		{"order_id":"","lat":1.30,"lng":103.80},
+		{"order_id":"o3","lat":91,"lng":0},
+		{"order_id":"o4","lat":1.31,"lng":103.81}
+	]}`
+	if status, _ := h.post(t, "/orders/batch", body); status != http.StatusUnauthorized {
+		t.Errorf("anonymous batch: got %d, want 401", status)
+	}
+
+	status, resp := h.do(t, http.MethodPost, "/orders/batch", body, "Authorization", "Bearer "+adminToken)
+	var batch handlers.BatchResponse
+	if status != http.StatusOK || json.Unmarshal([]byte(resp), &batch) != nil {
+		t.Fatalf("got %d %q", status, resp)
+	}
+	if batch.Succeeded != 2 || batch.Failed != 2 || len(batch.Results) != 4 {
+		t.Fatalf("batch = %+v", batch)
+	}
+	if r := batch.Results[2]; r.OrderID != "o3" || r.OK || r.Error != "invalid coordinates" {
+		t.Errorf("result of o3 = %+v", r)
+	}
+
+	order, err := h.store.GetOrder(context.Background(), "o1")
+	if err != nil || order.Mode != "driving" || order.Metadata["customer"] != "Ann" || order.Target == nil {
+		t.Errorf("o1 = %+v, %v", order, err)
+	}
+	if len(h.provider.Calls()) != 0 {
+		t.Error("registering orders should not calculate travel times")
+	}
+}
+
+func TestQueryOrders(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	for _, id := range []string{"o1", "o2"} {
+		h.post(t, "/location/target", `{"order_id":"`+id+`","lat":1.30,"lng":103.80}`)
+		h.post(t, "/location/current", `{"order_id":"`+id+`","lat":1.35,"lng":103.85}`)
+	}
+
+	body := `{"order_ids":["o2","missing","o1"]}`
+	if status, _ := h.post(t, "/orders/query", body); status != http.StatusUnauthorized {
+		t.Errorf("anonymous query: got %d, want 401", status)
+	}
+	status, resp := h.do(t, http.MethodPost, "/orders/query", body, admin...)
+	var read handlers.BulkReadResponse
+	if status != http.StatusOK || json.Unmarshal([]byte(resp), &read) != nil {
+		t.Fatalf("got %d %q", status, resp)
+	}
+	if len(read.Orders) != 2 || read.Orders[0].Order.ID != "o2" || read.Orders[1].Order.ID != "o1" {
+		t.Fatalf("orders = %+v", read.Orders)
+	}
+	if eta := read.Orders[0].ETA; eta.OrderID != "o2" || eta.ETA != 5*time.Minute {
+		t.Errorf("eta of o2 = %+v", eta)
+	}
+	if len(read.Missing) != 1 || read.Missing[0] != "missing" {
+		t.Errorf("missing = %v", read.Missing)
+	}
+
+	ids := make([]string, 501)
+	tooMany, _ := json.Marshal(handlers.BulkRead{OrderIDs: ids})
+	if status, _ := h.do(t, http.MethodPost, "/orders/query", string(tooMany), admin...); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many orders: got %d, want 413", status)
+	}
+}
+
+func TestCreateOrder(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Tracking.ImplicitOrders = false })
+	admin := []string{"Authorization", "Bearer " + adminToken}
+
+	// Updates of orders never created are refused rather than creating them
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusNotFound {
+		t.Errorf("update before create: got %d, want 404", status)
+	}
+	if status, _ := h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80,"metadata":{"a":"b"}}`); status != http.StatusNotFound {
+		t.Errorf("target before create: got %d, want 404", status)
+	}
+	for path, body := range map[string]string{
+		"/order/o1/deadline":    `{"deadline":"2030-01-01T12:00:00Z"}`,
+		"/order/o1/schedule":    `{"depart_at":"2030-01-01T11:00:00Z"}`,
+		"/order/o1/geofences":   `[]`,
+		"/order/o1/alerts":      `[]`,
+		"/order/o1/preferences": `{"channels":["sms"],"phone":"+6591234567"}`,
+	} {
+		if status, _ := h.do(t, http.MethodPut, path, body, admin...); status != http.StatusNotFound {
+			t.Errorf("%s before create: got %d, want 404", path, status)
+		}
+	}
+	if _, err := h.store.GetOrder(context.Background(), "o1"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("refused updates stored the order: %v", err)
+	}
+
+	body := `{"order_id":"o1","lat":1.30,"lng":103.80,"mode":"cycling","metadata":{"customer":"Ann"},"preferences":{"channels":["sms"]}}`
+	if status, _ := h.post(t, "/orders", body); status != http.StatusUnauthorized {
+		t.Errorf("anonymous create: got %d, want 401", status)
+	}
+	req, _ := http.NewRequest(http.MethodPost, h.srv.URL+"/orders", strings.NewReader(body))
+	req.Header.Set(admin[0], admin[1])
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var created handlers.OrderSummary
+	if resp.StatusCode != http.StatusCreated || json.NewDecoder(resp.Body).Decode(&created) != nil {
+		t.Fatalf("create: got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "/order/o1" {
+		t.Errorf("Location = %q", loc)
+	}
+	if created.ID != "o1" || created.Mode != "cycling" || created.Metadata["customer"] != "Ann" || created.CreatedAt.IsZero() ||
+		created.Target == nil || created.Preferences == nil || len(created.Preferences.Channels) != 1 {
+		t.Errorf("created = %+v", created)
+	}
+	if status, _ := h.do(t, http.MethodPost, "/orders", body, admin...); status != http.StatusConflict {
+		t.Errorf("create again: got %d, want 409", status)
+	}
+	if status, _ := h.do(t, http.MethodPost, "/orders", `{"lat":1.30,"lng":103.80}`, admin...); status != http.StatusBadRequest {
+		t.Errorf("create without id: got %d, want 400", status)
+	}
+
+	if status, resp := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusOK || resp != "5m0s" {
+		t.Errorf("update after create: got %d %q", status, resp)
+	}
+}
+
+func TestPickupPhase(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/pickup", `{"order_id":"o1","lat":1.32,"lng":103.82}`)
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 4 * time.Minute}, routing.ScriptedResult{TravelTime: 6 * time.Minute})
+
+	// Away from the pickup, the ETA covers both legs
+	if status, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusOK || body != "10m0s" {
+		t.Fatalf("pickup phase: got %d %q", status, body)
+	}
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	if eta.Phase != store.PhasePickup || eta.PickupETA != 4*time.Minute || eta.ETA != 10*time.Minute {
+		t.Errorf("eta = %+v", eta)
+	}
+	if calls := h.provider.Calls(); len(calls) != 2 || calls[1].Origin != (geo.Point{Lat: 1.32, Lng: 103.82}) {
+		t.Errorf("legs = %+v", calls)
+	}
+
+	// Reaching the pickup moves the order on to its dropoff
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.32,"lng":103.8201}`)
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if order.Phase != store.PhaseDropoff || order.ETA != 5*time.Minute {
+		t.Errorf("after pickup: %+v", order)
+	}
+
+	var phases []string
+	for _, e := range h.publisher.Events() {
+		if e.Type == publish.EventPhase {
+			phases = append(phases, e.Phase)
+		}
+	}
+	if got := strings.Join(phases, ","); got != "pickup,dropoff" {
+		t.Errorf("phase events = %s", got)
+	}
+}
+
+func TestPrepTimeSplitsETA(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	if status, _ := h.post(t, "/location/pickup", `{"order_id":"o1","lat":1.32,"lng":103.82,"prep_time":-1}`); status != http.StatusBadRequest {
+		t.Errorf("negative prep time got %d", status)
+	}
+	h.post(t, "/location/pickup", fmt.Sprintf(`{"order_id":"o1","lat":1.32,"lng":103.82,"prep_time":%d}`, 15*time.Minute))
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 4 * time.Minute}, routing.ScriptedResult{TravelTime: 6 * time.Minute})
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	// The courier arrives 4m in and waits about 11m for the order
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	if eta.PickupETA != 4*time.Minute || eta.DropoffETA < 16*time.Minute || eta.DropoffETA > 17*time.Minute || eta.ETA != eta.PickupETA+eta.DropoffETA || eta.ReadyAt == nil {
+		t.Errorf("eta = %+v", eta)
+	}
+	events := h.publisher.Events()
+	last := events[len(events)-1]
+	if last.PickupETA != eta.PickupETA || last.DropoffETA != eta.DropoffETA {
+		t.Errorf("published legs %v and %v, read %v and %v", last.PickupETA, last.DropoffETA, eta.PickupETA, eta.DropoffETA)
+	}
+}
+
+func TestHandlingTimeAddsToETA(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Tracking.HandlingTime = config.Duration{Duration: 2 * time.Minute}
+		c.Maps.Modes = map[string]config.ModeConfig{"cycling": {HandlingTime: config.Duration{Duration: 30 * time.Second}}}
+	})
+	h.post(t, "/location/target", fmt.Sprintf(`{"order_id":"o1","lat":1.30,"lng":103.80,"handling_time":%d}`, time.Minute))
+	if status, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`); status != http.StatusOK || body != "8m0s" {
+		t.Errorf("got %d %q, want 5m routed plus 3m handling", status, body)
+	}
+
+	// Cycling has its own in place of the configured one
+	h.post(t, "/transport", `{"order_id":"o1","mode":"cycling"}`)
+	if _, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`); body != "6m30s" {
+		t.Errorf("cycling got %q, want 6m30s", body)
+	}
+}
+
+func TestGeofenceEvents(t *testing.T) {
+	depot := geo.Point{Lat: 1.30, Lng: 103.80}
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Tracking.Geofences = []geo.Fence{{Name: "depot", Center: &depot, Radius: 200}}
+	})
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.40,"lng":103.90}`)
+	zone := `[{"name":"delivery-zone","polygon":[{"lat":1.39,"lng":103.89},{"lat":1.39,"lng":103.91},{"lat":1.41,"lng":103.91},{"lat":1.41,"lng":103.89}]}]`
+	if status, body := h.do(t, http.MethodPut, "/order/o1/geofences", zone, "Authorization", "Bearer "+adminToken); status != http.StatusNoContent {
+		t.Fatalf("set geofences: %d %s", status, body)
+	}
+	if status, _ := h.do(t, http.MethodPut, "/order/o1/geofences", `[{"name":"bad","radius":10}]`, "Authorization", "Bearer "+adminToken); status != http.StatusBadRequest {
+		t.Errorf("invalid geofence: got %d", status)
+	}
+
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.3001,"lng":103.8001}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.3002,"lng":103.8002}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.40,"lng":103.90}`)
+
+	var got []string
+	for _, e := range h.publisher.Events() {
+		if e.Geofence != "" {
+			got = append(got, e.Type+" "+e.Geofence)
+		}
+	}
+	want := "geofence_entered depot,geofence_exited depot,geofence_entered delivery-zone"
+	if strings.Join(got, ",") != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+}
+
+func TestProximityAlertsFireOnce(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	alerts := `[{"within":360000000000},{"distance":1000}]`
+	if status, body := h.do(t, http.MethodPut, "/order/o1/alerts", alerts, "Authorization", "Bearer "+adminToken); status != http.StatusNoContent {
+		t.Fatalf("set alerts: %d %s", status, body)
+	}
+	if status, _ := h.do(t, http.MethodPut, "/order/o1/alerts", `[{"within":60000000000,"distance":10}]`, "Authorization", "Bearer "+adminToken); status != http.StatusBadRequest {
+		t.Errorf("alert with two thresholds: got %d", status)
+	}
+
+	// The scripted ETA of 5m is within the first alert from the start
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.301,"lng":103.801}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.3005,"lng":103.8005}`)
+
+	var got []string
+	for _, e := range h.publisher.Events() {
+		if e.Type == publish.EventProximity {
+			got = append(got, e.Alert)
+		}
+	}
+	if strings.Join(got, ",") != "eta<=6m0s,distance<=1000m" {
+		t.Errorf("alerts = %v", got)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if len(order.Fired) != 2 {
+		t.Errorf("fired = %v", order.Fired)
+	}
+}
+
+func TestCurrentLocationTelemetry(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85,"speed":8.5,"bearing":270,"accuracy":12,"recorded_at":"2024-03-01T10:00:00Z"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got %d", status)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	tel := order.Telemetry
+	if tel == nil || *tel.Speed != 8.5 || *tel.Bearing != 270 || tel.Accuracy != 12 || !tel.RecordedAt.Equal(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("telemetry = %+v", tel)
+	}
+
+	// Inaccurate fixes are rejected and leave the courier where it was
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.40,"lng":103.90,"accuracy":500}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("inaccurate fix: got %d", status)
+	}
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.40,"lng":103.90,"bearing":400}`); status != http.StatusBadRequest {
+		t.Errorf("invalid bearing: got %d", status)
+	}
+	order, _ = h.store.GetOrder(context.Background(), "o1")
+	if order.Current.Lat != 1.35 {
+		t.Errorf("courier moved to %v", order.Current)
+	}
+}
+
+func TestTeleportsAreRejected(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Tracking.MaxSpeed = 70 })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85,"recorded_at":"2024-03-01T10:00:00Z"}`)
+
+	// Some 50km in ten seconds
+	status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.80,"lng":103.85,"recorded_at":"2024-03-01T10:00:10Z"}`)
+	if status != http.StatusUnprocessableEntity {
+		t.Errorf("teleport: got %d", status)
+	}
+	// 200m in the same time is a fast bike
+	status, _ = h.post(t, "/location/current", `{"order_id":"o1","lat":1.3518,"lng":103.85,"recorded_at":"2024-03-01T10:00:10Z"}`)
+	if status != http.StatusOK {
+		t.Errorf("plausible move: got %d", status)
+	}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85,"accuracy":1000}`)
+
+	_, body := h.do(t, http.MethodGet, "/admin/rejections", "", "Authorization", "Bearer "+adminToken)
+	var rejected tracking.Rejections
+	json.Unmarshal([]byte(body), &rejected)
+	if rejected != (tracking.Rejections{Inaccurate: 1, Teleports: 1}) {
+		t.Errorf("rejections = %s", body)
+	}
+}
+
+func TestDriverTeleportsAreRejected(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Tracking.MaxSpeed = 50 })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","orders":["o1"]}`, "Authorization", "Bearer "+adminToken)
+	h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+
+	// Across the country in an instant
+	if status, _ := h.post(t, "/drivers/d1/location", `{"lat":15.35,"lng":103.85}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("teleport: got %d", status)
+	}
+	if status, _ := h.post(t, "/drivers/d1/location", `{"lat":1.3501,"lng":103.85,"accuracy":1000}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("inaccurate: got %d", status)
+	}
+	if status, _ := h.post(t, "/drivers/d1/location", `{"lat":1.3501,"lng":103.85}`); status != http.StatusOK {
+		t.Errorf("plausible move: got %d", status)
+	}
+
+	driver, _ := h.store.GetDriver(context.Background(), "d1")
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if want := (geo.Point{Lat: 1.3501, Lng: 103.85}); *driver.Position != want || *order.Current != want {
+		t.Errorf("driver at %v, order at %v, want %v", driver.Position, order.Current, want)
+	}
+	_, body := h.do(t, http.MethodGet, "/admin/rejections", "", "Authorization", "Bearer "+adminToken)
+	var rejected tracking.Rejections
+	json.Unmarshal([]byte(body), &rejected)
+	if rejected != (tracking.Rejections{Inaccurate: 1, Teleports: 1}) {
+		t.Errorf("rejections = %s", body)
+	}
+}
+
+func TestOrderPosition(t *testing.T) {
+	h := newHarness(t)
+	if status, _ := h.do(t, http.MethodGet, "/order/o1/position", ""); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d", status)
+	}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.31,"lng":103.80,"speed":10,"bearing":180}`)
+
+	status, body := h.do(t, http.MethodGet, "/order/o1/position", "")
+	var live handlers.LivePosition
+	json.Unmarshal([]byte(body), &live)
+	if status != http.StatusOK || live.Reported != (geo.Point{Lat: 1.31, Lng: 103.80}) || live.Bearing == nil {
+		t.Errorf("position: %d %s", status, body)
+	}
+}
+
+func TestOrderRoute(t *testing.T) {
+	h := newHarness(t)
+	h.provider.Via = []geo.Point{{Lat: 1.32, Lng: 103.81}}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	if status, _ := h.do(t, http.MethodGet, "/order/o1/route", ""); status != http.StatusNotFound {
+		t.Errorf("no courier yet: got %d", status)
+	}
+	h.post(t, "/location/pickup", `{"order_id":"o1","lat":1.33,"lng":103.82}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	// Before the pickup the route goes by way of it
+	status, body := h.do(t, http.MethodGet, "/order/o1/route", "")
+	var route handlers.OrderRoute
+	json.Unmarshal([]byte(body), &route)
+	want := []geo.Point{{Lat: 1.35, Lng: 103.85}, {Lat: 1.32, Lng: 103.81}, {Lat: 1.33, Lng: 103.82}, {Lat: 1.32, Lng: 103.81}, {Lat: 1.30, Lng: 103.80}}
+	if status != http.StatusOK || !slices.Equal(route.Path, want) || route.Duration != 10*time.Minute {
+		t.Errorf("route: %d %s", status, body)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1/route", "", "Accept", geo.GeoJSONType)
+	if !strings.Contains(body, `"LineString"`) {
+		t.Errorf("GeoJSON route = %s", body)
+	}
+
+	// Routes revalidate like other order reads
+	resp, err := http.Get(h.srv.URL + "/order/o1/route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if code, _ := h.do(t, http.MethodGet, "/order/o1/route", "", "If-None-Match", resp.Header.Get("ETag")); code != http.StatusNotModified {
+		t.Errorf("revalidating the route got %d, want 304", code)
+	}
+
+	// Providers that only tell travel times get a straight line
+	h = newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	_, body = h.do(t, http.MethodGet, "/order/o1/route", "")
+	json.Unmarshal([]byte(body), &route)
+	if want := []geo.Point{{Lat: 1.35, Lng: 103.85}, {Lat: 1.30, Lng: 103.80}}; !slices.Equal(route.Path, want) {
+		t.Errorf("straight route = %s", body)
+	}
+}
+
+// useJWT makes h authenticate JWTs, and returns a function minting them.
+func useJWT(t *testing.T, h *harness) func(scope string, orders ...string) []string {
+	key := []byte("test-signing-key")
+	h.serve(map[string]routing.Provider{routing.Google: h.provider}, auth.NewWithKeyfunc(
+		func(*jwt.Token) (interface{}, error) { return key, nil }, "", "", func() string { return adminToken }))
+	return func(scope string, orders ...string) []string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{Scope: scope, Orders: orders}).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []string{"Authorization", "Bearer " + signed}
+	}
+}
+
+func BenchmarkCurrentLocation(b *testing.B) {
+	h := newHarness(b)
+	h.store.SetLocation(context.Background(), "o1", store.Target, geo.Point{Lat: 1.30, Lng: 103.80})
+	handler := h.srv.Config.Handler
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/location/current", strings.NewReader(`{"order_id":"o1","lat":1.3456,"lng":103.8567,"speed":8}`))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("got %d %s", w.Code, w.Body)
+		}
+	}
+}
+
+func TestCoarsePositionsForCustomers(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) { c.Privacy.Precision = 2 })
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.3456,"lng":103.8567}`)
+
+	var live handlers.LivePosition
+	_, body := h.do(t, http.MethodGet, "/order/o1/position", "")
+	json.Unmarshal([]byte(body), &live)
+	if want := (geo.Point{Lat: 1.35, Lng: 103.86}); live.Courier != want || live.Reported != want {
+		t.Errorf("customer sees %+v, want %v", live, want)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1", "")
+	if !strings.Contains(body, `"current":{"lat":1.35,"lng":103.86}`) {
+		t.Errorf("customer order = %s", body)
+	}
+
+	// Admins see couriers, and read orders, as they are
+	_, body = h.do(t, http.MethodGet, "/order/o1/position", "", "Authorization", "Bearer "+adminToken)
+	json.Unmarshal([]byte(body), &live)
+	if want := (geo.Point{Lat: 1.3456, Lng: 103.8567}); live.Reported != want {
+		t.Errorf("admin sees %+v, want %v", live.Reported, want)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1", "", "Authorization", "Bearer "+adminToken)
+	if !strings.Contains(body, `"current":{"lat":1.3456,"lng":103.8567}`) {
+		t.Errorf("admin order = %s", body)
+	}
+	if order, _ := h.store.GetOrder(context.Background(), "o1"); *order.Current != (geo.Point{Lat: 1.3456, Lng: 103.8567}) {
+		t.Errorf("stored %v", order.Current)
+	}
+}
+
+func TestFuzzedPositionsStayPut(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Privacy.FuzzRadius = 200
+		c.Privacy.FuzzSecret = strings.Repeat("s", 32)
+	})
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	read := func() geo.Point {
+		var live handlers.LivePosition
+		_, body := h.do(t, http.MethodGet, "/order/o1/position", "")
+		json.Unmarshal([]byte(body), &live)
+		return live.Reported
+	}
+	first, reported := read(), geo.Point{Lat: 1.35, Lng: 103.85}
+	if d := geo.Distance(first, reported); d == 0 || d > 200.5 {
+		t.Errorf("fuzzed %v is %.0fm from %v", first, d, reported)
+	}
+	if again := read(); again != first {
+		t.Errorf("fuzzed to %v, then %v", first, again)
+	}
+}
+
+func TestResponsesShapedByAudience(t *testing.T) {
+	h := newHarness(t)
+	token := useJWT(t, h)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.do(t, http.MethodPost, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80,"metadata":{"customer":"Ann"}}`, admin...)
+	h.do(t, http.MethodPost, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85,"speed":8,"bearing":90,"accuracy":5}`, admin...)
+	h.do(t, http.MethodPut, "/order/o1/preferences", `{"channels":["sms"],"phone":"+6591234567","email":"ann@example.com"}`, admin...)
+
+	order := func(header []string) map[string]json.RawMessage {
+		t.Helper()
+		status, body := h.do(t, http.MethodGet, "/order/o1", "", header...)
+		var fields map[string]json.RawMessage
+		if status != http.StatusOK || json.Unmarshal([]byte(body), &fields) != nil {
+			t.Fatalf("got %d %q", status, body)
+		}
+		return fields
+	}
+	share, driver := token(auth.ScopeShare, "o1"), token(auth.ScopeDriver, "o1")
+	customer := order(share)
+	if customer["current"] == nil || customer["eta"] == nil || customer["metadata"] != nil || customer["routed_from"] != nil {
+		t.Errorf("customer sees %s", customer)
+	}
+	// Share links reach people other than the customer, so no contact
+	// details are in them
+	if customer["preferences"] != nil {
+		t.Errorf("share link sees preferences %s", customer["preferences"])
+	}
+	if got := string(customer["telemetry"]); got != `{"bearing":90}` {
+		t.Errorf("customer sees telemetry %s", got)
+	}
+	// Drivers do not see who they deliver to
+	if fields := order(driver); fields["metadata"] != nil || fields["driver_id"] != nil || fields["preferences"] != nil {
+		t.Errorf("driver sees %s", fields)
+	}
+	dispatcher := order(token(auth.ScopeDispatcher, "o1"))
+	if dispatcher["metadata"] == nil || dispatcher["preferences"] == nil || !strings.Contains(string(dispatcher["telemetry"]), `"speed":8`) {
+		t.Errorf("dispatcher sees %s", dispatcher)
+	}
+
+	// Only admins see who made a change
+	h.do(t, http.MethodPost, "/transport", `{"order_id":"o1","mode":"walking"}`, driver...)
+	_, body := h.do(t, http.MethodGet, "/order/o1/timeline", "", admin...)
+	if !strings.Contains(body, `"actor"`) {
+		t.Errorf("admin timeline = %s", body)
+	}
+
+	// Customers learn when their order arrived, not the proof of it
+	h.do(t, http.MethodPost, "/order/o1/delivered", `{"photo_url":"https://cdn.example.com/p/1.jpg","note":"left with concierge"}`, admin...)
+	if got := string(order(share)["delivery"]); !strings.Contains(got, `"at"`) || strings.Contains(got, "concierge") || strings.Contains(got, "location") {
+		t.Errorf("share link sees delivery %s", got)
+	}
+	if got := string(order(token(auth.ScopeDispatcher, "o1"))["delivery"]); !strings.Contains(got, "concierge") {
+		t.Errorf("dispatcher sees delivery %s", got)
+	}
+
+	// Shaping leaves the stored order alone
+	if o, _ := h.store.GetOrder(context.Background(), "o1"); o.Metadata["customer"] != "Ann" || o.Telemetry.Speed == nil || o.Preferences.Phone == "" {
+		t.Errorf("stored %+v", o)
+	}
+}
+
+func TestScheduledOrderPredictsFromDeparture(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/pickup", `{"order_id":"o1","lat":1.32,"lng":103.82}`)
+
+	departAt := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	status, body := h.do(t, http.MethodPut, "/order/o1/schedule", `{"depart_at":"`+departAt.Format(time.RFC3339)+`"}`, "Authorization", "Bearer "+adminToken)
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	if status != http.StatusOK || eta.ETA < 2*time.Hour+4*time.Minute || eta.ETA > 2*time.Hour+5*time.Minute {
+		t.Fatalf("schedule: %d %s", status, body)
+	}
+	calls := h.provider.Calls()
+	if last := calls[len(calls)-1]; !last.Departure.Equal(departAt) || last.Origin != (geo.Point{Lat: 1.32, Lng: 103.82}) {
+		t.Errorf("provider call = %+v", last)
+	}
+
+	// A courier checking in early does not turn the prediction into a
+	// trip starting now
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	_, body = h.do(t, http.MethodGet, "/order/o1/eta", "")
+	json.Unmarshal([]byte(body), &eta)
+	if eta.ETA < 2*time.Hour || eta.DepartAt == nil {
+		t.Errorf("eta after early check-in = %s", body)
+	}
+}
+
+func TestETAWindow(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 20 * time.Minute})
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	// 20% of 20 minutes late, half that early
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	if eta.ETALow != 18*time.Minute || eta.ETAHigh != 24*time.Minute {
+		t.Errorf("window = %s", body)
+	}
+	events := h.publisher.Events()
+	if last := events[len(events)-1]; last.ETALow != 18*time.Minute || last.ETAHigh != 24*time.Minute {
+		t.Errorf("event = %+v", last)
+	}
+
+	// The arrival slipping by two hours widens the window beyond 20%
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 140 * time.Minute})
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if order.ETADrift < 29*time.Minute || order.ETAHigh-order.ETA != order.ETADrift {
+		t.Errorf("after slip: eta %v, window %v-%v, drift %v", order.ETA, order.ETALow, order.ETAHigh, order.ETADrift)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	h := newHarness(t)
+	auth := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	if status, _ := h.do(t, http.MethodGet, "/order/o1/preferences", "", auth...); status != http.StatusNotFound {
+		t.Errorf("before any: got %d", status)
+	}
+
+	prefs := `{"channels":["push","sms"],"thresholds":[{"within":300000000000}],"locale":"en-SG","quiet_hours":{"start":"22:00","end":"07:00","time_zone":"Asia/Singapore"}}`
+	if status, body := h.do(t, http.MethodPut, "/order/o1/preferences", prefs, auth...); status != http.StatusNoContent {
+		t.Fatalf("set: %d %s", status, body)
+	}
+	for _, bad := range []string{`{"channels":["pigeon"]}`, `{"quiet_hours":{"start":"22:00","end":"07:00","time_zone":"Mars/Olympus"}}`} {
+		if status, _ := h.do(t, http.MethodPut, "/order/o1/preferences", bad, auth...); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d", bad, status)
+		}
+	}
+
+	_, body := h.do(t, http.MethodGet, "/order/o1/preferences", "", auth...)
+	var got store.Preferences
+	json.Unmarshal([]byte(body), &got)
+	if got.Locale != "en-SG" || len(got.Channels) != 2 || got.QuietHours == nil {
+		t.Errorf("preferences = %s", body)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if len(order.Alerts) != 1 || order.Alerts[0].Within != 5*time.Minute {
+		t.Errorf("alerts = %+v", order.Alerts)
+	}
+
+	sg := time.FixedZone("SGT", 8*3600)
+	for hour, want := range map[int]bool{23: true, 3: true, 7: false, 12: false} {
+		if quiet, _ := got.QuietHours.Quiet(time.Date(2024, 3, 1, hour, 0, 0, 0, sg)); quiet != want {
+			t.Errorf("quiet at %d:00 = %v", hour, quiet)
+		}
+	}
+}
+
+func TestSLAEvents(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	deadline := time.Now().Add(22 * time.Minute).Format(time.RFC3339Nano)
+	if status, _ := h.do(t, http.MethodPut, "/order/o1/deadline", `{"deadline":"`+deadline+`"}`, "Authorization", "Bearer "+adminToken); status != http.StatusNoContent {
+		t.Fatalf("set deadline: got %d", status)
+	}
+
+	// 20 minutes makes it, but the window reaches to 24
+	h.provider.Queue(routing.ScriptedResult{TravelTime: 20 * time.Minute},
+		routing.ScriptedResult{TravelTime: 30 * time.Minute},
+		routing.ScriptedResult{TravelTime: 10 * time.Minute})
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	var got []string
+	for _, e := range h.publisher.Events() {
+		if e.Deadline != nil {
+			got = append(got, fmt.Sprintf("%s %v", e.Type, e.Lateness.Round(time.Minute)))
+		}
+	}
+	if strings.Join(got, ",") != "sla_at_risk 2m0s,sla_breached 8m0s" {
+		t.Errorf("events = %v", got)
+	}
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	json.Unmarshal([]byte(body), &eta)
+	if eta.SLA != store.SLAOnTrack || eta.Deadline == nil {
+		t.Errorf("eta = %s", body)
+	}
+}
+
+func TestDeliveredFinalizesOrder(t *testing.T) {
+	h := newHarness(t)
+	if status, _ := h.post(t, "/order/o1/delivered", ""); status != http.StatusNotFound {
+		t.Errorf("unknown order: got %d", status)
+	}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.3001,"lng":103.8001}`)
+
+	status, body := h.post(t, "/order/o1/delivered", `{"photo_url":"https://cdn.example.com/p/1.jpg","note":"left with concierge"}`)
+	var delivery store.Delivery
+	json.Unmarshal([]byte(body), &delivery)
+	if status != http.StatusOK || delivery.At.IsZero() || *delivery.Location != (geo.Point{Lat: 1.3001, Lng: 103.8001}) || delivery.Note == "" {
+		t.Fatalf("delivered: %d %s", status, body)
+	}
+	if status, _ := h.post(t, "/order/o1/delivered", ""); status != http.StatusConflict {
+		t.Errorf("second delivery: got %d", status)
+	}
+	if status, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.31,"lng":103.81}`); status != http.StatusConflict {
+		t.Errorf("location after delivery: got %d", status)
+	}
+
+	events := h.publisher.Events()
+	if last := events[len(events)-1]; last.Type != publish.EventDelivered || last.DeliveredAt == nil || last.Location == nil {
+		t.Errorf("event = %+v", last)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1/eta", "")
+	if !strings.Contains(body, `"delivered_at"`) {
+		t.Errorf("eta = %s", body)
+	}
+}
+
+func TestBillingExport(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.31,"lng":103.81}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","status":"busy","orders":["o1"]}`, admin...)
+
+	// o1 rides with d1 for about 1.1km, o2 with its own courier for 2.2km
+	h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+	h.post(t, "/drivers/d1/location", `{"lat":1.36,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o2","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o2","lat":1.37,"lng":103.85}`)
+	h.post(t, "/order/o2/delivered", "")
+
+	status, body := h.do(t, http.MethodGet, "/admin/billing", "", admin...)
+	lines, _ := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if status != http.StatusOK || len(lines) != 3 || lines[1][0] != "o1" || lines[1][1] != "d1" || lines[1][3] != "1.112" || lines[2][3] != "2.224" || lines[2][4] == "" {
+		t.Errorf("export: %d\n%s", status, body)
+	}
+
+	_, body = h.do(t, http.MethodGet, "/admin/billing?driver=d1&format=json", "", admin...)
+	var billed []handlers.BillingLine
+	json.Unmarshal([]byte(body), &billed)
+	if len(billed) != 1 || billed[0].OrderID != "o1" {
+		t.Errorf("driver export = %s", body)
+	}
+	_, body = h.do(t, http.MethodGet, "/admin/billing?day=2001-01-01", "", admin...)
+	if lines, _ := csv.NewReader(strings.NewReader(body)).ReadAll(); len(lines) != 1 {
+		t.Errorf("other day = %q", body)
+	}
+}
+
+func TestFleetInBox(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o2","lat":1.45,"lng":103.95}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","status":"busy"}`, admin...)
+	h.post(t, "/drivers/d1/location", `{"lat":1.34,"lng":103.84}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d2","status":"available"}`, admin...)
+
+	status, body := h.do(t, http.MethodGet, "/fleet?bbox=103.8,1.3,103.9,1.4", "", admin...)
+	var fleet handlers.Fleet
+	json.Unmarshal([]byte(body), &fleet)
+	if status != http.StatusOK || len(fleet.Orders) != 1 || fleet.Orders[0].OrderID != "o1" || fleet.Orders[0].ETA != 5*time.Minute {
+		t.Errorf("orders: %d %s", status, body)
+	}
+	if len(fleet.Drivers) != 1 || fleet.Drivers[0].ID != "d1" || fleet.Drivers[0].Status != "busy" {
+		t.Errorf("drivers: %s", body)
+	}
+
+	if status, _ := h.do(t, http.MethodGet, "/fleet?bbox=103.9,1.3,103.8,1.4", "", admin...); status != http.StatusBadRequest {
+		t.Errorf("swapped box: got %d", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/fleet?bbox=103.8,1.3,103.9,1.4", ""); status != http.StatusUnauthorized {
+		t.Errorf("anonymous: got %d", status)
+	}
+}
+
+func TestSearchOrders(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.300,"lng":103.800}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.305,"lng":103.800}`)
+	h.post(t, "/transport", `{"order_id":"o2","mode":"bicycling"}`)
+	h.post(t, "/location/target", `{"order_id":"o3","lat":1.400,"lng":103.900}`)
+	h.post(t, "/location/current", `{"order_id":"o3","lat":1.36,"lng":103.86}`)
+
+	search := func(query string) []string {
+		t.Helper()
+		status, body := h.do(t, http.MethodGet, "/orders/search?"+query, "", admin...)
+		if status != http.StatusOK {
+			t.Fatalf("%s: %d %s", query, status, body)
+		}
+		var orders []handlers.OrderSummary
+		json.Unmarshal([]byte(body), &orders)
+		ids := []string{}
+		for _, o := range orders {
+			ids = append(ids, o.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"near=1.30,103.80&radius=1000", "o1,o2"},
+		{"within=1.34,103.84|1.34,103.87|1.37,103.87|1.37,103.84", "o1,o3"},
+		{"within=1.34,103.84|1.34,103.87|1.37,103.87|1.37,103.84&near=1.30,103.80&radius=1000", "o1"},
+		{"mode=bicycling", "o2"},
+		{"status=waiting", "o2"},
+		{"status=en_route", "o1,o3"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(search(tt.query), ","); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"near=1.30,103.80", "within=1,1|2,2", "status=lost"} {
+		if status, _ := h.do(t, http.MethodGet, "/orders/search?"+query, "", admin...); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d", query, status)
+		}
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.300,"lng":103.800}`)
+	h.post(t, "/location/target", `{"order_id":"o2","lat":1.301,"lng":103.801}`)
+	h.post(t, "/location/target", `{"order_id":"o3","lat":1.350,"lng":103.850}`)
+	h.post(t, "/order/o3/delivered", "")
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d1","status":"available"}`, admin...)
+	h.post(t, "/drivers/d1/location", `{"lat":1.35,"lng":103.85}`)
+	h.do(t, http.MethodPost, "/drivers", `{"driver_id":"d2","status":"offline"}`, admin...)
+	h.post(t, "/drivers/d2/location", `{"lat":1.35,"lng":103.85}`)
+
+	status, body := h.do(t, http.MethodGet, "/heatmap", "", admin...)
+	var cells []tracking.Cell
+	json.Unmarshal([]byte(body), &cells)
+	want := []tracking.Cell{
+		{Cell: "w21z6h", Orders: 2},
+		{Cell: "w21zej", Drivers: 1, Available: 1},
+	}
+	if status != http.StatusOK || len(cells) != 2 {
+		t.Fatalf("heatmap: %d %s", status, body)
+	}
+	for i, c := range cells {
+		c.Center = geo.Point{}
+		if c != want[i] {
+			t.Errorf("cell %d = %+v, want %+v", i, c, want[i])
+		}
+	}
+
+	_, body = h.do(t, http.MethodGet, "/heatmap?precision=3&bbox=103.7,1.2,103.82,1.32", "", admin...)
+	if json.Unmarshal([]byte(body), &cells); len(cells) != 1 || cells[0].Cell != "w21" || cells[0].Orders != 2 {
+		t.Errorf("coarse heatmap = %s", body)
+	}
+}
+
+func TestRouteCost(t *testing.T) {
+	h := newHarness(t)
+	h.provider.Cost = &routing.Cost{Distance: 12000, Tolls: []routing.Money{{Currency: "SGD", Amount: 2.5}}, Fuel: 1.1}
+	h.post(t, "/transport", `{"order_id":"o1","mode":"driving"}`)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if order.Cost == nil || order.Cost.Tolls[0].Amount != 2.5 {
+		t.Fatalf("cost = %+v", order.Cost)
+	}
+	events := h.publisher.Events()
+	if last := events[len(events)-1]; last.Cost == nil || last.Cost.Fuel != 1.1 {
+		t.Errorf("last event = %+v", last)
+	}
+
+	// A new target needs a new estimate; walkers are never priced
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.31,"lng":103.80}`)
+	h.post(t, "/transport", `{"order_id":"o1","mode":"walking"}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if order, _ := h.store.GetOrder(context.Background(), "o1"); order.Cost != nil {
+		t.Errorf("walking cost = %+v", order.Cost)
+	}
+
+	// Drivers routed by another provider are priced by it, which can't
+	h = newHarness(t, func(c *config.Configuration) {
+		c.Maps.Modes = map[string]config.ModeConfig{"driving": {Provider: routing.Haversine}}
+	})
+	h.provider.Cost = &routing.Cost{Distance: 12000, Fuel: 1.1}
+	h.post(t, "/transport", `{"order_id":"o1","mode":"driving"}`)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	if order, _ := h.store.GetOrder(context.Background(), "o1"); order.Cost != nil {
+		t.Errorf("cost priced by another provider = %+v", order.Cost)
+	}
+}
+
+func TestTargetAddresses(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":37.41,"lng":-122.07}`)
+
+	if status, body := h.do(t, http.MethodPost, "/location/target", `{"order_id":"o1","plus_code":"849VCWC8+R9"}`, admin...); status != http.StatusOK {
+		t.Fatalf("plus code: got %d %q", status, body)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if want := (geo.Point{Lat: 37.4220625, Lng: -122.0840625}); order.Target == nil || geo.Distance(*order.Target, want) > 0.01 {
+		t.Errorf("target = %v, want %v", order.Target, want)
+	}
+
+	for body, want := range map[string]int{
+		`{"order_id":"o1","plus_code":"849VCWC8"}`:                         http.StatusBadRequest,
+		`{"order_id":"o1","plus_code":"CWC8+R9"}`:                          http.StatusBadRequest,
+		`{"order_id":"o1","plus_code":"849VCWC8+R9","what3words":"a.b.c"}`: http.StatusBadRequest,
+		`{"order_id":"o1","what3words":"filled.count.soap"}`:               http.StatusNotImplemented,
+	} {
+		if status, got := h.do(t, http.MethodPost, "/location/target", body, admin...); status != want {
+			t.Errorf("%s: got %d %q, want %d", body, status, got, want)
+		}
+	}
+}
+
+func TestIsochroneNeedsProvider(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	if status, _ := h.do(t, http.MethodGet, "/isochrone?lat=1.3&lng=103.8&minutes=15", "", admin...); status != http.StatusNotImplemented {
+		t.Errorf("unconfigured: got %d", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/isochrone?lat=1.3&lng=103.8&minutes=600", "", admin...); status != http.StatusBadRequest {
+		t.Errorf("too long: got %d", status)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	h := newHarness(t)
+	admin := []string{"Authorization", "Bearer " + adminToken}
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	status, _ := h.do(t, http.MethodPost, "/location/current",
+		`{"type":"Feature","geometry":{"type":"Point","coordinates":[103.85,1.35]},"properties":{"order_id":"o1","speed":4}}`,
+		"Content-Type", geo.GeoJSONType)
+	if status != http.StatusOK {
+		t.Fatalf("GeoJSON location: got %d", status)
+	}
+	order, _ := h.store.GetOrder(context.Background(), "o1")
+	if order.Current == nil || *order.Current != (geo.Point{Lat: 1.35, Lng: 103.85}) || order.Telemetry == nil || *order.Telemetry.Speed != 4 {
+		t.Fatalf("order = %+v", order)
+	}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+
+	var collection geo.FeatureCollection
+	_, body := h.do(t, http.MethodGet, "/order/o1", "", append(admin, "Accept", geo.GeoJSONType)...)
+	json.Unmarshal([]byte(body), &collection)
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 2 || collection.Features[1].ID != "target" {
+		t.Errorf("order: %s", body)
+	}
+	_, body = h.do(t, http.MethodGet, "/admin/orders/o1/history", "", append(admin, "Accept", geo.GeoJSONType)...)
+	json.Unmarshal([]byte(body), &collection)
+	if trail := collection.Features[0]; trail.Geometry.Type != "LineString" || string(trail.Geometry.Coordinates) != "[[103.85,1.35],[103.84,1.34]]" {
+		t.Errorf("history: %s", body)
+	}
+
+	// Geofences go in and come out as features, circles as points with a radius
+	fences := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[103.8,1.3]},"properties":{"name":"door","radius":30}},
+		{"type":"Feature","id":"block","geometry":{"type":"Polygon","coordinates":[[[103.79,1.29],[103.81,1.29],[103.81,1.31],[103.79,1.29]]]},"properties":null}]}`
+	if status, body := h.do(t, http.MethodPut, "/order/o1/geofences", fences, append(admin, "Content-Type", geo.GeoJSONType)...); status != http.StatusNoContent {
+		t.Fatalf("set geofences: %d %s", status, body)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1/geofences", "", admin...)
+	var got []geo.Fence
+	json.Unmarshal([]byte(body), &got)
+	if len(got) != 2 || got[0].Radius != 30 || got[1].Name != "block" || len(got[1].Polygon) != 3 {
+		t.Errorf("geofences = %s", body)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1/geofences", "", append(admin, "Accept", geo.GeoJSONType)...)
+	if json.Unmarshal([]byte(body), &collection); len(collection.Features) != 2 || collection.Features[1].Geometry.Type != "Polygon" {
+		t.Errorf("geofences as GeoJSON = %s", body)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Tenancy.Tenants = map[string]config.TenantConfig{
+			"acme":  {APIKey: "acme-key", RateLimit: 0.001, RateBurst: 5},
+			"other": {APIKey: "other-key"},
+		}
+	})
+	authn, err := auth.New(h.runtime.Config().Auth, func() string { return adminToken })
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn.UseTenants(func() config.TenancyConfig { return h.runtime.Config().Tenancy })
+	providers := map[string]routing.Provider{routing.Google: h.provider}
+	handler := handlers.New(tracking.New(h.store, providers, h.publisher, h.runtime), h.runtime, authn)
+	handler.UseTenants(map[string]*tracking.Tracker{
+		"acme":  tracking.New(store.NewMemory(), providers, h.publisher, h.runtime),
+		"other": tracking.New(store.NewMemory(), providers, h.publisher, h.runtime),
+	})
+	h.srv.Config.Handler = server.Routes(handler)
+
+	h.do(t, http.MethodPost, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`, "Authorization", "Bearer acme-key")
+	if status, _ := h.do(t, http.MethodPost, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`, "Authorization", "Bearer acme-key"); status != http.StatusOK {
+		t.Fatalf("acme location: got %d, want 200", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", "", "X-Tenant", "acme"); status != http.StatusOK {
+		t.Errorf("order of acme: got %d, want 200", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", ""); status != http.StatusNotFound {
+		t.Errorf("order of the default tenant: got %d, want 404", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", "", "Authorization", "Bearer other-key"); status != http.StatusNotFound {
+		t.Errorf("order of another tenant: got %d, want 404", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", "", "Authorization", "Bearer other-key", "X-Tenant", "acme"); status != http.StatusForbidden {
+		t.Errorf("key naming another tenant: got %d, want 403", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", "", "X-Tenant", "nobody"); status != http.StatusNotFound {
+		t.Errorf("unknown tenant: got %d, want 404", status)
+	}
+	if status, _ := h.do(t, http.MethodPatch, "/admin/config", `{"log_level":"debug"}`, "Authorization", "Bearer acme-key"); status != http.StatusForbidden {
+		t.Errorf("tenant changing settings: got %d, want 403", status)
+	}
+
+	// acme spends the last of its burst of five here
+	h.do(t, http.MethodGet, "/order/o1", "", "X-Tenant", "acme")
+	status, _ := h.do(t, http.MethodGet, "/order/o1", "", "X-Tenant", "acme")
+	if status != http.StatusTooManyRequests {
+		t.Errorf("over the rate limit: got %d, want 429", status)
+	}
+	if status, _ := h.do(t, http.MethodGet, "/order/o1", "", "Authorization", "Bearer other-key"); status != http.StatusNotFound {
+		t.Errorf("other tenant while acme is limited: got %d, want 404", status)
+	}
+}
+
+func TestEventSequence(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+
+	events := h.publisher.Events()
+	for i, e := range events {
+		if e.Sequence != int64(i+1) || e.ID != fmt.Sprintf("o1:%d", i+1) {
+			t.Errorf("event %d numbered %d %q", i, e.Sequence, e.ID)
+		}
+	}
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	if err := json.Unmarshal([]byte(body), &eta); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) < 2 || eta.Sequence != int64(len(events)) {
+		t.Errorf("ETA sequence %d after %d events", eta.Sequence, len(events))
+	}
+}
+
+func TestMetadataPassThrough(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80,"metadata":{"customer":"Ana","packages":"2"}}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	want := map[string]string{"customer": "Ana", "packages": "2"}
+	events := h.publisher.Events()
+	if len(events) == 0 {
+		t.Fatal("no events published")
+	}
+	for _, e := range events {
+		if !reflect.DeepEqual(e.Metadata, want) {
+			t.Errorf("%s event has metadata %v", e.Type, e.Metadata)
+		}
+	}
+	_, body := h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	if err := json.Unmarshal([]byte(body), &eta); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(eta.Metadata, want) {
+		t.Errorf("ETA has metadata %v", eta.Metadata)
+	}
+
+	code, _ := h.do(t, http.MethodPost, "/location/target", `{"order_id":"o2","lat":1.30,"lng":103.80,"metadata":{"note":"`+strings.Repeat("x", 5000)+`"}}`)
+	if code != http.StatusBadRequest {
+		t.Errorf("oversized metadata got %d", code)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	h := newHarness(t)
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+	published := len(h.publisher.Events())
+
+	if code, _ := h.do(t, http.MethodPost, "/order/o1/pause", ""); code != http.StatusNoContent {
+		t.Fatalf("pause got %d", code)
+	}
+	code, body := h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+	if code != http.StatusAccepted || body != "0s" {
+		t.Errorf("paused location got %d %q", code, body)
+	}
+	if n := len(h.publisher.Events()); n != published {
+		t.Errorf("published %d events while paused", n-published)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1", "")
+	var summary handlers.OrderSummary
+	if err := json.Unmarshal([]byte(body), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Status != store.StatusPaused || *summary.Current != (geo.Point{Lat: 1.34, Lng: 103.84}) {
+		t.Errorf("paused order reads %s at %v", summary.Status, summary.Current)
+	}
+	_, body = h.do(t, http.MethodGet, "/order/o1/eta", "")
+	var eta handlers.ETA
+	if err := json.Unmarshal([]byte(body), &eta); err != nil {
+		t.Fatal(err)
+	}
+	if eta.PausedAt == nil || eta.ETA != 5*time.Minute {
+		t.Errorf("paused ETA reads %+v", eta)
+	}
+
+	if code, _ := h.do(t, http.MethodPost, "/order/o1/resume", ""); code != http.StatusNoContent {
+		t.Fatalf("resume got %d", code)
+	}
+	if code, _ := h.post(t, "/location/current", `{"order_id":"o1","lat":1.33,"lng":103.83}`); code != http.StatusOK {
+		t.Errorf("resumed location got %d", code)
+	}
+	if n := len(h.publisher.Events()); n <= published {
+		t.Error("nothing published after resuming")
+	}
+
+	if code, _ := h.do(t, http.MethodPost, "/order/nope/pause", ""); code != http.StatusNotFound {
+		t.Errorf("pausing an unknown order got %d", code)
+	}
+}
+
+func TestHotReadsServedInProcessUntilChanged(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Cache.ResponseTTL = config.Duration{Duration: time.Minute}
+		c.Cache.LocalEntries = 100
+		c.Cache.LocalTTL = config.Duration{Duration: time.Minute}
+	})
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	etag := func() string {
+		t.Helper()
+		resp, err := http.Get(h.srv.URL + "/order/o1/eta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("ETag")
+	}
+	tag := etag()
+
+	// Changes counted behind the tracker's back are not seen
+	h.store.NextSequence(context.Background(), "o1")
+	if got := etag(); got != tag {
+		t.Errorf("ETag %s, want %s from the process", got, tag)
+	}
+	// until the order changes through it
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+	if got := etag(); got == tag {
+		t.Errorf("ETag still %s after a change", got)
+	}
+}
+
+func TestOrderReadsRevalidateWithETag(t *testing.T) {
+	h := newHarness(t, func(c *config.Configuration) {
+		c.Cache.ResponseTTL = config.Duration{Duration: time.Minute}
+	})
+	h.post(t, "/location/target", `{"order_id":"o1","lat":1.30,"lng":103.80}`)
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.35,"lng":103.85}`)
+
+	resp, err := http.Get(h.srv.URL + "/order/o1/eta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || tag == "" {
+		t.Fatalf("got %d with ETag %q", resp.StatusCode, tag)
+	}
+	if code, body := h.do(t, http.MethodGet, "/order/o1/eta", "", "If-None-Match", tag); code != http.StatusNotModified || body != "" {
+		t.Errorf("revalidating got %d %q, want 304", code, body)
+	}
+	if code, _ := h.do(t, http.MethodGet, "/order/o1", "", "If-None-Match", tag); code != http.StatusOK {
+		t.Errorf("the ETA's ETag matched the order's, got %d", code)
+	}
+
+	// Until the order changes through the tracker, reads are answered from
+	// the response cache
+	h.store.SetMode(context.Background(), "o1", "walking")
+	if _, body := h.do(t, http.MethodGet, "/order/o1", ""); strings.Contains(body, "walking") {
+		t.Errorf("cached order read shows a change without an event: %s", body)
+	}
+	h.post(t, "/location/current", `{"order_id":"o1","lat":1.34,"lng":103.84}`)
+	if code, _ := h.do(t, http.MethodGet, "/order/o1/eta", "", "If-None-Match", tag); code != http.StatusOK {
+		t.Errorf("revalidating after an event got %d, want 200", code)
+	}
+	if _, body := h.do(t, http.MethodGet, "/order/o1", ""); !strings.Contains(body, "walking") {
+		t.Errorf("order read after an event is stale: %s", body)
+	}
+
+	// Changes that publish no event count all the same
+	resp, err = http.Get(h.srv.URL + "/order/o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tag = resp.Header.Get("ETag")
+	h.do(t, http.MethodPost, "/order/o1/pause", "", "Authorization", "Bearer "+adminToken)
+	if code, _ := h.do(t, http.MethodGet, "/order/o1", "", "If-None-Match", tag); code != http.StatusOK {
+		t.Errorf("revalidating after a pause got %d, want 200", code)
+	}
+	if _, body := h.do(t, http.MethodGet, "/order/o1", ""); !strings.Contains(body, "paused_at") {
+		t.Errorf("order read after a pause is stale: %s", body)
+	}
+	if code, _ := h.do(t, http.MethodGet, "/order/nope/eta", "", "If-None-Match", "*"); code != http.StatusNotFound {
+		t.Errorf("unknown order got %d, want 404", code)
+	}
+}
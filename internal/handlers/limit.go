@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+
+	"golang.org/x/time/rate"
+
+	"location/internal/auth"
+	"location/internal/config"
+)
+
+// RateLimit turns away requests of tenants making more than their
+// configured rate, so one brand cannot crowd out the others. It must run
+// after authentication, which decides the tenant.
+func (h *Handler) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := auth.TenantFromContext(r.Context())
+		t, ok := h.runtime.Config().Tenancy.Tenants[name]
+		if ok && t.RateLimit > 0 && !h.limiter(name, t).Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limiter returns the token bucket of a tenant, following changes to its
+// limit on reload.
+func (h *Handler) limiter(name string, t config.TenantConfig) *rate.Limiter {
+	burst := t.RateBurst
+	if burst == 0 {
+		burst = max(int(math.Ceil(t.RateLimit)), 1)
+	}
+
+	h.limitMu.Lock()
+	defer h.limitMu.Unlock()
+	l, ok := h.limiters[name]
+	if !ok {
+		if h.limiters == nil {
+			h.limiters = map[string]*rate.Limiter{}
+		}
+		l = rate.NewLimiter(rate.Limit(t.RateLimit), burst)
+		h.limiters[name] = l
+	}
+	if l.Limit() != rate.Limit(t.RateLimit) {
+		l.SetLimit(rate.Limit(t.RateLimit))
+	}
+	if l.Burst() != burst {
+		l.SetBurst(burst)
+	}
+	return l
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"location/internal/metrics"
+	"location/internal/tracking"
+)
+
+// Measure counts requests, and those failed by the server, for the
+// availability SLI, and tells the tracker when each request arrived for the
+// publish lag one. It goes outside every other middleware, so that requests
+// turned away count too.
+func (h *Handler) Measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(tracking.WithReceivedAt(r.Context(), time.Now())))
+		h.requests.Add(1)
+		if rec.status >= 500 {
+			h.serverErrors.Add(1)
+		}
+	})
+}
+
+// ConnState counts the connections clients open, for the http.Server's
+// ConnState hook; with requests, it shows how well clients reuse them.
+func (h *Handler) ConnState(_ net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		h.connections.Add(1)
+	}
+}
+
+// statusRecorder remembers the status of a response. Event streams still
+// flush through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wrote = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Metrics exports the service level indicators in the Prometheus text
+// format: ratios of good to all events, such as requests served without a
+// server error or ETAs routed, and distributions, such as the publish lag,
+// so that SLO burn-rate alerts follow what users see rather than raw error
+// counts. Tracker indicators are labelled with their tenant.
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+	names := []string{""}
+	for name := range h.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	slis := make([]tracking.SLIs, len(names))
+	for i, name := range names {
+		t := h.tracker
+		if name != "" {
+			t = h.tenants[name]
+		}
+		slis[i] = t.SLIs()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m := metrics.NewWriter(w)
+	m.Counter("location_http_requests_total", "HTTP requests served.", float64(h.requests.Load()))
+	m.Counter("location_http_server_errors_total", "HTTP requests answered with a 5xx status.", float64(h.serverErrors.Load()))
+	m.Counter("location_http_connections_total", "HTTP connections accepted.", float64(h.connections.Load()))
+	for i, s := range slis {
+		m.Counter("location_eta_computations_total", "ETAs routed, successfully or not.", float64(s.ETAComputations), "tenant", names[i])
+	}
+	for i, s := range slis {
+		m.Counter("location_eta_computation_failures_total", "ETAs the route provider failed to route.", float64(s.ETAFailures), "tenant", names[i])
+	}
+	for i, s := range slis {
+		m.Histogram("location_publish_lag_seconds", "Time from receiving a request to publishing the events it caused.", s.PublishLag, "tenant", names[i])
+	}
+	for i, s := range slis {
+		m.Gauge("location_oldest_active_order_staleness_seconds", "Time since the courier of the longest-silent order under way reported, as of the last watchdog round.", s.OldestSilence.Seconds(), "tenant", names[i])
+	}
+}
@@ -0,0 +1,628 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/auth"
+	"location/internal/geo"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// ETA is the read-only view of an order given to customers.
+type ETA struct {
+	OrderID string        `json:"order_id"`
+	ETA     time.Duration `json:"eta"`
+	ETAAt   time.Time     `json:"eta_at"`
+	// ETALow and ETAHigh bound the ETA, for promising a window.
+	ETALow  time.Duration `json:"eta_low,omitempty"`
+	ETAHigh time.Duration `json:"eta_high,omitempty"`
+	// Weather is set when the ETA was stretched for rain or snow.
+	Weather string `json:"weather,omitempty"`
+	// Deadline and SLA are set for orders promised by a time.
+	Deadline *time.Time `json:"deadline,omitempty"`
+	SLA      string     `json:"sla,omitempty"`
+	// DeliveredAt is set once the order has arrived.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// DepartAt is set for deliveries planned ahead that have not left yet.
+	DepartAt *time.Time `json:"depart_at,omitempty"`
+	// Phase is set for orders with a pickup. On the way there, PickupETA
+	// and DropoffETA split the ETA into the trip to the pickup and the
+	// rest, including waiting until ReadyAt for the order.
+	Phase      string        `json:"phase,omitempty"`
+	PickupETA  time.Duration `json:"pickup_eta,omitempty"`
+	DropoffETA time.Duration `json:"dropoff_eta,omitempty"`
+	ReadyAt    *time.Time    `json:"ready_at,omitempty"`
+	// Stale is set when the courier has stopped reporting, so the ETA can
+	// no longer be trusted.
+	Stale bool `json:"stale"`
+	// Arrived is set once the courier has reached the target; a zero ETA
+	// alone does not mean it has.
+	Arrived bool `json:"arrived"`
+	// Sequence is the number of the last event published about the order,
+	// for reconciling with the events received.
+	Sequence int64 `json:"sequence,omitempty"`
+	// Metadata is what the ordering backend attached to the order.
+	Metadata map[string]string `json:"metadata,omitempty" visible:"dispatcher"`
+	// PausedAt is set while tracking is paused; the ETA is the one from
+	// before.
+	PausedAt *time.Time `json:"paused_at,omitempty"`
+}
+
+// Order returns the full tracking state of an order. Like OrderETA, it
+// answers If-None-Match against the order's event sequence.
+func (h *Handler) Order(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	representation, contentType := "order", "application/json"
+	if wantsGeoJSON(r) {
+		representation, contentType = "order.geojson", geo.GeoJSONType
+	}
+	rv, ok := h.revalidate(w, r, orderID, representation, contentType)
+	if !ok {
+		return
+	}
+	order, ok := h.loadRevalidated(w, r, rv)
+	if !ok {
+		return
+	}
+	staleAfter := h.trackerFor(r.Context()).StaleAfter()
+	summary := OrderSummary{Order: h.obscureOrder(r, order), Status: order.Status(staleAfter), Stale: order.Stale(staleAfter)}
+	if wantsGeoJSON(r) {
+		h.respond(w, r, rv.key, contentType, orderFeatures(summary))
+		return
+	}
+	h.respond(w, r, rv.key, contentType, summary)
+}
+
+// NewOrder creates an order: its target, mode, metadata and schedule, as in
+// a batch, and how the customer wants to hear about it.
+type NewOrder struct {
+	BatchOrder
+	Preferences *store.Preferences `json:"preferences,omitempty"`
+}
+
+// CreateOrder creates an order for the ordering backend and answers 201 with
+// it, or 409 if it exists. Until orders are created, their location updates
+// are refused unless orders are created implicitly.
+func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var o NewOrder
+	err := json.NewDecoder(r.Body).Decode(&o)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if msg := o.check(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if o.Preferences != nil {
+		if err := o.Preferences.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	tracker := h.trackerFor(r.Context())
+	created := o.newOrder()
+	created.Preferences = o.Preferences
+	order, err := tracker.CreateOrder(ctx, created)
+	if errors.Is(err, store.ErrExists) {
+		http.Error(w, "Order already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to create order")
+		return
+	}
+
+	staleAfter := tracker.StaleAfter()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/order/"+url.PathEscape(order.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(h.shape(r, OrderSummary{Order: order, Status: order.Status(staleAfter), Stale: order.Stale(staleAfter)}))
+}
+
+// OrderETA returns the latest travel time of an order.
+func (h *Handler) OrderETA(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	rv, ok := h.revalidate(w, r, orderID, "eta", "application/json")
+	if !ok {
+		return
+	}
+	order, ok := h.loadRevalidated(w, r, rv)
+	if !ok {
+		return
+	}
+	h.respond(w, r, rv.key, "application/json", etaOf(h.trackerFor(r.Context()), order))
+}
+
+// etaOf tells the latest travel time of an order.
+func etaOf(tracker *tracking.Tracker, order store.Order) ETA {
+	eta := ETA{
+		OrderID:  order.ID,
+		ETA:      order.ETA,
+		ETAAt:    order.ETAAt,
+		ETALow:   order.ETALow,
+		ETAHigh:  order.ETAHigh,
+		Weather:  order.Weather,
+		Phase:    order.Phase,
+		Stale:    order.Stale(tracker.StaleAfter()),
+		Arrived:  tracker.Arrived(order),
+		Sequence: order.Sequence,
+		Metadata: order.Metadata,
+	}
+	if order.DepartAt.After(time.Now()) {
+		eta.DepartAt = &order.DepartAt
+	}
+	if order.Phase == store.PhasePickup {
+		eta.PickupETA, eta.DropoffETA = tracking.Legs(order, order.ETA)
+		if !order.ReadyAt.IsZero() {
+			eta.ReadyAt = &order.ReadyAt
+		}
+	}
+	if !order.Deadline.IsZero() {
+		eta.Deadline, eta.SLA = &order.Deadline, order.SLA
+	}
+	if order.Delivery != nil {
+		eta.DeliveredAt = &order.Delivery.At
+	}
+	if !order.PausedAt.IsZero() {
+		eta.PausedAt = &order.PausedAt
+	}
+	return eta
+}
+
+// ShareLink is a minted link to track one order.
+type ShareLink struct {
+	OrderID   string    `json:"order_id"`
+	Token     string    `json:"token"`
+	Path      string    `json:"path"`
+	Page      string    `json:"page"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ShareOrder mints a share link for an order. The optional ttl query
+// parameter shortens the link's lifetime below the configured maximum.
+func (h *Handler) ShareOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.AuthorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	shares := h.auth.Shares()
+	if shares == nil {
+		http.Error(w, "Share links are not enabled", http.StatusNotFound)
+		return
+	}
+
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+
+	if _, ok := h.loadOrder(w, r, orderID); !ok {
+		return
+	}
+	token, expires, err := shares.Mint(auth.TenantFromContext(r.Context()), orderID, ttl)
+	if err != nil {
+		log.Printf("Failed to mint share link for order %s: %v", orderID, err)
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	h.writeJSON(w, r, ShareLink{
+		OrderID:   orderID,
+		Token:     token,
+		Path:      "/order/" + url.PathEscape(orderID) + "/eta?share=" + url.QueryEscape(token),
+		Page:      "/track/" + token,
+		ExpiresAt: expires,
+	})
+}
+
+// SetGeofences replaces an order's own geofences with the JSON array in the
+// body, or the GeoJSON feature collection when sent as application/geo+json.
+// Like targets, they come from the ordering backend.
+func (h *Handler) SetGeofences(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	fences, err := decodeFences(r)
+	if err != nil {
+		http.Error(w, "Invalid request payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	names := map[string]bool{}
+	for _, f := range fences {
+		if err := f.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if names[f.Name] {
+			http.Error(w, "Duplicate geofence "+f.Name, http.StatusBadRequest)
+			return
+		}
+		names[f.Name] = true
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SetGeofences(ctx, orderID, fences)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to store geofences")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeFences reads a JSON array of geofences, or a GeoJSON feature
+// collection of them.
+func decodeFences(r *http.Request) ([]geo.Fence, error) {
+	fences := []geo.Fence{}
+	if !sentGeoJSON(r) {
+		err := json.NewDecoder(r.Body).Decode(&fences)
+		return fences, err
+	}
+	var collection geo.FeatureCollection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		return nil, err
+	}
+	for _, feature := range collection.Features {
+		f, err := geo.FenceFromFeature(feature)
+		if err != nil {
+			return nil, err
+		}
+		fences = append(fences, f)
+	}
+	return fences, nil
+}
+
+// Geofences returns an order's own geofences.
+func (h *Handler) Geofences(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	order, ok := h.loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	fences := order.Geofences
+	if fences == nil {
+		fences = []geo.Fence{}
+	}
+	if wantsGeoJSON(r) {
+		h.writeGeoJSON(w, r, fenceFeatures(fences))
+		return
+	}
+	h.writeJSON(w, r, fences)
+}
+
+// SetAlerts replaces an order's proximity alerts with the JSON array in the
+// body. Each alert fires once, however often the courier crosses it.
+func (h *Handler) SetAlerts(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var alerts []store.Alert
+	err := json.NewDecoder(r.Body).Decode(&alerts)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	for _, a := range alerts {
+		if err := a.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SetAlerts(ctx, orderID, alerts)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to store alerts")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetPreferences replaces an order's notification preferences. Customers
+// may set their own, as may the ordering backend.
+func (h *Handler) SetPreferences(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var prefs store.Preferences
+	err := json.NewDecoder(r.Body).Decode(&prefs)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if err := prefs.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SetPreferences(ctx, orderID, prefs)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to store preferences")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Preferences returns an order's notification preferences, or 404 if none
+// were set.
+func (h *Handler) Preferences(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	order, ok := h.loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	if order.Preferences == nil {
+		http.Error(w, "No preferences set", http.StatusNotFound)
+		return
+	}
+	h.writeJSON(w, r, order.Preferences)
+}
+
+// DeliveryConfirmation is the optional proof a courier sends with a
+// delivery. Without a lat and lng, the courier's last location is used.
+type DeliveryConfirmation struct {
+	PhotoURL      string   `json:"photo_url"`
+	SignatureHash string   `json:"signature_hash"`
+	Note          string   `json:"note"`
+	Lat           *float64 `json:"lat"`
+	Lng           *float64 `json:"lng"`
+}
+
+// Delivered finalizes an order as delivered and answers with the stored
+// proof of arrival. Delivering an order twice is a conflict.
+func (h *Handler) Delivered(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeDriver) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var confirmation DeliveryConfirmation
+	err := json.NewDecoder(r.Body).Decode(&confirmation)
+	if err != nil && err != io.EOF {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if u, err := url.Parse(confirmation.PhotoURL); confirmation.PhotoURL != "" && (err != nil || (u.Scheme != "https" && u.Scheme != "http")) {
+		http.Error(w, "photo_url must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+	delivery := store.Delivery{
+		PhotoURL:      confirmation.PhotoURL,
+		SignatureHash: confirmation.SignatureHash,
+		Note:          confirmation.Note,
+	}
+	if (confirmation.Lat == nil) != (confirmation.Lng == nil) {
+		http.Error(w, "lat and lng go together", http.StatusBadRequest)
+		return
+	}
+	if confirmation.Lat != nil {
+		delivery.Location = &geo.Point{Lat: *confirmation.Lat, Lng: *confirmation.Lng}
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	delivery, err = h.trackerFor(r.Context()).Deliver(ctx, orderID, delivery)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrDelivered):
+		http.Error(w, "Order already delivered", http.StatusConflict)
+	case err != nil:
+		failed(ctx, w, "Failed to mark order delivered")
+	default:
+		h.writeJSON(w, r, delivery)
+	}
+}
+
+// PauseOrder stops calculating ETAs and notifying about an order, such as
+// while its courier is on a break, until ResumeOrder. Pausing a paused
+// order does nothing.
+func (h *Handler) PauseOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeDriver) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	_, err := h.trackerFor(r.Context()).Pause(ctx, orderID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrDelivered):
+		http.Error(w, "Order already delivered", http.StatusConflict)
+	case err != nil:
+		failed(ctx, w, "Failed to pause order")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ResumeOrder restarts tracking of a paused order. Its ETA is calculated
+// again from the courier's next location.
+func (h *Handler) ResumeOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeDriver) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err := h.trackerFor(r.Context()).Resume(ctx, orderID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+	case err != nil:
+		failed(ctx, w, "Failed to resume order")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Deadline is the payload promising an order by a time.
+type Deadline struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+// SetDeadline records when an order was promised by. From then on the
+// order's ETA is compared with it, raising sla_at_risk and sla_breached
+// events.
+func (h *Handler) SetDeadline(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var deadline Deadline
+	err := json.NewDecoder(r.Body).Decode(&deadline)
+	if err != nil || deadline.Deadline.IsZero() {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	err = h.trackerFor(r.Context()).SetDeadline(ctx, orderID, deadline.Deadline)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to store deadline")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Schedule is the payload planning a delivery ahead.
+type Schedule struct {
+	DepartAt time.Time `json:"depart_at"`
+}
+
+// ScheduleOrder plans when an order's courier leaves, and answers with the
+// ETA predicted for that departure time, if the order has an origin yet.
+func (h *Handler) ScheduleOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var schedule Schedule
+	err := json.NewDecoder(r.Body).Decode(&schedule)
+	if err != nil || schedule.DepartAt.IsZero() {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	travelTime, err := h.trackerFor(r.Context()).Schedule(ctx, orderID, schedule.DepartAt)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to schedule order")
+		return
+	}
+	if travelTime > 0 {
+		err = h.trackerFor(r.Context()).PublishTravelTime(ctx, orderID, travelTime)
+		if err != nil {
+			failed(ctx, w, "Failed to publish travel time")
+			return
+		}
+	}
+	h.writeJSON(w, r, ETA{OrderID: orderID, ETA: travelTime, ETAAt: time.Now(), DepartAt: &schedule.DepartAt})
+}
+
+// loadOrder fetches an order, writing the error response if that fails.
+func (h *Handler) loadOrder(w http.ResponseWriter, r *http.Request, orderID string) (store.Order, bool) {
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	order, err := h.trackerFor(r.Context()).Order(ctx, orderID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return order, false
+	}
+	if err != nil {
+		failed(ctx, w, "Failed to get order")
+		return order, false
+	}
+	return order, true
+}
+
+// writeJSON writes v as the caller of r may see it.
+func (h *Handler) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.shape(r, v))
+}
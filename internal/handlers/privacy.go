@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"location/internal/auth"
+	"location/internal/geo"
+	"location/internal/store"
+)
+
+// coarse reports whether the caller of r sees couriers only as exactly as
+// the privacy settings allow: anyone but drivers, dispatchers and admins,
+// when they limit anything. Unlike audienceOf, callers with no credential
+// are rounded even without JWT authentication.
+func (h *Handler) coarse(r *http.Request) bool {
+	conf := h.runtime.Config().Privacy
+	if conf.Precision == 0 && conf.FuzzRadius == 0 {
+		return false
+	}
+	p, ok := auth.FromContext(r.Context())
+	return !ok || !(p.Has(auth.ScopeDriver) || p.Has(auth.ScopeDispatcher) || p.Has(auth.ScopeAdmin))
+}
+
+// obscure returns where to show the caller of r a courier of the order that
+// is at p.
+func (h *Handler) obscure(r *http.Request, orderID string, p geo.Point) geo.Point {
+	if !h.coarse(r) {
+		return p
+	}
+	return h.runtime.Config().Privacy.Obscure(orderID, p)
+}
+
+// obscureOrder returns order with the courier positions in it obscured for
+// the caller of r.
+func (h *Handler) obscureOrder(r *http.Request, order store.Order) store.Order {
+	if !h.coarse(r) {
+		return order
+	}
+	if order.Current != nil {
+		p := h.obscure(r, order.ID, *order.Current)
+		order.Current = &p
+	}
+	if order.RoutedFrom != nil {
+		p := h.obscure(r, order.ID, *order.RoutedFrom)
+		order.RoutedFrom = &p
+	}
+	if order.Stop != nil {
+		stop := *order.Stop
+		stop.Point = h.obscure(r, order.ID, stop.Point)
+		order.Stop = &stop
+	}
+	return order
+}
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/auth"
+	"location/internal/geo"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// keepAlive is how often an idle stream sends a comment, so proxies don't
+// close it.
+const keepAlive = 15 * time.Second
+
+// snapshotEvery is how often a stream of deltas sends the full state again,
+// so that clients that missed or misapplied a delta recover.
+const snapshotEvery = 5 * time.Minute
+
+// maxWait is the longest a long poll blocks, short of the idle timeouts of
+// common proxies.
+const maxWait = 30 * time.Second
+
+// Position is the live view of an order streamed to tracking pages.
+type Position struct {
+	OrderID     string        `json:"order_id"`
+	Courier     *geo.Point    `json:"courier,omitempty"`
+	Destination *geo.Point    `json:"destination,omitempty"`
+	Pickup      *geo.Point    `json:"pickup,omitempty"`
+	Phase       string        `json:"phase,omitempty"`
+	PickupETA   time.Duration `json:"pickup_eta,omitempty"`
+	DropoffETA  time.Duration `json:"dropoff_eta,omitempty"`
+	ETA         time.Duration `json:"eta"`
+	ETAAt       time.Time     `json:"eta_at"`
+	ETALow      time.Duration `json:"eta_low,omitempty"`
+	ETAHigh     time.Duration `json:"eta_high,omitempty"`
+	Stale       bool          `json:"stale"`
+	// Arrived is set once the courier has reached the target.
+	Arrived bool `json:"arrived"`
+	// Sequence is the number of the last event about the order.
+	Sequence int64 `json:"sequence,omitempty"`
+	// DeliveredAt is set once the order has arrived.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// Bearing and Speed are the courier's, when its device reports them.
+	Bearing *float64 `json:"bearing,omitempty"`
+	Speed   *float64 `json:"speed,omitempty" visible:"dispatcher"`
+}
+
+// LivePosition is the estimated position of an order's courier between its
+// location updates.
+type LivePosition struct {
+	OrderID string    `json:"order_id"`
+	Courier geo.Point `json:"courier"`
+	// Estimated is false when Courier is simply the last reported location.
+	Estimated bool `json:"estimated"`
+	// Reported and ReportedAt are the last location the courier sent.
+	Reported   geo.Point `json:"reported"`
+	ReportedAt time.Time `json:"reported_at"`
+	Bearing    *float64  `json:"bearing,omitempty"`
+}
+
+// OrderPosition estimates where an order's courier is right now, so that
+// maps can move the courier smoothly between location updates.
+func (h *Handler) OrderPosition(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	order, ok := h.loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	if order.Current == nil {
+		http.Error(w, "No courier location yet", http.StatusNotFound)
+		return
+	}
+
+	p, estimated := h.trackerFor(r.Context()).EstimatePosition(order, time.Now())
+	live := LivePosition{
+		OrderID:    orderID,
+		Courier:    h.obscure(r, orderID, p),
+		Estimated:  estimated,
+		Reported:   h.obscure(r, orderID, *order.Current),
+		ReportedAt: order.SeenAt,
+	}
+	if order.Telemetry != nil {
+		live.Bearing = order.Telemetry.Bearing
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if wantsGeoJSON(r) {
+		h.writeGeoJSON(w, r, geo.NewFeature(orderID, geo.NewPoint(live.Courier), live))
+		return
+	}
+	h.writeJSON(w, r, live)
+}
+
+// OrderRoute is the way an order's courier has left to go.
+type OrderRoute struct {
+	OrderID string `json:"order_id"`
+	// Path starts at the courier and ends at the destination, by way of
+	// the pickup before the order is collected.
+	Path     []geo.Point   `json:"path"`
+	Duration time.Duration `json:"duration"`
+}
+
+// OrderRoute returns the route from an order's courier to its destination,
+// for tracking pages to draw. It is a straight line where the provider
+// only estimates travel times. Like other order reads it revalidates with
+// an ETag, so the route is asked of the provider once per change.
+func (h *Handler) OrderRoute(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	representation, contentType := "route", "application/json"
+	if wantsGeoJSON(r) {
+		representation, contentType = "route.geojson", geo.GeoJSONType
+	}
+	rv, ok := h.revalidate(w, r, orderID, representation, contentType)
+	if !ok {
+		return
+	}
+	order, ok := h.loadRevalidated(w, r, rv)
+	if !ok {
+		return
+	}
+	if order.Current == nil || order.Target == nil {
+		http.Error(w, "No courier or destination location yet", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := h.requestContext(r)
+	defer cancel()
+	// Routed from where the courier is shown, so the path gives no more away
+	route, err := h.trackerFor(r.Context()).Route(ctx, h.obscureOrder(r, order))
+	if err != nil {
+		failed(ctx, w, "Failed to get route")
+		return
+	}
+	way := OrderRoute{OrderID: orderID, Path: route.Path, Duration: route.Duration}
+	if wantsGeoJSON(r) {
+		h.respond(w, r, rv.key, contentType, geo.NewFeature(orderID, geo.NewLineString(way.Path), way))
+		return
+	}
+	h.respond(w, r, rv.key, contentType, way)
+}
+
+// OrderEvents streams an order's position and ETA as server-sent events:
+// the current state right away, then again after every location update.
+// With the delta query parameter set to true, updates are "delta" events
+// holding only the fields that changed, null for those now unset, and the
+// sequence; the full state comes as a "snapshot" event first and every
+// five minutes after.
+func (h *Handler) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before the first read so no update falls in between
+	changes, unsubscribe := h.trackerFor(r.Context()).Watch(orderID)
+	defer unsubscribe()
+
+	order, ok := h.loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	deltas := r.URL.Query().Get("delta") == "true"
+	var last []byte
+	var snapshotAt time.Time
+	send := func(order store.Order) {
+		// Arrival is told from where the courier is, not where it is shown
+		arrived := h.trackerFor(r.Context()).Arrived(order)
+		order = h.obscureOrder(r, order)
+		pos := Position{
+			OrderID:     order.ID,
+			Courier:     order.Current,
+			Destination: order.Target,
+			Pickup:      order.Pickup,
+			Phase:       order.Phase,
+			ETA:         order.ETA,
+			ETAAt:       order.ETAAt,
+			ETALow:      order.ETALow,
+			ETAHigh:     order.ETAHigh,
+			Stale:       order.Stale(h.trackerFor(r.Context()).StaleAfter()),
+			Arrived:     arrived,
+			Sequence:    order.Sequence,
+		}
+		if order.Phase == store.PhasePickup {
+			pos.PickupETA, pos.DropoffETA = tracking.Legs(order, order.ETA)
+		}
+		if order.Telemetry != nil {
+			pos.Bearing, pos.Speed = order.Telemetry.Bearing, order.Telemetry.Speed
+		}
+		if order.Delivery != nil {
+			pos.DeliveredAt = &order.Delivery.At
+		}
+		data, _ := json.Marshal(h.shape(r, pos))
+		switch {
+		case !deltas:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case last == nil || time.Since(snapshotAt) >= snapshotEvery:
+			fmt.Fprintf(w, "event: snapshot\nid: %d\ndata: %s\n\n", pos.Sequence, data)
+			snapshotAt = time.Now()
+		default:
+			changed, ok := delta(last, data)
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: delta\nid: %d\ndata: %s\n\n", pos.Sequence, changed)
+		}
+		last = data
+		flusher.Flush()
+	}
+	send(order)
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case _, open := <-changes:
+			if !open {
+				return
+			}
+			ctx, cancel := h.requestContext(r)
+			order, err := h.trackerFor(r.Context()).Order(ctx, orderID)
+			cancel()
+			if err != nil {
+				// The stream is already under way; let the client reconnect
+				return
+			}
+			send(order)
+		}
+	}
+}
+
+// delta returns the fields of the JSON object next that differ from prev,
+// with null for those gone, and its sequence. It reports false if nothing
+// but the sequence changed.
+func delta(prev, next []byte) ([]byte, bool) {
+	var before, after map[string]json.RawMessage
+	json.Unmarshal(prev, &before)
+	json.Unmarshal(next, &after)
+	changed := map[string]json.RawMessage{}
+	for k, v := range after {
+		if !bytes.Equal(before[k], v) {
+			changed[k] = v
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changed[k] = json.RawMessage("null")
+		}
+	}
+	delete(changed, "sequence")
+	if len(changed) == 0 {
+		return nil, false
+	}
+	if seq, ok := after["sequence"]; ok {
+		changed["sequence"] = seq
+	}
+	data, _ := json.Marshal(changed)
+	return data, true
+}
+
+// WaitETA long-polls an order's ETA, for clients behind proxies that break
+// streams: it answers as soon as the order's sequence passes the since query
+// parameter, and with 204 No Content if it does not within the wait
+// parameter, at most 30s. Clients pass the sequence of the ETA they have.
+func (h *Handler) WaitETA(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+	if !h.auth.Authorize(r, orderID, auth.ScopeCustomer, auth.ScopeDriver, auth.ScopeDispatcher, auth.ScopeShare) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil || since < 0 {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	wait := maxWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(d, maxWait)
+	}
+
+	// Subscribe before the first read so no update falls in between
+	tracker := h.trackerFor(r.Context())
+	changes, unsubscribe := tracker.Watch(orderID)
+	defer unsubscribe()
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	w.Header().Set("Cache-Control", "no-store")
+	for {
+		order, ok := h.loadOrder(w, r, orderID)
+		if !ok {
+			return
+		}
+		if order.Sequence > since {
+			h.writeJSON(w, r, etaOf(tracker, order))
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timeout.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case _, open := <-changes:
+			if !open {
+				// Shutting down; the client polls again
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+}
+
+// CloseStreams ends every open event stream, so shutdown need not wait for
+// browsers to go away.
+func (h *Handler) CloseStreams() {
+	h.tracker.Close()
+	for _, t := range h.tenants {
+		t.Close()
+	}
+}
+
+//go:embed track.html
+var trackHTML string
+
+var trackPage = template.Must(template.New("track").Parse(trackHTML))
+
+// TrackPage serves a self-contained live tracking page for a share link,
+// so merchants can send customers a URL instead of building a frontend.
+func (h *Handler) TrackPage(w http.ResponseWriter, r *http.Request) {
+	shares := h.auth.Shares()
+	if shares == nil {
+		http.NotFound(w, r)
+		return
+	}
+	token := mux.Vars(r)["token"]
+	p, err := shares.Verify(token)
+	if err != nil {
+		http.Error(w, "This tracking link is invalid or has expired", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	trackPage.Execute(w, struct{ OrderID, Token string }{p.Orders[0], token})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+
+	"location/internal/auth"
+)
+
+// Audiences of responses, from the least trusted up. Response fields tagged
+// `visible:"dispatcher"` or `visible:"admin"` are left out for audiences
+// below that; untagged fields are visible to all.
+type audience int
+
+const (
+	// Customers, through their tokens or share links, see where their order
+	// is and when it arrives.
+	audienceCustomer audience = iota
+	// Dispatchers also see the couriers' telemetry and the workings of
+	// orders. Drivers see their orders as customers do.
+	audienceDispatcher
+	// Admins also see who changed what.
+	audienceAdmin
+)
+
+var audienceNames = []string{"customer", "dispatcher", "admin"}
+
+func (a audience) String() string {
+	return audienceNames[a]
+}
+
+// visibleTo returns the least audience a field with the visible tag is
+// shown to.
+func visibleTo(tag string) audience {
+	for a, name := range audienceNames {
+		if name == tag {
+			return audience(a)
+		}
+	}
+	return audienceCustomer
+}
+
+// audienceOf returns who the caller of r is, for shaping responses. Without
+// JWT authentication, callers with no credential are trusted with
+// everything, as they are allowed everything; coarse still counts them as
+// customers.
+func (h *Handler) audienceOf(r *http.Request) audience {
+	p, ok := auth.FromContext(r.Context())
+	switch {
+	case !ok && !h.auth.Enabled():
+		return audienceAdmin
+	case !ok:
+		return audienceCustomer
+	case p.Has(auth.ScopeAdmin):
+		return audienceAdmin
+	case p.Has(auth.ScopeDispatcher):
+		return audienceDispatcher
+	default:
+		return audienceCustomer
+	}
+}
+
+// shape returns v as the caller of r may see it, with the fields hidden from
+// its audience zeroed. v itself is left as it is.
+func (h *Handler) shape(r *http.Request, v interface{}) interface{} {
+	a := h.audienceOf(r)
+	if a == audienceAdmin || v == nil {
+		return v
+	}
+	return redact(reflect.ValueOf(v), a).Interface()
+}
+
+// redact returns a copy of v without the fields hidden from a, copying only
+// as deep as needed to leave v untouched.
+func redact(v reflect.Value, a audience) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Elem().Type())
+		out.Elem().Set(redact(v.Elem(), a))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(redact(v.Elem(), a))
+		return out
+	case reflect.Slice:
+		if v.IsNil() || !hidesFields(v.Type().Elem()) {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(redact(v.Index(i), a))
+		}
+		return out
+	case reflect.Struct:
+		if !hidesFields(v.Type()) {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if a < visibleTo(f.Tag.Get("visible")) {
+				out.Field(i).Set(reflect.Zero(f.Type))
+				continue
+			}
+			out.Field(i).Set(redact(v.Field(i), a))
+		}
+		return out
+	}
+	return v
+}
+
+// hiding caches hidesFields by type.
+var hiding sync.Map
+
+// hidesFields reports whether values of t may hold fields hidden from some
+// audience. Interfaces may hold anything.
+func hidesFields(t reflect.Type) bool {
+	if v, ok := hiding.Load(t); ok {
+		return v.(bool)
+	}
+	h := hides(t, map[reflect.Type]bool{})
+	hiding.Store(t, h)
+	return h
+}
+
+func hides(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice:
+		return hides(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.IsExported() && (f.Tag.Get("visible") != "" || hides(f.Type, seen)) {
+				return true
+			}
+		}
+	}
+	return false
+}
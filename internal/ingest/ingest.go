@@ -0,0 +1,183 @@
+// Package ingest accepts positions from hardware GPS trackers that cannot
+// speak HTTP, over raw TCP or UDP, and feeds them to the tracker as driver
+// locations.
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Fix is one position reported by a tracker.
+type Fix struct {
+	// Device identifies the tracker, usually by its IMEI.
+	Device string
+	Point  geo.Point
+	// At is when the tracker took the fix, by its own clock.
+	At time.Time
+}
+
+// Decoder reads the frames of one TCP connection or UDP datagram.
+// Decoders may keep state between frames, such as the device ID sent when
+// a connection opens.
+type Decoder interface {
+	// Decode reads the next frame, returning its fixes and the reply the
+	// device expects, if any.
+	Decode(r *bufio.Reader) (fixes []Fix, reply []byte, err error)
+}
+
+// ErrMalformed wraps errors for frames that could not be parsed. The
+// connection carries on with the next frame.
+var ErrMalformed = errors.New("malformed frame")
+
+// Protocol describes a tracker protocol.
+type Protocol struct {
+	// New returns a decoder for a new connection or datagram.
+	New func() Decoder
+	// Datagrams is set for protocols that also work over UDP.
+	Datagrams bool
+}
+
+// Protocols are the supported tracker protocols by configuration name:
+// "text" lines of device,lat,lng[,time], NMEA 0183 sentences, or Teltonika
+// Codec 8.
+var Protocols = map[string]Protocol{
+	"text":      {New: func() Decoder { return textDecoder{} }, Datagrams: true},
+	"nmea":      {New: func() Decoder { return &nmeaDecoder{last: -1} }, Datagrams: true},
+	"teltonika": {New: func() Decoder { return &teltonika{} }},
+}
+
+// idleTimeout closes TCP connections that have been silent this long.
+// Trackers report at least every few minutes while powered.
+const idleTimeout = 10 * time.Minute
+
+// Listener decodes tracker traffic with Protocol and passes every fix to
+// Handle, oldest first.
+type Listener struct {
+	Protocol Protocol
+	Handle   func(ctx context.Context, f Fix) error
+}
+
+// ServeTCP accepts tracker connections on addr until ctx is done.
+func (l *Listener) ServeTCP(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Tracker TCP listener on %s", addr)
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (l *Listener) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	r := bufio.NewReader(conn)
+	dec := l.Protocol.New()
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		fixes, reply, err := dec.Decode(r)
+		if errors.Is(err, ErrMalformed) {
+			log.Printf("tracker %s: %v", conn.RemoteAddr(), err)
+			continue
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				log.Printf("tracker %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		l.handle(ctx, fixes)
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeUDP reads tracker datagrams on addr until ctx is done.
+func (l *Listener) ServeUDP(ctx context.Context, addr string) error {
+	if !l.Protocol.Datagrams {
+		return errors.New("protocol does not support UDP")
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Tracker UDP listener on %s", addr)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		r := bufio.NewReader(strings.NewReader(string(buf[:n])))
+		dec := l.Protocol.New()
+		for {
+			fixes, reply, err := dec.Decode(r)
+			if errors.Is(err, ErrMalformed) {
+				log.Printf("tracker %s: %v", from, err)
+				continue
+			}
+			if err != nil {
+				break
+			}
+			l.handle(ctx, fixes)
+			if reply != nil {
+				conn.WriteTo(reply, from)
+			}
+		}
+	}
+}
+
+func valid(p geo.Point) bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lng >= -180 && p.Lng <= 180
+}
+
+func (l *Listener) handle(ctx context.Context, fixes []Fix) {
+	sort.SliceStable(fixes, func(i, j int) bool { return fixes[i].At.Before(fixes[j].At) })
+	for _, f := range fixes {
+		if err := l.Handle(ctx, f); err != nil {
+			log.Printf("tracker %s: %v", f.Device, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package ingest_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/ingest"
+)
+
+func TestText(t *testing.T) {
+	dec := ingest.Protocols["text"].New()
+	r := bufio.NewReader(strings.NewReader("d1,1.35,103.85,1700000000\n\nd1,95,0\nd2,1.3,103.8"))
+
+	fixes, _, err := dec.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ingest.Fix{Device: "d1", Point: geo.Point{Lat: 1.35, Lng: 103.85}, At: time.Unix(1700000000, 0)}
+	if len(fixes) != 1 || fixes[0] != want {
+		t.Fatalf("got %+v, want %+v", fixes, want)
+	}
+	if fixes, _, err := dec.Decode(r); err != nil || len(fixes) != 0 {
+		t.Fatalf("blank line: got %v, %v", fixes, err)
+	}
+	if _, _, err := dec.Decode(r); !errors.Is(err, ingest.ErrMalformed) {
+		t.Fatalf("got %v, want ErrMalformed", err)
+	}
+	fixes, _, err = dec.Decode(r)
+	if err != nil || len(fixes) != 1 || fixes[0].Device != "d2" {
+		t.Fatalf("last line without newline: got %v, %v", fixes, err)
+	}
+	if _, _, err := dec.Decode(r); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+}
+
+// avlPacket frames Codec 8 records as a Teltonika device would.
+func avlPacket(records ...[]byte) []byte {
+	data := []byte{0x08, byte(len(records))}
+	for _, r := range records {
+		data = append(data, r...)
+	}
+	data = append(data, byte(len(records)))
+	packet := binary.BigEndian.AppendUint32(nil, 0)
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(data)))
+	packet = append(packet, data...)
+	return binary.BigEndian.AppendUint32(packet, uint32(crc16(data)))
+}
+
+func avlRecord(at time.Time, p geo.Point) []byte {
+	r := binary.BigEndian.AppendUint64(nil, uint64(at.UnixMilli()))
+	r = append(r, 1)
+	r = binary.BigEndian.AppendUint32(r, uint32(int32(p.Lng*1e7)))
+	r = binary.BigEndian.AppendUint32(r, uint32(int32(p.Lat*1e7)))
+	r = append(r, 0, 10, 0, 90, 8, 0, 30) // Altitude, angle, satellites, speed
+	// Event ID, 2 IO elements: ignition (1 byte) and external voltage (2 bytes)
+	return append(r, 0, 2, 1, 239, 1, 1, 66, 0x30, 0x39, 0, 0)
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, c := range data {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func TestTeltonika(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write([]byte{0, 15})
+	stream.WriteString("356307042441013")
+	at := time.UnixMilli(1700000000000)
+	stream.Write(avlPacket(avlRecord(at, geo.Point{Lat: 1.35, Lng: 103.85}), avlRecord(at.Add(time.Second), geo.Point{})))
+	// The first example of the Codec 8 documentation, without a GPS fix
+	sample, _ := hex.DecodeString("000000000000003608010000016B40D8EA30010000000000000000000000000000000105021503010101425E0F01F10000601A014E0000000000000000010000C7CF")
+	stream.Write(sample)
+	corrupt := avlPacket(avlRecord(at, geo.Point{Lat: 1, Lng: 1}))
+	corrupt[len(corrupt)-1] ^= 0xFF
+	stream.Write(corrupt)
+
+	dec := ingest.Protocols["teltonika"].New()
+	r := bufio.NewReader(&stream)
+	fixes, reply, err := dec.Decode(r)
+	if err != nil || len(fixes) != 0 || !bytes.Equal(reply, []byte{1}) {
+		t.Fatalf("handshake: got %v, %x, %v", fixes, reply, err)
+	}
+
+	fixes, reply, err = dec.Decode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply, []byte{0, 0, 0, 2}) {
+		t.Errorf("got reply %x, want both records acknowledged", reply)
+	}
+	want := ingest.Fix{Device: "356307042441013", Point: geo.Point{Lat: 1.35, Lng: 103.85}, At: at}
+	if len(fixes) != 1 || fixes[0] != want {
+		t.Fatalf("got %+v, want only %+v", fixes, want)
+	}
+
+	fixes, reply, err = dec.Decode(r)
+	if err != nil || len(fixes) != 0 || !bytes.Equal(reply, []byte{0, 0, 0, 1}) {
+		t.Fatalf("documented sample: got %v, %x, %v", fixes, reply, err)
+	}
+
+	if _, _, err := dec.Decode(r); !errors.Is(err, ingest.ErrMalformed) {
+		t.Fatalf("got %v, want ErrMalformed for a bad CRC", err)
+	}
+}
+
+func TestParseNMEA(t *testing.T) {
+	munich := geo.Point{Lat: 48 + 7.038/60, Lng: 11 + 31.0/60}
+	for _, tc := range []struct {
+		sentence string
+		ok       bool
+		err      bool
+	}{
+		{sentence: "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A", ok: true},
+		{sentence: "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", ok: true},
+		{sentence: "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6B", err: true},
+		{sentence: "$GPRMC,123519,V,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W"},
+		{sentence: "$GPGGA,123519,4807.038,N,01131.000,E,0,00,,,M,,M,,"},
+		{sentence: "$GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00*74"},
+		{sentence: "GPRMC,123519", err: true},
+	} {
+		p, at, ok, err := ingest.ParseNMEA(tc.sentence)
+		if ok != tc.ok || (err != nil) != tc.err {
+			t.Errorf("%s: got ok %v, err %v", tc.sentence, ok, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if geo.Distance(p, munich) > 1 {
+			t.Errorf("%s: got %v, want %v", tc.sentence, p, munich)
+		}
+		if h, m, s := at.Clock(); h != 12 || m != 35 || s != 19 {
+			t.Errorf("%s: got time %v", tc.sentence, at)
+		}
+	}
+
+	_, at, _, _ := ingest.ParseNMEA("$GPRMC,123519.50,A,4807.038,S,01131.000,W,022.4,084.4,230394,003.1,W")
+	if want := time.Date(1994, 3, 23, 12, 35, 19, 5e8, time.UTC); !at.Equal(want) {
+		t.Errorf("got %v, want %v", at, want)
+	}
+}
+
+func TestNMEA(t *testing.T) {
+	dec := ingest.Protocols["nmea"].New()
+	r := bufio.NewReader(strings.NewReader("$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\n" +
+		"boat-7\n" +
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\n" +
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\n"))
+
+	if _, _, err := dec.Decode(r); !errors.Is(err, ingest.ErrMalformed) {
+		t.Fatalf("sentence before device: got %v, want ErrMalformed", err)
+	}
+	var fixes []ingest.Fix
+	for {
+		f, _, err := dec.Decode(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixes = append(fixes, f...)
+	}
+	// The GGA repeats the RMC fix
+	if len(fixes) != 1 || fixes[0].Device != "boat-7" {
+		t.Errorf("got %+v, want one fix from boat-7", fixes)
+	}
+}
@@ -0,0 +1,173 @@
+package ingest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"location/internal/geo"
+)
+
+// ParseNMEA reads the position from an NMEA 0183 GGA or RMC sentence from
+// any talker, such as $GPGGA or $GNRMC. ok is false for other sentences and
+// for ones reporting no fix. The checksum is verified when present.
+//
+// GGA sentences carry only the time of day; the date is taken to be the
+// latest at which that time is not in the future.
+func ParseNMEA(sentence string) (p geo.Point, at time.Time, ok bool, err error) {
+	sentence = strings.TrimSpace(sentence)
+	if !strings.HasPrefix(sentence, "$") {
+		return p, at, false, errors.New("not an NMEA sentence")
+	}
+	body := sentence[1:]
+	if i := strings.IndexByte(body, '*'); i >= 0 {
+		want, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return p, at, false, errors.New("invalid checksum")
+		}
+		body = body[:i]
+		var sum byte
+		for j := 0; j < len(body); j++ {
+			sum ^= body[j]
+		}
+		if sum != byte(want) {
+			return p, at, false, errors.New("checksum mismatch")
+		}
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return p, at, false, nil
+	}
+
+	var lat, latHemi, lng, lngHemi, date string
+	switch fields[0][2:] {
+	case "GGA":
+		if len(fields) < 7 {
+			return p, at, false, errors.New("short GGA sentence")
+		}
+		if fields[6] == "" || fields[6] == "0" {
+			return p, at, false, nil
+		}
+		lat, latHemi, lng, lngHemi = fields[2], fields[3], fields[4], fields[5]
+	case "RMC":
+		if len(fields) < 10 {
+			return p, at, false, errors.New("short RMC sentence")
+		}
+		if fields[2] != "A" {
+			return p, at, false, nil
+		}
+		lat, latHemi, lng, lngHemi, date = fields[3], fields[4], fields[5], fields[6], fields[9]
+	default:
+		return p, at, false, nil
+	}
+
+	if p.Lat, err = nmeaDegrees(lat, latHemi, "N", "S"); err != nil {
+		return p, at, false, err
+	}
+	if p.Lng, err = nmeaDegrees(lng, lngHemi, "E", "W"); err != nil {
+		return p, at, false, err
+	}
+	if !valid(p) {
+		return p, at, false, errors.New("coordinates out of range")
+	}
+	if at, err = nmeaTime(fields[1], date); err != nil {
+		return p, at, false, err
+	}
+	return p, at, true, nil
+}
+
+// nmeaDegrees converts NMEA's ddmm.mmmm, or dddmm.mmmm for longitudes, to
+// decimal degrees.
+func nmeaDegrees(v, hemisphere, positive, negative string) (float64, error) {
+	dot := strings.IndexByte(v, '.')
+	if dot < 0 {
+		dot = len(v)
+	}
+	if dot < 3 {
+		return 0, fmt.Errorf("invalid coordinate %q", v)
+	}
+	deg, err := strconv.Atoi(v[:dot-2])
+	if err != nil {
+		return 0, fmt.Errorf("invalid coordinate %q", v)
+	}
+	min, err := strconv.ParseFloat(v[dot-2:], 64)
+	if err != nil || min >= 60 {
+		return 0, fmt.Errorf("invalid coordinate %q", v)
+	}
+	d := float64(deg) + min/60
+	switch hemisphere {
+	case positive:
+		return d, nil
+	case negative:
+		return -d, nil
+	}
+	return 0, fmt.Errorf("invalid hemisphere %q", hemisphere)
+}
+
+// nmeaTime reads a UTC hhmmss.ss time and an optional ddmmyy date.
+func nmeaTime(clock, date string) (time.Time, error) {
+	if len(clock) < 6 {
+		return time.Time{}, fmt.Errorf("invalid time %q", clock)
+	}
+	dated := date != ""
+	if !dated {
+		date = time.Now().UTC().Format("020106")
+	}
+	at, err := time.Parse("020106150405", date+clock[:6])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", clock)
+	}
+	if len(clock) > 7 && clock[6] == '.' {
+		frac, err := strconv.ParseFloat("0"+clock[6:], 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", clock)
+		}
+		at = at.Add(time.Duration(frac * float64(time.Second)))
+	}
+	// A GGA time just before midnight UTC, read just after it, belongs to
+	// yesterday.
+	if !dated && at.After(time.Now().Add(time.Minute)) {
+		at = at.AddDate(0, 0, -1)
+	}
+	return at, nil
+}
+
+// nmeaDecoder reads NMEA sentences, one per line, after a line naming the
+// device, since NMEA itself has no notion of one. Units emitting both GGA
+// and RMC report each fix only once.
+type nmeaDecoder struct {
+	device string
+	// last is the time of day of the last fix, since GGA sentences carry
+	// no date.
+	last time.Duration
+}
+
+func (d *nmeaDecoder) Decode(r *bufio.Reader) ([]Fix, []byte, error) {
+	line, err := r.ReadString('\n')
+	if line == "" && err != nil {
+		return nil, nil, err
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "":
+		return nil, nil, nil
+	case !strings.HasPrefix(line, "$"):
+		d.device = line
+		return nil, nil, nil
+	case d.device == "":
+		return nil, nil, fmt.Errorf("%w: NMEA sentence before the device ID", ErrMalformed)
+	}
+	p, at, ok, err := ParseNMEA(line)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrMalformed, d.device, err)
+	}
+	clock := at.Sub(at.Truncate(24 * time.Hour))
+	if !ok || clock == d.last {
+		return nil, nil, nil
+	}
+	d.last = clock
+	return []Fix{{Device: d.device, Point: p, At: at}}, nil, nil
+}
@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Teltonika codec IDs.
+const (
+	codec8         = 0x08
+	codec8Extended = 0x8E
+)
+
+// maxTeltonikaPacket bounds the AVL data length read from the wire.
+const maxTeltonikaPacket = 64 * 1024
+
+// teltonika decodes Teltonika Codec 8 and 8 Extended over TCP. A
+// connection opens with the device's IMEI, acknowledged with 0x01, and then
+// carries AVL packets, each acknowledged with the number of records read.
+type teltonika struct {
+	imei string
+}
+
+func (t *teltonika) Decode(r *bufio.Reader) ([]Fix, []byte, error) {
+	if t.imei == "" {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, nil, err
+		}
+		imei := make([]byte, n)
+		if _, err := io.ReadFull(r, imei); err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			return nil, nil, fmt.Errorf("empty IMEI")
+		}
+		t.imei = string(imei)
+		return nil, []byte{0x01}, nil
+	}
+
+	var header struct {
+		Preamble uint32
+		Length   uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, nil, err
+	}
+	if header.Preamble != 0 || header.Length < 3 || header.Length > maxTeltonikaPacket {
+		// The stream is out of step; there is no resynchronizing it.
+		return nil, nil, fmt.Errorf("invalid AVL packet header from %s", t.imei)
+	}
+	data := make([]byte, header.Length+4)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, nil, err
+	}
+	data, crc := data[:header.Length], binary.BigEndian.Uint32(data[header.Length:])
+	if uint32(crc16(data)) != crc {
+		return nil, nil, fmt.Errorf("%w: CRC mismatch from %s", ErrMalformed, t.imei)
+	}
+	fixes, n, err := t.records(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrMalformed, t.imei, err)
+	}
+	reply := binary.BigEndian.AppendUint32(nil, uint32(n))
+	return fixes, reply, nil
+}
+
+// records reads the AVL records of a packet: codec ID, record count, the
+// records, and the count again. Records without a GPS fix are counted but
+// not returned.
+func (t *teltonika) records(data []byte) ([]Fix, int, error) {
+	b := avlReader{data: data}
+	codec := b.uint(1)
+	if codec != codec8 && codec != codec8Extended {
+		return nil, 0, fmt.Errorf("unsupported codec %#x", codec)
+	}
+	extended := codec == codec8Extended
+	// IO element IDs and counts are two bytes wide in Codec 8 Extended.
+	width := 1
+	if extended {
+		width = 2
+	}
+
+	n := int(b.uint(1))
+	fixes := make([]Fix, 0, n)
+	for i := 0; i < n; i++ {
+		at := time.UnixMilli(int64(b.uint(8)))
+		b.skip(1) // Priority
+		lng := float64(int32(b.uint(4))) / 1e7
+		lat := float64(int32(b.uint(4))) / 1e7
+		b.skip(2 + 2 + 1 + 2) // Altitude, angle, satellites, speed
+		b.skip(width)         // Event IO ID
+		b.skip(width)         // Total IO count
+		for _, size := range []int{1, 2, 4, 8} {
+			count := int(b.uint(width))
+			b.skip(count * (width + size))
+		}
+		if extended {
+			count := int(b.uint(2))
+			for j := 0; j < count; j++ {
+				b.skip(2)
+				b.skip(int(b.uint(2)))
+			}
+		}
+		if b.err != nil {
+			return nil, 0, b.err
+		}
+		p := geo.Point{Lat: lat, Lng: lng}
+		// Trackers without a GPS lock report 0,0.
+		if p == (geo.Point{}) || !valid(p) {
+			continue
+		}
+		fixes = append(fixes, Fix{Device: t.imei, Point: p, At: at})
+	}
+	if int(b.uint(1)) != n || b.err != nil {
+		return nil, 0, fmt.Errorf("record count mismatch")
+	}
+	return fixes, n, nil
+}
+
+// avlReader reads big-endian fields from an AVL packet, remembering the
+// first read past its end.
+type avlReader struct {
+	data []byte
+	err  error
+}
+
+func (b *avlReader) skip(n int) {
+	if b.err != nil {
+		return
+	}
+	if n > len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return
+	}
+	b.data = b.data[n:]
+}
+
+func (b *avlReader) uint(n int) uint64 {
+	if b.err != nil || n > len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	var v uint64
+	for _, c := range b.data[:n] {
+		v = v<<8 | uint64(c)
+	}
+	b.data = b.data[n:]
+	return v
+}
+
+// crc16 is CRC-16/IBM, which Teltonika devices use to check AVL data.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, c := range data {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,48 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// textDecoder reads one fix per line in the form
+//
+//	device,lat,lng[,time]
+//
+// where time is in Unix seconds, the time of receipt if left out. Many
+// trackers can be configured to send such lines.
+type textDecoder struct{}
+
+func (textDecoder) Decode(r *bufio.Reader) ([]Fix, []byte, error) {
+	line, err := r.ReadString('\n')
+	if line == "" && err != nil {
+		return nil, nil, err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil, nil
+	}
+	fields := strings.Split(line, ",")
+	if len(fields) < 3 || fields[0] == "" {
+		return nil, nil, fmt.Errorf("%w: want device,lat,lng[,time], got %q", ErrMalformed, line)
+	}
+	f := Fix{Device: fields[0], At: time.Now()}
+	f.Point.Lat, err = strconv.ParseFloat(fields[1], 64)
+	if err == nil {
+		f.Point.Lng, err = strconv.ParseFloat(fields[2], 64)
+	}
+	if err != nil || !valid(f.Point) {
+		return nil, nil, fmt.Errorf("%w: invalid coordinates in %q", ErrMalformed, line)
+	}
+	if len(fields) > 3 {
+		sec, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid time in %q", ErrMalformed, line)
+		}
+		f.At = time.Unix(sec, 0)
+	}
+	return []Fix{f}, nil, nil
+}
@@ -0,0 +1,88 @@
+// Package lru keeps values in process for a short time, evicting the least
+// recently used once full.
+package lru
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache holds up to a fixed number of entries, each for at most its TTL.
+// It is safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// New creates a cache of size entries kept for ttl.
+func New(size int, ttl time.Duration) *Cache {
+	return &Cache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get returns the value under key, if it has not expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Add keeps value under key for the cache's TTL, or ttl if that is shorter
+// and positive, evicting the least recently used entry if the cache is full.
+func (c *Cache) Add(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 || ttl > c.ttl {
+		ttl = c.ttl
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = &entry{key: key, value: value, expires: time.Now().Add(ttl)}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expires: time.Now().Add(ttl)})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Remove drops the entry under key, if there is one.
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Len returns the number of entries, expired ones included.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// remove drops el. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
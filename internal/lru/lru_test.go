@@ -0,0 +1,34 @@
+package lru
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := New(2, time.Minute)
+	c.Add("a", 1, 0)
+	c.Add("b", 2, 0)
+	// Reading a makes b the least recently used
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("a = %v, %v", v, ok)
+	}
+	c.Add("c", 3, 0)
+	if _, ok := c.Get("b"); ok {
+		t.Error("b not evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a evicted")
+	}
+
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Errorf("a not removed, %d entries", c.Len())
+	}
+
+	c.Add("short", 4, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Error("expired entry returned")
+	}
+}
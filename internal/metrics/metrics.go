@@ -0,0 +1,160 @@
+// Package metrics keeps the histograms the service exports and writes
+// metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Histogram counts observations into buckets with fixed upper bounds.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	// counts has one more bucket than bounds, for +Inf
+	counts []uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds.
+func NewHistogram(bounds ...float64) *Histogram {
+	bounds = append([]float64(nil), bounds...)
+	sort.Float64s(bounds)
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe adds v to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+// Snapshot returns the histogram as it is now.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{Bounds: h.bounds, Cumulative: make([]uint64, len(h.counts)), Sum: h.sum}
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		s.Cumulative[i] = n
+	}
+	return s
+}
+
+// HistogramSnapshot is a histogram at one moment. Cumulative counts the
+// observations at or below each bound, and has a last count for +Inf, which
+// is the number of observations.
+type HistogramSnapshot struct {
+	Bounds     []float64
+	Cumulative []uint64
+	Sum        float64
+}
+
+// Count returns the number of observations.
+func (s HistogramSnapshot) Count() uint64 {
+	if len(s.Cumulative) == 0 {
+		return 0
+	}
+	return s.Cumulative[len(s.Cumulative)-1]
+}
+
+// Writer writes metric families in the Prometheus text exposition format.
+// Samples of one family must be written one after the other; its help and
+// type are written before the first. The first write error is kept and
+// returned by Err.
+type Writer struct {
+	w    io.Writer
+	last string
+	err  error
+}
+
+// NewWriter writes metrics to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err returns the first error writing metrics.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+// Counter writes a sample of a counter. labels are name, value pairs.
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.family(name, help, "counter")
+	w.sample(name, value, labels)
+}
+
+// Gauge writes a sample of a gauge. labels are name, value pairs.
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.family(name, help, "gauge")
+	w.sample(name, value, labels)
+}
+
+// Histogram writes the buckets, sum and count of a histogram. labels are
+// name, value pairs.
+func (w *Writer) Histogram(name, help string, s HistogramSnapshot, labels ...string) {
+	w.family(name, help, "histogram")
+	for i, c := range s.Cumulative {
+		le := "+Inf"
+		if i < len(s.Bounds) {
+			le = formatValue(s.Bounds[i])
+		}
+		w.sample(name+"_bucket", float64(c), append(labels[:len(labels):len(labels)], "le", le))
+	}
+	w.sample(name+"_sum", s.Sum, labels)
+	w.sample(name+"_count", float64(s.Count()), labels)
+}
+
+func (w *Writer) family(name, help, kind string) {
+	if name == w.last {
+		return
+	}
+	w.last = name
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+func (w *Writer) sample(name string, value float64, labels []string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	w.printf("%s %s\n", b.String(), formatValue(value))
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/publish"
+	"location/internal/store"
+)
+
+// Sender delivers messages to customers over one channel, such as email or
+// SMS.
+type Sender interface {
+	// Address returns where the preferences say to reach the customer on
+	// this channel, or "" if they give nowhere.
+	Address(p store.Preferences) string
+	Send(ctx context.Context, to string, m Message) error
+}
+
+// Rule sends the customer a message rendered from Template on the events
+// listed, over one of Channels, or any channel if none are given.
+type Rule struct {
+	Events   []string
+	Template string
+	Channels []string
+}
+
+// DefaultRules confirm arrivals and warn of delays.
+var DefaultRules = []Rule{
+	{Events: []string{publish.EventDelivered}, Template: "delivered"},
+	{Events: []string{publish.EventSLAAtRisk, publish.EventSLABreached}, Template: "delay"},
+}
+
+// DefaultTemplates are the messages sent unless overridden: "delivered"
+// confirms an arrival, and "delay" warns that an order will be late. The
+// first line of each is the title, the subject of emails.
+const DefaultTemplates = `{{define "delivered"}}Your order {{.OrderID}} has arrived
+Your order {{.OrderID}} was delivered at {{.At.Format "15:04"}} UTC.
+{{if .TrackingURL}}
+Details: {{.TrackingURL}}
+{{end}}{{end}}
+{{- define "delay"}}Your order {{.OrderID}} is running late
+We're sorry, your order {{.OrderID}} is now expected in about {{.Minutes}} minutes, around {{.Arrival.Format "15:04"}} UTC.
+{{if .MapURL}}
+Where it is now: {{.MapURL}}
+{{end}}{{if .TrackingURL}}
+Follow it live: {{.TrackingURL}}
+{{end}}{{end}}`
+
+// TemplateData is what templates are rendered with.
+type TemplateData struct {
+	OrderID string
+	// ETA is the travel time of the event, and Minutes the same rounded
+	// to the minute.
+	ETA     time.Duration
+	Minutes int
+	Arrival time.Time
+	// At is when the event happened, such as the delivery.
+	At          time.Time
+	TrackingURL string
+	// MapURL shows the courier's last location on OpenStreetMap.
+	MapURL string
+	// Locale is the customer's, such as "en-GB", if they gave one.
+	Locale  string
+	Channel string
+	Event   publish.Event
+	Order   store.Order
+}
+
+// Customers passes events on to Next, and messages the customer of the
+// order for events a rule matches, on the first channel of their
+// preference that the rule allows and they gave an address for. Nothing
+// is sent during the customer's quiet hours.
+//
+// Templates are looked up by the rule's template name, preferring
+// channel and locale specific ones: "sms:delay.de-AT", then "sms:delay.de",
+// "sms:delay", "delay.de-AT", "delay.de" and "delay".
+type Customers struct {
+	Next   publish.Publisher
+	Orders interface {
+		GetOrder(ctx context.Context, orderID string) (store.Order, error)
+	}
+	// Senders are keyed by channel, such as store.ChannelEmail.
+	Senders   map[string]Sender
+	Rules     []Rule
+	Templates *template.Template
+	// TrackingLink returns the URL customers follow an order at, or "".
+	TrackingLink func(orderID string) string
+	// Obscure returns where customers may be shown a courier of the order
+	// that is at p; nil shows it exactly.
+	Obscure func(orderID string, p geo.Point) geo.Point
+}
+
+func (c Customers) Publish(ctx context.Context, ev publish.Event) error {
+	for _, r := range c.Rules {
+		if slices.Contains(r.Events, ev.Type) {
+			// Reading the order and sending are left to the background,
+			// after the event itself is on its way
+			go c.send(ev, r)
+			break
+		}
+	}
+	return c.Next.Publish(ctx, ev)
+}
+
+func (c Customers) send(ev publish.Event, r Rule) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	order, err := c.Orders.GetOrder(ctx, ev.OrderID)
+	if err != nil {
+		log.Printf("failed to get order %s to notify: %v", ev.OrderID, err)
+		return
+	}
+	prefs := order.Preferences
+	if prefs == nil {
+		return
+	}
+	if prefs.QuietHours != nil {
+		if quiet, err := prefs.QuietHours.Quiet(time.Now()); err == nil && quiet {
+			return
+		}
+	}
+	channel, sender, to := c.pick(*prefs, r)
+	if sender == nil {
+		return
+	}
+
+	now := time.Now()
+	data := TemplateData{
+		OrderID: ev.OrderID,
+		ETA:     ev.ETA,
+		Minutes: int(ev.ETA.Round(time.Minute).Minutes()),
+		Arrival: now.Add(ev.ETA).UTC(),
+		At:      now.UTC(),
+		Locale:  prefs.Locale,
+		Channel: channel,
+		Event:   c.obscureEvent(ev),
+		Order:   c.obscureOrder(order),
+	}
+	if ev.DeliveredAt != nil {
+		data.At = ev.DeliveredAt.UTC()
+	}
+	if c.TrackingLink != nil {
+		data.TrackingURL = c.TrackingLink(ev.OrderID)
+	}
+	if data.Order.Current != nil {
+		data.MapURL = mapURL(*data.Order.Current)
+	}
+	tmpl := c.template(channel, r.Template, prefs.Locale)
+	if tmpl == nil {
+		log.Printf("no %s template for %s", r.Template, channel)
+		return
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		log.Printf("failed to render %s message for order %s: %v", r.Template, ev.OrderID, err)
+		return
+	}
+	title, text, _ := strings.Cut(out.String(), "\n")
+	m := Message{Event: ev.Type, Title: strings.TrimSpace(title), Text: strings.TrimLeft(text, "\n")}
+	if err := sender.Send(ctx, to, m); err != nil {
+		log.Printf("failed to notify order %s by %s: %v", ev.OrderID, channel, err)
+	}
+}
+
+// pick returns the first channel of the customer's that r allows, that is
+// configured and that they gave an address for.
+func (c Customers) pick(prefs store.Preferences, r Rule) (string, Sender, string) {
+	for _, channel := range prefs.Channels {
+		if len(r.Channels) > 0 && !slices.Contains(r.Channels, channel) {
+			continue
+		}
+		sender, ok := c.Senders[channel]
+		if !ok {
+			continue
+		}
+		if to := sender.Address(prefs); to != "" {
+			return channel, sender, to
+		}
+	}
+	return "", nil, ""
+}
+
+// template finds the most specific template for channel and locale.
+func (c Customers) template(channel, name, locale string) *template.Template {
+	locales := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		locales = append(locales, lang)
+	}
+	for _, prefix := range []string{channel + ":", ""} {
+		for _, l := range locales {
+			if l == "" {
+				continue
+			}
+			if t := c.Templates.Lookup(prefix + name + "." + l); t != nil {
+				return t
+			}
+		}
+		if t := c.Templates.Lookup(prefix + name); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// obscureOrder returns order with the courier positions in it obscured for
+// customers.
+func (c Customers) obscureOrder(order store.Order) store.Order {
+	if c.Obscure == nil {
+		return order
+	}
+	if order.Current != nil {
+		p := c.Obscure(order.ID, *order.Current)
+		order.Current = &p
+	}
+	if order.RoutedFrom != nil {
+		p := c.Obscure(order.ID, *order.RoutedFrom)
+		order.RoutedFrom = &p
+	}
+	if order.Stop != nil {
+		stop := *order.Stop
+		stop.Point = c.Obscure(order.ID, stop.Point)
+		order.Stop = &stop
+	}
+	return order
+}
+
+// obscureEvent returns ev with the courier position in it obscured for
+// customers.
+func (c Customers) obscureEvent(ev publish.Event) publish.Event {
+	if c.Obscure != nil && ev.Location != nil {
+		p := c.Obscure(ev.OrderID, *ev.Location)
+		ev.Location = &p
+	}
+	return ev
+}
+
+func mapURL(p geo.Point) string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.5f&mlon=%.5f#map=16/%.5f/%.5f", p.Lat, p.Lng, p.Lat, p.Lng)
+}
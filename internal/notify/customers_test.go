@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/publish"
+	"location/internal/store"
+)
+
+type sentMail struct{ to, subject, body string }
+
+type outbox chan sentMail
+
+func (o outbox) SendMail(ctx context.Context, to, subject, body string) error {
+	o <- sentMail{to, subject, body}
+	return nil
+}
+
+func TestCustomers(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	st.SetLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.35, Lng: 103.85})
+	st.SetPreferences(ctx, "o1", store.Preferences{Channels: []string{store.ChannelEmail}, Email: "ana@example.com"})
+	st.SetLocation(ctx, "o2", store.Current, geo.Point{Lat: 1.35, Lng: 103.85})
+	st.SetPreferences(ctx, "o2", store.Preferences{Channels: []string{store.ChannelSMS}, Email: "bo@example.com"})
+	// Quiet all day, bar the minute before midnight
+	st.SetLocation(ctx, "o3", store.Current, geo.Point{Lat: 1.35, Lng: 103.85})
+	st.SetPreferences(ctx, "o3", store.Preferences{Channels: []string{store.ChannelEmail}, Email: "cy@example.com",
+		QuietHours: &store.QuietHours{Start: "00:00", End: "23:59", TimeZone: "UTC"}})
+
+	sent := make(outbox, 4)
+	e := Customers{
+		Next:         &publish.Capture{},
+		Orders:       st,
+		Senders:      map[string]Sender{store.ChannelEmail: Email{Mailer: sent}},
+		Rules:        DefaultRules,
+		Templates:    template.Must(template.New("email").Parse(DefaultTemplates)),
+		TrackingLink: func(orderID string) string { return "https://track.example.com/" + orderID },
+	}
+
+	e.Publish(ctx, publish.Event{Type: publish.EventSLAAtRisk, OrderID: "o1", ETA: 25*time.Minute + 10*time.Second})
+	m := <-sent
+	if m.to != "ana@example.com" || m.subject != "Your order o1 is running late" {
+		t.Errorf("got %+v", m)
+	}
+	for _, want := range []string{"about 25 minutes", "https://www.openstreetmap.org/?mlat=1.35000&mlon=103.85000", "https://track.example.com/o1"} {
+		if !strings.Contains(m.body, want) {
+			t.Errorf("body %q lacks %q", m.body, want)
+		}
+	}
+
+	deliveredAt := time.Date(2026, 1, 1, 9, 41, 0, 0, time.UTC)
+	e.Publish(ctx, publish.Event{Type: publish.EventDelivered, OrderID: "o1", DeliveredAt: &deliveredAt})
+	if m := <-sent; m.subject != "Your order o1 has arrived" || !strings.Contains(m.body, "delivered at 09:41 UTC") {
+		t.Errorf("got %+v", m)
+	}
+
+	// Neither the ETA, nor customers who did not choose email or are in
+	// their quiet hours, get any
+	e.Publish(ctx, publish.Event{Type: publish.EventETA, OrderID: "o1"})
+	e.Publish(ctx, publish.Event{Type: publish.EventSLABreached, OrderID: "o2"})
+	if time.Now().UTC().Format("15:04") != "23:59" {
+		e.Publish(ctx, publish.Event{Type: publish.EventSLABreached, OrderID: "o3"})
+	}
+	select {
+	case m := <-sent:
+		t.Errorf("unexpected email %+v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Map links show couriers only as exactly as the privacy settings allow
+	st.SetLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.35123, Lng: 103.85456})
+	e.Obscure = func(orderID string, p geo.Point) geo.Point { return geo.Round(p, 2) }
+	e.Publish(ctx, publish.Event{Type: publish.EventSLAAtRisk, OrderID: "o1", ETA: 25 * time.Minute})
+	if m := <-sent; !strings.Contains(m.body, "mlat=1.35000&mlon=103.85000") || strings.Contains(m.body, "1.35123") {
+		t.Errorf("body %q shows the courier exactly", m.body)
+	}
+}
+
+type sent struct {
+	channel, to string
+	m           Message
+}
+
+type fakeSender struct {
+	channel string
+	address func(store.Preferences) string
+	out     chan sent
+}
+
+func (f fakeSender) Address(p store.Preferences) string { return f.address(p) }
+
+func (f fakeSender) Send(ctx context.Context, to string, m Message) error {
+	f.out <- sent{f.channel, to, m}
+	return nil
+}
+
+func TestCustomerRulesAndLocales(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemory()
+	// Prefers SMS but gave no number, so falls back to email
+	st.SetPreferences(ctx, "o1", store.Preferences{Channels: []string{store.ChannelSMS, store.ChannelEmail}, Email: "ana@example.com", Locale: "de-AT"})
+	st.SetPreferences(ctx, "o2", store.Preferences{Channels: []string{store.ChannelSMS, store.ChannelEmail}, Email: "bo@example.com", Phone: "+6591234567", Locale: "fr"})
+
+	out := make(chan sent, 4)
+	senders := map[string]Sender{
+		store.ChannelEmail: fakeSender{store.ChannelEmail, func(p store.Preferences) string { return p.Email }, out},
+		store.ChannelSMS:   fakeSender{store.ChannelSMS, func(p store.Preferences) string { return p.Phone }, out},
+	}
+	templates := template.Must(template.New("").Parse(`
+{{- define "arrived"}}Arrived {{.OrderID}}{{end}}
+{{- define "arrived.de"}}Angekommen {{.OrderID}} {{.Locale}}{{end}}
+{{- define "sms:arrived"}}SMS {{.OrderID}} via {{.Channel}}{{end}}
+{{- define "nearby"}}Nearby {{.OrderID}}{{end}}`))
+	c := Customers{
+		Next:   &publish.Capture{},
+		Orders: st,
+		Rules: []Rule{
+			{Events: []string{publish.EventDelivered}, Template: "arrived"},
+			{Events: []string{publish.EventSLAAtRisk}, Template: "nearby", Channels: []string{store.ChannelEmail}},
+		},
+		Senders:   senders,
+		Templates: templates,
+	}
+
+	for _, tc := range []struct {
+		event, order string
+		want         sent
+	}{
+		{publish.EventDelivered, "o1", sent{store.ChannelEmail, "ana@example.com", Message{Event: publish.EventDelivered, Title: "Angekommen o1 de-AT"}}},
+		{publish.EventDelivered, "o2", sent{store.ChannelSMS, "+6591234567", Message{Event: publish.EventDelivered, Title: "SMS o2 via sms"}}},
+		// The rule only allows email, so o2 gets one despite preferring SMS
+		{publish.EventSLAAtRisk, "o2", sent{store.ChannelEmail, "bo@example.com", Message{Event: publish.EventSLAAtRisk, Title: "Nearby o2"}}},
+	} {
+		c.Publish(ctx, publish.Event{Type: tc.event, OrderID: tc.order})
+		if got := <-out; got != tc.want {
+			t.Errorf("%s %s: got %+v, want %+v", tc.event, tc.order, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"location/internal/store"
+)
+
+// Mailer sends one email.
+type Mailer interface {
+	SendMail(ctx context.Context, to, subject, body string) error
+}
+
+// SMTP sends plain text email through a submission server, with PLAIN
+// authentication when a username is set. The password is looked up on
+// every send so it can be rotated without a restart.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password func() string
+}
+
+func (s SMTP) SendMail(ctx context.Context, to, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", s.From, to, mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password(), host)
+	}
+	// net/smtp has no context support, so ctx only bounds the wait
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, s.From, []string{to}, msg.Bytes()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to send email: %v", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Email sends customer messages through m, with the title as subject.
+type Email struct {
+	Mailer Mailer
+}
+
+func (e Email) Address(p store.Preferences) string {
+	return p.Email
+}
+
+func (e Email) Send(ctx context.Context, to string, m Message) error {
+	return e.Mailer.SendMail(ctx, to, m.Title, m.Text)
+}
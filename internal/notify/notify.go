@@ -0,0 +1,189 @@
+// Package notify tells operators about events that need their attention,
+// such as lost couriers and failing route providers, in Slack or Microsoft
+// Teams channels, and customers about their orders by email, SMS or push
+// notification.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/publish"
+)
+
+// Operational events.
+const (
+	TrackingLost = publish.EventTrackingLost
+	// SLAAtRisk and SLABreached are sent when an order might miss, or will
+	// miss, its deadline.
+	SLAAtRisk   = publish.EventSLAAtRisk
+	SLABreached = publish.EventSLABreached
+	// Dwell is sent when a courier stays put mid-route, as when broken
+	// down.
+	Dwell = publish.EventDwell
+	// ProviderFailing is sent when most calls to a route provider fail.
+	ProviderFailing = "provider_failing"
+	// QuotaLow is sent once a day when most of the Google quota is used.
+	QuotaLow = "quota_low"
+)
+
+// Events are the events channels may subscribe to.
+var Events = []string{TrackingLost, SLAAtRisk, SLABreached, Dwell, ProviderFailing, QuotaLow}
+
+// Channel kinds.
+const (
+	Slack = "slack"
+	Teams = "teams"
+)
+
+// KnownKind reports whether kind is a supported channel kind.
+func KnownKind(kind string) bool {
+	return kind == Slack || kind == Teams
+}
+
+// Message is a notification about one event.
+type Message struct {
+	Event string
+	Title string
+	Text  string
+}
+
+// Channel delivers messages to people.
+type Channel interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Webhook posts messages to a Slack or Teams incoming webhook.
+type Webhook struct {
+	kind   string
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(kind, url string) *Webhook {
+	return &Webhook{kind: kind, url: url, client: egress.Client(egress.Notify, 10*time.Second)}
+}
+
+func (w *Webhook) Send(ctx context.Context, m Message) error {
+	var payload interface{}
+	switch w.kind {
+	case Teams:
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  m.Title,
+			"title":    m.Title,
+			"text":     m.Text,
+		}
+	default:
+		payload = map[string]string{"text": "*" + m.Title + "*\n" + m.Text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %v", w.kind, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post to %s: %s", w.kind, resp.Status)
+	}
+	return nil
+}
+
+// Notifier sends each message to the channels subscribed to its event.
+type Notifier struct {
+	routes []route
+}
+
+type route struct {
+	name    string
+	channel Channel
+	events  []string
+}
+
+// Route subscribes a channel to events, or to all of them if none are
+// given.
+func (n *Notifier) Route(name string, c Channel, events []string) {
+	n.routes = append(n.routes, route{name: name, channel: c, events: events})
+}
+
+// Notify sends m in the background so that slow webhooks never hold up
+// tracking. Failures are logged.
+func (n *Notifier) Notify(m Message) {
+	for _, r := range n.routes {
+		if len(r.events) > 0 && !slices.Contains(r.events, m.Event) {
+			continue
+		}
+		go func(r route) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := r.channel.Send(ctx, m); err != nil {
+				log.Printf("failed to notify %s of %s: %v", r.name, m.Event, err)
+			}
+		}(r)
+	}
+}
+
+// Publisher passes events on to Next, notifying operators of the ones
+// about orders in trouble.
+type Publisher struct {
+	Next     publish.Publisher
+	Notifier *Notifier
+}
+
+func (p Publisher) Publish(ctx context.Context, e publish.Event) error {
+	if m, ok := orderMessage(e); ok {
+		p.Notifier.Notify(m)
+	}
+	return p.Next.Publish(ctx, e)
+}
+
+func orderMessage(e publish.Event) (Message, bool) {
+	m := Message{Event: e.Type}
+	switch e.Type {
+	case TrackingLost:
+		m.Title = "Lost tracking of order " + e.OrderID
+		m.Text = "The courier has stopped reporting their location."
+		if e.SeenAt != nil {
+			m.Text = "The courier last reported at " + e.SeenAt.UTC().Format(time.TimeOnly) + " UTC."
+		}
+	case SLAAtRisk, SLABreached:
+		m.Title = "Order " + e.OrderID + " might miss its deadline"
+		if e.Type == SLABreached {
+			m.Title = "Order " + e.OrderID + " is late"
+		}
+		m.Text = fmt.Sprintf("Arriving in %v", e.ETA.Round(time.Minute))
+		if e.Deadline != nil {
+			m.Text += ", due at " + e.Deadline.UTC().Format(time.TimeOnly) + " UTC"
+		}
+		if e.Lateness > 0 {
+			m.Text += fmt.Sprintf(", %v late", e.Lateness.Round(time.Minute))
+		}
+		m.Text += "."
+	case Dwell:
+		m.Title = "Courier of order " + e.OrderID + " has stopped"
+		m.Text = fmt.Sprintf("Stopped for %v away from the pickup and target", e.Dwell.Round(time.Minute))
+		if e.Location != nil {
+			m.Text += ", at " + e.Location.String()
+		}
+		m.Text += "."
+	default:
+		return Message{}, false
+	}
+	return m, true
+}
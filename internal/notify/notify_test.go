@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"location/internal/publish"
+	"location/internal/routing"
+)
+
+// inbox collects the messages sent to a channel.
+type inbox chan Message
+
+func (in inbox) Send(ctx context.Context, m Message) error {
+	in <- m
+	return nil
+}
+
+func (in inbox) next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case m := <-in:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no message sent")
+		return Message{}
+	}
+}
+
+func (in inbox) empty(t *testing.T) {
+	t.Helper()
+	select {
+	case m := <-in:
+		t.Fatalf("unexpected message %+v", m)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	bodies := make(chan map[string]string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	m := Message{Event: TrackingLost, Title: "Lost tracking of order o1", Text: "Silent."}
+	if err := NewWebhook(Slack, srv.URL).Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-bodies; body["text"] != "*Lost tracking of order o1*\nSilent." {
+		t.Errorf("slack body = %v", body)
+	}
+	if err := NewWebhook(Teams, srv.URL).Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-bodies; body["@type"] != "MessageCard" || body["title"] != m.Title || body["text"] != m.Text {
+		t.Errorf("teams body = %v", body)
+	}
+}
+
+func TestPublisherRoutesByEvent(t *testing.T) {
+	ops, all := make(inbox, 4), make(inbox, 4)
+	n := &Notifier{}
+	n.Route("ops", ops, []string{SLABreached})
+	n.Route("all", all, nil)
+	next := &publish.Capture{}
+	p := Publisher{Next: next, Notifier: n}
+	ctx := context.Background()
+
+	p.Publish(ctx, publish.Event{Type: publish.EventETA, OrderID: "o1"})
+	deadline := time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC)
+	p.Publish(ctx, publish.Event{Type: publish.EventSLABreached, OrderID: "o1", ETA: 20 * time.Minute, Deadline: &deadline, Lateness: 5 * time.Minute})
+	p.Publish(ctx, publish.Event{Type: publish.EventTrackingLost, OrderID: "o2"})
+
+	if len(next.Events()) != 3 {
+		t.Errorf("passed on %d events, want 3", len(next.Events()))
+	}
+	m := ops.next(t)
+	if m.Title != "Order o1 is late" || m.Text != "Arriving in 20m0s, due at 12:30:00 UTC, 5m0s late." {
+		t.Errorf("got %+v", m)
+	}
+	ops.empty(t)
+	seen := map[string]bool{all.next(t).Event: true, all.next(t).Event: true}
+	if !seen[SLABreached] || !seen[TrackingLost] {
+		t.Errorf("all got %v", seen)
+	}
+	all.empty(t)
+}
+
+func TestProviders(t *testing.T) {
+	in := make(inbox, 4)
+	n := &Notifier{}
+	n.Route("platform", in, nil)
+	usage := routing.Usage{Day: "2026-01-01"}
+	p := &Providers{
+		Notifier: n,
+		Usage:    func() map[string]routing.Usage { return map[string]routing.Usage{routing.Google: usage} },
+		Quota:    func() int { return 100 },
+	}
+
+	usage.Calls, usage.Errors = 10, 1
+	p.check()
+	in.empty(t)
+
+	usage.Calls, usage.Errors = 20, 8
+	p.check()
+	if m := in.next(t); m.Event != ProviderFailing || m.Text != "7 of its last 10 calls failed." {
+		t.Errorf("got %+v", m)
+	}
+	// Still failing, already reported
+	usage.Calls, usage.Errors = 30, 18
+	p.check()
+	in.empty(t)
+
+	usage.Calls, usage.Errors = 90, 18
+	p.check()
+	if m := in.next(t); m.Event != QuotaLow {
+		t.Errorf("got %+v, want quota_low", m)
+	}
+	usage.Calls = 95
+	p.check()
+	in.empty(t)
+
+	// A new day brings a new quota, and a new warning when it runs low
+	usage = routing.Usage{Day: "2026-01-02", Calls: 95, Errors: 60}
+	p.check()
+	seen := map[string]bool{in.next(t).Event: true, in.next(t).Event: true}
+	if !seen[ProviderFailing] || !seen[QuotaLow] {
+		t.Errorf("got %v", seen)
+	}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"location/internal/routing"
+)
+
+// Thresholds for provider notifications.
+const (
+	// failingShare of the calls made within one check failing, out of at
+	// least failingCalls, counts as the provider failing.
+	failingShare = 0.5
+	failingCalls = 5
+	// quotaShare of the daily quota used counts as running low.
+	quotaShare = 0.9
+)
+
+// Providers watches route provider usage and notifies when a provider
+// starts failing or the Google quota runs low.
+type Providers struct {
+	Notifier *Notifier
+	// Tenant names the tenant whose providers are watched in the
+	// notifications, if any.
+	Tenant string
+	// Usage returns today's calls per provider, and Quota the Google
+	// daily quota, if any.
+	Usage    func() map[string]routing.Usage
+	Quota    func() int
+	Interval time.Duration
+
+	last      map[string]routing.Usage
+	failing   map[string]bool
+	warnedDay string
+}
+
+// Run checks usage every Interval until ctx is done.
+func (p *Providers) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.check()
+		}
+	}
+}
+
+func (p *Providers) check() {
+	if p.last == nil {
+		p.last, p.failing = map[string]routing.Usage{}, map[string]bool{}
+	}
+	for name, u := range p.Usage() {
+		prev := p.last[name]
+		p.last[name] = u
+		if prev.Day != u.Day {
+			// Counts start over at midnight UTC
+			prev = routing.Usage{}
+		}
+		calls, errors := u.Calls-prev.Calls, u.Errors-prev.Errors
+		failing := calls >= failingCalls && float64(errors) >= failingShare*float64(calls)
+		if failing && !p.failing[name] {
+			p.Notifier.Notify(Message{
+				Event: ProviderFailing,
+				Title: "Route provider " + name + " is failing" + p.of(),
+				Text:  fmt.Sprintf("%d of its last %d calls failed.", errors, calls),
+			})
+		}
+		// Recovering clears the way for the next failure to be reported
+		if calls > 0 {
+			p.failing[name] = failing
+		}
+
+		quota := p.Quota()
+		if name == routing.Google && quota > 0 && float64(u.Calls) >= quotaShare*float64(quota) && p.warnedDay != u.Day {
+			p.warnedDay = u.Day
+			p.Notifier.Notify(Message{
+				Event: QuotaLow,
+				Title: "Google quota running low" + p.of(),
+				Text:  fmt.Sprintf("%d of %d calls used today on this instance.", u.Calls, quota),
+			})
+		}
+	}
+}
+
+// of tells whose providers a notification is about.
+func (p *Providers) of() string {
+	if p.Tenant == "" {
+		return ""
+	}
+	return " for tenant " + p.Tenant
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"location/internal/egress"
+	"location/internal/store"
+)
+
+const (
+	fcmAPI   = "https://fcm.googleapis.com"
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// FCM sends push notifications to apps through Firebase Cloud Messaging.
+type FCM struct {
+	project string
+	api     string
+	client  *http.Client
+}
+
+// NewFCM authenticates with the service account key in credentialsFile,
+// or with Application Default Credentials if it is "". The project
+// defaults to the one the credentials belong to.
+func NewFCM(ctx context.Context, project, credentialsFile string) (*FCM, error) {
+	client := egress.Client(egress.Notify, 10*time.Second)
+	// Tokens are fetched through the egress transport too
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	var creds *google.Credentials
+	var err error
+	if credentialsFile != "" {
+		key, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FCM credentials: %v", err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, key, fcmScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FCM credentials: %v", err)
+		}
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, fcmScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google credentials: %v", err)
+		}
+	}
+	if project == "" {
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return nil, fmt.Errorf("no FCM project given, and none in the credentials")
+	}
+	client = &http.Client{Transport: &oauth2.Transport{Source: creds.TokenSource, Base: client.Transport}, Timeout: client.Timeout}
+	return &FCM{project: project, api: fcmAPI, client: client}, nil
+}
+
+func (f *FCM) Address(p store.Preferences) string {
+	return p.PushToken
+}
+
+// Send pushes m to the app registered as token, with the event as data
+// so the app can tell notifications apart.
+func (f *FCM) Send(ctx context.Context, token string, m Message) error {
+	payload := map[string]interface{}{
+		"message": map[string]interface{}{
+			"token":        token,
+			"notification": map[string]string{"title": m.Title, "body": m.Text},
+			"data":         map[string]string{"event": m.Event},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	u := f.api + "/v1/projects/" + url.PathEscape(f.project) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push notification: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send push notification: %s", resp.Status)
+	}
+	return nil
+}
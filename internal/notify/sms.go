@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/store"
+)
+
+const twilioAPI = "https://api.twilio.com"
+
+// Twilio sends text messages through the Twilio Messaging API. The auth
+// token is looked up on every send so it can be rotated without a
+// restart.
+type Twilio struct {
+	AccountSID string
+	AuthToken  func() string
+	// From is the sending number, or a messaging service SID.
+	From string
+
+	api    string
+	client *http.Client
+}
+
+func NewTwilio(accountSID string, authToken func() string, from string) *Twilio {
+	return &Twilio{AccountSID: accountSID, AuthToken: authToken, From: from,
+		api: twilioAPI, client: egress.Client(egress.Notify, 10*time.Second)}
+}
+
+func (t *Twilio) Address(p store.Preferences) string {
+	return p.Phone
+}
+
+// Send texts the title and text of m to the number to, as one message.
+func (t *Twilio) Send(ctx context.Context, to string, m Message) error {
+	form := url.Values{"To": {to}, "Body": {strings.TrimSpace(m.Title + "\n" + m.Text)}}
+	if strings.HasPrefix(t.From, "MG") {
+		form.Set("MessagingServiceSid", t.From)
+	} else {
+		form.Set("From", t.From)
+	}
+	u := t.api + "/2010-04-01/Accounts/" + url.PathEscape(t.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.AccountSID, t.AuthToken())
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send SMS: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTwilio(t *testing.T) {
+	var got *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tw := NewTwilio("AC123", func() string { return "secret" }, "+15005550006")
+	tw.api = srv.URL
+	if err := tw.Send(context.Background(), "+6591234567", Message{Title: "Your order o1 has arrived", Text: "At 09:41 UTC.\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/2010-04-01/Accounts/AC123/Messages.json" {
+		t.Errorf("posted to %s", got.URL.Path)
+	}
+	if user, pass, _ := got.BasicAuth(); user != "AC123" || pass != "secret" {
+		t.Errorf("authenticated as %s:%s", user, pass)
+	}
+	if f := got.PostForm; f.Get("To") != "+6591234567" || f.Get("From") != "+15005550006" || f.Get("Body") != "Your order o1 has arrived\nAt 09:41 UTC." {
+		t.Errorf("got form %v", f)
+	}
+}
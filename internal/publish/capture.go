@@ -0,0 +1,31 @@
+package publish
+
+import (
+	"context"
+	"sync"
+)
+
+// Capture is a fake publisher that keeps every event in memory. Setting Err
+// makes Publish fail.
+type Capture struct {
+	mu     sync.Mutex
+	events []Event
+	Err    error
+}
+
+func (c *Capture) Publish(ctx context.Context, e Event) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return c.Err
+	}
+	c.events = append(c.events, e)
+	return nil
+}
+
+// Events returns the events published so far.
+func (c *Capture) Events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
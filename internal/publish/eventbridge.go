@@ -0,0 +1,142 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+
+	"location/internal/egress"
+)
+
+// EventBridge puts each event on an Amazon EventBridge bus, once in every
+// schema version, with the event as the detail. The detail type is rendered
+// from a text/template of the Event, such as "Order {{.Type}}".
+type EventBridge struct {
+	bus, source string
+	detailType  *template.Template
+	versions    func() []int
+
+	region   string
+	endpoint string
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// NewEventBridge signs requests with the default AWS credential chain, so
+// an IAM role of the instance or pod is used when there is one. An empty
+// region is taken from the environment as well.
+func NewEventBridge(ctx context.Context, bus, source, detailType, region string, versions func() []int) (*EventBridge, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured for EventBridge")
+	}
+	return newEventBridge(bus, source, detailType, cfg.Region, "https://events."+cfg.Region+".amazonaws.com/", cfg.Credentials, versions)
+}
+
+func newEventBridge(bus, source, detailType, region, endpoint string, creds aws.CredentialsProvider, versions func() []int) (*EventBridge, error) {
+	tmpl, err := template.New("detail_type").Parse(detailType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EventBridge detail type: %v", err)
+	}
+	return &EventBridge{
+		bus:        bus,
+		source:     source,
+		detailType: tmpl,
+		versions:   versions,
+		region:     region,
+		endpoint:   endpoint,
+		creds:      creds,
+		signer:     v4.NewSigner(),
+		client:     egress.Client(egress.Publish, 10*time.Second),
+	}, nil
+}
+
+type eventBridgeEntry struct {
+	EventBusName string `json:"EventBusName"`
+	Source       string `json:"Source"`
+	DetailType   string `json:"DetailType"`
+	Detail       string `json:"Detail"`
+}
+
+func (p *EventBridge) Publish(ctx context.Context, e Event) error {
+	var detailType strings.Builder
+	if err := p.detailType.Execute(&detailType, e); err != nil {
+		return fmt.Errorf("failed to render EventBridge detail type: %v", err)
+	}
+	var entries []eventBridgeEntry
+	for _, version := range p.versions() {
+		detail, err := Encode(e, version)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, eventBridgeEntry{EventBusName: p.bus, Source: p.source, DetailType: detailType.String(), Detail: string(detail)})
+	}
+	body, err := json.Marshal(map[string]interface{}{"Entries": entries})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	creds, err := p.creds.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := p.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "events", p.region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign EventBridge request: %v", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to put event on EventBridge: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("EventBridge returned %s: %s", resp.Status, msg)
+	}
+	var result struct {
+		FailedEntryCount int
+		Entries          []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read EventBridge response: %v", err)
+	}
+	if result.FailedEntryCount > 0 {
+		for _, entry := range result.Entries {
+			if entry.ErrorCode != "" {
+				return fmt.Errorf("EventBridge rejected event of order %s: %s: %s", e.OrderID, entry.ErrorCode, entry.ErrorMessage)
+			}
+		}
+		return fmt.Errorf("EventBridge rejected %d entries of order %s", result.FailedEntryCount, e.OrderID)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestEventBridge(t *testing.T) {
+	var entries []eventBridgeEntry
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "AWSEvents.PutEvents" || !strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/events/aws4_request") {
+			t.Errorf("headers = %v", r.Header)
+		}
+		var body struct{ Entries []eventBridgeEntry }
+		json.NewDecoder(r.Body).Decode(&body)
+		entries = body.Entries
+		if len(entries) > 1 {
+			w.Write([]byte(`{"FailedEntryCount":1,"Entries":[{},{"ErrorCode":"InternalFailure","ErrorMessage":"try again"}]}`))
+			return
+		}
+		w.Write([]byte(`{"FailedEntryCount":0,"Entries":[{"EventId":"1"}]}`))
+	}))
+	defer srv.Close()
+	creds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	})
+	versions := []int{SchemaV1}
+	p, err := newEventBridge("orders", "location", "Order {{.Type}}", "eu-west-1", srv.URL, creds, func() []int { return versions })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Publish(context.Background(), Event{Type: EventETA, OrderID: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].EventBusName != "orders" || entries[0].DetailType != "Order eta" || !strings.Contains(entries[0].Detail, `"schema_version":1`) {
+		t.Errorf("entries = %+v", entries)
+	}
+
+	versions = []int{SchemaV1, SchemaV2}
+	err = p.Publish(context.Background(), Event{Type: EventETA, OrderID: "o1"})
+	if len(entries) != 2 || err == nil || !strings.Contains(err.Error(), "InternalFailure") {
+		t.Errorf("got %d entries and %v, want 2 and the rejection", len(entries), err)
+	}
+}
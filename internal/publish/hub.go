@@ -0,0 +1,166 @@
+package publish
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hub tells live subscribers in this process that an order changed, so
+// streaming endpoints can push the new state to browsers. Notifications
+// carry no data and coalesce: a slow subscriber sees one pending change
+// rather than a backlog, and re-reads the order when it gets to it.
+//
+// While relaying over a Backplane, changes also reach the subscribers of
+// every other instance sharing it.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan struct{}]struct{}
+	closed bool
+	outbox chan string
+	// listeners hear of every change, here or relayed
+	listeners []func(orderID string)
+}
+
+// Backplane carries messages to every instance of the service, this one
+// included, such as over Redis pub/sub.
+type Backplane interface {
+	PublishChange(ctx context.Context, message string) error
+	// SubscribeChanges calls fn with every message until ctx is done.
+	SubscribeChanges(ctx context.Context, fn func(message string)) error
+}
+
+// relayBacklog is how many changes may wait to be relayed before further
+// ones are dropped.
+const relayBacklog = 1024
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel that receives a value whenever orderID
+// changes, and a function that ends the subscription. The channel is closed
+// when the hub is.
+func (h *Hub) Subscribe(orderID string) (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(c)
+		return c, func() {}
+	}
+	if h.subs[orderID] == nil {
+		h.subs[orderID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[orderID][c] = struct{}{}
+
+	return c, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[orderID][c]; !ok {
+			return
+		}
+		delete(h.subs[orderID], c)
+		if len(h.subs[orderID]) == 0 {
+			delete(h.subs, orderID)
+		}
+		close(c)
+	}
+}
+
+// OnChange calls fn with the ID of every order changed, here or, while
+// relaying, on the other instances, such as to drop what is cached of it.
+// fn must not block or use the hub.
+func (h *Hub) OnChange(fn func(orderID string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.listeners = append(h.listeners, fn)
+}
+
+// Notify wakes every subscriber of orderID without blocking, here and, while
+// relaying, on the other instances.
+func (h *Hub) Notify(orderID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.outbox != nil {
+		select {
+		case h.outbox <- orderID:
+		default:
+			log.Printf("backplane backlog full, dropping change of order %s", orderID)
+		}
+	}
+	h.wake(orderID)
+}
+
+// Relay exchanges changes with the other instances on b until ctx is done.
+// Changes made here are sent in the background, and those from elsewhere
+// wake the subscribers here.
+func (h *Hub) Relay(ctx context.Context, b Backplane) error {
+	// Every instance hears its own messages, and skips them by their origin
+	origin := strconv.FormatInt(rand.Int63(), 36)
+	outbox := make(chan string, relayBacklog)
+	h.mu.Lock()
+	h.outbox = outbox
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.outbox = nil
+		h.mu.Unlock()
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case orderID := <-outbox:
+				if err := b.PublishChange(ctx, origin+" "+orderID); err != nil && ctx.Err() == nil {
+					log.Printf("failed to relay change of order %s: %v", orderID, err)
+				}
+			}
+		}
+	}()
+	return b.SubscribeChanges(ctx, func(message string) {
+		from, orderID, ok := strings.Cut(message, " ")
+		if ok && from != origin {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.wake(orderID)
+		}
+	})
+}
+
+// wake tells the listeners of the change of orderID and signals its
+// subscribers here. h.mu must be held.
+func (h *Hub) wake(orderID string) {
+	for _, fn := range h.listeners {
+		fn(orderID)
+	}
+	for c := range h.subs[orderID] {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close ends every subscription, letting long-lived streams finish so the
+// server can shut down.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, subs := range h.subs {
+		for c := range subs {
+			close(c)
+		}
+	}
+	h.subs = nil
+}
@@ -0,0 +1,105 @@
+// Package publish delivers travel time updates to other services.
+package publish
+
+import (
+	"context"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Event types.
+const (
+	// EventETA is published whenever an order's travel time is recalculated.
+	EventETA = "eta"
+	// EventTrackingLost is published once when an order's courier stops
+	// reporting its location.
+	EventTrackingLost = "tracking_lost"
+	// EventPhase is published when an order with a pickup enters a phase.
+	EventPhase = "phase_changed"
+	// EventGeofenceEnter and EventGeofenceExit are published when an
+	// order's courier enters or leaves a geofence.
+	EventGeofenceEnter = "geofence_entered"
+	EventGeofenceExit  = "geofence_exited"
+	// EventProximity is published once per alert threshold of an order,
+	// when its courier first comes within it.
+	EventProximity = "proximity_alert"
+	// EventSLAAtRisk is published when an order might miss its deadline,
+	// and EventSLABreached when it is projected to, or did.
+	EventSLAAtRisk   = "sla_at_risk"
+	EventSLABreached = "sla_breached"
+	// EventDelivered is published once when an order is delivered.
+	EventDelivered = "delivered"
+	// EventDwell is published once per stop when an order's courier stays
+	// put mid-route for longer than allowed.
+	EventDwell = "dwell"
+)
+
+// Event is an update about an order for downstream consumers.
+type Event struct {
+	// ID identifies the event for deduplication, and Sequence counts the
+	// events of the order up from 1, so consumers can tell one was missed.
+	// Both are unset if the order was deleted meanwhile.
+	ID       string        `json:"event_id,omitempty"`
+	Sequence int64         `json:"sequence,omitempty"`
+	Type     string        `json:"type"`
+	OrderID  string        `json:"order_id"`
+	ETA      time.Duration `json:"eta"`
+	// ETALow and ETAHigh bound ETA, for eta events.
+	ETALow  time.Duration `json:"eta_low,omitempty"`
+	ETAHigh time.Duration `json:"eta_high,omitempty"`
+	// PickupETA and DropoffETA split ETA, for eta events of orders on the
+	// way to their pickup: the trip there, and from there to the target
+	// including waiting for the order to be ready.
+	PickupETA  time.Duration `json:"pickup_eta,omitempty"`
+	DropoffETA time.Duration `json:"dropoff_eta,omitempty"`
+	// Distance is how far in meters the courier is from the target as the
+	// crow flies, for eta events.
+	Distance float64 `json:"distance,omitempty"`
+	// Arrived is set on eta events once the courier has reached the
+	// target. ETA is never negative, and may be zero well before arrival,
+	// such as for a zero-length route.
+	Arrived bool `json:"arrived,omitempty"`
+	// Cost is the estimated cost of the route, when known.
+	Cost *routing.Cost `json:"cost,omitempty"`
+	// Weather is set when ETA was stretched for the conditions.
+	Weather string `json:"weather,omitempty"`
+	// Phase is the phase entered, for phase_changed events.
+	Phase string `json:"phase,omitempty"`
+	// Geofence names the fence, for geofence events.
+	Geofence string `json:"geofence,omitempty"`
+	// Alert is the threshold crossed, for proximity_alert events, such as
+	// "eta<=5m0s" or "distance<=500m".
+	Alert string `json:"alert,omitempty"`
+	// Deadline and Lateness are the promised time and how late the order
+	// is projected to be, for SLA events.
+	Deadline *time.Time    `json:"deadline,omitempty"`
+	Lateness time.Duration `json:"lateness,omitempty"`
+	// DeliveredAt and Location are when and where the order arrived, for
+	// delivered events. Location is also where the courier stopped, and
+	// Dwell for how long so far, for dwell events.
+	DeliveredAt *time.Time    `json:"delivered_at,omitempty"`
+	Location    *geo.Point    `json:"location,omitempty"`
+	Dwell       time.Duration `json:"dwell,omitempty"`
+	// SeenAt is when the courier last reported, for tracking_lost events.
+	SeenAt *time.Time `json:"seen_at,omitempty"`
+	// Metadata is what the ordering backend attached to the order.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Publisher kinds accepted in configuration.
+const (
+	KindWebSocket   = "websocket"
+	KindEventBridge = "eventbridge"
+	KindPubSub      = "pubsub"
+	KindServiceBus  = "servicebus"
+)
+
+// Kinds lists the publisher kinds.
+var Kinds = []string{KindWebSocket, KindEventBridge, KindPubSub, KindServiceBus}
+
+// Publisher delivers events to downstream consumers.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
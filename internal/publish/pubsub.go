@@ -0,0 +1,106 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"location/internal/egress"
+)
+
+// PubSub publishes each event to a Google Cloud Pub/Sub topic, once in every
+// schema version, with the order ID as ordering key so subscriptions with
+// ordering enabled see an order's events in turn.
+type PubSub struct {
+	topic    string
+	endpoint string
+	versions func() []int
+	client   *http.Client
+}
+
+// pubsubScope is the OAuth scope publishing needs.
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// NewPubSub publishes to topic, given as projects/PROJECT/topics/TOPIC. It
+// authenticates with the service account key in credentialsFile or, if
+// empty, application default credentials. An empty endpoint uses the
+// global one; ordered delivery works best through a regional endpoint such
+// as https://europe-west1-pubsub.googleapis.com.
+func NewPubSub(ctx context.Context, topic, credentialsFile, endpoint string, versions func() []int) (*PubSub, error) {
+	client := egress.Client(egress.Publish, 10*time.Second)
+	// Tokens are fetched through the egress transport too
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	var creds *google.Credentials
+	var err error
+	if credentialsFile != "" {
+		key, err := os.ReadFile(credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Pub/Sub credentials: %v", err)
+		}
+		creds, err = google.CredentialsFromJSON(ctx, key, pubsubScope)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Pub/Sub credentials: %v", err)
+		}
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, pubsubScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find Google credentials: %v", err)
+		}
+	}
+	client = &http.Client{Transport: &oauth2.Transport{Source: creds.TokenSource, Base: client.Transport}, Timeout: client.Timeout}
+	if endpoint == "" {
+		endpoint = "https://pubsub.googleapis.com"
+	}
+	return &PubSub{topic: topic, endpoint: strings.TrimSuffix(endpoint, "/"), versions: versions, client: client}, nil
+}
+
+type pubsubMessage struct {
+	Data        []byte            `json:"data"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+func (p *PubSub) Publish(ctx context.Context, e Event) error {
+	var messages []pubsubMessage
+	for _, version := range p.versions() {
+		data, err := Encode(e, version)
+		if err != nil {
+			return err
+		}
+		// Attributes let subscriptions filter without decoding the data
+		messages = append(messages, pubsubMessage{
+			Data:        data,
+			OrderingKey: e.OrderID,
+			Attributes:  map[string]string{"type": e.Type, "order_id": e.OrderID, "schema_version": strconv.Itoa(version)},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/v1/"+p.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish to Pub/Sub: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Pub/Sub returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package publish
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"location/internal/egress"
+)
+
+func TestPubSub(t *testing.T) {
+	var got []pubsubMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/acme/topics/orders:publish" {
+			http.NotFound(w, r)
+			return
+		}
+		var body struct{ Messages []pubsubMessage }
+		json.NewDecoder(r.Body).Decode(&body)
+		got = body.Messages
+		w.Write([]byte(`{"messageIds":["1","2"]}`))
+	}))
+	defer srv.Close()
+	p := &PubSub{topic: "projects/acme/topics/orders", endpoint: srv.URL, versions: func() []int { return []int{SchemaV1, SchemaV2} }, client: srv.Client()}
+
+	if err := p.Publish(context.Background(), Event{Type: EventDelivered, OrderID: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].OrderingKey != "o1" || got[1].Attributes["schema_version"] != "2" || got[0].Attributes["type"] != EventDelivered {
+		t.Errorf("messages = %+v", got)
+	}
+	var v2 eventV2
+	if err := json.Unmarshal(got[1].Data, &v2); err != nil || v2.SchemaVersion != 2 {
+		t.Errorf("v2 data = %s", got[1].Data)
+	}
+
+	p.topic = "projects/acme/topics/missing"
+	if err := p.Publish(context.Background(), Event{Type: EventETA, OrderID: "o1"}); err == nil {
+		t.Error("publishing to a missing topic succeeded")
+	}
+}
+
+func TestPubSubGoesThroughEgress(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		if r.URL.Host == "oauth.example.com" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"token","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"messageIds":["1"]}`))
+	}))
+	defer proxy.Close()
+	if err := egress.Configure(egress.Options{Proxy: proxy.URL}); err != nil {
+		t.Fatal(err)
+	}
+	defer egress.Configure(egress.Options{})
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "publisher@acme.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri":    "http://oauth.example.com/token",
+	})
+	credentials := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(credentials, account, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPubSub(context.Background(), "projects/acme/topics/orders", credentials, "http://pubsub.example.com", func() []int { return []int{SchemaV1} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(context.Background(), Event{Type: EventETA, OrderID: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(proxied) != 2 || proxied[0] != "http://oauth.example.com/token" || proxied[1] != "http://pubsub.example.com/v1/projects/acme/topics/orders:publish" {
+		t.Errorf("proxied %q", proxied)
+	}
+}
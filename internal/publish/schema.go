@@ -0,0 +1,130 @@
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Schema versions of published payloads. Version 1 is Event as it is;
+// version 2 groups the ETA, SLA and delivery details and adds the arrival
+// time. Both may be sent at once while consumers migrate.
+const (
+	SchemaV1 = 1
+	SchemaV2 = 2
+)
+
+// SchemaVersions lists the versions Encode supports.
+var SchemaVersions = []int{SchemaV1, SchemaV2}
+
+// Encode renders e in the given schema version.
+func Encode(e Event, version int) ([]byte, error) {
+	return encode(e, version, time.Now())
+}
+
+func encode(e Event, version int, now time.Time) ([]byte, error) {
+	switch version {
+	case SchemaV1:
+		return json.Marshal(struct {
+			SchemaVersion int `json:"schema_version"`
+			Event
+		}{SchemaV1, e})
+	case SchemaV2:
+		return json.Marshal(toV2(e, now))
+	default:
+		return nil, fmt.Errorf("unknown schema version %d", version)
+	}
+}
+
+type eventV2 struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            string    `json:"event_id,omitempty"`
+	Sequence      int64     `json:"sequence,omitempty"`
+	Type          string    `json:"type"`
+	OrderID       string    `json:"order_id"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	ETA           *etaV2    `json:"eta,omitempty"`
+	// DistanceMeters is how far the courier is from the target as the
+	// crow flies.
+	DistanceMeters *float64          `json:"distance_meters,omitempty"`
+	Cost           *routing.Cost     `json:"cost,omitempty"`
+	Weather        string            `json:"weather,omitempty"`
+	Phase          string            `json:"phase,omitempty"`
+	Geofence       string            `json:"geofence,omitempty"`
+	Alert          string            `json:"alert,omitempty"`
+	SLA            *slaV2            `json:"sla,omitempty"`
+	Delivery       *deliveryV2       `json:"delivery,omitempty"`
+	Dwell          *dwellV2          `json:"dwell,omitempty"`
+	SeenAt         *time.Time        `json:"seen_at,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+}
+
+type etaV2 struct {
+	Seconds        int64     `json:"seconds"`
+	LowSeconds     int64     `json:"low_seconds,omitempty"`
+	HighSeconds    int64     `json:"high_seconds,omitempty"`
+	PickupSeconds  int64     `json:"pickup_seconds,omitempty"`
+	DropoffSeconds int64     `json:"dropoff_seconds,omitempty"`
+	ArrivalTime    time.Time `json:"arrival_time"`
+	Arrived        bool      `json:"arrived"`
+}
+
+type slaV2 struct {
+	Deadline        time.Time `json:"deadline"`
+	LatenessSeconds int64     `json:"lateness_seconds"`
+}
+
+type deliveryV2 struct {
+	At       time.Time  `json:"at"`
+	Location *geo.Point `json:"location,omitempty"`
+}
+
+type dwellV2 struct {
+	Location geo.Point `json:"location"`
+	Seconds  int64     `json:"seconds"`
+}
+
+func toV2(e Event, now time.Time) eventV2 {
+	v := eventV2{
+		SchemaVersion: SchemaV2,
+		ID:            e.ID,
+		Sequence:      e.Sequence,
+		Type:          e.Type,
+		OrderID:       e.OrderID,
+		OccurredAt:    now.UTC(),
+		Cost:          e.Cost,
+		Weather:       e.Weather,
+		Phase:         e.Phase,
+		Geofence:      e.Geofence,
+		Alert:         e.Alert,
+		SeenAt:        e.SeenAt,
+		Metadata:      e.Metadata,
+	}
+	if e.ETA > 0 || e.Type == EventETA {
+		v.ETA = &etaV2{
+			Seconds:        int64(e.ETA.Seconds()),
+			LowSeconds:     int64(e.ETALow.Seconds()),
+			HighSeconds:    int64(e.ETAHigh.Seconds()),
+			PickupSeconds:  int64(e.PickupETA.Seconds()),
+			DropoffSeconds: int64(e.DropoffETA.Seconds()),
+			ArrivalTime:    now.Add(e.ETA).UTC(),
+			Arrived:        e.Arrived,
+		}
+	}
+	if e.Distance > 0 {
+		v.DistanceMeters = &e.Distance
+	}
+	if e.Deadline != nil {
+		v.SLA = &slaV2{Deadline: *e.Deadline, LatenessSeconds: int64(e.Lateness.Seconds())}
+	}
+	if e.DeliveredAt != nil {
+		v.Delivery = &deliveryV2{At: *e.DeliveredAt, Location: e.Location}
+	}
+	if e.Type == EventDwell && e.Location != nil {
+		v.Dwell = &dwellV2{Location: *e.Location, Seconds: int64(e.Dwell.Seconds())}
+	}
+	return v
+}
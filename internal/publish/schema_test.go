@@ -0,0 +1,39 @@
+package publish
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncodeVersions(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	e := Event{ID: "o1:3", Sequence: 3, Type: EventETA, OrderID: "o1", ETA: 5 * time.Minute, ETALow: 4 * time.Minute, ETAHigh: 7 * time.Minute, Distance: 1200}
+
+	v1, err := encode(e, SchemaV1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flat map[string]interface{}
+	json.Unmarshal(v1, &flat)
+	if flat["schema_version"] != 1.0 || flat["eta"] != float64(5*time.Minute) || flat["sequence"] != 3.0 {
+		t.Errorf("v1 = %s", v1)
+	}
+
+	v2, err := encode(e, SchemaV2, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var structured eventV2
+	json.Unmarshal(v2, &structured)
+	if structured.SchemaVersion != 2 || structured.Sequence != 3 || structured.ETA == nil || structured.DistanceMeters == nil || *structured.DistanceMeters != 1200 {
+		t.Fatalf("v2 = %s", v2)
+	}
+	if eta := *structured.ETA; eta.Seconds != 300 || eta.LowSeconds != 240 || !eta.ArrivalTime.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("v2 eta = %+v", eta)
+	}
+
+	if _, err := encode(e, 3, now); err == nil {
+		t.Error("unknown version encoded")
+	}
+}
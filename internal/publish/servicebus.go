@@ -0,0 +1,172 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"location/internal/egress"
+)
+
+// ServiceBus sends each event to an Azure Service Bus topic, once in every
+// schema version. The order ID is the session ID, so session-enabled
+// subscriptions see an order's events in turn, and the event ID and
+// version the message ID, for duplicate detection.
+type ServiceBus struct {
+	url      string
+	token    func(ctx context.Context) (string, error)
+	versions func() []int
+	client   *http.Client
+}
+
+// NewServiceBus sends to topic in the namespace of connectionString, signed
+// with its shared access key. Without a connection string it authenticates
+// as the managed identity of the host to namespace, such as
+// acme.servicebus.windows.net; clientID picks a user-assigned identity.
+func NewServiceBus(connectionString, namespace, topic, clientID string, versions func() []int) (*ServiceBus, error) {
+	p := &ServiceBus{versions: versions, client: egress.Client(egress.Publish, 10*time.Second)}
+	if connectionString != "" {
+		fields := map[string]string{}
+		for _, part := range strings.Split(connectionString, ";") {
+			if k, v, ok := strings.Cut(part, "="); ok {
+				fields[k] = v
+			}
+		}
+		u, err := url.Parse(fields["Endpoint"])
+		if err != nil || u.Host == "" || fields["SharedAccessKeyName"] == "" || fields["SharedAccessKey"] == "" {
+			return nil, errors.New("invalid Service Bus connection string")
+		}
+		namespace = u.Host
+		p.token = sharedAccessToken("https://"+namespace+"/"+topic, fields["SharedAccessKeyName"], fields["SharedAccessKey"])
+	} else {
+		p.token = (&managedIdentity{clientID: clientID, client: p.client}).token
+	}
+	p.url = "https://" + namespace + "/" + url.PathEscape(topic) + "/messages"
+	return p, nil
+}
+
+func (p *ServiceBus) Publish(ctx context.Context, e Event) error {
+	token, err := p.token(ctx)
+	if err != nil {
+		return err
+	}
+	for _, version := range p.versions() {
+		body, err := Encode(e, version)
+		if err != nil {
+			return err
+		}
+		broker, _ := json.Marshal(map[string]string{"SessionId": e.OrderID, "MessageId": e.ID + "/v" + strconv.Itoa(version), "Label": e.Type})
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("BrokerProperties", string(broker))
+		// Custom properties let subscription rules filter on them
+		req.Header.Set("type", strconv.Quote(e.Type))
+		req.Header.Set("schema_version", strconv.Itoa(version))
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send to Service Bus: %v", err)
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			return fmt.Errorf("Service Bus returned %s: %s", resp.Status, msg)
+		}
+	}
+	return nil
+}
+
+// sharedAccessToken signs tokens for resource valid for an hour.
+func sharedAccessToken(resource, keyName, key string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		encoded := url.QueryEscape(strings.ToLower(resource))
+		expiry := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(encoded + "\n" + expiry))
+		sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", encoded, url.QueryEscape(sig), expiry, keyName), nil
+	}
+}
+
+// managedIdentity fetches Azure AD tokens for Service Bus from the identity
+// endpoint of App Service and Container Apps, or else the instance metadata
+// service of virtual machines and AKS, and keeps them until shortly before
+// they expire.
+type managedIdentity struct {
+	clientID string
+	client   *http.Client
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+const serviceBusResource = "https://servicebus.azure.net/"
+
+func (m *managedIdentity) token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.current != "" && time.Until(m.expires) > 5*time.Minute {
+		return m.current, nil
+	}
+
+	query := url.Values{"resource": {serviceBusResource}}
+	if m.clientID != "" {
+		query.Set("client_id", m.clientID)
+	}
+	var req *http.Request
+	var err error
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		query.Set("api-version", "2019-08-01")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err == nil {
+			req.Header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+		}
+	} else {
+		query.Set("api-version", "2018-02-01")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+		if err == nil {
+			req.Header.Set("Metadata", "true")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed identity token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("managed identity endpoint returned %s: %s", resp.Status, msg)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		// ExpiresOn is Unix seconds, as a string
+		ExpiresOn string `json:"expires_on"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to read managed identity token: %v", err)
+	}
+	expires, _ := strconv.ParseInt(body.ExpiresOn, 10, 64)
+	m.current, m.expires = "Bearer "+body.AccessToken, time.Unix(expires, 0)
+	return m.current, nil
+}
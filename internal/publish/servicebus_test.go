@@ -0,0 +1,70 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceBus(t *testing.T) {
+	var broker []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedAccessSignature sr=https%3A%2F%2Facme.servicebus.windows.net%2Forders&sig=") {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		var props map[string]string
+		json.Unmarshal([]byte(r.Header.Get("BrokerProperties")), &props)
+		broker = append(broker, props)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	p, err := NewServiceBus("Endpoint=sb://acme.servicebus.windows.net/;SharedAccessKeyName=send;SharedAccessKey=c2VjcmV0", "", "orders", "", func() []int { return []int{SchemaV1, SchemaV2} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.url != "https://acme.servicebus.windows.net/orders/messages" {
+		t.Errorf("url = %s", p.url)
+	}
+	p.url = srv.URL
+	if err := p.Publish(context.Background(), Event{ID: "o1:4", Sequence: 4, Type: EventETA, OrderID: "o1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(broker) != 2 || broker[0]["SessionId"] != "o1" || broker[1]["MessageId"] != "o1:4/v2" {
+		t.Errorf("broker properties = %v", broker)
+	}
+
+	if _, err := NewServiceBus("Endpoint=sb://acme.servicebus.windows.net/", "", "orders", "", nil); err == nil {
+		t.Error("connection string without a key accepted")
+	}
+}
+
+func TestManagedIdentityToken(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("X-IDENTITY-HEADER") != "header" || r.URL.Query().Get("resource") != serviceBusResource || r.URL.Query().Get("client_id") != "id" {
+			t.Errorf("request = %v %v", r.URL, r.Header)
+		}
+		expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		w.Write([]byte(`{"access_token":"aad","expires_on":"` + expires + `"}`))
+	}))
+	defer srv.Close()
+	t.Setenv("IDENTITY_ENDPOINT", srv.URL)
+	t.Setenv("IDENTITY_HEADER", "header")
+
+	m := &managedIdentity{clientID: "id", client: srv.Client()}
+	for i := 0; i < 2; i++ {
+		if token, err := m.token(context.Background()); err != nil || token != "Bearer aad" {
+			t.Fatalf("token = %q, %v", token, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fetched the token %d times, want it kept", calls)
+	}
+}
@@ -0,0 +1,62 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+
+	"location/internal/egress"
+)
+
+// WebSocket sends each event as a text message over a fresh connection to
+// the URL returned by url, which may change between calls, once in every
+// schema version versions returns.
+type WebSocket struct {
+	url      func() string
+	versions func() []int
+}
+
+func NewWebSocket(url func() string, versions func() []int) *WebSocket {
+	return &WebSocket{url: url, versions: versions}
+}
+
+// dialer dials through the egress proxy, trusting its certificate
+// authorities.
+func dialer() *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.Proxy, d.TLSClientConfig = egress.Proxy, egress.TLSConfig()
+	return &d
+}
+
+func (p *WebSocket) Publish(ctx context.Context, e Event) error {
+	log.Printf("Publishing travel time for order %s: %v", e.OrderID, e.ETA)
+	var messages [][]byte
+	for _, version := range p.versions() {
+		message, err := Encode(e, version)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("%v", err)
+		}
+		messages = append(messages, message)
+	}
+
+	u, _ := url.Parse(p.url())
+	conn, _, err := dialer().DialContext(ctx, u.String(), nil)
+	if err != nil {
+		log.Println(err)
+		return fmt.Errorf("%v", err)
+	}
+	defer conn.Close()
+
+	// Send the messages
+	for _, message := range messages {
+		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			log.Println(err)
+			return fmt.Errorf("%v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package recorder captures incoming requests so real traffic can be
+// replayed against another instance.
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is one line of a recording file.
+type Request struct {
+	Time   time.Time         `json:"time"`
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   string            `json:"body,omitempty"`
+}
+
+// recordedHeaders lists the only headers kept in a recording. Everything
+// else, credentials in particular, is dropped.
+var recordedHeaders = []string{"Content-Type"}
+
+// redacted replaces credentials carried in request URIs.
+const redacted = "redacted"
+
+// sanitize returns the request URI to record, with the share link tokens
+// it carries redacted, or false if the request is not to be recorded at
+// all: the admin surface, and the login flow, whose callback carries an
+// authorization code.
+func sanitize(u *url.URL) (string, bool) {
+	if strings.HasPrefix(u.Path, "/admin/") || strings.HasPrefix(u.Path, "/auth/") {
+		return "", false
+	}
+	clean := *u
+	if strings.HasPrefix(u.Path, "/track/") {
+		clean.Path, clean.RawPath = "/track/"+redacted, ""
+	}
+	if q := u.Query(); q.Has("share") {
+		q.Set("share", redacted)
+		clean.RawQuery = q.Encode()
+	}
+	return clean.RequestURI(), true
+}
+
+// Recorder appends sanitized requests to a file as JSON lines.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func New(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %v", err)
+	}
+	return &Recorder{enc: json.NewEncoder(f)}, nil
+}
+
+// Middleware records every request sanitize lets through before passing it
+// on unchanged.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uri, ok := sanitize(r.URL); ok {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			entry := Request{
+				Time:   time.Now(),
+				Method: r.Method,
+				Path:   uri,
+				Body:   string(body),
+			}
+			for _, name := range recordedHeaders {
+				if v := r.Header.Get(name); v != "" {
+					if entry.Header == nil {
+						entry.Header = map[string]string{}
+					}
+					entry.Header[name] = v
+				}
+			}
+
+			rec.mu.Lock()
+			err = rec.enc.Encode(entry)
+			rec.mu.Unlock()
+			if err != nil {
+				log.Printf("failed to record request: %v", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Replay implements the replay command: it sends recorded requests to a
+// target instance, preserving their relative timing scaled by -speed.
+func Replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the instance to replay against")
+	speed := fs.Float64("speed", 1, "playback speed multiplier; 0 sends requests as fast as possible")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: location replay [flags] <recording.jsonl>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Printf("failed to open recording: %v", err)
+		return 1
+	}
+	defer f.Close()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = map[int]int{}
+		failures int
+		start    = time.Now()
+		first    time.Time
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Printf("skipping malformed recording line: %v", err)
+			continue
+		}
+		if first.IsZero() {
+			first = req.Time
+		}
+		if *speed > 0 {
+			due := start.Add(time.Duration(float64(req.Time.Sub(first)) / *speed))
+			time.Sleep(time.Until(due))
+		}
+
+		wg.Add(1)
+		go func(req Request) {
+			defer wg.Done()
+			status, err := sendRecorded(*target, req)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures++
+				log.Printf("%s %s: %v", req.Method, req.Path, err)
+				return
+			}
+			statuses[status]++
+		}(req)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("failed to read recording: %v", err)
+	}
+	wg.Wait()
+
+	log.Printf("Replay finished in %v: %d transport errors, responses by status %v", time.Since(start).Round(time.Millisecond), failures, statuses)
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
+
+func sendRecorded(target string, req Request) (int, error) {
+	httpReq, err := http.NewRequest(req.Method, strings.TrimSuffix(target, "/")+req.Path, strings.NewReader(req.Body))
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range req.Header {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,43 @@
+package recorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialsNeverReachTheRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	rec, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, uri := range []string{
+		"/order/o1?share=s3cr3t&fields=eta",
+		"/track/s3cr3t",
+		"/auth/callback?code=s3cr3t&state=abc",
+		"/admin/orders?token=s3cr3t",
+	} {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording := string(data)
+	if strings.Contains(recording, "s3cr3t") || strings.Contains(recording, "/auth/") || strings.Contains(recording, "/admin/") {
+		t.Errorf("recorded credentials: %s", recording)
+	}
+	for _, want := range []string{"share=redacted", `"/track/redacted"`} {
+		if !strings.Contains(recording, want) {
+			t.Errorf("recording lacks %s: %s", want, recording)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Cache stores computed travel times for a limited time.
+type Cache interface {
+	CachedTravelTime(ctx context.Context, key string) (time.Duration, bool)
+	CacheTravelTime(ctx context.Context, key string, travelTime time.Duration, ttl time.Duration) error
+}
+
+// Cached reuses travel times for the same trip for ttl.
+type Cached struct {
+	Next  Provider
+	Cache Cache
+	TTL   time.Duration
+}
+
+// cacheKey rounds coordinates to roughly 10m so small GPS jitter still hits.
+func cacheKey(origin, destination geo.Point, mode string) string {
+	return fmt.Sprintf("eta-cache:%.4f,%.4f:%.4f,%.4f:%s", origin.Lat, origin.Lng, destination.Lat, destination.Lng, mode)
+}
+
+func (c Cached) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	key := cacheKey(origin, destination, mode)
+	if departure, ok := DepartureTime(ctx); ok {
+		// Predictions for the same quarter hour are interchangeable
+		key += ":" + departure.Truncate(15*time.Minute).Format(time.RFC3339)
+	}
+	if travelTime, ok := c.Cache.CachedTravelTime(ctx, key); ok {
+		return travelTime, nil
+	}
+
+	travelTime, err := c.Next.TravelTime(ctx, origin, destination, mode)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.Cache.CacheTravelTime(ctx, key, travelTime, c.TTL); err != nil {
+		log.Printf("failed to cache travel time: %v", err)
+	}
+	return travelTime, nil
+}
@@ -0,0 +1,28 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"location/internal/geo"
+)
+
+// ErrNoProvider is returned by an empty Chain.
+var ErrNoProvider = errors.New("no route provider")
+
+// Chain asks each provider in turn until one answers, so a failing or
+// exhausted provider hands over to the next.
+type Chain []Provider
+
+func (c Chain) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	err := ErrNoProvider
+	for _, p := range c {
+		var d time.Duration
+		d, err = p.TravelTime(ctx, origin, destination, mode)
+		if err == nil || ctx.Err() != nil {
+			return d, err
+		}
+	}
+	return 0, err
+}
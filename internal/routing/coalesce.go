@@ -0,0 +1,102 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// metersPerDegree is the length of a degree of latitude.
+const metersPerDegree = 111320
+
+// Coalescer shares travel times between requests for the same destination
+// from origins close together, such as the orders collected at one food
+// court. A request waits for a matching one in flight rather than calling
+// the provider again, and reuses its result for a short window after.
+type Coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done       chan struct{}
+	travelTime time.Duration
+	err        error
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{flights: map[string]*flight{}}
+}
+
+// Wrap returns next with its requests coalesced: origins in the same cell
+// of a grid radius meters wide share results for window after they arrive.
+// Predictions for a departure time are passed straight through.
+func (c *Coalescer) Wrap(next Provider, window time.Duration, radius float64) Provider {
+	return coalesced{c: c, next: next, window: window, radius: radius}
+}
+
+type coalesced struct {
+	c      *Coalescer
+	next   Provider
+	window time.Duration
+	radius float64
+}
+
+func (p coalesced) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	if _, ok := DepartureTime(ctx); ok {
+		return p.next.TravelTime(ctx, origin, destination, mode)
+	}
+	key := coalesceKey(origin, destination, mode, p.radius)
+
+	p.c.mu.Lock()
+	f, ok := p.c.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		p.c.flights[key] = f
+	}
+	p.c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		if f.err != nil && ctx.Err() == nil {
+			// The other request may have given up for reasons of its own
+			return p.next.TravelTime(ctx, origin, destination, mode)
+		}
+		return f.travelTime, f.err
+	}
+
+	f.travelTime, f.err = p.next.TravelTime(ctx, origin, destination, mode)
+	close(f.done)
+	forget := func() {
+		p.c.mu.Lock()
+		defer p.c.mu.Unlock()
+		if p.c.flights[key] == f {
+			delete(p.c.flights, key)
+		}
+	}
+	if f.err != nil || p.window <= 0 {
+		forget()
+	} else {
+		time.AfterFunc(p.window, forget)
+	}
+	return f.travelTime, f.err
+}
+
+// coalesceKey snaps origin to a grid of cells radius meters wide, and
+// rounds the destination to roughly 10m like the travel time cache.
+func coalesceKey(origin, destination geo.Point, mode string, radius float64) string {
+	radius = max(radius, 1)
+	latStep := radius / metersPerDegree
+	row := math.Floor(origin.Lat / latStep)
+	lngStep := radius / (metersPerDegree * max(math.Cos((row+0.5)*latStep*math.Pi/180), 0.01))
+	col := math.Floor(origin.Lng / lngStep)
+	return fmt.Sprintf("%.0f,%.0f:%.4f,%.4f:%s", row, col, destination.Lat, destination.Lng, mode)
+}
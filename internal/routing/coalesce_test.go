@@ -0,0 +1,57 @@
+package routing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+// slowProvider counts its calls and takes a moment to answer each.
+type slowProvider struct {
+	calls atomic.Int64
+}
+
+func (s *slowProvider) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	s.calls.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	return 5 * time.Minute, nil
+}
+
+func TestCoalescer(t *testing.T) {
+	ctx := context.Background()
+	next := &slowProvider{}
+	p := NewCoalescer().Wrap(next, time.Minute, 100)
+	courtyard := geo.Point{Lat: 1.3000, Lng: 103.8000}
+	target := geo.Point{Lat: 1.35, Lng: 103.85}
+
+	// A dozen orders leave the same food court together
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			origin := geo.Point{Lat: courtyard.Lat + float64(i)*0.00001, Lng: courtyard.Lng}
+			if d, err := p.TravelTime(ctx, origin, target, "driving"); err != nil || d != 5*time.Minute {
+				t.Errorf("got %v, %v", d, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := next.calls.Load(); n != 1 {
+		t.Errorf("provider called %d times, want 1", n)
+	}
+
+	// Within the window the result is reused; other modes, destinations
+	// and origins far away are not
+	p.TravelTime(ctx, courtyard, target, "driving")
+	p.TravelTime(ctx, courtyard, target, "walking")
+	p.TravelTime(ctx, courtyard, geo.Point{Lat: 1.36, Lng: 103.85}, "driving")
+	p.TravelTime(ctx, geo.Point{Lat: 1.31, Lng: 103.80}, target, "driving")
+	if n := next.calls.Load(); n != 4 {
+		t.Errorf("provider called %d times, want 4", n)
+	}
+}
@@ -0,0 +1,49 @@
+package routing
+
+import (
+	"context"
+	"errors"
+
+	"location/internal/geo"
+)
+
+// Vehicle fuels accepted in configuration, which decide how a route's
+// fuel or energy use is estimated.
+const (
+	Gasoline = "gasoline"
+	Diesel   = "diesel"
+	Hybrid   = "hybrid"
+	Electric = "electric"
+)
+
+// KnownVehicle reports whether name is a supported vehicle fuel.
+func KnownVehicle(name string) bool {
+	return name == Gasoline || name == Diesel || name == Hybrid || name == Electric
+}
+
+// Cost is what driving a route costs besides the courier's time.
+type Cost struct {
+	// Distance is the routed distance in meters.
+	Distance float64 `json:"distance"`
+	// Tolls is the estimated toll price, in every currency it is charged
+	// in; empty for routes without tolls.
+	Tolls []Money `json:"tolls,omitempty"`
+	// Fuel is the estimated fuel use in liters, and Energy the estimated
+	// battery use in kWh of electric vehicles.
+	Fuel   float64 `json:"fuel,omitempty"`
+	Energy float64 `json:"energy,omitempty"`
+}
+
+// Money is an amount in a currency such as "EUR".
+type Money struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// Coster is implemented by providers that can estimate what a drive costs.
+type Coster interface {
+	RouteCost(ctx context.Context, origin, destination geo.Point, vehicle string) (Cost, error)
+}
+
+// ErrNoCost is returned for routes a provider cannot price.
+var ErrNoCost = errors.New("provider does not estimate route costs")
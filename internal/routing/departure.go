@@ -0,0 +1,40 @@
+package routing
+
+import (
+	"context"
+	"time"
+)
+
+type departureKey struct{}
+
+// WithDepartureTime asks providers for the travel time of a trip starting
+// at t rather than now, for deliveries planned ahead. Providers that cannot
+// predict traffic ignore it.
+func WithDepartureTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, departureKey{}, t)
+}
+
+// DepartureTime returns the departure time set with WithDepartureTime, if
+// it is still in the future.
+func DepartureTime(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(departureKey{}).(time.Time)
+	if !ok || !t.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+type urgentKey struct{}
+
+// WithUrgency marks the travel time of ctx as urgent, such as for a
+// high-priority order or one about to arrive, so a busy Pool calculates it
+// ahead of routine refreshes.
+func WithUrgency(ctx context.Context) context.Context {
+	return context.WithValue(ctx, urgentKey{}, true)
+}
+
+// Urgent reports whether ctx was marked with WithUrgency.
+func Urgent(ctx context.Context) bool {
+	urgent, _ := ctx.Value(urgentKey{}).(bool)
+	return urgent
+}
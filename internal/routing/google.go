@@ -0,0 +1,117 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"googlemaps.github.io/maps"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// GoogleMaps asks the Google Maps Directions API for the travel time. The
+// API key is looked up on every call so it can be rotated without a restart.
+type GoogleMaps struct {
+	apiKey func() string
+
+	mu     sync.Mutex
+	key    string
+	client *maps.Client
+}
+
+func NewGoogleMaps(apiKey func() string) *GoogleMaps {
+	return &GoogleMaps{apiKey: apiKey}
+}
+
+// mapsClient returns a client for the current API key, creating a new one
+// when the key changes. Calls already in flight keep their old client.
+func (g *GoogleMaps) mapsClient() (*maps.Client, error) {
+	key := g.apiKey()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.client != nil && g.key == key {
+		return g.client, nil
+	}
+	if key == "" {
+		return nil, fmt.Errorf("no Google Maps API key configured")
+	}
+
+	// Initialize Google Maps client
+	client, err := maps.NewClient(maps.WithAPIKey(key), maps.WithHTTPClient(egress.Client(egress.Maps, 0)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google Maps client: %v", err)
+	}
+	g.key, g.client = key, client
+	return client, nil
+}
+
+func (g *GoogleMaps) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	route, scheduled, err := g.directions(ctx, origin, destination, mode)
+	if err != nil {
+		return 0, err
+	}
+	return legDuration(route.Legs[0], scheduled), nil
+}
+
+// Route follows the overview polyline of the directions, which smooths the
+// roads a little.
+func (g *GoogleMaps) Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error) {
+	route, scheduled, err := g.directions(ctx, origin, destination, mode)
+	if err != nil {
+		return Route{}, err
+	}
+	points, err := route.OverviewPolyline.Decode()
+	if err != nil {
+		return Route{}, fmt.Errorf("failed to decode route: %v", err)
+	}
+	path := make([]geo.Point, 0, len(points)+2)
+	path = append(path, origin)
+	for _, p := range points {
+		path = append(path, geo.Point{Lat: p.Lat, Lng: p.Lng})
+	}
+	return Route{Path: append(path, destination), Duration: legDuration(route.Legs[0], scheduled)}, nil
+}
+
+// directions returns the first route found, with at least one leg, and
+// whether it departs at a scheduled time.
+func (g *GoogleMaps) directions(ctx context.Context, origin, destination geo.Point, mode string) (maps.Route, bool, error) {
+	mapsClient, err := g.mapsClient()
+	if err != nil {
+		return maps.Route{}, false, err
+	}
+
+	req := &maps.DirectionsRequest{
+		Origin:      origin.String(),
+		Destination: destination.String(),
+		Mode:        maps.Mode(mode),
+	}
+	departure, scheduled := DepartureTime(ctx)
+	if scheduled {
+		req.DepartureTime = strconv.FormatInt(departure.Unix(), 10)
+	}
+	routes, _, err := mapsClient.Directions(ctx, req)
+	if err != nil {
+		log.Printf("failed to get directions: %v", err)
+		return maps.Route{}, false, fmt.Errorf("failed to get directions: %v", err)
+	}
+
+	if len(routes) == 0 || len(routes[0].Legs) == 0 {
+		log.Printf("no directions found: %v", routes)
+		return maps.Route{}, false, fmt.Errorf("no directions found")
+	}
+	return routes[0], scheduled, nil
+}
+
+// legDuration is how long the leg takes; driving directions with a
+// departure time predict the traffic then.
+func legDuration(leg *maps.Leg, scheduled bool) time.Duration {
+	if scheduled && leg.DurationInTraffic > 0 {
+		return leg.DurationInTraffic
+	}
+	return leg.Duration
+}
@@ -0,0 +1,98 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// routesURL is the Google Routes API, which unlike the Directions API
+// estimates tolls and fuel use.
+const routesURL = "https://routes.googleapis.com/directions/v2:computeRoutes"
+
+// RouteCost asks the Google Routes API for the tolls and fuel use of
+// driving from origin to destination. Fuel is not estimated for electric
+// vehicles.
+func (g *GoogleMaps) RouteCost(ctx context.Context, origin, destination geo.Point, vehicle string) (Cost, error) {
+	key := g.apiKey()
+	if key == "" {
+		return Cost{}, fmt.Errorf("no Google Maps API key configured")
+	}
+	waypoint := func(p geo.Point) map[string]interface{} {
+		return map[string]interface{}{"location": map[string]interface{}{"latLng": map[string]float64{"latitude": p.Lat, "longitude": p.Lng}}}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"origin":            waypoint(origin),
+		"destination":       waypoint(destination),
+		"travelMode":        "DRIVE",
+		"routingPreference": "TRAFFIC_AWARE_OPTIMAL",
+		"extraComputations": []string{"TOLLS", "FUEL_CONSUMPTION"},
+		"routeModifiers": map[string]interface{}{
+			"vehicleInfo": map[string]string{"emissionType": strings.ToUpper(vehicle)},
+		},
+	})
+	if err != nil {
+		return Cost{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, routesURL, bytes.NewReader(body))
+	if err != nil {
+		return Cost{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Goog-Api-Key", key)
+	req.Header.Set("X-Goog-FieldMask", "routes.distanceMeters,routes.travelAdvisory.tollInfo,routes.travelAdvisory.fuelConsumptionMicroliters")
+	resp, err := egress.Client(egress.Maps, 0).Do(req)
+	if err != nil {
+		return Cost{}, fmt.Errorf("failed to get route cost: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Cost{}, fmt.Errorf("failed to get route cost: %s", resp.Status)
+	}
+	return decodeRouteCost(resp.Body)
+}
+
+// decodeRouteCost reads the first route of a Routes API response.
+func decodeRouteCost(r io.Reader) (Cost, error) {
+	var body struct {
+		Routes []struct {
+			DistanceMeters float64 `json:"distanceMeters"`
+			TravelAdvisory struct {
+				TollInfo *struct {
+					EstimatedPrice []struct {
+						CurrencyCode string `json:"currencyCode"`
+						Units        string `json:"units"`
+						Nanos        int64  `json:"nanos"`
+					} `json:"estimatedPrice"`
+				} `json:"tollInfo"`
+				FuelConsumptionMicroliters string `json:"fuelConsumptionMicroliters"`
+			} `json:"travelAdvisory"`
+		} `json:"routes"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return Cost{}, fmt.Errorf("failed to decode route cost: %v", err)
+	}
+	if len(body.Routes) == 0 {
+		return Cost{}, fmt.Errorf("no route found")
+	}
+	route := body.Routes[0]
+	cost := Cost{Distance: route.DistanceMeters}
+	if toll := route.TravelAdvisory.TollInfo; toll != nil {
+		for _, p := range toll.EstimatedPrice {
+			units, _ := strconv.ParseInt(p.Units, 10, 64)
+			cost.Tolls = append(cost.Tolls, Money{Currency: p.CurrencyCode, Amount: float64(units) + float64(p.Nanos)/1e9})
+		}
+	}
+	if v, err := strconv.ParseInt(route.TravelAdvisory.FuelConsumptionMicroliters, 10, 64); err == nil {
+		cost.Fuel = float64(v) / 1e6
+	}
+	return cost, nil
+}
@@ -0,0 +1,25 @@
+package routing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeRouteCost(t *testing.T) {
+	body := `{"routes":[{"distanceMeters":15300,"travelAdvisory":{
+		"tollInfo":{"estimatedPrice":[{"currencyCode":"EUR","units":"3","nanos":250000000}]},
+		"fuelConsumptionMicroliters":"1234000"}}]}`
+	cost, err := decodeRouteCost(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost.Distance != 15300 || len(cost.Tolls) != 1 || cost.Tolls[0] != (Money{Currency: "EUR", Amount: 3.25}) || cost.Fuel != 1.234 {
+		t.Errorf("cost = %+v", cost)
+	}
+
+	// Toll-free routes have no toll info at all
+	cost, _ = decodeRouteCost(strings.NewReader(`{"routes":[{"distanceMeters":900}]}`))
+	if len(cost.Tolls) != 0 || cost.Fuel != 0 {
+		t.Errorf("toll-free cost = %+v", cost)
+	}
+}
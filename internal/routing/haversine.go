@@ -0,0 +1,33 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"location/internal/geo"
+)
+
+// HaversineEstimate estimates travel time from the great-circle distance and
+// a typical speed for the mode. It needs no API key and never fails, which
+// makes it a useful stand-in while the Maps API is unavailable.
+type HaversineEstimate struct{}
+
+// Typical door-to-door speeds in km/h, including stops and detours.
+var modeSpeeds = map[string]float64{
+	"driving":   30,
+	"bicycling": 15,
+	"transit":   20,
+	"walking":   5,
+}
+
+// detourFactor accounts for roads never being a straight line.
+const detourFactor = 1.3
+
+func (HaversineEstimate) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	speed, ok := modeSpeeds[mode]
+	if !ok {
+		speed = modeSpeeds["walking"]
+	}
+	km := geo.Distance(origin, destination) / 1000 * detourFactor
+	return time.Duration(km / speed * float64(time.Hour)), nil
+}
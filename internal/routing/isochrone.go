@@ -0,0 +1,157 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// Isochroner is implemented by providers that can tell how far one can
+// travel from a point in a given time.
+type Isochroner interface {
+	// Isochrone returns the area reachable from center within limit, as
+	// one or more polygons.
+	Isochrone(ctx context.Context, center geo.Point, mode string, limit time.Duration) ([][]geo.Point, error)
+}
+
+// Isochrone provider names accepted in configuration.
+const (
+	OpenRouteService = "openrouteservice"
+	Valhalla         = "valhalla"
+)
+
+// KnownIsochrone reports whether name is a supported isochrone provider.
+func KnownIsochrone(name string) bool {
+	return name == OpenRouteService || name == Valhalla
+}
+
+// ErrUnsupportedMode is returned for travel modes a provider cannot
+// compute isochrones for, such as transit.
+var ErrUnsupportedMode = errors.New("travel mode not supported")
+
+// NewIsochroner returns the named isochrone provider at baseURL, or at the
+// public OpenRouteService API if empty.
+func NewIsochroner(name, baseURL string, apiKey func() string) Isochroner {
+	if name == Valhalla {
+		return &isochrones{url: strings.TrimSuffix(baseURL, "/") + "/isochrone", apiKey: apiKey, request: valhallaRequest}
+	}
+	if baseURL == "" {
+		baseURL = "https://api.openrouteservice.org"
+	}
+	return &isochrones{url: strings.TrimSuffix(baseURL, "/") + "/v2/isochrones/", apiKey: apiKey, request: orsRequest}
+}
+
+// isochrones asks an OpenRouteService or Valhalla server for isochrones.
+// Both answer with a GeoJSON FeatureCollection of polygons.
+type isochrones struct {
+	url    string
+	apiKey func() string
+	// request returns the URL and body for one isochrone.
+	request func(url string, center geo.Point, mode string, limit time.Duration) (string, interface{}, error)
+}
+
+func orsRequest(url string, center geo.Point, mode string, limit time.Duration) (string, interface{}, error) {
+	profile, ok := map[string]string{"driving": "driving-car", "walking": "foot-walking", "bicycling": "cycling-regular"}[mode]
+	if !ok {
+		return "", nil, ErrUnsupportedMode
+	}
+	return url + profile, map[string]interface{}{
+		"locations": [][]float64{{center.Lng, center.Lat}},
+		"range":     []float64{limit.Seconds()},
+	}, nil
+}
+
+func valhallaRequest(url string, center geo.Point, mode string, limit time.Duration) (string, interface{}, error) {
+	costing, ok := map[string]string{"driving": "auto", "walking": "pedestrian", "bicycling": "bicycle"}[mode]
+	if !ok {
+		return "", nil, ErrUnsupportedMode
+	}
+	return url, map[string]interface{}{
+		"locations": []map[string]float64{{"lat": center.Lat, "lon": center.Lng}},
+		"costing":   costing,
+		"contours":  []map[string]float64{{"time": limit.Minutes()}},
+		"polygons":  true,
+	}, nil
+}
+
+func (s *isochrones) Isochrone(ctx context.Context, center geo.Point, mode string, limit time.Duration) ([][]geo.Point, error) {
+	url, body, err := s.request(s.url, center, mode, limit)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := s.apiKey(); key != "" {
+		req.Header.Set("Authorization", key)
+	}
+	resp, err := egress.Client(egress.Maps, 0).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get isochrone: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get isochrone: %s", resp.Status)
+	}
+
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("failed to decode isochrone: %v", err)
+	}
+	// Only the outer ring of each polygon matters for showing reach
+	var rings [][][][]float64
+	for _, f := range collection.Features {
+		switch f.Geometry.Type {
+		case "Polygon":
+			var polygon [][][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygon); err != nil {
+				return nil, fmt.Errorf("failed to decode isochrone: %v", err)
+			}
+			rings = append(rings, polygon)
+		case "MultiPolygon":
+			var polygons [][][][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &polygons); err != nil {
+				return nil, fmt.Errorf("failed to decode isochrone: %v", err)
+			}
+			rings = append(rings, polygons...)
+		}
+	}
+	var areas [][]geo.Point
+	for _, polygon := range rings {
+		if len(polygon) == 0 {
+			continue
+		}
+		area := make([]geo.Point, 0, len(polygon[0]))
+		for _, c := range polygon[0] {
+			if len(c) >= 2 {
+				area = append(area, geo.Point{Lat: c[1], Lng: c[0]})
+			}
+		}
+		areas = append(areas, area)
+	}
+	if len(areas) == 0 {
+		return nil, fmt.Errorf("no isochrone found")
+	}
+	return areas, nil
+}
@@ -0,0 +1,50 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+func TestIsochrone(t *testing.T) {
+	var path string
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon",
+			"coordinates":[[[103.80,1.30],[103.90,1.30],[103.85,1.40],[103.80,1.30]]]}}]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	center := geo.Point{Lat: 1.33, Lng: 103.85}
+
+	ors := NewIsochroner(OpenRouteService, srv.URL, func() string { return "key" })
+	areas, err := ors.Isochrone(ctx, center, "bicycling", 15*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v2/isochrones/cycling-regular" || body["range"].([]interface{})[0] != 900.0 {
+		t.Errorf("openrouteservice request: %s %v", path, body)
+	}
+	if len(areas) != 1 || len(areas[0]) != 4 || areas[0][2] != (geo.Point{Lat: 1.40, Lng: 103.85}) {
+		t.Errorf("areas = %v", areas)
+	}
+
+	valhalla := NewIsochroner(Valhalla, srv.URL, func() string { return "" })
+	if _, err := valhalla.Isochrone(ctx, center, "driving", 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/isochrone" || body["costing"] != "auto" {
+		t.Errorf("valhalla request: %s %v", path, body)
+	}
+	if _, err := valhalla.Isochrone(ctx, center, "transit", 10*time.Minute); !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("transit: %v", err)
+	}
+}
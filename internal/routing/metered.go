@@ -0,0 +1,120 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Usage is how often a provider was called on one UTC day.
+type Usage struct {
+	Day    string `json:"day"`
+	Calls  int64  `json:"calls"`
+	Errors int64  `json:"errors"`
+}
+
+// ErrBudgetExhausted is returned by a Metered provider once its daily
+// budget is spent.
+var ErrBudgetExhausted = errors.New("daily budget exhausted")
+
+// Metered counts the calls made to a provider per UTC day, so operators can
+// see how much of a paid quota is used up. Counts are kept per process and
+// start over on restart.
+type Metered struct {
+	Next Provider
+	// Budget, if set, returns how many calls may be made per day; past
+	// it calls fail with ErrBudgetExhausted without reaching Next. Zero
+	// means unlimited.
+	Budget func() int
+
+	mu    sync.Mutex
+	usage Usage
+}
+
+func (m *Metered) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	if m.exhausted() {
+		return 0, ErrBudgetExhausted
+	}
+	d, err := m.Next.TravelTime(ctx, origin, destination, mode)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	m.usage.Calls++
+	if err != nil {
+		m.usage.Errors++
+	}
+	return d, err
+}
+
+// RouteCost prices the route if the provider can, counting the call like
+// any other.
+func (m *Metered) RouteCost(ctx context.Context, origin, destination geo.Point, vehicle string) (Cost, error) {
+	c, ok := m.Next.(Coster)
+	if !ok {
+		return Cost{}, ErrNoCost
+	}
+	if m.exhausted() {
+		return Cost{}, ErrBudgetExhausted
+	}
+	cost, err := c.RouteCost(ctx, origin, destination, vehicle)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	m.usage.Calls++
+	if err != nil {
+		m.usage.Errors++
+	}
+	return cost, err
+}
+
+// Route finds the way if the provider can, counting the call like any
+// other.
+func (m *Metered) Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error) {
+	r, ok := m.Next.(Router)
+	if !ok {
+		return Route{}, ErrNoRoute
+	}
+	if m.exhausted() {
+		return Route{}, ErrBudgetExhausted
+	}
+	route, err := r.Route(ctx, origin, destination, mode)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	m.usage.Calls++
+	if err != nil {
+		m.usage.Errors++
+	}
+	return route, err
+}
+
+// Usage returns today's counts.
+func (m *Metered) Usage() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	return m.usage
+}
+
+// exhausted reports whether today's budget is spent. Concurrent calls may
+// overshoot it by a few.
+func (m *Metered) exhausted() bool {
+	if m.Budget == nil {
+		return false
+	}
+	budget := m.Budget()
+	return budget > 0 && m.Usage().Calls >= int64(budget)
+}
+
+// rollover resets the counts when the day has changed.
+func (m *Metered) rollover() {
+	if day := time.Now().UTC().Format(time.DateOnly); m.usage.Day != day {
+		m.usage = Usage{Day: day}
+	}
+}
@@ -0,0 +1,77 @@
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// OSRMURL is the public OSRM demo server. Its usage policy only allows
+// light use, and it only routes cars; run your own for anything more.
+const OSRMURL = "https://router.project-osrm.org"
+
+// osrmProfiles are the OSRM profiles of travel modes.
+var osrmProfiles = map[string]string{
+	"driving":   "car",
+	"bicycling": "bike",
+	"walking":   "foot",
+}
+
+// OSRM finds routes on OpenStreetMap roads with an OSRM server, free of
+// charge.
+type OSRM struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOSRM(baseURL string) *OSRM {
+	return &OSRM{baseURL: strings.TrimSuffix(baseURL, "/"), client: egress.Client(egress.Maps, 10*time.Second)}
+}
+
+func (o *OSRM) Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error) {
+	profile, ok := osrmProfiles[mode]
+	if !ok {
+		return Route{}, ErrUnsupportedMode
+	}
+	url := fmt.Sprintf("%s/route/v1/%s/%f,%f;%f,%f?overview=full&geometries=geojson",
+		o.baseURL, profile, origin.Lng, origin.Lat, destination.Lng, destination.Lat)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Route{}, err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return Route{}, fmt.Errorf("failed to get route: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Routes  []struct {
+			Duration float64 `json:"duration"`
+			Geometry struct {
+				Coordinates [][2]float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"routes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Route{}, fmt.Errorf("failed to get route: %s", resp.Status)
+	}
+	if body.Code != "Ok" || len(body.Routes) == 0 {
+		return Route{}, fmt.Errorf("no route found: %s %s", body.Code, body.Message)
+	}
+	r := body.Routes[0]
+	path := make([]geo.Point, 0, len(r.Geometry.Coordinates)+2)
+	path = append(path, origin)
+	for _, c := range r.Geometry.Coordinates {
+		path = append(path, geo.Point{Lat: c[1], Lng: c[0]})
+	}
+	return Route{Path: append(path, destination), Duration: time.Duration(r.Duration * float64(time.Second))}, nil
+}
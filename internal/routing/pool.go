@@ -0,0 +1,149 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"location/internal/geo"
+)
+
+// ErrOverloaded is returned by a Pool that sheds work when its queue is
+// full.
+var ErrOverloaded = errors.New("travel time queue full")
+
+// Pool calculates travel times on a fixed number of workers, so a burst of
+// requests queues up rather than all calling providers at once. When the
+// queue is full, callers either wait for room or, if the pool sheds, fail
+// at once with ErrOverloaded.
+//
+// Urgent calculations, marked with WithUrgency, have a queue of their own
+// that workers take from first, so they neither wait behind nor are shed
+// for routine ones.
+type Pool struct {
+	workers         int
+	shed            bool
+	urgent, routine chan job
+
+	busy, shedCount, completed atomic.Int64
+}
+
+type job struct {
+	ctx                 context.Context
+	next                Provider
+	origin, destination geo.Point
+	mode                string
+	done                chan result
+}
+
+type result struct {
+	travelTime time.Duration
+	err        error
+}
+
+// NewPool returns a pool of workers taking up to queue calculations
+// waiting in each of its queues. Nothing is calculated until Run.
+func NewPool(workers, queue int, shed bool) *Pool {
+	return &Pool{workers: workers, shed: shed, urgent: make(chan job, queue), routine: make(chan job, queue)}
+}
+
+// Run works through the queue until ctx ends.
+func (p *Pool) Run(ctx context.Context) error {
+	for i := 0; i < p.workers; i++ {
+		go p.work(ctx)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		var j job
+		select {
+		case j = <-p.urgent:
+		default:
+			select {
+			case <-ctx.Done():
+				return
+			case j = <-p.urgent:
+			case j = <-p.routine:
+			}
+		}
+		if err := j.ctx.Err(); err != nil {
+			// The caller gave up while it queued
+			j.done <- result{err: err}
+			continue
+		}
+		p.busy.Add(1)
+		d, err := j.next.TravelTime(j.ctx, j.origin, j.destination, j.mode)
+		p.busy.Add(-1)
+		p.completed.Add(1)
+		j.done <- result{d, err}
+	}
+}
+
+// Wrap returns next with its calculations run on the pool.
+func (p *Pool) Wrap(next Provider) Provider {
+	return pooled{p: p, next: next}
+}
+
+type pooled struct {
+	p    *Pool
+	next Provider
+}
+
+func (q pooled) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	j := job{ctx: ctx, next: q.next, origin: origin, destination: destination, mode: mode, done: make(chan result, 1)}
+	queue := q.p.routine
+	if Urgent(ctx) {
+		queue = q.p.urgent
+	}
+	if q.p.shed {
+		select {
+		case queue <- j:
+		default:
+			q.p.shedCount.Add(1)
+			return 0, ErrOverloaded
+		}
+	} else {
+		select {
+		case queue <- j:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	select {
+	case r := <-j.done:
+		return r.travelTime, r.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// PoolStats is a snapshot of a Pool.
+type PoolStats struct {
+	Workers  int `json:"workers"`
+	Capacity int `json:"capacity"`
+	// Queued is how many calculations wait for a worker, of which
+	// QueuedUrgent are urgent, and Busy how many workers are calculating.
+	Queued       int   `json:"queued"`
+	QueuedUrgent int   `json:"queued_urgent"`
+	Busy         int64 `json:"busy"`
+	// Shed and Completed count calculations since the start.
+	Shed      int64 `json:"shed"`
+	Completed int64 `json:"completed"`
+}
+
+// Stats returns the pool's queue depth and counts.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Workers:      p.workers,
+		Capacity:     cap(p.routine),
+		Queued:       len(p.urgent) + len(p.routine),
+		QueuedUrgent: len(p.urgent),
+		Busy:         p.busy.Load(),
+		Shed:         p.shedCount.Load(),
+		Completed:    p.completed.Load(),
+	}
+}
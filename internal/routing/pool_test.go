@@ -0,0 +1,108 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+// gatedProvider answers once its gate is opened.
+type gatedProvider chan struct{}
+
+func (g gatedProvider) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	select {
+	case <-g:
+		return time.Minute, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func TestPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, shed := range []bool{true, false} {
+		gate := make(gatedProvider)
+		pool := NewPool(1, 1, shed)
+		go pool.Run(ctx)
+		p := pool.Wrap(gate)
+
+		// One calculation runs and one waits in the queue
+		results := make(chan error, 2)
+		calculate := func() {
+			_, err := p.TravelTime(ctx, geo.Point{}, geo.Point{Lat: 1}, "driving")
+			results <- err
+		}
+		go calculate()
+		waitFor(t, func() bool { return pool.Stats().Busy == 1 })
+		go calculate()
+		waitFor(t, func() bool { return pool.Stats().Queued == 1 })
+
+		short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+		_, err := p.TravelTime(short, geo.Point{}, geo.Point{Lat: 1}, "driving")
+		cancelShort()
+		if shed && !errors.Is(err, ErrOverloaded) {
+			t.Errorf("shedding pool returned %v", err)
+		}
+		if !shed && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("blocking pool returned %v", err)
+		}
+
+		close(gate)
+		for i := 0; i < 2; i++ {
+			if err := <-results; err != nil {
+				t.Errorf("queued calculation failed: %v", err)
+			}
+		}
+		if s := pool.Stats(); s.Completed != 2 || (s.Shed == 1) != shed {
+			t.Errorf("shed %v: stats %+v", shed, s)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolTakesUrgentFirst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gate := make(gatedProvider)
+	pool := NewPool(1, 4, false)
+	go pool.Run(ctx)
+	p := pool.Wrap(gate)
+
+	// Hold the only worker, then queue routine work before urgent work
+	go p.TravelTime(ctx, geo.Point{}, geo.Point{Lat: 1}, "driving")
+	waitFor(t, func() bool { return pool.Stats().Busy == 1 })
+	order := make(chan string, 2)
+	go func() {
+		p.TravelTime(ctx, geo.Point{}, geo.Point{Lat: 2}, "driving")
+		order <- "routine"
+	}()
+	waitFor(t, func() bool { return pool.Stats().Queued == 1 })
+	go func() {
+		p.TravelTime(WithUrgency(ctx), geo.Point{}, geo.Point{Lat: 3}, "driving")
+		order <- "urgent"
+	}()
+	waitFor(t, func() bool { return pool.Stats().QueuedUrgent == 1 })
+
+	// Let one calculation through at a time
+	gate <- struct{}{}
+	gate <- struct{}{}
+	if first := <-order; first != "urgent" {
+		t.Errorf("%s calculated first", first)
+	}
+	gate <- struct{}{}
+	<-order
+}
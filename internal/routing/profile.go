@@ -0,0 +1,57 @@
+package routing
+
+import (
+	"context"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Profiles keep how fast couriers actually moved, per area, travel mode and
+// hour of the week, learned from their recorded trips.
+type Profiles interface {
+	// Observed returns the meters covered and the time spent covering them
+	// in the geohash cell by mode during the hour of the week, counting
+	// from Sunday midnight.
+	Observed(ctx context.Context, cell, mode string, hour int) (meters float64, elapsed time.Duration)
+}
+
+// HourOfWeek is the hour of the week at t, in t's location, counting from
+// Sunday midnight.
+func HourOfWeek(t time.Time) int {
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// Profiled corrects the estimates of Next, which knows no traffic and
+// assumes a typical speed per mode as HaversineEstimate does, by how much
+// faster or slower couriers were seen to move around the origin at that
+// hour of the week. Hours observed for less than MinObserved keep the
+// estimate as it is.
+type Profiled struct {
+	Next        Provider
+	Profiles    Profiles
+	Precision   int
+	MinObserved time.Duration
+}
+
+func (p Profiled) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	travelTime, err := p.Next.TravelTime(ctx, origin, destination, mode)
+	if err != nil {
+		return 0, err
+	}
+	at, ok := DepartureTime(ctx)
+	if !ok {
+		at = time.Now()
+	}
+	meters, elapsed := p.Profiles.Observed(ctx, geo.Geohash(origin, p.Precision), mode, HourOfWeek(at))
+	if meters <= 0 || elapsed < p.MinObserved || elapsed <= 0 {
+		return travelTime, nil
+	}
+	speed, ok := modeSpeeds[mode]
+	if !ok {
+		speed = modeSpeeds["walking"]
+	}
+	// Speeds are in km/h, observations in m/s
+	observed := meters / elapsed.Seconds() * 3.6
+	return time.Duration(float64(travelTime) * speed / observed), nil
+}
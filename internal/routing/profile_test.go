@@ -0,0 +1,51 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+// fixedProfiles observed the same in every cell, at one hour of the week.
+type fixedProfiles struct {
+	hour    int
+	meters  float64
+	elapsed time.Duration
+}
+
+func (f fixedProfiles) Observed(ctx context.Context, cell, mode string, hour int) (float64, time.Duration) {
+	if hour != f.hour {
+		return 0, 0
+	}
+	return f.meters, f.elapsed
+}
+
+func TestProfiled(t *testing.T) {
+	origin, destination := geo.Point{Lat: 1.30, Lng: 103.80}, geo.Point{Lat: 1.35, Lng: 103.85}
+	raw, _ := HaversineEstimate{}.TravelTime(context.Background(), origin, destination, "driving")
+	rushHour := time.Now().Add(24 * time.Hour)
+
+	// Drivers crawled along at 15km/h, half the typical speed, at this
+	// hour of tomorrow's weekday
+	p := Profiled{
+		Next:        HaversineEstimate{},
+		Profiles:    fixedProfiles{hour: HourOfWeek(rushHour), meters: 15000, elapsed: time.Hour},
+		Precision:   5,
+		MinObserved: 10 * time.Minute,
+	}
+	got, err := p.TravelTime(WithDepartureTime(context.Background(), rushHour), origin, destination, "driving")
+	if err != nil || (got-2*raw).Abs() > time.Millisecond {
+		t.Errorf("rush hour got %v, %v, want %v", got, err, 2*raw)
+	}
+	if got, _ := p.TravelTime(WithDepartureTime(context.Background(), rushHour.Add(3*time.Hour)), origin, destination, "driving"); got != raw {
+		t.Errorf("unobserved hour got %v, want %v", got, raw)
+	}
+
+	// Too little seen to go by
+	p.Profiles = fixedProfiles{hour: HourOfWeek(rushHour), meters: 250, elapsed: time.Minute}
+	if got, _ := p.TravelTime(WithDepartureTime(context.Background(), rushHour), origin, destination, "driving"); got != raw {
+		t.Errorf("barely observed hour got %v, want %v", got, raw)
+	}
+}
@@ -0,0 +1,67 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Router is implemented by providers that can tell the way between two
+// points, not only how long it takes.
+type Router interface {
+	// Route returns the way from origin to destination and how long
+	// travelling it takes.
+	Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error)
+}
+
+// ErrNoRoute is returned for ways a provider cannot tell.
+var ErrNoRoute = errors.New("provider does not return routes")
+
+// Route is a way between two points.
+type Route struct {
+	// Path starts at the origin and ends at the destination.
+	Path     []geo.Point
+	Duration time.Duration
+}
+
+// Length returns the length of the path in meters.
+func (r Route) Length() float64 {
+	var meters float64
+	for i := 1; i < len(r.Path); i++ {
+		meters += geo.Distance(r.Path[i-1], r.Path[i])
+	}
+	return meters
+}
+
+// Along returns the point meters along the path, and whether it is short
+// of the end; past it, the end is returned.
+func (r Route) Along(meters float64) (geo.Point, bool) {
+	if len(r.Path) == 0 {
+		return geo.Point{}, false
+	}
+	for i := 1; i < len(r.Path); i++ {
+		d := geo.Distance(r.Path[i-1], r.Path[i])
+		if meters < d {
+			return geo.Toward(r.Path[i-1], r.Path[i], meters), true
+		}
+		meters -= d
+	}
+	return r.Path[len(r.Path)-1], false
+}
+
+// Route goes in a straight line, taking as long as TravelTime estimates.
+func (h HaversineEstimate) Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error) {
+	d, err := h.TravelTime(ctx, origin, destination, mode)
+	return Route{Path: []geo.Point{origin, destination}, Duration: d}, err
+}
+
+// Router names accepted in configuration: Google, Haversine for straight
+// lines, and OSRM.
+const OSRMRouter = "osrm"
+
+// KnownRouter reports whether name is a supported router.
+func KnownRouter(name string) bool {
+	return name == Google || name == Haversine || name == OSRMRouter
+}
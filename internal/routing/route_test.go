@@ -0,0 +1,57 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+func TestOSRMRoute(t *testing.T) {
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Write([]byte(`{"code":"Ok","routes":[{"duration":120.5,"geometry":{"type":"LineString",
+			"coordinates":[[103.850,1.300],[103.850,1.305],[103.855,1.305]]}}]}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	from, to := geo.Point{Lat: 1.3, Lng: 103.85}, geo.Point{Lat: 1.305, Lng: 103.855}
+
+	route, err := NewOSRM(srv.URL).Route(ctx, from, to, "bicycling")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/route/v1/bike/103.850000,1.300000;103.855000,1.305000" {
+		t.Errorf("asked for %s", path)
+	}
+	if route.Duration != 120500*time.Millisecond || len(route.Path) != 5 || route.Path[2] != (geo.Point{Lat: 1.305, Lng: 103.85}) {
+		t.Errorf("got route %+v", route)
+	}
+	if _, err := NewOSRM(srv.URL).Route(ctx, from, to, "transit"); !errors.Is(err, ErrUnsupportedMode) {
+		t.Errorf("routed transit: %v", err)
+	}
+}
+
+func TestRouteAlong(t *testing.T) {
+	corner := geo.Point{Lat: 1.31, Lng: 103.85}
+	route := Route{Path: []geo.Point{{Lat: 1.30, Lng: 103.85}, corner, {Lat: 1.31, Lng: 103.86}}}
+	first := geo.Distance(route.Path[0], corner)
+	if got, want := route.Length(), first+geo.Distance(corner, route.Path[2]); math.Abs(got-want) > 1e-6 {
+		t.Errorf("length %f, want %f", got, want)
+	}
+	if p, enRoute := route.Along(first); !enRoute || geo.Distance(p, corner) > 0.01 {
+		t.Errorf("at the corner got %v, %t", p, enRoute)
+	}
+	if p, enRoute := route.Along(first / 2); !enRoute || math.Abs(p.Lat-1.305) > 1e-6 {
+		t.Errorf("halfway got %v", p)
+	}
+	if p, enRoute := route.Along(route.Length() + 1); enRoute || p != route.Path[2] {
+		t.Errorf("past the end got %v, %t", p, enRoute)
+	}
+}
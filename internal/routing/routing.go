@@ -0,0 +1,25 @@
+// Package routing estimates travel times between two points.
+package routing
+
+import (
+	"context"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Provider estimates how long it takes to travel between two points.
+type Provider interface {
+	TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error)
+}
+
+// Provider names accepted in configuration and /admin/config.
+const (
+	Google    = "google"
+	Haversine = "haversine"
+)
+
+// Known reports whether name is a supported provider.
+func Known(name string) bool {
+	return name == Google || name == Haversine
+}
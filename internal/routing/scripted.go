@@ -0,0 +1,84 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Scripted is a fake provider for tests and demos. It answers with the
+// queued results in order and then keeps repeating Default.
+type Scripted struct {
+	Default time.Duration
+	// Cost, if set, is the answer to every RouteCost call.
+	Cost *Cost
+	// Via, if set, are the points every Route passes between origin and
+	// destination; without it Route fails with ErrNoRoute.
+	Via []geo.Point
+
+	mu      sync.Mutex
+	results []ScriptedResult
+	calls   []ScriptedCall
+}
+
+// ScriptedResult is one queued answer.
+type ScriptedResult struct {
+	TravelTime time.Duration
+	Err        error
+}
+
+// ScriptedCall records the arguments of one call.
+type ScriptedCall struct {
+	Origin, Destination geo.Point
+	Mode                string
+	// Departure is the departure time asked for, if any.
+	Departure time.Time
+}
+
+// ErrScripted is a ready-made error to queue.
+var ErrScripted = errors.New("scripted provider failure")
+
+// Queue appends answers for the next calls.
+func (s *Scripted) Queue(results ...ScriptedResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, results...)
+}
+
+// Calls returns the calls made so far.
+func (s *Scripted) Calls() []ScriptedCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ScriptedCall(nil), s.calls...)
+}
+
+func (s *Scripted) TravelTime(ctx context.Context, origin, destination geo.Point, mode string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	departure, _ := DepartureTime(ctx)
+	s.calls = append(s.calls, ScriptedCall{Origin: origin, Destination: destination, Mode: mode, Departure: departure})
+	if len(s.results) == 0 {
+		return s.Default, nil
+	}
+	r := s.results[0]
+	s.results = s.results[1:]
+	return r.TravelTime, r.Err
+}
+
+func (s *Scripted) RouteCost(ctx context.Context, origin, destination geo.Point, vehicle string) (Cost, error) {
+	if s.Cost == nil {
+		return Cost{}, ErrNoCost
+	}
+	return *s.Cost, nil
+}
+
+func (s *Scripted) Route(ctx context.Context, origin, destination geo.Point, mode string) (Route, error) {
+	if s.Via == nil {
+		return Route{}, ErrNoRoute
+	}
+	path := append(append([]geo.Point{origin}, s.Via...), destination)
+	return Route{Path: path, Duration: s.Default}, nil
+}
@@ -0,0 +1,98 @@
+package routing
+
+import "location/internal/geo"
+
+// Sequence orders the stops of a multi-drop trip starting at origin, to keep
+// the total distance short. It returns indexes into stops in visiting order.
+//
+// The route is built nearest-neighbour first and then improved with 2-opt,
+// on straight-line distances: good enough for the handful of stops a courier
+// carries, and free, unlike asking a provider for a distance matrix.
+func Sequence(origin geo.Point, stops []geo.Point) []int {
+	return SequenceAfter(origin, stops, nil)
+}
+
+// SequenceAfter is Sequence for stops that must wait on others, like a
+// drop-off on its pickup: prior[i], unless negative, is the index of the
+// stop to visit before stop i. A nil prior puts no stop first.
+func SequenceAfter(origin geo.Point, stops []geo.Point, prior []int) []int {
+	// ready reports whether stop i may be visited once those in visited are
+	ready := func(i int, visited []bool) bool {
+		return prior == nil || prior[i] < 0 || visited[prior[i]]
+	}
+	order := make([]int, 0, len(stops))
+	visited := make([]bool, len(stops))
+	at := origin
+	for range stops {
+		next := -1
+		for i, s := range stops {
+			if !visited[i] && ready(i, visited) && (next < 0 || geo.Distance(at, s) < geo.Distance(at, stops[next])) {
+				next = i
+			}
+		}
+		if next < 0 {
+			// The rest wait on each other; visit them as listed
+			for i := range stops {
+				if !visited[i] {
+					next = i
+					break
+				}
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		at = stops[next]
+	}
+
+	// point returns the i-th point of the path, where -1 is the origin
+	point := func(i int) geo.Point {
+		if i < 0 {
+			return origin
+		}
+		return stops[order[i]]
+	}
+	// keeps reports whether order visits every stop after the one it
+	// waits on
+	keeps := func() bool {
+		if prior == nil {
+			return true
+		}
+		seen := make([]bool, len(stops))
+		for _, i := range order {
+			if !ready(i, seen) {
+				return false
+			}
+			seen[i] = true
+		}
+		return true
+	}
+	reverse := func(i, j int) {
+		for a, b := i, j; a < b; a, b = a+1, b-1 {
+			order[a], order[b] = order[b], order[a]
+		}
+	}
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				// Reversing order[i..j] replaces edges (i-1,i) and (j,j+1)
+				// with (i-1,j) and (i,j+1); the path is open at the end.
+				before := geo.Distance(point(i-1), point(i))
+				after := geo.Distance(point(i-1), point(j))
+				if j+1 < len(order) {
+					before += geo.Distance(point(j), point(j+1))
+					after += geo.Distance(point(i), point(j+1))
+				}
+				if after < before-1e-6 {
+					reverse(i, j)
+					if !keeps() {
+						reverse(i, j)
+						continue
+					}
+					improved = true
+				}
+			}
+		}
+	}
+	return order
+}
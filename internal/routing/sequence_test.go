@@ -0,0 +1,39 @@
+package routing
+
+import (
+	"reflect"
+	"testing"
+
+	"location/internal/geo"
+)
+
+func TestSequence(t *testing.T) {
+	origin := geo.Point{Lat: 0, Lng: 0}
+	tests := []struct {
+		name  string
+		stops []geo.Point
+		want  []int
+	}{
+		{"none", nil, []int{}},
+		{"one", []geo.Point{{Lat: 1, Lng: 1}}, []int{0}},
+		{"along a line", []geo.Point{{Lat: 0, Lng: 0.03}, {Lat: 0, Lng: 0.01}, {Lat: 0, Lng: 0.02}}, []int{1, 2, 0}},
+		{"short hop back first", []geo.Point{{Lat: 0, Lng: 0.010}, {Lat: 0, Lng: -0.011}, {Lat: 0, Lng: -0.030}}, []int{0, 1, 2}},
+	}
+	for _, tt := range tests {
+		if got := Sequence(origin, tt.stops); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceAfter(t *testing.T) {
+	origin := geo.Point{Lat: 0, Lng: 0}
+	// The drop-off at 0.01 waits on the pickup at 0.03
+	stops := []geo.Point{{Lat: 0, Lng: 0.01}, {Lat: 0, Lng: 0.03}, {Lat: 0, Lng: 0.02}}
+	if got, want := SequenceAfter(origin, stops, []int{1, -1, -1}), []int{2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := SequenceAfter(origin, stops, nil), []int{0, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("without prior stops: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Listen addresses besides host:port.
+const (
+	// unixPrefix starts the path of a Unix socket to listen on, as in
+	// "unix:/run/location/http.sock".
+	unixPrefix = "unix:"
+	// systemdAddr takes the socket passed by systemd socket activation; a
+	// name after a colon, as in "systemd:http", picks the socket of that
+	// FileDescriptorName= when the unit passes several.
+	systemdAddr = "systemd"
+)
+
+// listenFDsStart is the first file descriptor systemd passes.
+const listenFDsStart = 3
+
+// Listen listens on addr: a TCP host:port, a Unix socket as "unix:" and its
+// path, or the socket systemd activated the service with as "systemd". The
+// Unix socket is made with the permissions mode, so a reverse proxy running
+// as another user of the group can connect, replacing any socket left
+// behind by an instance that did not exit cleanly, and removed on close.
+func Listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return listenUnix(path, mode)
+	}
+	if addr == systemdAddr || strings.HasPrefix(addr, systemdAddr+":") {
+		return listenSystemd(strings.TrimPrefix(strings.TrimPrefix(addr, systemdAddr), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// A live instance would still answer on it
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %v", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to set permissions of %s: %v", path, err)
+		}
+	}
+	return l, nil
+}
+
+// listenSystemd takes the socket named name, or the first if name is
+// empty, from those passed in LISTEN_FDS. The variables are cleared so that
+// processes the service starts do not take the sockets too.
+func listenSystemd(name string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("not started by systemd socket activation")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("systemd passed no sockets")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	defer func() {
+		for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(v)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), fdName)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use systemd socket %d: %v", fd, err)
+		}
+		return l, nil
+	}
+	return nil, fmt.Errorf("systemd passed no socket named %q", name)
+}
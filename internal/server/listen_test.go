@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// A socket left behind by an instance that did not exit cleanly
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := Listen("unix:"+path, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode %v, %v", fi.Mode(), err)
+	}
+	if _, err := Listen("unix:"+path, 0o660); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("listened twice: %v", err)
+	}
+
+	l.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left after close: %v", err)
+	}
+}
+
+func TestListenSystemdNeedsActivation(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if _, err := Listen("systemd", 0); err == nil {
+		t.Error("took sockets passed to another process")
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/handlers"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// operation documents one method on a route. Request and Response are
+// values of the types the handler reads and writes.
+type operation struct {
+	Summary  string
+	Tag      string
+	Query    []param
+	Request  interface{}
+	Response interface{}
+	// Content is the response media type when not JSON.
+	Content string
+	// NoContent is set for handlers answering 204, and Created for those
+	// answering 201.
+	NoContent bool
+	Created   bool
+}
+
+type param struct {
+	Name, Description string
+}
+
+var (
+	dateRange = []param{{"from", "First day, YYYY-MM-DD; a week before to by default"}, {"to", "Last day, YYYY-MM-DD; today by default"}}
+	bbox      = param{"bbox", "minLng,minLat,maxLng,maxLat"}
+)
+
+// operations documents every route registered by Routes, keyed by method
+// and path template. A test keeps the two in step.
+var operations = map[string]operation{
+	"POST /location/current":         {Summary: "Report a courier location and get the order's travel time in nanoseconds", Tag: "locations", Request: handlers.Location{}, Content: "text/plain"},
+	"POST /location/target":          {Summary: "Set an order's destination, by coordinates or address", Tag: "locations", Request: handlers.Location{}, Content: "text/plain"},
+	"POST /location/pickup":          {Summary: "Set where the courier collects an order", Tag: "locations", Request: handlers.Location{}, Content: "text/plain"},
+	"POST /transport":                {Summary: "Set an order's travel mode", Tag: "locations", Request: handlers.Transport{}, Content: "text/plain"},
+	"POST /orders":                   {Summary: "Create an order", Tag: "orders", Request: handlers.NewOrder{}, Response: handlers.OrderSummary{}, Created: true},
+	"POST /orders/batch":             {Summary: "Create or update many orders at once", Tag: "orders", Request: handlers.BatchRequest{}, Response: handlers.BatchResponse{}},
+	"GET /orders/search":             {Summary: "Search orders by area, mode and status", Tag: "orders", Query: []param{{"near", "lat,lng of targets to search around"}, {"radius", "Meters around near"}, {"within", "lat,lng|lat,lng|... polygon the courier is in"}, {"mode", "Travel mode"}, {"status", "scheduled, waiting, en_route, stale, paused or delivered"}}, Response: []handlers.OrderSummary{}},
+	"POST /orders/query":             {Summary: "Read the state and ETA of many orders at once", Tag: "orders", Request: handlers.BulkRead{}, Response: handlers.BulkReadResponse{}},
+	"GET /order/{id}":                {Summary: "Get an order", Tag: "orders", Response: handlers.OrderSummary{}},
+	"GET /order/{id}/eta":            {Summary: "Get an order's latest ETA", Tag: "orders", Query: []param{{"share", "Share link token"}}, Response: handlers.ETA{}},
+	"GET /order/{id}/events":         {Summary: "Stream an order's position and ETA as server-sent events", Tag: "orders", Query: []param{{"delta", "true to send only changed fields between periodic snapshots"}}, Content: "text/event-stream"},
+	"GET /eta/{id}/wait":             {Summary: "Wait for an ETA newer than a sequence, or 204 after the wait", Tag: "orders", Query: []param{{"since", "Sequence of the ETA the client has"}, {"wait", "How long to wait, at most and by default 30s"}}, Response: handlers.ETA{}},
+	"GET /order/{id}/position":       {Summary: "Get an order's live position", Tag: "orders", Response: handlers.LivePosition{}},
+	"GET /order/{id}/route":          {Summary: "Get the route left to an order's destination", Tag: "orders", Response: handlers.OrderRoute{}},
+	"GET /order/{id}/timeline":       {Summary: "What happened to an order, for support staff", Tag: "orders", Response: []tracking.TimelineEvent{}},
+	"POST /order/{id}/share":         {Summary: "Create a read-only share link", Tag: "orders", Query: []param{{"ttl", "Link lifetime such as 2h"}}, Response: handlers.ShareLink{}},
+	"PUT /order/{id}/geofences":      {Summary: "Replace an order's geofences", Tag: "orders", Request: []geo.Fence{}, NoContent: true},
+	"GET /order/{id}/geofences":      {Summary: "Get an order's geofences", Tag: "orders", Response: []geo.Fence{}},
+	"PUT /order/{id}/alerts":         {Summary: "Replace an order's proximity alerts", Tag: "orders", Request: []store.Alert{}, NoContent: true},
+	"PUT /order/{id}/schedule":       {Summary: "Plan an order's departure", Tag: "orders", Request: handlers.Schedule{}, Response: handlers.ETA{}},
+	"PUT /order/{id}/deadline":       {Summary: "Set an order's promised delivery time", Tag: "orders", Request: handlers.Deadline{}, NoContent: true},
+	"POST /order/{id}/delivered":     {Summary: "Confirm an order's delivery", Tag: "orders", Request: handlers.DeliveryConfirmation{}, Response: store.Delivery{}},
+	"POST /order/{id}/pause":         {Summary: "Pause ETAs and notifications of an order", Tag: "orders", NoContent: true},
+	"POST /order/{id}/resume":        {Summary: "Resume tracking of a paused order", Tag: "orders", NoContent: true},
+	"PUT /order/{id}/preferences":    {Summary: "Replace the customer's notification preferences", Tag: "orders", Request: store.Preferences{}, NoContent: true},
+	"GET /order/{id}/preferences":    {Summary: "Get the customer's notification preferences", Tag: "orders", Response: store.Preferences{}},
+	"GET /track/{token}":             {Summary: "Live tracking page for a share link", Tag: "orders", Content: "text/html"},
+	"POST /drivers":                  {Summary: "Register a driver and their orders", Tag: "drivers", Request: store.Driver{}, Response: store.Driver{}},
+	"GET /drivers/{id}":              {Summary: "Get a driver", Tag: "drivers", Response: store.Driver{}},
+	"POST /drivers/{id}/location":    {Summary: "Report a driver's position, updating all their orders", Tag: "drivers", Request: handlers.DriverPosition{}, Response: tracking.DriverUpdate{}},
+	"POST /drivers/{id}/nmea":        {Summary: "Report a driver's position as NMEA 0183 sentences", Tag: "drivers", Response: tracking.DriverUpdate{}},
+	"GET /fleet":                     {Summary: "Drivers and orders inside an area", Tag: "operations", Query: []param{bbox}, Response: handlers.Fleet{}},
+	"GET /heatmap":                   {Summary: "Orders and drivers per geohash cell", Tag: "operations", Query: []param{bbox, {"precision", "Geohash length from 1 to 12"}}, Response: []tracking.Cell{}},
+	"GET /isochrone":                 {Summary: "Area reachable from a point within some minutes", Tag: "operations", Query: []param{{"lat", "Latitude"}, {"lng", "Longitude"}, {"minutes", "1 to 60"}, {"mode", "Travel mode"}}, Response: handlers.Isochrone{}},
+	"GET /analytics/deliveries":      {Summary: "Deliveries per day", Tag: "analytics", Query: dateRange, Response: []tracking.DayStats{}},
+	"GET /analytics/eta-error":       {Summary: "ETA accuracy", Tag: "analytics", Query: dateRange, Response: tracking.ErrorStats{}},
+	"GET /analytics/trips":           {Summary: "Average trips per mode or zone", Tag: "analytics", Query: append([]param{{"by", "mode or zone"}}, dateRange...), Response: []tracking.TripStats{}},
+	"GET /admin/config":              {Summary: "Get runtime settings", Tag: "admin", Response: config.Settings{}},
+	"PATCH /admin/config":            {Summary: "Change runtime settings", Tag: "admin", Request: config.SettingsUpdate{}, Response: config.Settings{}},
+	"POST /admin/config":             {Summary: "Change runtime settings", Tag: "admin", Request: config.SettingsUpdate{}, Response: config.Settings{}},
+	"GET /admin/orders":              {Summary: "List every order", Tag: "admin", Query: []param{{"stale_after", "Silence before a courier is flagged, such as 2m"}}, Response: []handlers.OrderSummary{}},
+	"DELETE /admin/orders/{id}":      {Summary: "Delete an order", Tag: "admin", NoContent: true},
+	"GET /admin/orders/{id}/history": {Summary: "An order's recorded history", Tag: "admin", Response: []store.Entry{}},
+	"GET /admin/providers":           {Summary: "Route provider usage today", Tag: "admin", Response: []handlers.ProviderUsage{}},
+	"GET /admin/rejections":          {Summary: "Courier locations rejected as outliers", Tag: "admin", Response: tracking.Rejections{}},
+	"GET /admin/workers":             {Summary: "Queue depth of the travel time workers", Tag: "admin", Response: routing.PoolStats{}},
+	"GET /admin/billing":             {Summary: "Billable distance per driver and day, as CSV or with format=json", Tag: "admin", Query: []param{{"day", "YYYY-MM-DD"}, {"driver", "Driver ID"}, {"format", "json for JSON"}}, Response: []handlers.BillingLine{}, Content: "text/csv"},
+	"GET /admin/dashboard/":          {Summary: "Operator dashboard", Tag: "admin", Content: "text/html"},
+	"GET /auth/login":                {Summary: "Log in to the admin surface", Tag: "auth"},
+	"GET /auth/callback":             {Summary: "Identity provider callback", Tag: "auth"},
+	"GET /auth/logout":               {Summary: "Log out", Tag: "auth"},
+	"POST /auth/logout":              {Summary: "Log out", Tag: "auth"},
+	"GET /metrics":                   {Summary: "Service level indicators in the Prometheus text format", Tag: "admin", Content: "text/plain"},
+	"GET /openapi.json":              {Summary: "This specification", Tag: "docs"},
+	"GET /docs":                      {Summary: "Swagger UI for this specification", Tag: "docs", Content: "text/html"},
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPI builds the OpenAPI 3 specification of the routes registered on
+// r. Routes without a method restriction are documented for the methods
+// in operations.
+func openAPI(r *mux.Router) (map[string]interface{}, error) {
+	s := newSchemas()
+	paths := map[string]interface{}{}
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		if len(methods) == 0 {
+			for key := range operations {
+				if method, p, _ := strings.Cut(key, " "); p == tmpl {
+					methods = append(methods, method)
+				}
+			}
+		}
+		item, _ := paths[tmpl].(map[string]interface{})
+		if item == nil {
+			item = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			op, ok := operations[method+" "+tmpl]
+			if !ok {
+				continue
+			}
+			item[strings.ToLower(method)] = s.operation(tmpl, op)
+		}
+		if len(item) > 0 {
+			paths[tmpl] = item
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Location service",
+			"description": "Courier tracking and ETAs. Durations are in nanoseconds.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+	}, nil
+}
+
+func (s *schemas) operation(tmpl string, op operation) map[string]interface{} {
+	var params []interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(tmpl, -1) {
+		params = append(params, map[string]interface{}{"name": m[1], "in": "path", "required": true, "schema": schema{"type": "string"}})
+	}
+	for _, q := range op.Query {
+		params = append(params, map[string]interface{}{"name": q.Name, "in": "query", "description": q.Description, "schema": schema{"type": "string"}})
+	}
+
+	out := map[string]interface{}{"summary": op.Summary, "tags": []string{op.Tag}}
+	if len(params) > 0 {
+		out["parameters"] = params
+	}
+	if op.Request != nil {
+		out["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(op.Request))}},
+		}
+	}
+	content := map[string]interface{}{}
+	if op.Response != nil {
+		content["application/json"] = map[string]interface{}{"schema": s.of(reflect.TypeOf(op.Response))}
+	}
+	if op.Content != "" {
+		content[op.Content] = map[string]interface{}{"schema": schema{"type": "string"}}
+	}
+	ok := map[string]interface{}{"description": "OK"}
+	if len(content) > 0 {
+		ok["content"] = content
+	}
+	responses := map[string]interface{}{"200": ok}
+	if op.Created {
+		ok["description"] = "Created"
+		responses = map[string]interface{}{"201": ok}
+	}
+	if op.NoContent {
+		responses = map[string]interface{}{"204": map[string]interface{}{"description": "No Content"}}
+	}
+	out["responses"] = responses
+	return out
+}
+
+// serveOpenAPI serves the specification of r, built on first use once
+// every route is registered.
+func serveOpenAPI(r *mux.Router) http.HandlerFunc {
+	var (
+		once sync.Once
+		spec []byte
+		err  error
+	)
+	return func(w http.ResponseWriter, _ *http.Request) {
+		once.Do(func() {
+			var doc map[string]interface{}
+			if doc, err = openAPI(r); err == nil {
+				spec, err = json.MarshalIndent(doc, "", "  ")
+			}
+		})
+		if err != nil {
+			http.Error(w, "Failed to build specification", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+//go:embed swagger.html
+var swaggerHTML []byte
+
+// serveSwaggerUI serves Swagger UI for /openapi.json. The page is embedded;
+// the Swagger UI scripts come from a CDN.
+func serveSwaggerUI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(swaggerHTML)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/handlers"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+func TestEveryRouteDocumented(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := tracking.New(store.NewMemory(), map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}, &publish.Capture{}, rt)
+	authn, err := auth.New(config.Default().Auth, func() string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := router(handlers.New(tracker, rt, authn))
+
+	routes := map[string]bool{}
+	r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, m := range methods {
+			routes[m+" "+tmpl] = true
+			if _, ok := operations[m+" "+tmpl]; !ok {
+				t.Errorf("%s %s is not documented", m, tmpl)
+			}
+		}
+		return nil
+	})
+	for key := range operations {
+		// Login only exists with OIDC configured
+		if !routes[key] && !strings.Contains(key, " /auth/") && !strings.HasPrefix(key, "POST /location/") &&
+			key != "POST /transport" && !strings.HasSuffix(key, " /admin/config") {
+			t.Errorf("%s is documented but not routed", key)
+		}
+	}
+
+	spec, err := openAPI(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := spec["paths"].(map[string]interface{})
+	if _, ok := paths["/location/target"].(map[string]interface{})["post"]; !ok {
+		t.Error("routes without methods are missing")
+	}
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	props := schemas["OrderSummary"].(schema)["properties"].(schema)
+	// Embedded store.Order fields are flattened, as encoding/json does
+	for _, name := range []string{"order_id", "status", "current", "eta"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("OrderSummary lacks %s: %v", name, props)
+		}
+	}
+	if got := props["eta"].(schema)["type"]; got != "integer" {
+		t.Errorf("durations are %v, want integer nanoseconds", got)
+	}
+}
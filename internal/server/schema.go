@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding"
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemas derives JSON Schemas from Go types the way encoding/json writes
+// them, collecting named structs as reusable components.
+type schemas struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]interface{}{}, names: map[reflect.Type]string{}}
+}
+
+type schema = map[string]interface{}
+
+func (s *schemas) of(t reflect.Type) schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case t == durationType:
+		return schema{"type": "integer", "description": "Nanoseconds"}
+	case t == rawMessageType:
+		return schema{}
+	case t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType):
+		return schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + s.component(t)}
+	}
+	return schema{}
+}
+
+// component registers a named struct, returning its component name. Names
+// are qualified by package only when two packages use the same one.
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := s.components[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	s.names[t] = name
+	// Reserve the name first so that recursive types terminate
+	s.components[name] = schema{}
+	s.components[name] = s.object(t)
+	return name
+}
+
+func (s *schemas) object(t reflect.Type) schema {
+	props := schema{}
+	s.fields(t, props)
+	return schema{"type": "object", "properties": props}
+}
+
+// fields adds the JSON properties of a struct, flattening embedded structs
+// as encoding/json does.
+func (s *schemas) fields(t reflect.Type, props schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			s.fields(ft, props)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.of(f.Type)
+	}
+}
@@ -0,0 +1,142 @@
+// Package server wires the HTTP handlers into a server.
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"location/internal/config"
+	"location/internal/handlers"
+)
+
+// Routes registers every endpoint on a new router. Requests are measured
+// for the SLIs, and callers authenticated and held to their tenant's rate
+// limit, up front; each handler then authorizes what it serves.
+func Routes(h *handlers.Handler) http.Handler {
+	return h.Measure(h.Auth().Middleware(h.RateLimit(router(h))))
+}
+
+func router(h *handlers.Handler) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/location/current", h.CurrentLocation)
+	r.HandleFunc("/location/target", h.TargetLocation)
+	r.HandleFunc("/location/pickup", h.PickupLocation)
+	r.HandleFunc("/transport", h.Transport)
+	r.HandleFunc("/orders", h.CreateOrder).Methods(http.MethodPost)
+	r.HandleFunc("/orders/batch", h.BatchOrders).Methods(http.MethodPost)
+	r.HandleFunc("/orders/search", h.SearchOrders).Methods(http.MethodGet)
+	r.HandleFunc("/orders/query", h.QueryOrders).Methods(http.MethodPost)
+	r.HandleFunc("/order/{id}", h.Order).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/eta", h.OrderETA).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/events", h.OrderEvents).Methods(http.MethodGet)
+	r.HandleFunc("/eta/{id}/wait", h.WaitETA).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/position", h.OrderPosition).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/route", h.OrderRoute).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/timeline", h.OrderTimeline).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/share", h.ShareOrder).Methods(http.MethodPost)
+	r.HandleFunc("/order/{id}/geofences", h.SetGeofences).Methods(http.MethodPut)
+	r.HandleFunc("/order/{id}/geofences", h.Geofences).Methods(http.MethodGet)
+	r.HandleFunc("/order/{id}/alerts", h.SetAlerts).Methods(http.MethodPut)
+	r.HandleFunc("/order/{id}/schedule", h.ScheduleOrder).Methods(http.MethodPut)
+	r.HandleFunc("/order/{id}/deadline", h.SetDeadline).Methods(http.MethodPut)
+	r.HandleFunc("/order/{id}/delivered", h.Delivered).Methods(http.MethodPost)
+	r.HandleFunc("/order/{id}/pause", h.PauseOrder).Methods(http.MethodPost)
+	r.HandleFunc("/order/{id}/resume", h.ResumeOrder).Methods(http.MethodPost)
+	r.HandleFunc("/order/{id}/preferences", h.SetPreferences).Methods(http.MethodPut)
+	r.HandleFunc("/order/{id}/preferences", h.Preferences).Methods(http.MethodGet)
+	r.HandleFunc("/track/{token}", h.TrackPage).Methods(http.MethodGet)
+	r.HandleFunc("/drivers", h.RegisterDriver).Methods(http.MethodPost)
+	r.HandleFunc("/drivers/{id}", h.Driver).Methods(http.MethodGet)
+	r.HandleFunc("/drivers/{id}/location", h.DriverLocation).Methods(http.MethodPost)
+	r.HandleFunc("/drivers/{id}/nmea", h.DriverNMEA).Methods(http.MethodPost)
+	r.HandleFunc("/fleet", h.Fleet).Methods(http.MethodGet)
+	r.HandleFunc("/heatmap", h.Heatmap).Methods(http.MethodGet)
+	r.HandleFunc("/isochrone", h.Isochrone).Methods(http.MethodGet)
+	r.HandleFunc("/analytics/deliveries", h.AnalyticsDeliveries).Methods(http.MethodGet)
+	r.HandleFunc("/analytics/eta-error", h.AnalyticsETAError).Methods(http.MethodGet)
+	r.HandleFunc("/analytics/trips", h.AnalyticsTrips).Methods(http.MethodGet)
+	r.HandleFunc("/admin/config", h.AdminConfig)
+	r.HandleFunc("/admin/orders", h.AdminOrders).Methods(http.MethodGet)
+	r.HandleFunc("/admin/orders/{id}", h.AdminDeleteOrder).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/orders/{id}/history", h.AdminOrderHistory).Methods(http.MethodGet)
+	r.HandleFunc("/admin/providers", h.AdminProviders).Methods(http.MethodGet)
+	r.HandleFunc("/admin/rejections", h.AdminRejections).Methods(http.MethodGet)
+	r.HandleFunc("/admin/workers", h.AdminWorkers).Methods(http.MethodGet)
+	r.HandleFunc("/admin/billing", h.AdminBilling).Methods(http.MethodGet)
+	r.Handle("/admin/dashboard", http.RedirectHandler("/admin/dashboard/", http.StatusMovedPermanently))
+	r.HandleFunc("/admin/dashboard/", h.Dashboard).Methods(http.MethodGet)
+	if o := h.Auth().OIDC(); o != nil {
+		r.HandleFunc("/auth/login", o.Login).Methods(http.MethodGet)
+		r.HandleFunc("/auth/callback", o.Callback).Methods(http.MethodGet)
+		r.HandleFunc("/auth/logout", o.Logout).Methods(http.MethodGet, http.MethodPost)
+	}
+	r.HandleFunc("/metrics", h.Metrics).Methods(http.MethodGet)
+	r.HandleFunc("/openapi.json", serveOpenAPI(r)).Methods(http.MethodGet)
+	r.HandleFunc("/docs", serveSwaggerUI).Methods(http.MethodGet)
+	return r
+}
+
+// New creates a server for the handlers, wrapping them in the given
+// middleware from the outside in. Connections are kept alive between
+// requests for conf's idle timeout and, with H2C, may speak HTTP/2 without
+// TLS, as by prior knowledge or an Upgrade from HTTP/1.1.
+func New(conf config.ServerConfig, h *handlers.Handler, middleware ...func(http.Handler) http.Handler) (*http.Server, error) {
+	var handler http.Handler = Routes(h)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	srv := &http.Server{
+		Addr:              conf.ListenAddr,
+		Handler:           handler,
+		IdleTimeout:       conf.IdleTimeout.Duration,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout.Duration,
+		ConnState:         h.ConnState,
+	}
+	if conf.H2C {
+		h2s := &http2.Server{MaxConcurrentStreams: conf.MaxConcurrentStreams, IdleTimeout: conf.IdleTimeout.Duration}
+		// Configuring the server too has shutting it down send GOAWAY on
+		// HTTP/2 connections
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return nil, err
+		}
+		srv.Handler = h2c.NewHandler(handler, h2s)
+	}
+	// Event streams never finish on their own
+	srv.RegisterOnShutdown(h.CloseStreams)
+	return srv, nil
+}
+
+// Run serves on l until ctx is done, then shuts down gracefully, giving
+// in-flight requests up to shutdownTimeout to finish.
+func Run(ctx context.Context, srv *http.Server, l net.Listener, shutdownTimeout time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Server listening on %s", srv.Addr)
+		errc <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down HTTP server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/handlers"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+func TestH2C(t *testing.T) {
+	conf := config.Default()
+	conf.Server.H2C = true
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := tracking.New(store.NewMemory(), map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}, &publish.Capture{}, rt)
+	authn, err := auth.New(conf.Auth, func() string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(conf.Server, handlers.New(tracker, rt, authn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Run(ctx, srv, lis, time.Second) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// HTTP/2 with prior knowledge, as from a load balancer
+	dials := 0
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			dials++
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("http://" + lis.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+			t.Fatalf("got %s %s", resp.Proto, resp.Status)
+		}
+		if i == 1 && !strings.Contains(string(body), "location_http_connections_total 1\n") {
+			t.Errorf("metrics do not count one connection:\n%s", body)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("dialed %d connections for two requests", dials)
+	}
+}
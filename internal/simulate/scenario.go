@@ -0,0 +1,234 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"location/internal/geo"
+)
+
+// Scenario scripts trips for regression tests of how the service handles
+// drivers who stray, go silent or change how they travel. Times are of the
+// simulation: with a speedup, the service's own thresholds, such as
+// tracking.stale_after, are not sped up along.
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Interval and Speedup replace those of the simulation if set.
+	Interval time.Duration `yaml:"interval"`
+	Speedup  float64       `yaml:"speedup"`
+	// Seed picks the points trips leave out, the same on every run.
+	Seed  int64  `yaml:"seed"`
+	Trips []Trip `yaml:"trips"`
+}
+
+// Trip is one order a driver delivers. The trips of a driver are driven in
+// turn, and those of different drivers at once.
+type Trip struct {
+	// Driver and Order name the driver and order; by default they are
+	// numbered after the trip, and orders after the run too.
+	Driver string `yaml:"driver"`
+	Order  string `yaml:"order"`
+	// From and To, as "lat,lng", default to random points within the
+	// simulation's radius of its center.
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	Mode string `yaml:"mode"`
+	// Events happen on the way.
+	Events []Event `yaml:"events"`
+	// Expect lists the types of events the order's timeline must have once
+	// delivered, such as "tracking_lost"; those starting with "!" it must
+	// not have.
+	Expect []string `yaml:"expect"`
+}
+
+// Event is something that happens At a time after the driver departs.
+// Each does one thing.
+type Event struct {
+	At time.Duration `yaml:"at"`
+	// OffRoute moves the driver's positions this many meters to the right
+	// of the route, negative to the left, For a while.
+	OffRoute float64       `yaml:"off_route"`
+	For      time.Duration `yaml:"for"`
+	// GPSDropout stops the driver posting positions for this long, while
+	// it drives on.
+	GPSDropout time.Duration `yaml:"gps_dropout"`
+	// Stop holds the driver in place for this long.
+	Stop time.Duration `yaml:"stop"`
+	// Mode changes the travel mode of the order, and the route with it.
+	Mode string `yaml:"mode"`
+}
+
+// length is how long the event lasts.
+func (e Event) length() time.Duration {
+	switch {
+	case e.OffRoute != 0:
+		return e.For
+	case e.GPSDropout > 0:
+		return e.GPSDropout
+	}
+	return e.Stop
+}
+
+func (e Event) validate() error {
+	actions := 0
+	for _, set := range []bool{e.OffRoute != 0, e.GPSDropout > 0, e.Stop > 0, e.Mode != ""} {
+		if set {
+			actions++
+		}
+	}
+	switch {
+	case actions != 1:
+		return errors.New("want exactly one of off_route, gps_dropout, stop and mode")
+	case e.At < 0 || e.For < 0 || e.GPSDropout < 0 || e.Stop < 0:
+		return errors.New("times must not be negative")
+	case e.OffRoute != 0 && e.For == 0:
+		return errors.New("off_route needs for")
+	}
+	return nil
+}
+
+// LoadScenario reads a scenario from a YAML file.
+func LoadScenario(path string) (Scenario, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+	defer f.Close()
+	var sc Scenario
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&sc); err != nil {
+		return Scenario{}, fmt.Errorf("failed to parse scenario %s: %v", path, err)
+	}
+	if len(sc.Trips) == 0 {
+		return Scenario{}, fmt.Errorf("scenario %s has no trips", path)
+	}
+	if sc.Interval < 0 || sc.Speedup < 0 {
+		return Scenario{}, fmt.Errorf("scenario %s: interval and speedup must not be negative", path)
+	}
+	for i, t := range sc.Trips {
+		for _, p := range []string{t.From, t.To} {
+			if _, err := geo.Parse(p); p != "" && err != nil {
+				return Scenario{}, fmt.Errorf("scenario %s, trip %d: %v", path, i+1, err)
+			}
+		}
+		for j, e := range t.Events {
+			if err := e.validate(); err != nil {
+				return Scenario{}, fmt.Errorf("scenario %s, trip %d, event %d: %v", path, i+1, j+1, err)
+			}
+		}
+	}
+	return sc, nil
+}
+
+// Play drives the trips of the scenario, then checks each order's timeline
+// against what its trip expects. It returns an error telling every trip
+// that failed or missed an expectation.
+func (s *Simulator) Play(ctx context.Context, sc Scenario) error {
+	sim := *s
+	if sc.Interval > 0 {
+		sim.opts.Interval = sc.Interval
+	}
+	if sc.Speedup > 0 {
+		sim.opts.Speedup = sc.Speedup
+	}
+
+	// Points are drawn in the order of the trips, so they do not depend on
+	// how the drivers' goroutines are scheduled
+	rng := rand.New(rand.NewSource(sc.Seed))
+	point := func(p string) geo.Point {
+		if p == "" {
+			return sim.randomPoint(rng)
+		}
+		point, _ := geo.Parse(p)
+		return point
+	}
+	var drivers []string
+	rides := map[string][]ride{}
+	expects := map[string][]string{}
+	for i, t := range sc.Trips {
+		r := ride{
+			driverID: t.Driver,
+			orderID:  t.Order,
+			from:     point(t.From),
+			to:       point(t.To),
+			mode:     t.Mode,
+			events:   t.Events,
+		}
+		if r.driverID == "" {
+			r.driverID = fmt.Sprintf("%sdriver-%d", sim.opts.Prefix, i+1)
+		}
+		if r.orderID == "" {
+			r.orderID = fmt.Sprintf("%s%s-%d", sim.opts.Prefix, sim.run, i+1)
+		}
+		if r.mode == "" {
+			r.mode = sim.opts.Mode
+		}
+		if _, ok := rides[r.driverID]; !ok {
+			drivers = append(drivers, r.driverID)
+		}
+		rides[r.driverID] = append(rides[r.driverID], r)
+		expects[r.orderID] = t.Expect
+	}
+
+	var mu sync.Mutex
+	var failures []error
+	var wg sync.WaitGroup
+	for _, driverID := range drivers {
+		wg.Add(1)
+		go func(rides []ride) {
+			defer wg.Done()
+			for _, r := range rides {
+				err := sim.ride(ctx, r)
+				if err == nil {
+					err = sim.check(ctx, r.orderID, expects[r.orderID])
+				}
+				if err != nil {
+					mu.Lock()
+					failures = append(failures, fmt.Errorf("order %s: %v", r.orderID, err))
+					mu.Unlock()
+				}
+			}
+		}(rides[driverID])
+	}
+	wg.Wait()
+	return errors.Join(failures...)
+}
+
+// check tells the expected event types missing from the order's timeline,
+// and those it should not have.
+func (s *Simulator) check(ctx context.Context, orderID string, expect []string) error {
+	if len(expect) == 0 {
+		return nil
+	}
+	timeline, err := s.api.Timeline(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, e := range timeline {
+		seen[e.Type] = true
+	}
+	var missed []string
+	for _, want := range expect {
+		if unwanted, ok := strings.CutPrefix(want, "!"); ok {
+			if seen[unwanted] {
+				missed = append(missed, "unexpected "+unwanted)
+			}
+		} else if !seen[want] {
+			missed = append(missed, "no "+want)
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("timeline has %s", strings.Join(missed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+// Package simulate drives synthetic couriers through the API as real ones
+// would, for demos, load tests and frontend development. Each driver takes
+// one order after another: the order is created with a random target, the
+// driver dispatched with it and moved along the road there at the speed
+// the route takes, posting its position every interval, and the order is
+// marked delivered on arrival.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"location/client"
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Options shape the simulation.
+type Options struct {
+	// Drivers is how many drivers are simulated, each with an order at a
+	// time.
+	Drivers int
+	// Center and Radius, in meters, bound where drivers start and orders
+	// are delivered.
+	Center geo.Point
+	Radius float64
+	// Interval is how often drivers post their position.
+	Interval time.Duration
+	// Mode is the travel mode of orders.
+	Mode string
+	// Speedup plays trips this many times faster than real time; positions
+	// are as far apart as they would be every interval, but posted sooner.
+	Speedup float64
+	// Prefix starts the IDs of simulated drivers and orders.
+	Prefix string
+}
+
+// fallbackSpeed in m/s moves drivers along routes that take no time.
+const fallbackSpeed = 8.0
+
+// Simulator drives the simulated drivers through an API client.
+type Simulator struct {
+	api    *client.Client
+	router routing.Router
+	opts   Options
+	// run tells the orders of one simulation from those of earlier ones
+	run string
+}
+
+func New(api *client.Client, router routing.Router, opts Options) *Simulator {
+	if opts.Speedup <= 0 {
+		opts.Speedup = 1
+	}
+	return &Simulator{api: api, router: router, opts: opts, run: strconv.FormatInt(time.Now().Unix(), 36)}
+}
+
+// Run drives the drivers until ctx is done. Failed calls are logged and
+// retried, so the simulation outlives the service restarting.
+func (s *Simulator) Run(ctx context.Context) error {
+	log.Printf("Simulating %d drivers within %.0fm of %s", s.opts.Drivers, s.opts.Radius, s.opts.Center)
+	var wg sync.WaitGroup
+	for i := 1; i <= s.opts.Drivers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			s.drive(ctx, n)
+		}(i)
+	}
+	wg.Wait()
+	return nil
+}
+
+// drive runs the trips of the nth driver, starting at a random time within
+// the first interval so that drivers do not post all at once.
+func (s *Simulator) drive(ctx context.Context, n int) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(n)))
+	driverID := fmt.Sprintf("%sdriver-%d", s.opts.Prefix, n)
+	if !s.sleep(ctx, time.Duration(rng.Int63n(int64(s.tick())+1))) {
+		return
+	}
+	position := s.randomPoint(rng)
+	for trip := 1; ctx.Err() == nil; trip++ {
+		r := ride{
+			driverID: driverID,
+			orderID:  fmt.Sprintf("%s%s-%d-%d", s.opts.Prefix, s.run, n, trip),
+			from:     position,
+			to:       s.randomPoint(rng),
+			mode:     s.opts.Mode,
+		}
+		if err := s.ride(ctx, r); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("simulated driver %s: %v", driverID, err)
+			}
+			s.sleep(ctx, s.tick())
+			continue
+		}
+		position = r.to
+	}
+}
+
+// ride is one trip of a driver with an order.
+type ride struct {
+	driverID, orderID string
+	from, to          geo.Point
+	mode              string
+	// events script what happens on the way, by the time since departure
+	events []Event
+}
+
+// ride creates the order, dispatches the driver with it and drives it to
+// the target, then marks the order delivered.
+func (s *Simulator) ride(ctx context.Context, r ride) error {
+	_, err := s.api.CreateOrder(ctx, client.NewOrder{BatchOrder: client.BatchOrder{
+		OrderID:  r.orderID,
+		Lat:      r.to.Lat,
+		Lng:      r.to.Lng,
+		Mode:     r.mode,
+		Metadata: map[string]string{"simulated": "true"},
+	}})
+	if err != nil {
+		return err
+	}
+	_, err = s.api.RegisterDriver(ctx, client.Driver{ID: r.driverID, Status: client.DriverBusy, Orders: []string{r.orderID}})
+	if err != nil {
+		return err
+	}
+
+	route := s.route(ctx, r.orderID, r.from, r.to, r.mode)
+	done := make([]bool, len(r.events))
+	travelled := 0.0
+	for elapsed := time.Duration(0); ; elapsed += s.opts.Interval {
+		p, enRoute := route.Along(travelled)
+		shown, posting, moving := p, true, true
+		for i, e := range r.events {
+			if elapsed < e.At {
+				continue
+			}
+			switch {
+			case e.Mode != "":
+				if !done[i] {
+					done[i] = true
+					if err := s.api.SetMode(ctx, r.orderID, e.Mode); err != nil {
+						return err
+					}
+					route, travelled = s.route(ctx, r.orderID, p, r.to, e.Mode), 0
+				}
+			case elapsed >= e.At+e.length():
+			case e.GPSDropout > 0:
+				posting = false
+			case e.Stop > 0:
+				moving = false
+			case e.OffRoute != 0:
+				shown = aside(route, travelled, e.OffRoute)
+			}
+		}
+		if posting {
+			if _, err := s.api.UpdateDriverLocation(ctx, r.driverID, shown); err != nil && ctx.Err() == nil {
+				log.Printf("simulated driver %s: %v", r.driverID, err)
+			}
+		}
+		if !enRoute {
+			break
+		}
+		if moving {
+			travelled += speed(route) * s.opts.Interval.Seconds()
+		}
+		if !s.sleep(ctx, s.tick()) {
+			return ctx.Err()
+		}
+	}
+	_, err = s.api.Delivered(ctx, r.orderID, client.DeliveryConfirmation{Note: "simulated"})
+	return err
+}
+
+// route finds the way from from to to, or a straight line if the router
+// fails, so that demos go on without it.
+func (s *Simulator) route(ctx context.Context, orderID string, from, to geo.Point, mode string) routing.Route {
+	route, err := s.router.Route(ctx, from, to, mode)
+	if err != nil {
+		log.Printf("failed to route order %s, driving in a straight line: %v", orderID, err)
+		route, _ = routing.HaversineEstimate{}.Route(ctx, from, to, mode)
+	}
+	return route
+}
+
+// speed returns how fast the route is travelled, in m/s.
+func speed(route routing.Route) float64 {
+	if length := route.Length(); route.Duration > 0 && length > 0 {
+		return length / route.Duration.Seconds()
+	}
+	return fallbackSpeed
+}
+
+// tick is the real time between positions.
+func (s *Simulator) tick() time.Duration {
+	return time.Duration(float64(s.opts.Interval) / s.opts.Speedup)
+}
+
+// randomPoint returns a point spread evenly within the radius of the
+// center.
+func (s *Simulator) randomPoint(rng *rand.Rand) geo.Point {
+	r := s.opts.Radius * math.Sqrt(rng.Float64())
+	bearing := 2 * math.Pi * rng.Float64()
+	return offset(s.opts.Center, r*math.Cos(bearing), r*math.Sin(bearing))
+}
+
+// metersPerDegree of latitude.
+const metersPerDegree = 111320
+
+// offset returns p moved the meters given north and east, on the flat map,
+// which is accurate over the few kilometres of a city.
+func offset(p geo.Point, north, east float64) geo.Point {
+	return geo.Point{
+		Lat: p.Lat + north/metersPerDegree,
+		Lng: p.Lng + east/(metersPerDegree*math.Cos(p.Lat*math.Pi/180)),
+	}
+}
+
+// aside returns the point meters to the right of the route, travelled
+// along it; negative meters are to the left.
+func aside(route routing.Route, travelled, meters float64) geo.Point {
+	p, _ := route.Along(travelled)
+	behind, _ := route.Along(travelled - 1)
+	ahead, _ := route.Along(travelled + 1)
+	north := (ahead.Lat - behind.Lat) * metersPerDegree
+	east := (ahead.Lng - behind.Lng) * metersPerDegree * math.Cos(p.Lat*math.Pi/180)
+	length := math.Hypot(north, east)
+	if length == 0 {
+		return offset(p, meters, 0)
+	}
+	return offset(p, -east/length*meters, north/length*meters)
+}
+
+// sleep waits for d, reporting false if ctx is done first.
+func (s *Simulator) sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
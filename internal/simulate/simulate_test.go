@@ -0,0 +1,169 @@
+package simulate
+
+import (
+	"context"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"location/client"
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/handlers"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/server"
+	"location/internal/store"
+	"location/internal/tracking"
+)
+
+// newAPI serves the API on a fresh in-memory tracker, with the
+// configuration changed by mutate, and returns a client of it.
+func newAPI(t *testing.T, mutate func(*config.Configuration)) (*client.Client, *tracking.Tracker, *publish.Capture) {
+	t.Helper()
+	conf := config.Default()
+	conf.Tracking.MaxSpeed = 0
+	conf.Auth.AdminToken = "admin"
+	if mutate != nil {
+		mutate(&conf)
+	}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := &publish.Capture{}
+	tracker := tracking.New(store.NewMemory(), map[string]routing.Provider{routing.Google: routing.HaversineEstimate{}}, events, rt)
+	authn, err := auth.New(conf.Auth, func() string { return "admin" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(server.Routes(handlers.New(tracker, rt, authn)))
+	t.Cleanup(srv.Close)
+	return client.New(srv.URL, "admin"), tracker, events
+}
+
+func TestDriversDeliverThroughTheAPI(t *testing.T) {
+	api, tracker, events := newAPI(t, nil)
+	center := geo.Point{Lat: 1.30, Lng: 103.85}
+	sim := New(api, routing.HaversineEstimate{}, Options{
+		Drivers:  2,
+		Center:   center,
+		Radius:   500,
+		Interval: 5 * time.Second,
+		Mode:     "driving",
+		Speedup:  2000,
+		Prefix:   "sim-",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- sim.Run(ctx) }()
+
+	var delivered store.Order
+	for deadline := time.Now().Add(10 * time.Second); delivered.ID == "" && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		tracker.ForEachOrder(context.Background(), func(o store.Order) error {
+			if o.Delivery != nil {
+				delivered = o
+			}
+			return nil
+		})
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if delivered.ID == "" {
+		t.Fatal("no simulated order was delivered")
+	}
+	if delivered.Metadata["simulated"] != "true" || delivered.Mode != "driving" {
+		t.Errorf("delivered %+v", delivered)
+	}
+	if d := geo.Distance(*delivered.Current, center); d > 500+1 {
+		t.Errorf("delivered %.0fm from the center", d)
+	}
+	if len(events.Events()) == 0 {
+		t.Error("no ETAs published along the way")
+	}
+}
+
+const scenarioYAML = `
+name: strays and goes silent
+interval: 1s
+speedup: 20
+seed: 7
+trips:
+  - driver: d1
+    order: o1
+    from: "1.300000,103.850000"
+    to: "1.301800,103.850000"
+    events:
+      - at: 2s
+        off_route: 100
+        for: 3s
+      - at: 6s
+        gps_dropout: 12s
+      - at: 20s
+        mode: bicycling
+    expect: [tracking_lost, mode_changed, delivered]
+  - driver: d2
+    order: o2
+    expect: ["!tracking_lost", geofence_entered]
+`
+
+func TestScenario(t *testing.T) {
+	api, tracker, _ := newAPI(t, func(c *config.Configuration) {
+		c.Tracking.StaleAfter = config.Duration{Duration: 300 * time.Millisecond}
+		c.Tracking.WatchdogInterval = config.Duration{Duration: 50 * time.Millisecond}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracker.Watchdog(ctx)
+
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(scenarioYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := New(api, routing.HaversineEstimate{}, Options{Center: geo.Point{Lat: 1.30, Lng: 103.85}, Radius: 100, Interval: time.Minute, Mode: "driving", Prefix: "sim-"})
+	err = sim.Play(ctx, sc)
+
+	// The second trip has no geofences to enter
+	if err == nil || err.Error() != "order o2: timeline has no geofence_entered" {
+		t.Errorf("got %v", err)
+	}
+	history, err := tracker.History(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Driving north, the right is east
+	var aside float64
+	for _, e := range history {
+		if e.Point != nil {
+			aside = math.Max(aside, geo.Distance(*e.Point, geo.Point{Lat: e.Point.Lat, Lng: 103.85}))
+		}
+	}
+	if aside < 95 || aside > 105 {
+		t.Errorf("strayed at most %.0fm from the route, want 100m", aside)
+	}
+}
+
+func TestLoadScenarioChecksEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	os.WriteFile(path, []byte("trips:\n  - events:\n      - at: 1m\n        stop: 1m\n        mode: walking\n"), 0o600)
+	if _, err := LoadScenario(path); err == nil || !strings.Contains(err.Error(), "trip 1, event 1: want exactly one") {
+		t.Errorf("got %v", err)
+	}
+	os.WriteFile(path, []byte("trips:\n  - drvier: d1\n"), 0o600)
+	if _, err := LoadScenario(path); err == nil {
+		t.Error("accepted an unknown field")
+	}
+}
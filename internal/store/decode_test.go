@@ -0,0 +1,38 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"location/internal/geo"
+)
+
+func TestDecodePoint(t *testing.T) {
+	f := pointFieldsOf(Current)
+	for _, tc := range []struct {
+		name   string
+		fields map[string]string
+		want   *geo.Point
+		err    string
+	}{
+		{"none", map[string]string{"mode": "driving"}, nil, ""},
+		{"numeric", map[string]string{"current_lat": "1.3", "current_lng": "103.85"}, &geo.Point{Lat: 1.3, Lng: 103.85}, ""},
+		{"legacy", map[string]string{"current": "1.300000,103.850000"}, &geo.Point{Lat: 1.3, Lng: 103.85}, ""},
+		// An older instance wrote the legacy field after a newer one wrote
+		// both
+		{"both", map[string]string{"current": "1.400000,103.950000", "current_lat": "1.3", "current_lng": "103.85"}, &geo.Point{Lat: 1.4, Lng: 103.95}, ""},
+		{"invalid lat", map[string]string{"current_lat": "north", "current_lng": "103.85"}, nil, "invalid current_lat"},
+		{"invalid lng", map[string]string{"current_lat": "1.3", "current_lng": ""}, nil, "invalid current_lng"},
+		{"invalid legacy", map[string]string{"current": "1.3"}, nil, "lat,lng"},
+	} {
+		p, err := decodePoint(tc.fields, f)
+		switch {
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%s: got error %v, want %q", tc.name, err, tc.err)
+		case tc.err == "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case (p == nil) != (tc.want == nil) || p != nil && *p != *tc.want:
+			t.Errorf("%s: got %v, want %v", tc.name, p, tc.want)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package store
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Memory keeps everything in process memory. It is meant for tests and local
+// development: state is lost on restart and not shared between instances.
+type Memory struct {
+	mu      sync.Mutex
+	orders  map[string]Order
+	history map[string][]Entry
+	drivers map[string]Driver
+	cache   map[string]cacheEntry
+	traffic map[profileKey]observation
+}
+
+// profileKey is a traffic profile hour of a cell and mode.
+type profileKey struct {
+	cell, mode string
+	hour       int
+}
+
+// observation sums up a traffic profile hour.
+type observation struct {
+	meters  float64
+	elapsed time.Duration
+}
+
+type cacheEntry struct {
+	travelTime time.Duration
+	body       []byte
+	expires    time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		orders:  map[string]Order{},
+		history: map[string][]Entry{},
+		drivers: map[string]Driver{},
+		cache:   map[string]cacheEntry{},
+		traffic: map[profileKey]observation{},
+	}
+}
+
+// update applies fn to the order, creating it if needed.
+func (s *Memory) update(orderID string, fn func(*Order)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		order = Order{ID: orderID}
+	}
+	fn(&order)
+	s.orders[orderID] = order
+}
+
+func (s *Memory) CreateOrder(ctx context.Context, orderID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[orderID]; ok {
+		return ErrExists
+	}
+	s.orders[orderID] = Order{ID: orderID, CreatedAt: at}
+	return nil
+}
+
+func (s *Memory) SetLocation(ctx context.Context, orderID, kind string, p geo.Point) error {
+	s.update(orderID, func(o *Order) {
+		switch kind {
+		case Current:
+			o.Current = &p
+			o.SeenAt = time.Now()
+			o.LostAt = time.Time{}
+		case Pickup:
+			o.Pickup = &p
+		default:
+			o.Target, o.Cost, o.RoutedFrom = &p, nil, nil
+		}
+	})
+	return nil
+}
+
+func (s *Memory) SetRoutedFrom(ctx context.Context, orderID string, p geo.Point) error {
+	s.update(orderID, func(o *Order) { o.RoutedFrom = &p })
+	return nil
+}
+
+func (s *Memory) SetMode(ctx context.Context, orderID, mode string) error {
+	s.update(orderID, func(o *Order) { o.Mode, o.Cost, o.RoutedFrom = mode, nil, nil })
+	return nil
+}
+
+func (s *Memory) SetCost(ctx context.Context, orderID string, c routing.Cost) error {
+	s.update(orderID, func(o *Order) { o.Cost = &c })
+	return nil
+}
+
+func (s *Memory) SetMetadata(ctx context.Context, orderID string, metadata map[string]string) error {
+	copied := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	s.update(orderID, func(o *Order) { o.Metadata = copied })
+	return nil
+}
+
+func (s *Memory) SetDeadline(ctx context.Context, orderID string, deadline time.Time) error {
+	s.update(orderID, func(o *Order) { o.Deadline = deadline })
+	return nil
+}
+
+func (s *Memory) SetSLA(ctx context.Context, orderID, state string) error {
+	s.update(orderID, func(o *Order) { o.SLA = state })
+	return nil
+}
+
+func (s *Memory) SetDepartAt(ctx context.Context, orderID string, at time.Time) error {
+	s.update(orderID, func(o *Order) { o.DepartAt = at })
+	return nil
+}
+
+func (s *Memory) SetHandlingTime(ctx context.Context, orderID string, d time.Duration) error {
+	s.update(orderID, func(o *Order) { o.HandlingTime = d })
+	return nil
+}
+
+func (s *Memory) SetReadyAt(ctx context.Context, orderID string, at time.Time) error {
+	s.update(orderID, func(o *Order) { o.ReadyAt = at })
+	return nil
+}
+
+func (s *Memory) SetPausedAt(ctx context.Context, orderID string, at time.Time) error {
+	s.update(orderID, func(o *Order) { o.PausedAt = at })
+	return nil
+}
+
+func (s *Memory) SetPreferences(ctx context.Context, orderID string, p Preferences) error {
+	s.update(orderID, func(o *Order) { o.Preferences = &p })
+	return nil
+}
+
+func (s *Memory) SetStop(ctx context.Context, orderID string, stop Stop) error {
+	s.update(orderID, func(o *Order) { o.Stop = &stop })
+	return nil
+}
+
+func (s *Memory) SetTelemetry(ctx context.Context, orderID string, t Telemetry) error {
+	s.update(orderID, func(o *Order) { o.Telemetry = &t })
+	return nil
+}
+
+func (s *Memory) SetGeofences(ctx context.Context, orderID string, fences []geo.Fence) error {
+	copied := append([]geo.Fence(nil), fences...)
+	s.update(orderID, func(o *Order) { o.Geofences = copied })
+	return nil
+}
+
+func (s *Memory) SetInside(ctx context.Context, orderID string, names []string) error {
+	copied := append([]string(nil), names...)
+	s.update(orderID, func(o *Order) { o.Inside = copied })
+	return nil
+}
+
+func (s *Memory) SetAlerts(ctx context.Context, orderID string, alerts []Alert) error {
+	copied := append([]Alert(nil), alerts...)
+	s.update(orderID, func(o *Order) { o.Alerts = copied })
+	return nil
+}
+
+func (s *Memory) MarkAlertFired(ctx context.Context, orderID, key string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	for _, fired := range order.Fired {
+		if fired == key {
+			return false, nil
+		}
+	}
+	order.Fired = append(append([]string(nil), order.Fired...), key)
+	s.orders[orderID] = order
+	return true, nil
+}
+
+func (s *Memory) SetPhase(ctx context.Context, orderID, phase string) error {
+	s.update(orderID, func(o *Order) { o.Phase = phase })
+	return nil
+}
+
+func (s *Memory) SetWeather(ctx context.Context, orderID, conditions string) error {
+	s.update(orderID, func(o *Order) { o.Weather = conditions })
+	return nil
+}
+
+func (s *Memory) SavePickupETA(ctx context.Context, orderID string, eta time.Duration) error {
+	s.update(orderID, func(o *Order) { o.PickupETA = eta })
+	return nil
+}
+
+func (s *Memory) SaveETARange(ctx context.Context, orderID string, low, high, drift time.Duration) error {
+	s.update(orderID, func(o *Order) { o.ETALow, o.ETAHigh, o.ETADrift = low, high, drift })
+	return nil
+}
+
+func (s *Memory) SaveETA(ctx context.Context, orderID string, eta time.Duration, at time.Time) error {
+	s.update(orderID, func(o *Order) { o.ETA, o.ETAAt = eta, at })
+	return nil
+}
+
+func (s *Memory) GetOrder(ctx context.Context, orderID string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return Order{}, ErrNotFound
+	}
+	return order, nil
+}
+
+func (s *Memory) GetOrders(ctx context.Context, ids []string) ([]Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orders := []Order{}
+	for _, id := range ids {
+		if order, ok := s.orders[id]; ok {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (s *Memory) ForEachOrder(ctx context.Context, fn func(Order) error) error {
+	s.mu.Lock()
+	orders := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	for _, o := range orders {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Memory) MarkTrackingLost(ctx context.Context, orderID string, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if !order.LostAt.IsZero() {
+		return false, nil
+	}
+	order.LostAt = at
+	s.orders[orderID] = order
+	return true, nil
+}
+
+func (s *Memory) NextSequence(ctx context.Context, orderID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	order.Sequence++
+	order.Revision++
+	s.orders[orderID] = order
+	return order.Sequence, nil
+}
+
+func (s *Memory) Sequence(ctx context.Context, orderID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return order.Sequence, nil
+}
+
+func (s *Memory) Touch(ctx context.Context, orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return ErrNotFound
+	}
+	order.Revision++
+	s.orders[orderID] = order
+	return nil
+}
+
+func (s *Memory) Revision(ctx context.Context, orderID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return order.Revision, nil
+}
+
+func (s *Memory) AddDistance(ctx context.Context, orderID string, meters float64) error {
+	s.update(orderID, func(o *Order) { o.Distance += meters })
+	return nil
+}
+
+func (s *Memory) SetOrderDriver(ctx context.Context, orderID, driverID string) error {
+	s.update(orderID, func(o *Order) { o.DriverID = driverID })
+	return nil
+}
+
+func (s *Memory) MarkDelivered(ctx context.Context, orderID string, d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		return ErrNotFound
+	}
+	if order.Delivery != nil {
+		return ErrDelivered
+	}
+	order.Delivery = &d
+	s.orders[orderID] = order
+	return nil
+}
+
+func (s *Memory) DeleteOrder(ctx context.Context, orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[orderID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.orders, orderID)
+	delete(s.history, orderID)
+	return nil
+}
+
+func (s *Memory) SaveDriver(ctx context.Context, d Driver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.drivers[d.ID]; ok {
+		d.Position, d.SeenAt = old.Position, old.SeenAt
+	}
+	d.Orders = append([]string{}, d.Orders...)
+	d.Metadata = maps.Clone(d.Metadata)
+	s.drivers[d.ID] = d
+	return nil
+}
+
+func (s *Memory) GetDriver(ctx context.Context, driverID string) (Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.drivers[driverID]
+	if !ok {
+		return Driver{}, ErrDriverNotFound
+	}
+	d.Orders = append([]string{}, d.Orders...)
+	return d, nil
+}
+
+func (s *Memory) SetDriverPosition(ctx context.Context, driverID string, p geo.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.drivers[driverID]
+	if !ok {
+		return ErrDriverNotFound
+	}
+	d.Position, d.SeenAt = &p, time.Now()
+	s.drivers[driverID] = d
+	return nil
+}
+
+func (s *Memory) ClearDriverPosition(ctx context.Context, driverID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.drivers[driverID]; ok {
+		d.Position, d.SeenAt = nil, time.Time{}
+		s.drivers[driverID] = d
+	}
+	return nil
+}
+
+func (s *Memory) OrdersWithin(ctx context.Context, box geo.Box) ([]Order, error) {
+	orders := []Order{}
+	err := s.ForEachOrder(ctx, func(o Order) error {
+		if o.Current != nil && o.Delivery == nil && box.Contains(*o.Current) {
+			orders = append(orders, o)
+		}
+		return nil
+	})
+	return orders, err
+}
+
+func (s *Memory) TargetsNear(ctx context.Context, p geo.Point, radius float64) ([]Order, error) {
+	orders := []Order{}
+	err := s.ForEachOrder(ctx, func(o Order) error {
+		if o.Target != nil && geo.Distance(p, *o.Target) <= radius {
+			orders = append(orders, o)
+		}
+		return nil
+	})
+	return orders, err
+}
+
+func (s *Memory) DriversWithin(ctx context.Context, box geo.Box) ([]Driver, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drivers := []Driver{}
+	for _, d := range s.drivers {
+		if d.Position != nil && box.Contains(*d.Position) {
+			d.Orders = append([]string{}, d.Orders...)
+			drivers = append(drivers, d)
+		}
+	}
+	sort.Slice(drivers, func(i, j int) bool { return drivers[i].ID < drivers[j].ID })
+	return drivers, nil
+}
+
+func (s *Memory) AppendHistory(ctx context.Context, orderID string, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := append(s.history[orderID], e)
+	if len(h) > HistoryLimit {
+		h = h[len(h)-HistoryLimit:]
+	}
+	s.history[orderID] = h
+	return nil
+}
+
+func (s *Memory) History(ctx context.Context, orderID string) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Entry(nil), s.history[orderID]...), nil
+}
+
+func (s *Memory) CachedTravelTime(ctx context.Context, key string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.cache[key]
+	if !ok || time.Now().After(e.expires) {
+		return 0, false
+	}
+	return e.travelTime, true
+}
+
+func (s *Memory) CacheTravelTime(ctx context.Context, key string, travelTime time.Duration, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[key] = cacheEntry{travelTime: travelTime, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *Memory) CachedResponse(ctx context.Context, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.cache[key]
+	if !ok || e.body == nil || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.body, true
+}
+
+func (s *Memory) CacheResponse(ctx context.Context, key string, body []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[key] = cacheEntry{body: body, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *Memory) ObserveSpeed(ctx context.Context, cell, mode string, hour int, meters float64, elapsed time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := profileKey{cell, mode, hour}
+	o := s.traffic[key]
+	o.meters += meters
+	o.elapsed += elapsed
+	s.traffic[key] = o
+	return nil
+}
+
+func (s *Memory) Observed(ctx context.Context, cell, mode string, hour int) (float64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.traffic[profileKey{cell, mode, hour}]
+	return o.meters, o.elapsed
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// keyPrefixes start the keys the service writes besides order hashes.
+var keyPrefixes = []string{historyKey(""), driverPrefix, "geo:", profilePrefix, lockPrefix, "response:", "eta-cache:", "eta-warm:", tenantPrefix}
+
+// MoveKeys moves the keys the service wrote under the namespace from, ""
+// for none, into s's namespace, returning how many it moved. Other
+// applications sharing the server keep theirs: besides keys of the
+// service's own kinds, such as histories, only the orders found in the
+// position and target indexes or with a history are moved. Keys already in
+// s's namespace are kept. It is meant to run while no instance serves.
+func (s *Redis) MoveKeys(ctx context.Context, from string) (int, error) {
+	if from == s.namespace {
+		return 0, nil
+	}
+	orders := map[string]bool{}
+	for _, index := range []string{orderIndex, targetIndex} {
+		ids, err := s.client.ZRange(ctx, from+index, 0, -1).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s%s: %v", from, index, err)
+		}
+		for _, id := range ids {
+			orders[id] = true
+		}
+	}
+	histories := s.client.Scan(ctx, 0, from+historyKey("*"), 100).Iterator()
+	for histories.Next(ctx) {
+		orders[strings.TrimPrefix(histories.Val(), from+historyKey(""))] = true
+	}
+	if err := histories.Err(); err != nil {
+		return 0, fmt.Errorf("failed to scan histories: %v", err)
+	}
+
+	moved := 0
+	iter := s.client.Scan(ctx, 0, from+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if s.namespace != "" && strings.HasPrefix(key, s.namespace) {
+			continue
+		}
+		k := strings.TrimPrefix(key, from)
+		if !ownKey(k, orders) {
+			continue
+		}
+		ok, err := s.client.RenameNX(ctx, key, s.namespace+k).Result()
+		if err != nil {
+			return moved, fmt.Errorf("failed to move %s: %v", key, err)
+		}
+		if !ok {
+			log.Printf("not moving %s, %s%s exists", key, s.namespace, k)
+			continue
+		}
+		moved++
+	}
+	return moved, iter.Err()
+}
+
+// ownKey reports whether the unprefixed key k was written by the service,
+// given the IDs of the orders known from the indexes.
+func ownKey(k string, orders map[string]bool) bool {
+	for _, prefix := range keyPrefixes {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return orders[k]
+}
@@ -0,0 +1,24 @@
+package store
+
+import "testing"
+
+func TestOwnKey(t *testing.T) {
+	orders := map[string]bool{"o1": true}
+	for k, want := range map[string]bool{
+		"o1":                    true,
+		"history:o1":            true,
+		"driver:d1":             true,
+		"geo:orders":            true,
+		"profile:w21z7:driving": true,
+		"lock:watchdog":         true,
+		"response:eta:o1:3":     true,
+		"tenant:acme:o2":        true,
+		// Another application's keys, and orders the indexes do not know
+		"sessions:abc": false,
+		"o2":           false,
+	} {
+		if got := ownKey(k, orders); got != want {
+			t.Errorf("ownKey(%q) = %t, want %t", k, got, want)
+		}
+	}
+}
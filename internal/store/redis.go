@@ -0,0 +1,1155 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Redis stores each order as a hash keyed by its ID, and its history as a
+// list of JSON entries under "history:" followed by the ID. Drivers are
+// hashes under "driver:" followed by their ID. The last known positions of
+// orders and drivers are also indexed in the GEO sets "geo:orders" and
+// "geo:drivers", and order targets in "geo:targets". Changes are relayed
+// between instances on the pub/sub channel "changes", and background jobs
+// are run by whichever instance holds their "lock:" key.
+//
+// Points, such as an order's current location or a driver's position, are
+// kept as numeric fields named after them, such as "current_lat" and
+// "current_lng". Older versions kept them in one field, such as "current",
+// as "lat,lng" with six decimals. Until told otherwise with
+// WithLegacyPoints, points are written in both layouts, so that instances
+// of those versions still running during a rolling deploy read them, and
+// not upgraded on read. Where a hash has both, the legacy field wins: older
+// versions only ever write that one, and this one writes it too or drops
+// it. The deploy order is thus:
+//
+//  1. roll out this version everywhere;
+//  2. turn legacy points off, with redis.legacy_points, everywhere;
+//  3. run "location migrate" to upgrade the hashes left.
+//
+// The keys of a tenant are the same, prefixed with "tenant:", its name and
+// a colon. All keys may be kept in a namespace, such as "esd:", that starts
+// them all, apart from other applications sharing the server.
+//
+// Order, driver and history reads of ReadOnly contexts go to the read
+// replicas, if any, in turn; everything else goes to the primary.
+type Redis struct {
+	client   *redis.Client
+	replicas *replicas
+	// namespace starts every key, and prefix every key of the tenant
+	namespace, prefix string
+	// legacyPoints writes points in the "lat,lng" fields too
+	legacyPoints bool
+}
+
+// replicas are the read replicas of a primary, shared by its tenants.
+type replicas struct {
+	clients []*redis.Client
+	next    atomic.Uint64
+}
+
+func NewRedis(client *redis.Client, replicaClients ...*redis.Client) *Redis {
+	return &Redis{client: client, replicas: &replicas{clients: replicaClients}, legacyPoints: true}
+}
+
+// WithLegacyPoints returns a store sharing s's connection that writes
+// points in the fields of versions before numeric ones too, or, once no
+// instance of those versions is left, only in numeric fields.
+func (s *Redis) WithLegacyPoints(on bool) *Redis {
+	t := *s
+	t.legacyPoints = on
+	return &t
+}
+
+// reader returns the client to read with for ctx: the next replica for
+// ReadOnly contexts, if there are replicas, and the primary otherwise.
+func (s *Redis) reader(ctx context.Context) *redis.Client {
+	if n := len(s.replicas.clients); n > 0 && readOnly(ctx) {
+		return s.replicas.clients[s.replicas.next.Add(1)%uint64(n)]
+	}
+	return s.client
+}
+
+// tenantPrefix starts every key of a tenant other than the default one.
+const tenantPrefix = "tenant:"
+
+// ForTenant returns a store sharing s's connection that keeps the state of
+// the named tenant apart from every other's.
+func (s *Redis) ForTenant(name string) *Redis {
+	t := *s
+	t.prefix = s.namespace + tenantPrefix + name + ":"
+	return &t
+}
+
+// InNamespace returns a store of the default tenant sharing s's connection
+// that keeps every key, its tenants' included, under namespace.
+func (s *Redis) InNamespace(namespace string) *Redis {
+	t := *s
+	t.namespace, t.prefix = namespace, namespace
+	return &t
+}
+
+// key namespaces a key for the store's tenant.
+func (s *Redis) key(k string) string {
+	return s.prefix + k
+}
+
+// Dial connects to the Redis server at url and verifies it is reachable.
+func Dial(ctx context.Context, url string) (*redis.Client, error) {
+	opt, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis.url: %v", err)
+	}
+	client := redis.NewClient(opt)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to reach Redis at %s: %v", opt.Addr, err)
+	}
+	return client, nil
+}
+
+func (s *Redis) Ping(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to reach Redis: %v", err)
+	}
+	return nil
+}
+
+// createOrder starts the hash of orders that do not exist yet.
+var createOrder = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "created_at", ARGV[1], "schema", ARGV[2])
+return 1
+`)
+
+func (s *Redis) CreateOrder(ctx context.Context, orderID string, at time.Time) error {
+	created, err := createOrder.Run(ctx, s.client, []string{s.key(orderID)}, at.Unix(), s.writesVersion()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to create order in Redis: %v", err)
+	}
+	if created == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (s *Redis) SetLocation(ctx context.Context, orderID, kind string, p geo.Point) error {
+	key, f := s.key(orderID), pointFieldsOf(kind)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.setLegacyPoint(ctx, pipe, key, f, p)
+		switch kind {
+		case Current:
+			pipe.HSet(ctx, key, f.lat, p.Lat, f.lng, p.Lng, "seen_at", time.Now().Unix())
+			pipe.HDel(ctx, key, "lost_at")
+			pipe.GeoAdd(ctx, s.key(orderIndex), &redis.GeoLocation{Name: orderID, Longitude: p.Lng, Latitude: p.Lat})
+		case Target:
+			pipe.HSet(ctx, key, f.lat, p.Lat, f.lng, p.Lng)
+			pipe.HDel(ctx, key, append([]string{"cost"}, routedFromFields...)...)
+			pipe.GeoAdd(ctx, s.key(targetIndex), &redis.GeoLocation{Name: orderID, Longitude: p.Lng, Latitude: p.Lat})
+		default:
+			pipe.HSet(ctx, key, f.lat, p.Lat, f.lng, p.Lng)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("failed to update location in Redis:")
+		return fmt.Errorf("failed to update location in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetRoutedFrom(ctx context.Context, orderID string, p geo.Point) error {
+	key, f := s.key(orderID), pointFieldsOf(routedFrom)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, f.lat, p.Lat, f.lng, p.Lng)
+		s.setLegacyPoint(ctx, pipe, key, f, p)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store routed origin in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetMode(ctx context.Context, orderID, mode string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.key(orderID), "mode", mode)
+		pipe.HDel(ctx, s.key(orderID), append([]string{"cost"}, routedFromFields...)...)
+		return nil
+	})
+	if err != nil {
+		log.Println("failed to update mode in Redis")
+		return fmt.Errorf("failed to update mode in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetMetadata(ctx context.Context, orderID string, metadata map[string]string) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "metadata", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update metadata in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetDeadline(ctx context.Context, orderID string, deadline time.Time) error {
+	err := s.client.HSet(ctx, s.key(orderID), "deadline", deadline.Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update deadline in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetSLA(ctx context.Context, orderID, state string) error {
+	err := s.client.HSet(ctx, s.key(orderID), "sla", state).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update SLA state in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetDepartAt(ctx context.Context, orderID string, at time.Time) error {
+	err := s.client.HSet(ctx, s.key(orderID), "depart_at", at.Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update departure time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetHandlingTime(ctx context.Context, orderID string, d time.Duration) error {
+	err := s.client.HSet(ctx, s.key(orderID), "handling_time", int64(d)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update handling time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetReadyAt(ctx context.Context, orderID string, at time.Time) error {
+	err := s.client.HSet(ctx, s.key(orderID), "ready_at", at.Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update ready time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetPausedAt(ctx context.Context, orderID string, at time.Time) error {
+	var err error
+	if at.IsZero() {
+		err = s.client.HDel(ctx, s.key(orderID), "paused_at").Err()
+	} else {
+		err = s.client.HSet(ctx, s.key(orderID), "paused_at", at.Unix()).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update paused time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetPreferences(ctx context.Context, orderID string, p Preferences) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "preferences", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update preferences in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetTelemetry(ctx context.Context, orderID string, t Telemetry) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "telemetry", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update telemetry in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetGeofences(ctx context.Context, orderID string, fences []geo.Fence) error {
+	data, err := json.Marshal(fences)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "geofences", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update geofences in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetInside(ctx context.Context, orderID string, names []string) error {
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "inside", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update geofence state in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetAlerts(ctx context.Context, orderID string, alerts []Alert) error {
+	data, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "alerts", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update alerts in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetPhase(ctx context.Context, orderID, phase string) error {
+	err := s.client.HSet(ctx, s.key(orderID), "phase", phase).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update phase in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetStop(ctx context.Context, orderID string, stop Stop) error {
+	data, err := json.Marshal(stop)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "stop", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update stop in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetCost(ctx context.Context, orderID string, c routing.Cost) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = s.client.HSet(ctx, s.key(orderID), "cost", data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update route cost in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetWeather(ctx context.Context, orderID, conditions string) error {
+	var err error
+	if conditions == "" {
+		err = s.client.HDel(ctx, s.key(orderID), "weather").Err()
+	} else {
+		err = s.client.HSet(ctx, s.key(orderID), "weather", conditions).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update weather in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SavePickupETA(ctx context.Context, orderID string, eta time.Duration) error {
+	err := s.client.HSet(ctx, s.key(orderID), "pickup_eta", int64(eta)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store pickup travel time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SaveETARange(ctx context.Context, orderID string, low, high, drift time.Duration) error {
+	err := s.client.HSet(ctx, s.key(orderID), "eta_low", int64(low), "eta_high", int64(high), "eta_drift", int64(drift)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store travel time range in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SaveETA(ctx context.Context, orderID string, eta time.Duration, at time.Time) error {
+	err := s.client.HSet(ctx, s.key(orderID), "eta", int64(eta), "eta_at", at.Unix()).Err()
+	if err != nil {
+		return fmt.Errorf("failed to store travel time in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) GetOrder(ctx context.Context, orderID string) (Order, error) {
+	fields, err := s.reader(ctx).HGetAll(ctx, s.key(orderID)).Result()
+	if err != nil {
+		log.Println("failed to get order from Redis")
+		return Order{}, fmt.Errorf("failed to get order from Redis: %v", err)
+	}
+	if len(fields) == 0 {
+		return Order{}, ErrNotFound
+	}
+	s.upgrade(ctx, s.key(orderID), fields)
+	return decodeOrder(orderID, fields)
+}
+
+func (s *Redis) GetOrders(ctx context.Context, ids []string) ([]Order, error) {
+	cmds := make([]*redis.StringStringMapCmd, len(ids))
+	_, err := s.reader(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGetAll(ctx, s.key(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get orders from Redis: %v", err)
+	}
+	orders := []Order{}
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+		s.upgrade(ctx, s.key(ids[i]), fields)
+		order, err := decodeOrder(ids[i], fields)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (s *Redis) ForEachOrder(ctx context.Context, fn func(Order) error) error {
+	iter := s.client.ScanType(ctx, 0, s.prefix+"*", 100, "hash").Iterator()
+	for iter.Next(ctx) {
+		id := strings.TrimPrefix(iter.Val(), s.prefix)
+		if strings.HasPrefix(id, driverPrefix) || !s.ownsHash(id) {
+			continue
+		}
+		order, err := s.GetOrder(ctx, id)
+		if err == ErrNotFound {
+			// Deleted since the scan saw it
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// nextSequence counts up the event sequence of orders that exist.
+var nextSequence = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+redis.call("HINCRBY", KEYS[1], "rev", 1)
+return redis.call("HINCRBY", KEYS[1], "seq", 1)
+`)
+
+func (s *Redis) NextSequence(ctx context.Context, orderID string) (int64, error) {
+	seq, err := nextSequence.Run(ctx, s.client, []string{s.key(orderID)}).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to number event in Redis: %v", err)
+	}
+	if seq < 0 {
+		return 0, ErrNotFound
+	}
+	return seq, nil
+}
+
+// sequence reads the event sequence of orders that exist.
+var sequence = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return tonumber(redis.call("HGET", KEYS[1], "seq") or 0)
+`)
+
+func (s *Redis) Sequence(ctx context.Context, orderID string) (int64, error) {
+	seq, err := sequence.Run(ctx, s.client, []string{s.key(orderID)}).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read event sequence from Redis: %v", err)
+	}
+	if seq < 0 {
+		return 0, ErrNotFound
+	}
+	return seq, nil
+}
+
+// touch counts a change to orders that exist.
+var touch = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HINCRBY", KEYS[1], "rev", 1)
+`)
+
+func (s *Redis) Touch(ctx context.Context, orderID string) error {
+	rev, err := touch.Run(ctx, s.client, []string{s.key(orderID)}).Int64()
+	if err != nil {
+		return fmt.Errorf("failed to count order change in Redis: %v", err)
+	}
+	if rev < 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// revision reads the change count of orders that exist.
+var revision = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return tonumber(redis.call("HGET", KEYS[1], "rev") or 0)
+`)
+
+func (s *Redis) Revision(ctx context.Context, orderID string) (int64, error) {
+	rev, err := revision.Run(ctx, s.client, []string{s.key(orderID)}).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read order revision from Redis: %v", err)
+	}
+	if rev < 0 {
+		return 0, ErrNotFound
+	}
+	return rev, nil
+}
+
+// markTrackingLost sets lost_at on orders that exist and do not have it.
+var markTrackingLost = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HSETNX", KEYS[1], "lost_at", ARGV[1])
+`)
+
+func (s *Redis) MarkTrackingLost(ctx context.Context, orderID string, at time.Time) (bool, error) {
+	set, err := markTrackingLost.Run(ctx, s.client, []string{s.key(orderID)}, at.Unix()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark tracking lost in Redis: %v", err)
+	}
+	if set < 0 {
+		return false, ErrNotFound
+	}
+	return set == 1, nil
+}
+
+// alertPrefix starts the order hash fields recording fired alerts; each
+// holds when its alert fired.
+const alertPrefix = "alert:"
+
+// setOnce sets a field of an existing order unless it is set, returning 1
+// if it did, 0 if it was set, and -1 if there is no such order.
+var setOnce = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HSETNX", KEYS[1], ARGV[1], ARGV[2])
+`)
+
+func (s *Redis) MarkAlertFired(ctx context.Context, orderID, key string, at time.Time) (bool, error) {
+	set, err := setOnce.Run(ctx, s.client, []string{s.key(orderID)}, alertPrefix+key, at.Unix()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark alert fired in Redis: %v", err)
+	}
+	if set < 0 {
+		return false, ErrNotFound
+	}
+	return set == 1, nil
+}
+
+func (s *Redis) AddDistance(ctx context.Context, orderID string, meters float64) error {
+	err := s.client.HIncrByFloat(ctx, s.key(orderID), "distance", meters).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add distance in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) SetOrderDriver(ctx context.Context, orderID, driverID string) error {
+	err := s.client.HSet(ctx, s.key(orderID), "driver_id", driverID).Err()
+	if err != nil {
+		return fmt.Errorf("failed to update driver of order in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) MarkDelivered(ctx context.Context, orderID string, d Delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	set, err := setOnce.Run(ctx, s.client, []string{s.key(orderID)}, "delivery", data).Int()
+	if err != nil {
+		return fmt.Errorf("failed to mark order delivered in Redis: %v", err)
+	}
+	switch set {
+	case -1:
+		return ErrNotFound
+	case 0:
+		return ErrDelivered
+	}
+	// Delivered orders no longer move, so they leave the fleet map
+	if err := s.client.ZRem(ctx, s.key(orderIndex), orderID).Err(); err != nil {
+		log.Printf("failed to unindex delivered order %s: %v", orderID, err)
+	}
+	return nil
+}
+
+func (s *Redis) DeleteOrder(ctx context.Context, orderID string) error {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, s.key(orderID))
+		pipe.Del(ctx, s.key(historyKey(orderID)))
+		pipe.ZRem(ctx, s.key(orderIndex), orderID)
+		pipe.ZRem(ctx, s.key(targetIndex), orderID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete order from Redis: %v", err)
+	}
+	if deleted.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const driverPrefix = "driver:"
+
+// The GEO sets indexing the last known position of each order and driver,
+// and where each order is going.
+const (
+	orderIndex  = "geo:orders"
+	driverIndex = "geo:drivers"
+	targetIndex = "geo:targets"
+)
+
+func (s *Redis) SaveDriver(ctx context.Context, d Driver) error {
+	key := s.key(driverPrefix + d.ID)
+	metadata, err := json.Marshal(d.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode driver metadata: %v", err)
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "status", d.Status, "orders", strings.Join(d.Orders, ","))
+		if len(d.Metadata) > 0 {
+			pipe.HSet(ctx, key, "metadata", metadata)
+		} else {
+			pipe.HDel(ctx, key, "metadata")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save driver in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) GetDriver(ctx context.Context, driverID string) (Driver, error) {
+	fields, err := s.reader(ctx).HGetAll(ctx, s.key(driverPrefix+driverID)).Result()
+	if err != nil {
+		return Driver{}, fmt.Errorf("failed to get driver from Redis: %v", err)
+	}
+	if len(fields) == 0 {
+		return Driver{}, ErrDriverNotFound
+	}
+	s.upgrade(ctx, s.key(driverPrefix+driverID), fields)
+	d := Driver{ID: driverID, Status: fields["status"], Orders: []string{}}
+	if v := fields["orders"]; v != "" {
+		d.Orders = strings.Split(v, ",")
+	}
+	p, err := decodePoint(fields, pointFieldsOf(position))
+	if err != nil {
+		return d, fmt.Errorf("failed to parse driver position: %v", err)
+	}
+	d.Position = p
+	if v, err := strconv.ParseInt(fields["seen_at"], 10, 64); err == nil {
+		d.SeenAt = time.Unix(v, 0)
+	}
+	if v, ok := fields["metadata"]; ok {
+		if err := json.Unmarshal([]byte(v), &d.Metadata); err != nil {
+			return d, fmt.Errorf("failed to parse driver metadata: %v", err)
+		}
+	}
+	return d, nil
+}
+
+// setDriverPosition only updates drivers that exist, so a stray report
+// cannot create a half-registered driver.
+var setDriverPosition = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "position_lat", ARGV[3], "position_lng", ARGV[2], "seen_at", ARGV[1])
+if ARGV[5] == "" then
+	redis.call("HDEL", KEYS[1], "position")
+else
+	redis.call("HSET", KEYS[1], "position", ARGV[5])
+end
+redis.call("GEOADD", KEYS[2], ARGV[2], ARGV[3], ARGV[4])
+return 1
+`)
+
+func (s *Redis) SetDriverPosition(ctx context.Context, driverID string, p geo.Point) error {
+	keys := []string{s.key(driverPrefix + driverID), s.key(driverIndex)}
+	legacy := ""
+	if s.legacyPoints {
+		legacy = p.String()
+	}
+	ok, err := setDriverPosition.Run(ctx, s.client, keys, time.Now().Unix(), p.Lng, p.Lat, driverID, legacy).Int()
+	if err != nil {
+		return fmt.Errorf("failed to update driver position in Redis: %v", err)
+	}
+	if ok == 0 {
+		return ErrDriverNotFound
+	}
+	return nil
+}
+
+func (s *Redis) ClearDriverPosition(ctx context.Context, driverID string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		f := pointFieldsOf(position)
+		pipe.HDel(ctx, s.key(driverPrefix+driverID), f.legacy, f.lat, f.lng, "seen_at")
+		pipe.ZRem(ctx, s.key(driverIndex), driverID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear driver position in Redis: %v", err)
+	}
+	return nil
+}
+
+// searchBox returns the members of a GEO set within the box. GEOSEARCH
+// measures boxes in meters around a center, which covers slightly more
+// than the box at high latitudes; callers check the exact bounds.
+func (s *Redis) searchBox(ctx context.Context, key string, box geo.Box) ([]string, error) {
+	center := box.Center()
+	width, height := box.Size()
+	return s.reader(ctx).GeoSearch(ctx, s.key(key), &redis.GeoSearchQuery{
+		Longitude: center.Lng,
+		Latitude:  center.Lat,
+		BoxWidth:  width,
+		BoxHeight: height,
+		BoxUnit:   "m",
+	}).Result()
+}
+
+// getOrders returns the orders among ids that match keep, sorted by ID.
+func (s *Redis) getOrders(ctx context.Context, ids []string, keep func(Order) bool) ([]Order, error) {
+	sort.Strings(ids)
+	found, err := s.GetOrders(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	orders := []Order{}
+	for _, order := range found {
+		if keep(order) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (s *Redis) OrdersWithin(ctx context.Context, box geo.Box) ([]Order, error) {
+	ids, err := s.searchBox(ctx, orderIndex, box)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search orders in Redis: %v", err)
+	}
+	return s.getOrders(ctx, ids, func(o Order) bool {
+		return o.Current != nil && o.Delivery == nil && box.Contains(*o.Current)
+	})
+}
+
+func (s *Redis) TargetsNear(ctx context.Context, p geo.Point, radius float64) ([]Order, error) {
+	ids, err := s.client.GeoSearch(ctx, s.key(targetIndex), &redis.GeoSearchQuery{
+		Longitude:  p.Lng,
+		Latitude:   p.Lat,
+		Radius:     radius,
+		RadiusUnit: "m",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search order targets in Redis: %v", err)
+	}
+	return s.getOrders(ctx, ids, func(o Order) bool {
+		return o.Target != nil && geo.Distance(p, *o.Target) <= radius
+	})
+}
+
+func (s *Redis) DriversWithin(ctx context.Context, box geo.Box) ([]Driver, error) {
+	ids, err := s.searchBox(ctx, driverIndex, box)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search drivers in Redis: %v", err)
+	}
+	sort.Strings(ids)
+	drivers := []Driver{}
+	for _, id := range ids {
+		d, err := s.GetDriver(ctx, id)
+		if err == ErrDriverNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.Position != nil && box.Contains(*d.Position) {
+			drivers = append(drivers, d)
+		}
+	}
+	return drivers, nil
+}
+
+func historyKey(orderID string) string {
+	return "history:" + orderID
+}
+
+// entryBuffers hold history entries being encoded; every location update
+// appends one.
+var entryBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func (s *Redis) AppendHistory(ctx context.Context, orderID string, e Entry) error {
+	buf := entryBuffers.Get().(*bytes.Buffer)
+	defer entryBuffers.Put(buf)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(e); err != nil {
+		return err
+	}
+	// The pipeline has written the entry by the time it returns
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	key := s.key(historyKey(orderID))
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.LTrim(ctx, key, -HistoryLimit, -1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to append history in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) History(ctx context.Context, orderID string) ([]Entry, error) {
+	values, err := s.reader(ctx).LRange(ctx, s.key(historyKey(orderID)), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history from Redis: %v", err)
+	}
+	entries := make([]Entry, 0, len(values))
+	for _, v := range values {
+		var e Entry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			log.Printf("skipping unreadable history entry of order %s: %v", orderID, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *Redis) CachedTravelTime(ctx context.Context, key string) (time.Duration, bool) {
+	val, err := s.client.Get(ctx, s.key(key)).Int64()
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(val), true
+}
+
+func (s *Redis) CacheTravelTime(ctx context.Context, key string, travelTime time.Duration, ttl time.Duration) error {
+	return s.client.Set(ctx, s.key(key), int64(travelTime), ttl).Err()
+}
+
+func (s *Redis) CachedResponse(ctx context.Context, key string) ([]byte, bool) {
+	body, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func (s *Redis) CacheResponse(ctx context.Context, key string, body []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.key(key), body, ttl).Err()
+}
+
+// profilePrefix starts the keys of traffic profiles.
+const profilePrefix = "profile:"
+
+// profileKey is the hash of a cell's traffic profile for a mode, holding
+// the meters and milliseconds observed per hour of the week.
+func (s *Redis) profileKey(cell, mode string) string {
+	return s.key(profilePrefix + cell + ":" + mode)
+}
+
+func (s *Redis) ObserveSpeed(ctx context.Context, cell, mode string, hour int, meters float64, elapsed time.Duration) error {
+	key := s.profileKey(cell, mode)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrByFloat(ctx, key, fmt.Sprintf("%d:m", hour), meters)
+		pipe.HIncrBy(ctx, key, fmt.Sprintf("%d:ms", hour), elapsed.Milliseconds())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update traffic profile in Redis: %v", err)
+	}
+	return nil
+}
+
+func (s *Redis) Observed(ctx context.Context, cell, mode string, hour int) (float64, time.Duration) {
+	vals, err := s.client.HMGet(ctx, s.profileKey(cell, mode), fmt.Sprintf("%d:m", hour), fmt.Sprintf("%d:ms", hour)).Result()
+	if err != nil || len(vals) != 2 {
+		return 0, 0
+	}
+	m, _ := vals[0].(string)
+	ms, _ := vals[1].(string)
+	meters, _ := strconv.ParseFloat(m, 64)
+	millis, _ := strconv.ParseInt(ms, 10, 64)
+	return meters, time.Duration(millis) * time.Millisecond
+}
+
+// Names of the points kept besides the order location kinds.
+const (
+	routedFrom = "routed_from"
+	position   = "position"
+)
+
+// pointFields are the hash fields of a point: the numeric latitude and
+// longitude, and the "lat,lng" field older versions wrote.
+type pointFields struct {
+	legacy, lat, lng string
+}
+
+var pointFieldsByName = func() map[string]pointFields {
+	m := map[string]pointFields{}
+	for _, name := range []string{Current, Target, Pickup, routedFrom, position} {
+		m[name] = pointFields{legacy: name, lat: name + "_lat", lng: name + "_lng"}
+	}
+	return m
+}()
+
+func pointFieldsOf(name string) pointFields {
+	return pointFieldsByName[name]
+}
+
+// routedFromFields are every field of the routed origin, dropped whenever
+// the route it was the origin of changes.
+var routedFromFields = func() []string {
+	f := pointFieldsOf(routedFrom)
+	return []string{f.legacy, f.lat, f.lng}
+}()
+
+// setLegacyPoint writes p in the legacy field of f, or drops that field once
+// legacy points are off.
+func (s *Redis) setLegacyPoint(ctx context.Context, pipe redis.Pipeliner, key string, f pointFields, p geo.Point) {
+	if s.legacyPoints {
+		pipe.HSet(ctx, key, f.legacy, p.String())
+	} else {
+		pipe.HDel(ctx, key, f.legacy)
+	}
+}
+
+// decodePoint reads the point kept in f from a hash, in either layout, or
+// nil if there is none. The legacy field wins over the numeric ones, as
+// only older versions leave it stale.
+func decodePoint(fields map[string]string, f pointFields) (*geo.Point, error) {
+	if v, ok := fields[f.legacy]; ok {
+		p, err := geo.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		return &p, nil
+	}
+	if lat, ok := fields[f.lat]; ok {
+		var p geo.Point
+		var err error
+		if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", f.lat, err)
+		}
+		if p.Lng, err = strconv.ParseFloat(fields[f.lng], 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", f.lng, err)
+		}
+		return &p, nil
+	}
+	return nil, nil
+}
+
+// decodeOrder maps the fields of an order hash onto an Order.
+func decodeOrder(orderID string, fields map[string]string) (Order, error) {
+	order := Order{ID: orderID, Mode: fields["mode"], Phase: fields["phase"], SLA: fields["sla"], DriverID: fields["driver_id"], Weather: fields["weather"]}
+	for _, dst := range [...]struct {
+		name  string
+		point **geo.Point
+	}{{Current, &order.Current}, {Target, &order.Target}, {Pickup, &order.Pickup}, {routedFrom, &order.RoutedFrom}} {
+		p, err := decodePoint(fields, pointFieldsOf(dst.name))
+		if err != nil {
+			log.Printf("failed to parse %s location", dst.name)
+			return order, fmt.Errorf("failed to parse %s location: %v", dst.name, err)
+		}
+		*dst.point = p
+	}
+	if v, ok := fields["metadata"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Metadata); err != nil {
+			return order, fmt.Errorf("failed to parse metadata: %v", err)
+		}
+	}
+	if v, ok := fields["delivery"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Delivery); err != nil {
+			return order, fmt.Errorf("failed to parse delivery: %v", err)
+		}
+	}
+	if v, ok := fields["preferences"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Preferences); err != nil {
+			return order, fmt.Errorf("failed to parse preferences: %v", err)
+		}
+	}
+	if v, ok := fields["cost"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Cost); err != nil {
+			return order, fmt.Errorf("failed to parse route cost: %v", err)
+		}
+	}
+	if v, ok := fields["stop"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Stop); err != nil {
+			return order, fmt.Errorf("failed to parse stop: %v", err)
+		}
+	}
+	if v, ok := fields["telemetry"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Telemetry); err != nil {
+			return order, fmt.Errorf("failed to parse telemetry: %v", err)
+		}
+	}
+	if v, ok := fields["geofences"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Geofences); err != nil {
+			return order, fmt.Errorf("failed to parse geofences: %v", err)
+		}
+	}
+	if v, ok := fields["inside"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Inside); err != nil {
+			return order, fmt.Errorf("failed to parse geofence state: %v", err)
+		}
+	}
+	if v, ok := fields["alerts"]; ok {
+		if err := json.Unmarshal([]byte(v), &order.Alerts); err != nil {
+			return order, fmt.Errorf("failed to parse alerts: %v", err)
+		}
+	}
+	for field := range fields {
+		if key, ok := strings.CutPrefix(field, alertPrefix); ok {
+			order.Fired = append(order.Fired, key)
+		}
+	}
+	sort.Strings(order.Fired)
+	if v, err := strconv.ParseInt(fields["eta"], 10, 64); err == nil {
+		order.ETA = time.Duration(v)
+	}
+	for field, dst := range map[string]*time.Duration{"eta_low": &order.ETALow, "eta_high": &order.ETAHigh, "eta_drift": &order.ETADrift} {
+		if v, err := strconv.ParseInt(fields[field], 10, 64); err == nil {
+			*dst = time.Duration(v)
+		}
+	}
+	if v, err := strconv.ParseFloat(fields["distance"], 64); err == nil {
+		order.Distance = v
+	}
+	if v, err := strconv.ParseInt(fields["pickup_eta"], 10, 64); err == nil {
+		order.PickupETA = time.Duration(v)
+	}
+	if v, err := strconv.ParseInt(fields["eta_at"], 10, 64); err == nil {
+		order.ETAAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["created_at"], 10, 64); err == nil {
+		order.CreatedAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["seen_at"], 10, 64); err == nil {
+		order.SeenAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["deadline"], 10, 64); err == nil {
+		order.Deadline = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["depart_at"], 10, 64); err == nil {
+		order.DepartAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["seq"], 10, 64); err == nil {
+		order.Sequence = v
+	}
+	if v, err := strconv.ParseInt(fields["rev"], 10, 64); err == nil {
+		order.Revision = v
+	}
+	if v, err := strconv.ParseInt(fields["lost_at"], 10, 64); err == nil {
+		order.LostAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["paused_at"], 10, 64); err == nil {
+		order.PausedAt = time.Unix(v, 0)
+	}
+	if v, err := strconv.ParseInt(fields["handling_time"], 10, 64); err == nil {
+		order.HandlingTime = time.Duration(v)
+	}
+	if v, err := strconv.ParseInt(fields["ready_at"], 10, 64); err == nil {
+		order.ReadyAt = time.Unix(v, 0)
+	}
+	return order, nil
+}
+
+// changesChannel is the pub/sub channel of the change backplane.
+const changesChannel = "changes"
+
+// PublishChange sends message to every instance subscribed to the changes
+// of the store's tenant.
+func (s *Redis) PublishChange(ctx context.Context, message string) error {
+	return s.client.Publish(ctx, s.key(changesChannel), message).Err()
+}
+
+// SubscribeChanges calls fn with every message published on the changes
+// of the store's tenant until ctx is done. Lost connections are
+// re-established, and messages sent meanwhile are missed.
+func (s *Redis) SubscribeChanges(ctx context.Context, fn func(message string)) error {
+	sub := s.client.Subscribe(ctx, s.key(changesChannel))
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to changes: %v", err)
+	}
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg := <-messages:
+			fn(msg.Payload)
+		}
+	}
+}
+
+// lockPrefix starts the keys of background job locks.
+const lockPrefix = "lock:"
+
+// takeLock sets a lock held by nobody, or extends it for its holder.
+var takeLock = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func (s *Redis) Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	held, err := takeLock.Run(ctx, s.client, []string{s.key(lockPrefix + name)}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to take lock %s in Redis: %v", name, err)
+	}
+	return held == 1, nil
+}
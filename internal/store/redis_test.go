@@ -0,0 +1,254 @@
+//go:build redis
+
+package store
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"location/internal/geo"
+)
+
+// These tests run the Redis store against miniredis:
+//
+//	go test -tags redis ./internal/store
+
+func newRedis(t *testing.T) *Redis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client)
+}
+
+func TestTenantFleet(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t)
+	acme := s.ForTenant("acme")
+	p := geo.Point{Lat: 1.30, Lng: 103.85}
+	box := geo.Box{Min: geo.Point{Lat: 1.29, Lng: 103.84}, Max: geo.Point{Lat: 1.31, Lng: 103.86}}
+
+	if err := acme.SetLocation(ctx, "o1", Current, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := acme.SaveDriver(ctx, Driver{ID: "d1", Status: "busy", Orders: []string{"o1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := acme.SetDriverPosition(ctx, "d1", p); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := acme.OrdersWithin(ctx, box)
+	if err != nil || len(orders) != 1 || orders[0].ID != "o1" {
+		t.Errorf("tenant orders within: %+v, %v", orders, err)
+	}
+	drivers, err := acme.DriversWithin(ctx, box)
+	if err != nil || len(drivers) != 1 || drivers[0].ID != "d1" {
+		t.Errorf("tenant drivers within: %+v, %v", drivers, err)
+	}
+	orders, _ = s.OrdersWithin(ctx, box)
+	drivers, _ = s.DriversWithin(ctx, box)
+	if len(orders) != 0 || len(drivers) != 0 {
+		t.Errorf("default tenant sees %+v and %+v", orders, drivers)
+	}
+}
+
+func TestPrimaryReadsSkipLaggingReplicas(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := newRedis(t), newRedis(t)
+	s := NewRedis(primary.client, replica.client)
+	if err := s.SetLocation(ctx, "o1", Target, geo.Point{Lat: 1.30, Lng: 103.85}); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := s.NextSequence(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The replica has yet to see the order
+	if _, err := s.GetOrder(ReadOnly(ctx), "o1"); err != ErrNotFound {
+		t.Errorf("replica read got %v", err)
+	}
+	order, err := s.GetOrder(Primary(ReadOnly(ctx)), "o1")
+	if err != nil || order.Sequence != seq {
+		t.Errorf("primary read got %+v, %v, want sequence %d", order, err, seq)
+	}
+}
+
+func TestRevisionCountsEveryChange(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t)
+	if err := s.Touch(ctx, "o1"); err != ErrNotFound {
+		t.Errorf("touching an unknown order got %v", err)
+	}
+	if _, err := s.Revision(ctx, "o1"); err != ErrNotFound {
+		t.Errorf("revision of an unknown order got %v", err)
+	}
+	if err := s.SetLocation(ctx, "o1", Target, geo.Point{Lat: 1.30, Lng: 103.85}); err != nil {
+		t.Fatal(err)
+	}
+	s.NextSequence(ctx, "o1")
+	s.Touch(ctx, "o1")
+	if rev, err := s.Revision(ctx, "o1"); err != nil || rev != 2 {
+		t.Errorf("revision = %d, %v, want 2", rev, err)
+	}
+	if order, _ := s.GetOrder(ctx, "o1"); order.Sequence != 1 || order.Revision != 2 {
+		t.Errorf("order numbered %d, revised %d", order.Sequence, order.Revision)
+	}
+}
+
+func TestLegacyPointsDuringRollingDeploy(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t)
+	if err := s.SetLocation(ctx, "o1", Current, geo.Point{Lat: 1.30, Lng: 103.85}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.client.HGet(ctx, "o1", "current").Result(); v != "1.300000,103.850000" {
+		t.Errorf("legacy field holds %q", v)
+	}
+
+	// An instance of an older version moves the courier
+	s.client.HSet(ctx, "o1", "current", "1.310000,103.860000")
+	if order, err := s.GetOrder(ctx, "o1"); err != nil || *order.Current != (geo.Point{Lat: 1.31, Lng: 103.86}) {
+		t.Errorf("got %v, %v", order.Current, err)
+	}
+	if _, err := s.Migrate(ctx); err != ErrLegacyPoints {
+		t.Errorf("migrated with legacy points on: %v", err)
+	}
+
+	// Once they are all gone
+	s = s.WithLegacyPoints(false)
+	if err := s.SetLocation(ctx, "o1", Current, geo.Point{Lat: 1.32, Lng: 103.87}); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.client.HExists(ctx, "o1", "current").Result(); exists {
+		t.Error("legacy field kept")
+	}
+	if order, err := s.GetOrder(ctx, "o1"); err != nil || *order.Current != (geo.Point{Lat: 1.32, Lng: 103.87}) {
+		t.Errorf("got %v, %v", order.Current, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t).WithLegacyPoints(false)
+	acme := s.ForTenant("acme")
+	// Written by older versions, the numeric fields by one before the
+	// legacy field
+	s.client.HSet(ctx, "o1", "current", "1.310000,103.860000", "current_lat", "1.3", "current_lng", "103.85", "target", "nowhere")
+	s.client.HSet(ctx, "driver:d1", "status", "idle", "position", "1.300000,103.850000")
+	s.client.HSet(ctx, "tenant:acme:o2", "current", "1.300000,103.850000")
+	if err := s.ObserveSpeed(ctx, "w21z7", "driving", 10, 500, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.Migrate(ctx); err != nil || n != 2 {
+		t.Fatalf("upgraded %d, %v", n, err)
+	}
+	o1 := s.client.HGetAll(ctx, "o1").Val()
+	if o1["schema"] != "1" || o1["current_lat"] != "1.310000" || o1["current_lng"] != "103.860000" || o1["current"] != "" {
+		t.Errorf("upgraded order to %v", o1)
+	}
+	// Unreadable points are left to report
+	if o1["target"] != "nowhere" {
+		t.Errorf("upgraded target to %v", o1)
+	}
+	if d1 := s.client.HGetAll(ctx, "driver:d1").Val(); d1["position_lat"] != "1.300000" || d1["position"] != "" {
+		t.Errorf("upgraded driver to %v", d1)
+	}
+	// Traffic profiles are not orders, and the default tenant's migration
+	// leaves other tenants' orders to theirs
+	for _, key := range []string{"profile:w21z7:driving", "tenant:acme:o2"} {
+		if s.client.HExists(ctx, key, "schema").Val() {
+			t.Errorf("stamped %s", key)
+		}
+	}
+	if n, err := acme.Migrate(ctx); err != nil || n != 1 {
+		t.Errorf("upgraded %d of tenant, %v", n, err)
+	}
+	if n, err := s.Migrate(ctx); err != nil || n != 0 {
+		t.Errorf("upgraded %d again, %v", n, err)
+	}
+}
+
+func TestSchemaVersionStamps(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t)
+	at := time.Now()
+	if err := s.CreateOrder(ctx, "o1", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WithLegacyPoints(false).CreateOrder(ctx, "o2", at); err != nil {
+		t.Fatal(err)
+	}
+	// Orders created while legacy points are written are left to upgrade
+	if v := s.client.HGet(ctx, "o1", "schema").Val(); v != "0" {
+		t.Errorf("stamped %q with legacy points", v)
+	}
+	if v := s.client.HGet(ctx, "o2", "schema").Val(); v != strconv.Itoa(SchemaVersion) {
+		t.Errorf("stamped %q", v)
+	}
+
+	// Reads upgrade what they find old, once legacy points are off
+	s.client.HSet(ctx, "o3", "current", "1.300000,103.850000")
+	if _, err := s.GetOrder(ctx, "o3"); err != nil || s.client.HExists(ctx, "o3", "schema").Val() {
+		t.Errorf("upgraded on read with legacy points: %v", err)
+	}
+	if _, err := s.WithLegacyPoints(false).GetOrder(ctx, "o3"); err != nil || s.client.HExists(ctx, "o3", "current").Val() {
+		t.Errorf("not upgraded on read: %v", err)
+	}
+}
+
+func TestMoveKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newRedis(t)
+	if err := s.SetLocation(ctx, "o1", Current, geo.Point{Lat: 1.30, Lng: 103.85}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AppendHistory(ctx, "o2", Entry{Kind: Current, Point: &geo.Point{Lat: 1.30, Lng: 103.85}}); err != nil {
+		t.Fatal(err)
+	}
+	s.client.Set(ctx, "sessions:abc", "another application's", 0)
+	s.client.HSet(ctx, "o3", "not", "ours")
+	// Already moved, and written since
+	s.client.Set(ctx, "lock:watchdog", "old", 0)
+	s.client.Set(ctx, "esd:lock:watchdog", "new", 0)
+
+	esd := s.InNamespace("esd:")
+	n, err := esd.MoveKeys(ctx, "")
+	if err != nil || n != 3 {
+		t.Errorf("moved %d, %v", n, err)
+	}
+	for _, key := range []string{"esd:o1", "esd:geo:orders", "esd:history:o2", "sessions:abc", "o3", "lock:watchdog"} {
+		if s.client.Exists(ctx, key).Val() != 1 {
+			t.Errorf("no %s", key)
+		}
+	}
+	if v := s.client.Get(ctx, "esd:lock:watchdog").Val(); v != "new" {
+		t.Errorf("overwrote a moved key with %q", v)
+	}
+	if order, err := esd.GetOrder(ctx, "o1"); err != nil || order.Current == nil {
+		t.Errorf("moved order reads %+v, %v", order, err)
+	}
+}
+
+func BenchmarkAppendHistory(b *testing.B) {
+	ctx := context.Background()
+	server := miniredis.RunT(b)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	b.Cleanup(func() { client.Close() })
+	s := NewRedis(client)
+	e := Entry{Kind: Current, Point: &geo.Point{Lat: 1.3456, Lng: 103.8567}, Time: time.Now()}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.AppendHistory(ctx, "o1", e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Order and driver hashes are stamped with the version of the layout they
+// were last upgraded to in their "schema" field; unstamped ones are version
+// 0. Each step of the schema upgrades hashes of the version before it, in a
+// Lua script that checks and stamps the version, so a step runs at most once
+// per hash and never races the service's own writes. Reads decode every
+// layout still in use, upgrade what they find old, and Migrate upgrades
+// everything at once.
+const schemaField = "schema"
+
+// schemaStep upgrades a hash to the version after the one it is at.
+type schemaStep struct {
+	name   string
+	script *redis.Script
+}
+
+// schemaScript makes a step of body, which runs on the hash KEYS[1] only if
+// it exists and is of the version before ARGV[1], and stamps ARGV[1] after.
+func schemaScript(body string) *redis.Script {
+	return redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if tonumber(redis.call("HGET", KEYS[1], "schema") or "0") >= tonumber(ARGV[1]) then
+	return 0
+end
+` + body + `
+redis.call("HSET", KEYS[1], "schema", ARGV[1])
+return 1
+`)
+}
+
+// schema lists the steps from the first layout; version n is the layout
+// after the nth step. Steps are only ever added.
+var schema = []schemaStep{
+	{
+		// Points move from "lat,lng" strings to numeric fields, replacing
+		// the numeric ones as decodePoint prefers the strings; unreadable
+		// ones are left for decodePoint to report
+		name: "numeric point fields",
+		script: schemaScript(`
+for _, name in ipairs({"current", "target", "pickup", "routed_from", "position"}) do
+	local v = redis.call("HGET", KEYS[1], name)
+	if v then
+		local lat, lng = string.match(v, "^%s*([^,%s]+)%s*,%s*([^,%s]+)%s*$")
+		if lat and tonumber(lat) and tonumber(lng) then
+			redis.call("HSET", KEYS[1], name .. "_lat", lat, name .. "_lng", lng)
+			redis.call("HDEL", KEYS[1], name)
+		end
+	end
+end`),
+	},
+}
+
+// SchemaVersion is the version of the layout this build writes.
+var SchemaVersion = len(schema)
+
+// legacyPointsVersion is the version of the layout written while legacy
+// points are on: the one before numeric point fields, which Migrate and
+// reads upgrade from once they are off.
+const legacyPointsVersion = 0
+
+// writesVersion returns the version of the layout s writes.
+func (s *Redis) writesVersion() int {
+	if s.legacyPoints {
+		return legacyPointsVersion
+	}
+	return SchemaVersion
+}
+
+// upgrade brings the hash under key, read as fields, up to SchemaVersion.
+// The read decoded it whatever its version, so failures are only logged,
+// and the hash is left for the next read. While legacy points are on,
+// hashes are left as they are for older instances to read.
+func (s *Redis) upgrade(ctx context.Context, key string, fields map[string]string) {
+	if s.legacyPoints {
+		return
+	}
+	from, _ := strconv.Atoi(fields[schemaField])
+	if from >= SchemaVersion {
+		return
+	}
+	if _, err := s.upgradeFrom(ctx, key, from); err != nil {
+		log.Printf("failed to upgrade %s: %v", key, err)
+	}
+}
+
+// upgradeFrom runs the steps after version from on the hash under key,
+// reporting whether any changed it.
+func (s *Redis) upgradeFrom(ctx context.Context, key string, from int) (bool, error) {
+	upgraded := false
+	for v := from; v < len(schema); v++ {
+		ran, err := schema[v].script.Run(ctx, s.client, []string{key}, v+1).Int()
+		if err != nil {
+			return upgraded, fmt.Errorf("schema step %d (%s): %v", v+1, schema[v].name, err)
+		}
+		upgraded = upgraded || ran == 1
+	}
+	return upgraded, nil
+}
+
+// ErrLegacyPoints is returned by Migrate while legacy points are on.
+var ErrLegacyPoints = errors.New("points are still written for older versions; turn redis.legacy_points off on every instance first")
+
+// Migrate upgrades every order and driver of the store's tenant to
+// SchemaVersion, returning how many it changed. It is safe to run while the
+// service is serving, and more than once, but only once no instance writes
+// legacy points.
+func (s *Redis) Migrate(ctx context.Context) (int, error) {
+	if s.legacyPoints {
+		return 0, ErrLegacyPoints
+	}
+	iter := s.client.ScanType(ctx, 0, s.prefix+"*", 100, "hash").Iterator()
+	upgraded := 0
+	for iter.Next(ctx) {
+		id := strings.TrimPrefix(iter.Val(), s.prefix)
+		if !s.ownsHash(id) {
+			continue
+		}
+		ok, err := s.upgradeFrom(ctx, iter.Val(), 0)
+		if err != nil {
+			return upgraded, err
+		}
+		if ok {
+			upgraded++
+		}
+	}
+	return upgraded, iter.Err()
+}
+
+// ownsHash reports whether the hash under the unprefixed key k is an order
+// or driver of the store's tenant, rather than a traffic profile or, to the
+// default tenant, another tenant's.
+func (s *Redis) ownsHash(k string) bool {
+	return !strings.HasPrefix(k, profilePrefix) && !(s.prefix == s.namespace && strings.HasPrefix(k, tenantPrefix))
+}
@@ -0,0 +1,559 @@
+// Package store persists per-order tracking state.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/routing"
+)
+
+// Location kinds stored on an order.
+const (
+	Current = "current"
+	Target  = "target"
+	Pickup  = "pickup"
+)
+
+// Phases of an order with a pickup. Orders without one have no phase.
+const (
+	PhasePickup  = "pickup"
+	PhaseDropoff = "dropoff"
+)
+
+// Order is the tracking state of a single order. Fields tagged visible are
+// left out of API responses to callers less trusted than named.
+type Order struct {
+	ID string `json:"order_id"`
+	// CreatedAt is when the order was created with CreateOrder, zero for
+	// orders created by their first update.
+	CreatedAt time.Time  `json:"created_at"`
+	Current   *geo.Point `json:"current,omitempty"`
+	Target    *geo.Point `json:"target,omitempty"`
+	Pickup    *geo.Point `json:"pickup,omitempty"`
+	Phase     string     `json:"phase,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	// Metadata is free-form data attached by the ordering backend.
+	Metadata map[string]string `json:"metadata,omitempty" visible:"dispatcher"`
+	ETA      time.Duration     `json:"eta,omitempty"`
+	ETAAt    time.Time         `json:"eta_at"`
+	// ETALow and ETAHigh bound the ETA: the courier should arrive between
+	// them. ETADrift is how much recent predictions of the arrival moved.
+	ETALow   time.Duration `json:"eta_low,omitempty"`
+	ETAHigh  time.Duration `json:"eta_high,omitempty"`
+	ETADrift time.Duration `json:"eta_drift,omitempty" visible:"dispatcher"`
+	// Weather is the conditions the ETA was stretched for, empty if it was
+	// not adjusted.
+	Weather string `json:"weather,omitempty"`
+	// HandlingTime is added to the routed trip of this order, on top of
+	// the configured one, such as for a loading dock.
+	HandlingTime time.Duration `json:"handling_time,omitempty" visible:"dispatcher"`
+	// ReadyAt is when the pickup has the order ready, after its prep time;
+	// couriers arriving earlier wait for it.
+	ReadyAt time.Time `json:"ready_at"`
+	// PickupETA is the travel time to the pickup during the pickup phase.
+	// ETA is always the travel time to the target, via the pickup if needed.
+	PickupETA time.Duration `json:"pickup_eta,omitempty"`
+	// RoutedFrom is where the courier was when the ETA was last asked of
+	// the route provider, cleared when the target or mode changes.
+	RoutedFrom *geo.Point `json:"routed_from,omitempty" visible:"dispatcher"`
+	// Stop is where the courier has stayed since when, for reporting
+	// dwelling mid-route.
+	Stop *Stop `json:"stop,omitempty" visible:"dispatcher"`
+	// SeenAt is when the courier last reported its location.
+	SeenAt time.Time `json:"seen_at"`
+	// Deadline is when the order was promised by, and SLA how the ETA
+	// compares to it.
+	Deadline time.Time `json:"deadline"`
+	SLA      string    `json:"sla,omitempty"`
+	// DepartAt is when the courier of a delivery planned ahead is due to
+	// leave the pickup, zero for deliveries under way.
+	DepartAt time.Time `json:"depart_at"`
+	// LostAt is when tracking was reported lost, cleared by the next
+	// courier location.
+	LostAt time.Time `json:"lost_at"`
+	// PausedAt is when tracking was paused, such as for the courier's
+	// break, zero while it runs.
+	PausedAt time.Time `json:"paused_at"`
+	// Telemetry is what the courier's device reported with its location.
+	Telemetry *Telemetry `json:"telemetry,omitempty"`
+	// Geofences are the order's own fences, such as its delivery zone.
+	Geofences []geo.Fence `json:"geofences,omitempty" visible:"dispatcher"`
+	// Inside names the fences the courier was last inside.
+	Inside []string `json:"inside,omitempty" visible:"dispatcher"`
+	// Alerts are the proximity thresholds of the order, and Fired the keys
+	// of those already announced.
+	Alerts []Alert  `json:"alerts,omitempty" visible:"dispatcher"`
+	Fired  []string `json:"fired,omitempty" visible:"dispatcher"`
+	// DriverID is the driver last dispatched with the order.
+	DriverID string `json:"driver_id,omitempty" visible:"dispatcher"`
+	// Distance is how far in meters the courier has actually travelled
+	// with the order, summed over its locations, for billing.
+	Distance float64 `json:"distance,omitempty" visible:"dispatcher"`
+	// Cost is what driving the route to the target is estimated to cost,
+	// for orders driven with a provider that prices routes. It is
+	// estimated again when the target or mode changes.
+	Cost *routing.Cost `json:"cost,omitempty" visible:"admin"`
+	// Delivery is the proof of arrival, set once the order is delivered.
+	Delivery *Delivery `json:"delivery,omitempty"`
+	// Preferences say how the customer wants to hear about the order. They
+	// hold the customer's contact details, so share links and drivers do
+	// not see them.
+	Preferences *Preferences `json:"preferences,omitempty" visible:"dispatcher"`
+	// Sequence is the number of the last event published about the order.
+	Sequence int64 `json:"sequence,omitempty"`
+	// Revision counts the changes to the order, events or not; reads of
+	// the order are cached and revalidated by it.
+	Revision int64 `json:"-"`
+}
+
+// Telemetry is the optional detail a device reports along with a location.
+type Telemetry struct {
+	// Speed is in meters per second.
+	Speed *float64 `json:"speed,omitempty" visible:"dispatcher"`
+	// Bearing is the direction of travel in degrees clockwise from north.
+	Bearing *float64 `json:"bearing,omitempty"`
+	// Accuracy is the radius of uncertainty in meters, 0 if unknown.
+	Accuracy float64 `json:"accuracy,omitempty" visible:"dispatcher"`
+	// RecordedAt is when the device took the fix, by its own clock.
+	RecordedAt *time.Time `json:"recorded_at,omitempty" visible:"dispatcher"`
+}
+
+// SLA states of an order with a deadline.
+const (
+	SLAOnTrack  = "on_track"
+	SLAAtRisk   = "at_risk"
+	SLABreached = "breached"
+)
+
+// Notification channels.
+const (
+	ChannelSMS   = "sms"
+	ChannelEmail = "email"
+	ChannelPush  = "push"
+)
+
+// Preferences are the customer's choices about notifications for an order,
+// kept with it so every notifier reads the same ones.
+type Preferences struct {
+	// Channels to notify on, in order of preference.
+	Channels []string `json:"channels"`
+	// Email is where email notifications go.
+	Email string `json:"email,omitempty"`
+	// Phone is where SMS notifications go, in E.164 form such as
+	// "+6591234567".
+	Phone string `json:"phone,omitempty"`
+	// PushToken is the registration token of the app to send push
+	// notifications to.
+	PushToken string `json:"push_token,omitempty"`
+	// Thresholds are the proximity alerts to notify about; setting them
+	// replaces the order's alerts.
+	Thresholds []Alert `json:"thresholds,omitempty"`
+	// Locale is a BCP 47 language tag such as "en-GB".
+	Locale     string      `json:"locale,omitempty"`
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+}
+
+// QuietHours is a daily period without notifications, such as 22:00 to
+// 07:00, in the customer's time zone.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"time_zone"`
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Validate checks the channels, contacts, thresholds and quiet hours.
+func (p Preferences) Validate() error {
+	for _, c := range p.Channels {
+		if c != ChannelSMS && c != ChannelEmail && c != ChannelPush {
+			return fmt.Errorf("unknown channel %q", c)
+		}
+	}
+	if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			return fmt.Errorf("invalid email: %v", err)
+		}
+	}
+	if p.Phone != "" && !e164.MatchString(p.Phone) {
+		return fmt.Errorf("invalid phone %q, must be E.164 such as +6591234567", p.Phone)
+	}
+	for _, a := range p.Thresholds {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+	}
+	if q := p.QuietHours; q != nil {
+		if _, err := q.Quiet(time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Quiet reports whether t falls within the quiet hours.
+func (q QuietHours) Quiet(t time.Time) (bool, error) {
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return false, fmt.Errorf("quiet hours: %v", err)
+	}
+	start, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return false, fmt.Errorf("quiet hours start: %v", err)
+	}
+	end, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return false, fmt.Errorf("quiet hours end: %v", err)
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	from, to := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	if from <= to {
+		return now >= from && now < to, nil
+	}
+	// The period spans midnight
+	return now >= from || now < to, nil
+}
+
+// Alert is a proximity threshold that is announced once, the first time the
+// courier comes within it. Exactly one of Within and Distance is set.
+type Alert struct {
+	// Within is an ETA, in nanoseconds like every duration in the API.
+	Within time.Duration `json:"within,omitempty"`
+	// Distance is a straight-line distance to the target in meters.
+	Distance float64 `json:"distance,omitempty"`
+}
+
+// Key identifies the alert among an order's alerts, for bookkeeping.
+func (a Alert) Key() string {
+	if a.Within > 0 {
+		return "eta<=" + a.Within.String()
+	}
+	return "distance<=" + strconv.FormatFloat(a.Distance, 'f', -1, 64) + "m"
+}
+
+// Validate checks that exactly one threshold is set.
+func (a Alert) Validate() error {
+	if a.Within < 0 || a.Distance < 0 || (a.Within > 0) == (a.Distance > 0) {
+		return errors.New("an alert needs either a positive within or a positive distance")
+	}
+	return nil
+}
+
+// Order statuses, derived from the rest of its state by Status.
+const (
+	StatusScheduled = "scheduled"
+	StatusWaiting   = "waiting"
+	StatusEnRoute   = "en_route"
+	StatusStale     = "stale"
+	StatusPaused    = "paused"
+	StatusDelivered = "delivered"
+)
+
+// Statuses lists the order statuses.
+var Statuses = []string{StatusScheduled, StatusWaiting, StatusEnRoute, StatusStale, StatusPaused, StatusDelivered}
+
+// Status sums up where the order is: scheduled to leave later, waiting for
+// its courier's first location, en route, stale if the courier has been
+// silent for longer than staleAfter, paused, or delivered.
+func (o Order) Status(staleAfter time.Duration) string {
+	switch {
+	case o.Delivery != nil:
+		return StatusDelivered
+	case !o.PausedAt.IsZero():
+		return StatusPaused
+	case o.DepartAt.After(time.Now()):
+		return StatusScheduled
+	case o.Current == nil:
+		return StatusWaiting
+	case o.Stale(staleAfter):
+		return StatusStale
+	}
+	return StatusEnRoute
+}
+
+// Stale reports whether the courier has been silent for longer than after.
+// Orders without a courier location yet, paused and delivered ones are
+// never stale.
+func (o Order) Stale(after time.Duration) bool {
+	return o.Current != nil && o.Delivery == nil && o.PausedAt.IsZero() && time.Since(o.SeenAt) > after
+}
+
+// Delivery confirms that an order arrived.
+type Delivery struct {
+	At time.Time `json:"at"`
+	// Location is where the courier was, exactly, so only dispatchers see
+	// it, like the rest of the proof.
+	Location *geo.Point `json:"location,omitempty" visible:"dispatcher"`
+	// PhotoURL, SignatureHash and Note are whatever proof the courier's app
+	// collected; the service stores them as given.
+	PhotoURL      string `json:"photo_url,omitempty" visible:"dispatcher"`
+	SignatureHash string `json:"signature_hash,omitempty" visible:"dispatcher"`
+	Note          string `json:"note,omitempty" visible:"dispatcher"`
+}
+
+// Stop is a place a courier stayed within a few metres of.
+type Stop struct {
+	Point geo.Point `json:"point"`
+	Since time.Time `json:"since"`
+	// Reported is set once the stop was announced as dwelling.
+	Reported bool `json:"reported,omitempty"`
+}
+
+// Kinds of history entries besides the location kinds.
+const (
+	KindMode         = "mode"
+	KindPhase        = "phase"
+	KindETA          = "eta"
+	KindTrackingLost = "tracking_lost"
+	KindGeofenceIn   = "geofence_entered"
+	KindGeofenceOut  = "geofence_exited"
+	KindAlert        = "alert"
+	KindSLA          = "sla"
+	KindDelivered    = "delivered"
+	KindPaused       = "paused"
+	KindResumed      = "resumed"
+	KindDwell        = "dwell"
+)
+
+// Entry is one change to an order, kept so operators can see how it got to
+// its current state and who made each change.
+type Entry struct {
+	Time  time.Time     `json:"time"`
+	Kind  string        `json:"kind"`
+	Actor string        `json:"actor,omitempty" visible:"admin"`
+	Point *geo.Point    `json:"point,omitempty"`
+	Mode  string        `json:"mode,omitempty"`
+	Phase string        `json:"phase,omitempty"`
+	ETA   time.Duration `json:"eta,omitempty"`
+	// Geofence is the fence entered or left.
+	Geofence string `json:"geofence,omitempty"`
+	// Alert is the key of the alert fired.
+	Alert string `json:"alert,omitempty"`
+	// SLA is the SLA state entered.
+	SLA string `json:"sla,omitempty"`
+}
+
+// HistoryLimit is how many entries are kept per order; older ones are
+// dropped.
+const HistoryLimit = 500
+
+// Driver statuses.
+const (
+	DriverAvailable = "available"
+	DriverBusy      = "busy"
+	DriverOffline   = "offline"
+)
+
+// Driver is a courier and the orders it is currently carrying.
+type Driver struct {
+	ID       string     `json:"driver_id"`
+	Status   string     `json:"status"`
+	Orders   []string   `json:"orders"`
+	Position *geo.Point `json:"position,omitempty"`
+	// SeenAt is when the driver last reported its position.
+	SeenAt time.Time `json:"seen_at"`
+	// Metadata is free-form data attached by the dispatching backend, such
+	// as the driver's shift hours.
+	Metadata map[string]string `json:"metadata,omitempty" visible:"dispatcher"`
+}
+
+// ErrNotFound is returned for orders that have no stored state.
+var ErrNotFound = errors.New("order not found")
+
+// ErrExists is returned for orders created again.
+var ErrExists = errors.New("order already exists")
+
+// ErrDelivered is returned for changes to orders that were delivered.
+var ErrDelivered = errors.New("order already delivered")
+
+// ErrDriverNotFound is returned for drivers that were never registered.
+var ErrDriverNotFound = errors.New("driver not found")
+
+type readOnlyKey struct{}
+
+// ReadOnly marks ctx as serving a request that changes nothing, whose reads
+// a store may serve from a replica lagging slightly behind the primary.
+func ReadOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// Primary undoes ReadOnly, for the reads of a request that must see the
+// latest writes.
+func Primary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, false)
+}
+
+func readOnly(ctx context.Context) bool {
+	ro, _ := ctx.Value(readOnlyKey{}).(bool)
+	return ro
+}
+
+// Store is the persistence layer used by the tracker.
+type Store interface {
+	// CreateOrder starts the stored state of an order created at at, or
+	// returns ErrExists.
+	CreateOrder(ctx context.Context, orderID string, at time.Time) error
+	// SetLocation records the current or target location of an order.
+	SetLocation(ctx context.Context, orderID, kind string, p geo.Point) error
+	// SetMode records the travel mode of an order.
+	SetMode(ctx context.Context, orderID, mode string) error
+	// SetMetadata replaces the metadata of an order.
+	SetMetadata(ctx context.Context, orderID string, metadata map[string]string) error
+	// SetPhase records the phase of an order with a pickup.
+	SetPhase(ctx context.Context, orderID, phase string) error
+	// SetDeadline records when an order was promised by.
+	SetDeadline(ctx context.Context, orderID string, deadline time.Time) error
+	// SetSLA records how an order's ETA compares to its deadline.
+	SetSLA(ctx context.Context, orderID, state string) error
+	// SetDepartAt records the planned departure of an order.
+	SetDepartAt(ctx context.Context, orderID string, at time.Time) error
+	// SetRoutedFrom records where the courier was when the ETA was last
+	// routed.
+	SetRoutedFrom(ctx context.Context, orderID string, p geo.Point) error
+	// SetHandlingTime records the order's own handling time.
+	SetHandlingTime(ctx context.Context, orderID string, d time.Duration) error
+	// SetReadyAt records when the pickup has the order ready.
+	SetReadyAt(ctx context.Context, orderID string, at time.Time) error
+	// SetPausedAt pauses tracking of an order as of at, or resumes it if
+	// at is zero.
+	SetPausedAt(ctx context.Context, orderID string, at time.Time) error
+	// SetPreferences replaces the notification preferences of an order.
+	SetPreferences(ctx context.Context, orderID string, p Preferences) error
+	// SetStop records where the courier has stayed since when.
+	SetStop(ctx context.Context, orderID string, s Stop) error
+	// SetTelemetry records the detail reported with the current location.
+	SetTelemetry(ctx context.Context, orderID string, t Telemetry) error
+	// SetGeofences replaces the order's own geofences.
+	SetGeofences(ctx context.Context, orderID string, fences []geo.Fence) error
+	// SetInside records which geofences the courier is inside.
+	SetInside(ctx context.Context, orderID string, names []string) error
+	// SetAlerts replaces the proximity alerts of an order. Alerts already
+	// fired stay fired.
+	SetAlerts(ctx context.Context, orderID string, alerts []Alert) error
+	// MarkAlertFired records that the alert with key fired, unless it
+	// already had, reporting whether it did, so that only one caller
+	// announces it.
+	MarkAlertFired(ctx context.Context, orderID, key string, at time.Time) (bool, error)
+	// SaveETA records the most recently computed travel time.
+	SaveETA(ctx context.Context, orderID string, eta time.Duration, at time.Time) error
+	// SaveETARange records the window around the travel time saved last,
+	// and the drift it was derived from.
+	SaveETARange(ctx context.Context, orderID string, low, high, drift time.Duration) error
+	// SetCost records the estimated cost of the order's route.
+	SetCost(ctx context.Context, orderID string, c routing.Cost) error
+	// SetWeather records the conditions the ETA was adjusted for, or that
+	// it was not when empty.
+	SetWeather(ctx context.Context, orderID, conditions string) error
+	// SavePickupETA records the travel time to the pickup.
+	SavePickupETA(ctx context.Context, orderID string, eta time.Duration) error
+	// GetOrder returns the stored state, or ErrNotFound.
+	GetOrder(ctx context.Context, orderID string) (Order, error)
+	// GetOrders returns the stored state of the orders among ids that
+	// exist, in the order of ids, in one round trip.
+	GetOrders(ctx context.Context, ids []string) ([]Order, error)
+	// ForEachOrder calls fn for every stored order.
+	ForEachOrder(ctx context.Context, fn func(Order) error) error
+	// MarkTrackingLost sets LostAt unless it is already set, reporting
+	// whether it did, so that only one caller reports the loss.
+	MarkTrackingLost(ctx context.Context, orderID string, at time.Time) (bool, error)
+	// NextSequence numbers the next event of an order, counting up from 1.
+	NextSequence(ctx context.Context, orderID string) (int64, error)
+	// Sequence returns the number of the last event of an order, 0 if
+	// none was published, or ErrNotFound.
+	Sequence(ctx context.Context, orderID string) (int64, error)
+	// Touch counts a change to an order that publishes no event; numbering
+	// an event counts as one too. It returns ErrNotFound for orders that
+	// do not exist.
+	Touch(ctx context.Context, orderID string) error
+	// Revision returns the number of changes to an order, or ErrNotFound.
+	Revision(ctx context.Context, orderID string) (int64, error)
+	// AddDistance adds to the distance travelled with an order.
+	AddDistance(ctx context.Context, orderID string, meters float64) error
+	// SetOrderDriver records the driver dispatched with an order.
+	SetOrderDriver(ctx context.Context, orderID, driverID string) error
+	// MarkDelivered records the delivery of an order unless it was already
+	// delivered, in which case it returns ErrDelivered, or ErrNotFound.
+	MarkDelivered(ctx context.Context, orderID string, d Delivery) error
+	// DeleteOrder removes an order and its history, or returns ErrNotFound.
+	DeleteOrder(ctx context.Context, orderID string) error
+
+	// SaveDriver registers a driver or replaces its status, orders and
+	// metadata, keeping its last position.
+	SaveDriver(ctx context.Context, d Driver) error
+	// GetDriver returns a registered driver, or ErrDriverNotFound.
+	GetDriver(ctx context.Context, driverID string) (Driver, error)
+	// SetDriverPosition records where a registered driver is, or returns
+	// ErrDriverNotFound.
+	SetDriverPosition(ctx context.Context, driverID string, p geo.Point) error
+	// ClearDriverPosition forgets where and when a driver was last seen.
+	ClearDriverPosition(ctx context.Context, driverID string) error
+
+	// OrdersWithin returns the undelivered orders whose courier was last
+	// seen inside the box.
+	OrdersWithin(ctx context.Context, box geo.Box) ([]Order, error)
+	// DriversWithin returns the drivers last seen inside the box.
+	DriversWithin(ctx context.Context, box geo.Box) ([]Driver, error)
+	// TargetsNear returns the orders whose target is within radius meters
+	// of the point.
+	TargetsNear(ctx context.Context, p geo.Point, radius float64) ([]Order, error)
+
+	// AppendHistory adds an entry to the order's history.
+	AppendHistory(ctx context.Context, orderID string, e Entry) error
+	// History returns the order's history, oldest first.
+	History(ctx context.Context, orderID string) ([]Entry, error)
+
+	// CachedTravelTime and CacheTravelTime back the shared travel time cache.
+	CachedTravelTime(ctx context.Context, key string) (time.Duration, bool)
+	CacheTravelTime(ctx context.Context, key string, travelTime time.Duration, ttl time.Duration) error
+	// ObserveSpeed adds to the traffic profile of a geohash cell and mode
+	// that couriers covered meters in elapsed during the hour of the week;
+	// Observed sums them up. See routing.Profiles.
+	ObserveSpeed(ctx context.Context, cell, mode string, hour int, meters float64, elapsed time.Duration) error
+	Observed(ctx context.Context, cell, mode string, hour int) (meters float64, elapsed time.Duration)
+	// CachedResponse and CacheResponse back the cache of rendered reads.
+	CachedResponse(ctx context.Context, key string) ([]byte, bool)
+	CacheResponse(ctx context.Context, key string, body []byte, ttl time.Duration) error
+}
+
+// Locker is implemented by stores shared between instances, which take
+// turns running background jobs.
+type Locker interface {
+	// Lock takes the named lock for owner, or extends it if owner already
+	// holds it, for ttl. It reports whether owner holds the lock.
+	Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+}
+
+// Backends lists the storage backends that can be selected in config.
+var Backends = []string{"redis", "memory"}
+
+// Known reports whether name is a supported storage backend.
+func Known(name string) bool {
+	for _, b := range Backends {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Migrator is implemented by stores keeping data that older versions of the
+// service may have written in another layout.
+type Migrator interface {
+	// Migrate upgrades everything stored to the layout of this version,
+	// returning how many keys it changed.
+	Migrate(ctx context.Context) (int, error)
+}
+
+// Pinger is implemented by stores on a server that can become unreachable.
+type Pinger interface {
+	// Ping reports whether the server answers.
+	Ping(ctx context.Context) error
+}
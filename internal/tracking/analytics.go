@@ -0,0 +1,158 @@
+package tracking
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"location/internal/store"
+)
+
+// Analytics summarizes the orders delivered over a period.
+type Analytics struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Days counts deliveries per UTC day, including days without any.
+	Days []DayStats `json:"days"`
+	// ETAError is how far predicted arrivals were from actual ones, over
+	// every ETA recorded for the delivered orders.
+	ETAError ErrorStats `json:"eta_error"`
+	// Modes and Zones average the trips per travel mode and per configured
+	// geofence around the target. Trips ending outside every geofence are
+	// grouped under an empty zone.
+	Modes []TripStats `json:"modes"`
+	Zones []TripStats `json:"zones"`
+}
+
+// DayStats is the number of orders delivered on a day.
+type DayStats struct {
+	Day       string `json:"day"`
+	Delivered int    `json:"delivered"`
+}
+
+// ErrorStats describes the absolute error of a set of predictions.
+type ErrorStats struct {
+	Samples int           `json:"samples"`
+	Mean    time.Duration `json:"mean"`
+	P95     time.Duration `json:"p95"`
+}
+
+// TripStats averages the trips of one mode or zone. A trip runs from the
+// courier's first location to the delivery.
+type TripStats struct {
+	Name  string `json:"name"`
+	Trips int    `json:"trips"`
+	// Distance is in meters, as travelled.
+	Distance float64       `json:"distance"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Analyze summarizes the orders delivered from from up to to, reading each
+// one's history. It scans every order, so it is meant for dashboards
+// refreshing every few minutes rather than for every request.
+func (t *Tracker) Analyze(ctx context.Context, from, to time.Time) (Analytics, error) {
+	a := Analytics{From: from, To: to, Days: []DayStats{}, Modes: []TripStats{}, Zones: []TripStats{}}
+	perDay := map[string]int{}
+	var errs []time.Duration
+	modes, zones := map[string]*TripStats{}, map[string]*TripStats{}
+
+	err := t.store.ForEachOrder(ctx, func(o store.Order) error {
+		if o.Delivery == nil || o.Delivery.At.Before(from) || !o.Delivery.At.Before(to) {
+			return nil
+		}
+		delivered := o.Delivery.At
+		perDay[delivered.UTC().Format(time.DateOnly)]++
+
+		history, err := t.store.History(ctx, o.ID)
+		if err != nil {
+			return err
+		}
+		var start time.Time
+		for _, e := range history {
+			switch {
+			case e.Kind == store.Current && start.IsZero():
+				start = e.Time
+			case e.Kind == store.KindETA && e.Time.Before(delivered):
+				errs = append(errs, abs(e.Time.Add(e.ETA).Sub(delivered)))
+			}
+		}
+		if start.IsZero() {
+			return nil
+		}
+
+		mode := o.Mode
+		if mode == "" {
+			mode = DefaultMode
+		}
+		zone := ""
+		if o.Target != nil {
+			for _, f := range t.runtime.Config().Tracking.Geofences {
+				if f.Contains(*o.Target) {
+					zone = f.Name
+					break
+				}
+			}
+		}
+		for _, group := range []struct {
+			stats map[string]*TripStats
+			name  string
+		}{{modes, mode}, {zones, zone}} {
+			s := group.stats[group.name]
+			if s == nil {
+				s = &TripStats{Name: group.name}
+				group.stats[group.name] = s
+			}
+			s.Trips++
+			s.Distance += o.Distance
+			s.Duration += delivered.Sub(start)
+		}
+		return nil
+	})
+	if err != nil {
+		return Analytics{}, err
+	}
+
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		a.Days = append(a.Days, DayStats{Day: key, Delivered: perDay[key]})
+	}
+	a.ETAError = errorStats(errs)
+	a.Modes, a.Zones = averages(modes), averages(zones)
+	return a, nil
+}
+
+func errorStats(errs []time.Duration) ErrorStats {
+	if len(errs) == 0 {
+		return ErrorStats{}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i] < errs[j] })
+	var sum time.Duration
+	for _, e := range errs {
+		sum += e
+	}
+	// Nearest rank
+	rank := (95*len(errs) + 99) / 100
+	return ErrorStats{
+		Samples: len(errs),
+		Mean:    sum / time.Duration(len(errs)),
+		P95:     errs[rank-1],
+	}
+}
+
+func averages(groups map[string]*TripStats) []TripStats {
+	stats := make([]TripStats, 0, len(groups))
+	for _, s := range groups {
+		s.Distance /= float64(s.Trips)
+		s.Duration /= time.Duration(s.Trips)
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
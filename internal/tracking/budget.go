@@ -0,0 +1,65 @@
+package tracking
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"location/internal/routing"
+	"location/internal/store"
+)
+
+// ErrDegraded is returned along with a usable travel time that was not
+// routed, because routing did not fit the request's latency budget: the
+// order's last ETA counted down, or else a straight-line estimate. It is
+// neither saved nor should it be published.
+var ErrDegraded = errors.New("latency budget exhausted")
+
+type budgetKey struct{}
+
+// WithLatencyBudget bounds the work done for a request by budget, of which
+// routing gets its configured share and the store the rest. An ETA that
+// cannot be routed in its share is answered degraded rather than failed.
+func WithLatencyBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(ctx, budgetKey{}, true), budget)
+}
+
+func budgeted(ctx context.Context) bool {
+	b, _ := ctx.Value(budgetKey{}).(bool)
+	return b
+}
+
+// routeContext gives routing its share of what is left of ctx's latency
+// budget, if it runs on one.
+func (t *Tracker) routeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || !budgeted(ctx) {
+		return ctx, func() {}
+	}
+	share := t.runtime.Config().Server.ProviderShare
+	return context.WithTimeout(ctx, time.Duration(float64(time.Until(deadline))*share))
+}
+
+// overBudget reports whether routing with routeCtx failed with err for
+// running out of the latency budget of ctx, which still has time left.
+func overBudget(ctx, routeCtx context.Context, err error) bool {
+	return err != nil && budgeted(ctx) && ctx.Err() == nil &&
+		(routeCtx.Err() != nil || errors.Is(err, context.DeadlineExceeded))
+}
+
+// degradedETA answers an order whose route did not fit the latency budget:
+// with its last ETA counted down, or else a straight-line estimate by way
+// of the pickup if the courier is on the way there.
+func (t *Tracker) degradedETA(order store.Order, mode string) time.Duration {
+	if !order.ETAAt.IsZero() {
+		return max(order.ETA-time.Since(order.ETAAt), 0)
+	}
+	var estimate routing.HaversineEstimate
+	origin, eta := *order.Current, time.Duration(0)
+	if order.Phase == store.PhasePickup && order.Pickup != nil {
+		eta, _ = estimate.TravelTime(context.Background(), origin, *order.Pickup, mode)
+		origin = *order.Pickup
+	}
+	leg, _ := estimate.TravelTime(context.Background(), origin, *order.Target, mode)
+	return eta + leg + t.handlingTime(order, mode)
+}
@@ -0,0 +1,69 @@
+package tracking
+
+import (
+	"context"
+	"sort"
+
+	"location/internal/geo"
+	"location/internal/store"
+)
+
+// Cell counts demand and supply in one geohash cell.
+type Cell struct {
+	Cell   string    `json:"cell"`
+	Center geo.Point `json:"center"`
+	// Orders are the undelivered orders going to the cell.
+	Orders int `json:"orders"`
+	// Drivers are the drivers in the cell that are not offline, Available
+	// those of them free to take an order.
+	Drivers   int `json:"drivers"`
+	Available int `json:"available"`
+}
+
+// Heatmap buckets the undelivered orders by target, and the drivers by
+// position, into geohash cells of the given precision inside the box. Only
+// cells with something in them are returned, sorted by geohash.
+func (t *Tracker) Heatmap(ctx context.Context, box geo.Box, precision int) ([]Cell, error) {
+	cells := map[string]*Cell{}
+	cell := func(p geo.Point) *Cell {
+		hash := geo.Geohash(p, precision)
+		c := cells[hash]
+		if c == nil {
+			center, _ := geo.GeohashCenter(hash)
+			c = &Cell{Cell: hash, Center: center}
+			cells[hash] = c
+		}
+		return c
+	}
+
+	err := t.store.ForEachOrder(ctx, func(o store.Order) error {
+		if o.Delivery == nil && o.Target != nil && box.Contains(*o.Target) {
+			cell(*o.Target).Orders++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	drivers, err := t.store.DriversWithin(ctx, box)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range drivers {
+		if d.Status == store.DriverOffline {
+			continue
+		}
+		c := cell(*d.Position)
+		c.Drivers++
+		if d.Status == store.DriverAvailable {
+			c.Available++
+		}
+	}
+
+	heatmap := make([]Cell, 0, len(cells))
+	for _, c := range cells {
+		heatmap = append(heatmap, *c)
+	}
+	sort.Slice(heatmap, func(i, j int) bool { return heatmap[i].Cell < heatmap[j].Cell })
+	return heatmap, nil
+}
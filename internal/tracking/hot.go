@@ -0,0 +1,84 @@
+package tracking
+
+import (
+	"context"
+	"time"
+
+	"location/internal/lru"
+	"location/internal/routing"
+	"location/internal/store"
+)
+
+// useHot puts an in-process cache of size entries, each kept for at most
+// ttl, in front of the store for revisions, responses and travel times.
+// Revisions are dropped when their order changes.
+func (t *Tracker) useHot(size int, ttl time.Duration) {
+	t.hot = lru.New(size, ttl)
+	t.hub.OnChange(func(orderID string) {
+		t.hot.Remove(revisionKey(orderID))
+	})
+}
+
+func revisionKey(orderID string) string {
+	return "rev:" + orderID
+}
+
+// Revision returns the number of changes to an order, events or not. It
+// may lag by up to the local TTL if the order changed on an instance not
+// relaying its changes.
+func (t *Tracker) Revision(ctx context.Context, orderID string) (int64, error) {
+	if t.hot != nil {
+		if rev, ok := t.hot.Get(revisionKey(orderID)); ok {
+			return rev.(int64), nil
+		}
+	}
+	rev, err := t.store.Revision(ctx, orderID)
+	if err == nil && t.hot != nil {
+		t.hot.Add(revisionKey(orderID), rev, 0)
+	}
+	return rev, err
+}
+
+// CachedResponse returns a rendered read kept with CacheResponse.
+func (t *Tracker) CachedResponse(ctx context.Context, key string) ([]byte, bool) {
+	if t.hot != nil {
+		if body, ok := t.hot.Get(key); ok {
+			return body.([]byte), true
+		}
+	}
+	return t.store.CachedResponse(ctx, key)
+}
+
+// CacheResponse keeps a rendered read for ttl.
+func (t *Tracker) CacheResponse(ctx context.Context, key string, body []byte, ttl time.Duration) error {
+	if t.hot != nil {
+		t.hot.Add(key, body, ttl)
+	}
+	return t.store.CacheResponse(ctx, key, body, ttl)
+}
+
+// travelTimes returns the cache of travel times: the store, behind the
+// in-process cache if there is one.
+func (t *Tracker) travelTimes() routing.Cache {
+	if t.hot == nil {
+		return t.store
+	}
+	return hotTravelTimes{hot: t.hot, next: t.store}
+}
+
+type hotTravelTimes struct {
+	hot  *lru.Cache
+	next store.Store
+}
+
+func (c hotTravelTimes) CachedTravelTime(ctx context.Context, key string) (time.Duration, bool) {
+	if travelTime, ok := c.hot.Get(key); ok {
+		return travelTime.(time.Duration), true
+	}
+	return c.next.CachedTravelTime(ctx, key)
+}
+
+func (c hotTravelTimes) CacheTravelTime(ctx context.Context, key string, travelTime time.Duration, ttl time.Duration) error {
+	c.hot.Add(key, travelTime, ttl)
+	return c.next.CacheTravelTime(ctx, key, travelTime, ttl)
+}
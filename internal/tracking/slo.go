@@ -0,0 +1,82 @@
+package tracking
+
+import (
+	"context"
+	"time"
+
+	"location/internal/metrics"
+	"location/internal/store"
+)
+
+// publishLagBounds are the upper bounds, in seconds, of the publish lag
+// histogram buckets; SLOs pick one of them as their threshold.
+var publishLagBounds = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type receivedAtKey struct{}
+
+// WithReceivedAt tells the tracker when the request its calls serve was
+// received, so that the lag until the events it causes are published can be
+// measured.
+func WithReceivedAt(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtKey{}, at)
+}
+
+func receivedAt(ctx context.Context) (time.Time, bool) {
+	at, ok := ctx.Value(receivedAtKey{}).(time.Time)
+	return at, ok
+}
+
+// SLIs are the service level indicators of a tracker, as counts and
+// distributions that SLO burn-rate alerts take ratios of.
+type SLIs struct {
+	// ETAComputations counts the ETAs routed since the process started,
+	// and ETAFailures those the provider failed to route.
+	ETAComputations int64
+	ETAFailures     int64
+	// PublishLag is how long, in seconds, events caused by a request took
+	// from its arrival to being published.
+	PublishLag metrics.HistogramSnapshot
+	// OldestSilence is how long the courier of the longest-silent order
+	// under way has not reported, as of the last watchdog round, or zero
+	// if there is none. Only the instance leading the watchdog knows.
+	OldestSilence time.Duration
+}
+
+// SLIs returns the service level indicators of t.
+func (t *Tracker) SLIs() SLIs {
+	s := SLIs{
+		ETAComputations: t.etaComputations.Load(),
+		ETAFailures:     t.etaFailures.Load(),
+		PublishLag:      t.publishLag.Snapshot(),
+	}
+	if seen := t.oldestSeen.Load(); seen != 0 {
+		s.OldestSilence = time.Since(time.Unix(0, seen))
+	}
+	return s
+}
+
+// computed counts an ETA routed, successfully unless err is set.
+func (t *Tracker) computed(err error) {
+	t.etaComputations.Add(1)
+	if err != nil {
+		t.etaFailures.Add(1)
+	}
+}
+
+// published observes the lag of an event published for the request ctx
+// serves, if it tells when that arrived.
+func (t *Tracker) published(ctx context.Context) {
+	if at, ok := receivedAt(ctx); ok {
+		t.publishLag.Observe(time.Since(at).Seconds())
+	}
+}
+
+// activeSince returns when the courier of an order under way, silent or
+// not, last reported, and false for orders not under way.
+func activeSince(o store.Order, staleAfter time.Duration) (time.Time, bool) {
+	switch o.Status(staleAfter) {
+	case store.StatusEnRoute, store.StatusStale:
+		return o.SeenAt, true
+	}
+	return time.Time{}, false
+}
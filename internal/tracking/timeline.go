@@ -0,0 +1,103 @@
+package tracking
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"location/internal/geo"
+	"location/internal/store"
+)
+
+// Timeline event types. Besides these, every history kind is its own type,
+// such as "geofence_entered" or "dwell".
+const (
+	TimelineCreated        = "created"
+	TimelineTargetSet      = "target_set"
+	TimelinePickupSet      = "pickup_set"
+	TimelineCourierLocated = "courier_located"
+	TimelineModeChanged    = "mode_changed"
+	TimelinePhaseChanged   = "phase_changed"
+	TimelineETA            = "eta_updated"
+	TimelineAlert          = "alert_fired"
+	TimelineSLA            = "sla_changed"
+)
+
+// TimelineEvent is one thing that happened to an order, told for people
+// supporting it.
+type TimelineEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Summary string    `json:"summary"`
+	// Actor is who made the change, if it was made on someone's behalf.
+	Actor string        `json:"actor,omitempty" visible:"admin"`
+	Point *geo.Point    `json:"point,omitempty"`
+	ETA   time.Duration `json:"eta,omitempty"`
+}
+
+// Timeline tells the history of an order, oldest first: when it was created,
+// its targets, modes, phases, ETAs, alerts, geofences, SLA states and
+// arrival. Only the first courier location is told; the rest are in the
+// history.
+func (t *Tracker) Timeline(ctx context.Context, orderID string) ([]TimelineEvent, error) {
+	history, err := t.store.History(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []TimelineEvent
+	located := false
+	for i, e := range history {
+		if i == 0 {
+			events = append(events, TimelineEvent{Time: e.Time, Type: TimelineCreated, Summary: "Order created", Actor: e.Actor})
+		}
+		ev := TimelineEvent{Time: e.Time, Type: e.Kind, Actor: e.Actor, Point: e.Point}
+		switch e.Kind {
+		case store.Target:
+			ev.Type, ev.Summary = TimelineTargetSet, "Target set to "+pointText(e.Point)
+		case store.Pickup:
+			ev.Type, ev.Summary = TimelinePickupSet, "Pickup set to "+pointText(e.Point)
+		case store.Current:
+			if located {
+				continue
+			}
+			located = true
+			ev.Type, ev.Summary = TimelineCourierLocated, "Courier first located at "+pointText(e.Point)
+		case store.KindMode:
+			ev.Type, ev.Summary = TimelineModeChanged, "Travel mode changed to "+e.Mode
+		case store.KindPhase:
+			ev.Type, ev.Summary = TimelinePhaseChanged, "Entered the "+e.Phase+" phase"
+		case store.KindETA:
+			ev.Type, ev.Summary, ev.ETA = TimelineETA, fmt.Sprintf("ETA updated to %v", e.ETA.Round(time.Second)), e.ETA
+		case store.KindGeofenceIn:
+			ev.Summary = "Courier entered geofence " + e.Geofence
+		case store.KindGeofenceOut:
+			ev.Summary = "Courier left geofence " + e.Geofence
+		case store.KindAlert:
+			ev.Type, ev.Summary = TimelineAlert, "Alert "+e.Alert+" fired"
+		case store.KindSLA:
+			ev.Type, ev.Summary = TimelineSLA, "SLA is now "+e.SLA
+		case store.KindTrackingLost:
+			ev.Summary = "Courier stopped reporting"
+		case store.KindPaused:
+			ev.Summary = "Tracking paused"
+		case store.KindResumed:
+			ev.Summary = "Tracking resumed"
+		case store.KindDwell:
+			ev.Summary = "Courier stopped mid-route at " + pointText(e.Point)
+		case store.KindDelivered:
+			ev.Summary = "Delivered"
+		default:
+			ev.Summary = e.Kind
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func pointText(p *geo.Point) string {
+	if p == nil {
+		return "an unknown place"
+	}
+	return p.String()
+}
@@ -0,0 +1,1963 @@
+// Package tracking keeps order locations up to date and recalculates their
+// travel times.
+package tracking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"location/internal/auth"
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/geocode"
+	"location/internal/lru"
+	"location/internal/metrics"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/weather"
+)
+
+// DefaultMode is used for orders that never had a travel mode set.
+const DefaultMode = "walking"
+
+// Tracker ties together storage, route providers and the publisher.
+type Tracker struct {
+	store     store.Store
+	providers map[string]*routing.Metered
+	publisher publish.Publisher
+	runtime   *config.Runtime
+	hub       *publish.Hub
+	// hot is the in-process cache in front of the store, if enabled
+	hot       *lru.Cache
+	weather   weather.Provider
+	isochrone routing.Isochroner
+	addresses map[string]geocode.Resolver
+	coalescer *routing.Coalescer
+	pool      *routing.Pool
+	tenant    string
+	// owner identifies this instance to the other holders of job locks
+	owner string
+
+	inaccurate, teleports atomic.Int64
+
+	// Service level indicators; see SLIs
+	etaComputations, etaFailures atomic.Int64
+	publishLag                   *metrics.Histogram
+	oldestSeen                   atomic.Int64
+}
+
+func New(s store.Store, providers map[string]routing.Provider, p publish.Publisher, rt *config.Runtime) *Tracker {
+	metered := make(map[string]*routing.Metered, len(providers))
+	for name, provider := range providers {
+		metered[name] = &routing.Metered{Next: provider}
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s/%d/%d", host, os.Getpid(), rand.Int63())
+	t := &Tracker{store: s, providers: metered, publisher: p, runtime: rt, hub: publish.NewHub(),
+		coalescer: routing.NewCoalescer(), owner: owner, publishLag: metrics.NewHistogram(publishLagBounds...)}
+	if conf := rt.Config().Cache; conf.LocalEntries > 0 {
+		t.useHot(conf.LocalEntries, conf.LocalTTL.Duration)
+	}
+	return t
+}
+
+// UseTenant makes t serve the named tenant: Google calls stop at the
+// tenant's daily quota, and its provider chain, if it has one, replaces the
+// provider selected in the runtime settings.
+func (t *Tracker) UseTenant(name string) {
+	t.tenant = name
+	if m, ok := t.providers[routing.Google]; ok {
+		m.Budget = func() int { return t.runtime.Config().Tenancy.Tenants[name].DailyQuota }
+	}
+}
+
+// UsePool calculates travel times on p's workers.
+func (t *Tracker) UsePool(p *routing.Pool) {
+	t.pool = p
+}
+
+// PoolStats returns the queue depth and counts of the worker pool, if
+// travel times are calculated on one.
+func (t *Tracker) PoolStats() (routing.PoolStats, bool) {
+	if t.pool == nil {
+		return routing.PoolStats{}, false
+	}
+	return t.pool.Stats(), true
+}
+
+// UseWeather stretches ETAs by the configured multipliers for the
+// conditions w reports where the courier is.
+func (t *Tracker) UseWeather(w weather.Provider) {
+	t.weather = w
+}
+
+// UseIsochrones enables Isochrone through i.
+func (t *Tracker) UseIsochrones(i routing.Isochroner) {
+	t.isochrone = i
+}
+
+// ErrNoIsochrones is returned by Isochrone when no provider is configured.
+var ErrNoIsochrones = errors.New("no isochrone provider configured")
+
+// Isochrone returns the area reachable from center by mode within limit.
+func (t *Tracker) Isochrone(ctx context.Context, center geo.Point, mode string, limit time.Duration) ([][]geo.Point, error) {
+	if t.isochrone == nil {
+		return nil, ErrNoIsochrones
+	}
+	return t.isochrone.Isochrone(ctx, center, mode, limit)
+}
+
+// UseAddresses enables ResolveAddress for the addressing systems in r,
+// keyed by geocode.What3Words or geocode.PlusCode.
+func (t *Tracker) UseAddresses(r map[string]geocode.Resolver) {
+	t.addresses = r
+}
+
+// ErrNoResolver is returned by ResolveAddress for addressing systems that
+// are not configured.
+var ErrNoResolver = errors.New("addressing system not configured")
+
+// ResolveAddress returns the coordinates of an address in the given
+// system.
+func (t *Tracker) ResolveAddress(ctx context.Context, system, address string) (geo.Point, error) {
+	r, ok := t.addresses[system]
+	if !ok {
+		return geo.Point{}, ErrNoResolver
+	}
+	return r.Resolve(ctx, address)
+}
+
+// UpdateLocation records a current or target location and returns the
+// order's recalculated travel time. Unless orders are created implicitly,
+// orders that were never created return store.ErrNotFound.
+func (t *Tracker) UpdateLocation(ctx context.Context, orderID, kind string, p geo.Point) (time.Duration, error) {
+	if err := t.known(ctx, orderID); err != nil {
+		return 0, err
+	}
+	return t.updateLocation(ctx, orderID, kind, p)
+}
+
+// known returns store.ErrNotFound for orders that were never created, unless
+// orders are created implicitly by their first update.
+func (t *Tracker) known(ctx context.Context, orderID string) error {
+	if t.runtime.Config().Tracking.ImplicitOrders {
+		return nil
+	}
+	_, err := t.store.Sequence(ctx, orderID)
+	return err
+}
+
+func (t *Tracker) updateLocation(ctx context.Context, orderID, kind string, p geo.Point) (time.Duration, error) {
+	settings := t.runtime.Settings()
+
+	err := t.store.SetLocation(ctx, orderID, kind, p)
+	if err != nil {
+		return 0, err
+	}
+	// Live viewers see the new position even if no travel time comes of it
+	defer t.changed(ctx, orderID)
+	t.record(ctx, orderID, store.Entry{Kind: kind, Point: &p})
+
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return 0, err
+	}
+	if order.Delivery != nil {
+		return 0, store.ErrDelivered
+	}
+	if !order.PausedAt.IsZero() {
+		return 0, ErrPaused
+	}
+
+	// The phase moves on whether or not the update is debounced
+	t.advancePhase(ctx, &order, kind, p)
+	if kind == store.Current {
+		t.checkGeofences(ctx, order, p)
+		t.checkDwell(ctx, order, p)
+	}
+
+	// Until a planned delivery sets off, its ETA is a prediction
+	if order.DepartAt.After(time.Now()) {
+		return t.scheduledETA(ctx, order)
+	}
+
+	mode := order.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+
+	// Skip recalculating when the courier reported in only moments ago
+	if interval := t.debounceInterval(settings, mode); interval > 0 && kind == store.Current && !order.ETAAt.IsZero() {
+		elapsed := time.Since(order.ETAAt)
+		if elapsed <= interval {
+			travelTime := max(order.ETA-elapsed, 0)
+			t.checkAlerts(ctx, order, travelTime)
+			return travelTime, nil
+		}
+	}
+
+	// Nor when the courier has hardly moved since the last route
+	if radius := t.runtime.Config().Tracking.StationaryRadius; radius > 0 && kind == store.Current && order.RoutedFrom != nil && !order.ETAAt.IsZero() &&
+		geo.Distance(p, *order.RoutedFrom) <= radius {
+		travelTime := max(order.ETA-time.Since(order.ETAAt), 0)
+		t.checkAlerts(ctx, order, travelTime)
+		return travelTime, nil
+	}
+
+	if order.Current == nil {
+		log.Println("failed to get current location")
+		return 0, fmt.Errorf("%w: order %s has no current location", ErrIncomplete, orderID)
+	}
+	if order.Target == nil {
+		log.Println("failed to get target location")
+		return 0, fmt.Errorf("%w: order %s has no target location", ErrIncomplete, orderID)
+	}
+
+	// The first update after a planned delivery sets off from where it was
+	// planned to uses the route warmed ahead of departure
+	travelTime, ok := t.warmed(ctx, order, mode)
+	if !ok {
+		routeCtx, cancel := t.routeContext(ctx)
+		travelTime, err = t.liveTravelTime(routeCtx, order, settings, mode)
+		cancel()
+		t.computed(err)
+		if overBudget(ctx, routeCtx, err) {
+			log.Printf("Routing order %s ran out of its latency budget: %v", orderID, err)
+			return t.degradedETA(order, mode), ErrDegraded
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	travelTime = t.adjustForWeather(ctx, order, mode, travelTime) + t.handlingTime(order, mode)
+
+	// Remember the result, and where from, so the next update can be
+	// debounced against it
+	t.saveETA(ctx, order, travelTime, time.Now())
+	if err := t.store.SetRoutedFrom(ctx, orderID, *order.Current); err != nil {
+		log.Println(err)
+	}
+	t.checkAlerts(ctx, order, travelTime)
+	if order.Cost == nil && mode == "driving" {
+		t.priceRoute(ctx, order, settings, mode)
+	}
+
+	return travelTime, nil
+}
+
+// liveTravelTime asks the provider for the travel time of order from the
+// courier to the target, by way of the pickup before it is collected.
+func (t *Tracker) liveTravelTime(ctx context.Context, order store.Order, settings config.Settings, mode string) (time.Duration, error) {
+	// Before the pickup, the trip to the target goes by way of it, and
+	// waits there for the order if it is not ready
+	ctx = t.urgency(ctx, order)
+	provider := t.provider(settings, mode)
+	origin, pickupLeg := *order.Current, time.Duration(0)
+	if order.Phase == store.PhasePickup && order.Pickup != nil {
+		var err error
+		pickupLeg, err = provider.TravelTime(ctx, origin, *order.Pickup, mode)
+		if err != nil {
+			log.Println("failed to calculate travel time to pickup")
+			return 0, fmt.Errorf("failed to calculate travel time to pickup: %w", err)
+		}
+		origin = *order.Pickup
+		err = t.store.SavePickupETA(ctx, order.ID, pickupLeg)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	travelTime, err := provider.TravelTime(ctx, origin, *order.Target, mode)
+	if err != nil {
+		log.Println("failed to calculate travel time")
+		return 0, fmt.Errorf("failed to calculate travel time: %w", err)
+	}
+	if order.Phase == store.PhasePickup && order.Pickup != nil && !order.ReadyAt.IsZero() {
+		// The courier waits at the pickup until the order is ready
+		travelTime += max(time.Until(order.ReadyAt)-pickupLeg, 0)
+	}
+	return travelTime + pickupLeg, nil
+}
+
+// priceRoute estimates the tolls and fuel of driving the order from the
+// courier to its target, if the provider for mode can. The cost is kept
+// until the target or mode changes, so it is asked for once per route.
+func (t *Tracker) priceRoute(ctx context.Context, order store.Order, settings config.Settings, mode string) {
+	m, ok := t.metered(settings, mode)
+	if !ok {
+		return
+	}
+	conf := t.runtime.Config().Maps
+	cost, err := m.RouteCost(ctx, *order.Current, *order.Target, conf.Vehicle)
+	if errors.Is(err, routing.ErrNoCost) || errors.Is(err, routing.ErrBudgetExhausted) {
+		return
+	}
+	if err != nil {
+		log.Printf("failed to estimate route cost of order %s: %v", order.ID, err)
+		return
+	}
+	if conf.Vehicle == routing.Electric {
+		cost.Fuel, cost.Energy = 0, cost.Distance/1000*conf.EnergyPerKm
+	}
+	if err := t.store.SetCost(ctx, order.ID, cost); err != nil {
+		log.Println(err)
+	}
+}
+
+// Route returns the way the courier of order has left to go, by way of the
+// pickup before it is collected, as the provider for its mode routes it.
+// Where that provider only estimates travel times, or its budget is spent,
+// the way is a straight line.
+func (t *Tracker) Route(ctx context.Context, order store.Order) (routing.Route, error) {
+	if order.Current == nil || order.Target == nil {
+		return routing.Route{}, fmt.Errorf("%w: order %s has no current or target location", ErrIncomplete, order.ID)
+	}
+	mode := order.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+	stops := []geo.Point{*order.Current, *order.Target}
+	if order.Phase == store.PhasePickup && order.Pickup != nil {
+		stops = []geo.Point{*order.Current, *order.Pickup, *order.Target}
+	}
+
+	var router routing.Router = routing.HaversineEstimate{}
+	if m, ok := t.metered(t.runtime.Settings(), mode); ok {
+		router = m
+	}
+	var route routing.Route
+	for i := 1; i < len(stops); i++ {
+		leg, err := router.Route(ctx, stops[i-1], stops[i], mode)
+		if errors.Is(err, routing.ErrNoRoute) || errors.Is(err, routing.ErrBudgetExhausted) {
+			leg, err = routing.HaversineEstimate{}.Route(ctx, stops[i-1], stops[i], mode)
+		}
+		if err != nil {
+			return routing.Route{}, fmt.Errorf("failed to route order %s: %w", order.ID, err)
+		}
+		if len(route.Path) > 0 {
+			// The leg starts where the last one ended
+			leg.Path = leg.Path[1:]
+		}
+		route.Path = append(route.Path, leg.Path...)
+		route.Duration += leg.Duration
+	}
+	return route, nil
+}
+
+// adjustForWeather stretches the travel time of order by the multiplier for
+// the conditions at the courier, and records which conditions it allowed
+// for. Without a weather provider, or when it fails, the travel time is
+// left as routed.
+func (t *Tracker) adjustForWeather(ctx context.Context, order store.Order, mode string, travelTime time.Duration) time.Duration {
+	if t.weather == nil {
+		return travelTime
+	}
+	conditions, err := t.weather.Conditions(ctx, *order.Current)
+	if err != nil {
+		log.Printf("failed to get weather for order %s: %v", order.ID, err)
+		conditions = ""
+	}
+	m, ok := t.runtime.Config().Weather.Multipliers[conditions][mode]
+	if !ok || m == 1 {
+		conditions, m = "", 1
+	}
+	if conditions != order.Weather {
+		if err := t.store.SetWeather(ctx, order.ID, conditions); err != nil {
+			log.Println(err)
+		}
+	}
+	return time.Duration(float64(travelTime) * m)
+}
+
+// Errors for courier locations rejected as outliers. Either leaves the order
+// as it was.
+var (
+	// ErrInaccurate is returned for locations less accurate than the
+	// configured tracking.max_accuracy.
+	ErrInaccurate = errors.New("location too inaccurate")
+	// ErrTeleport is returned for locations the courier could not have
+	// reached from its last one without exceeding tracking.max_speed.
+	ErrTeleport = errors.New("location implies an impossible speed")
+	// ErrPaused is returned for locations of orders whose tracking is
+	// paused. The location is stored, but no ETA is calculated from it.
+	ErrPaused = errors.New("tracking paused")
+	// ErrIncomplete is returned for locations of orders that lack the
+	// courier or target location to route between. The location is
+	// stored, and the ETA calculated once the other one arrives.
+	ErrIncomplete = errors.New("order has no route yet")
+)
+
+// Rejections counts the courier locations rejected as outliers since the
+// process started.
+type Rejections struct {
+	Inaccurate int64 `json:"inaccurate"`
+	Teleports  int64 `json:"teleports"`
+}
+
+// UpdateCurrent records a courier location along with the telemetry its
+// device reported, and returns the order's recalculated travel time.
+// Outliers are rejected with ErrInaccurate or ErrTeleport before they reach
+// the stored state.
+func (t *Tracker) UpdateCurrent(ctx context.Context, orderID string, p geo.Point, tel store.Telemetry) (time.Duration, error) {
+	conf := t.runtime.Config().Tracking
+	if conf.MaxAccuracy > 0 && tel.Accuracy > conf.MaxAccuracy {
+		t.inaccurate.Add(1)
+		log.Printf("Rejected location of order %s: accuracy %.0fm", orderID, tel.Accuracy)
+		return 0, ErrInaccurate
+	}
+	prev, err := t.store.GetOrder(ctx, orderID)
+	if err != nil && (!errors.Is(err, store.ErrNotFound) || !conf.ImplicitOrders) {
+		return 0, err
+	}
+	if prev.Delivery != nil {
+		return 0, store.ErrDelivered
+	}
+	if conf.MaxSpeed > 0 {
+		if speed, ok := impliedSpeed(prev, p, tel); ok && speed > conf.MaxSpeed {
+			t.teleports.Add(1)
+			log.Printf("Rejected location of order %s: implied speed %.0fm/s", orderID, speed)
+			return 0, ErrTeleport
+		}
+	}
+
+	err = t.store.SetTelemetry(ctx, orderID, tel)
+	if err != nil {
+		return 0, err
+	}
+	t.travelled(ctx, orderID, prev.Current, p)
+	t.observeSpeed(ctx, prev, p)
+	return t.updateLocation(ctx, orderID, store.Current, p)
+}
+
+// observeSpeed adds the move of the courier of prev to p to the traffic
+// profiles. Moves after a silence, while paused, or around the pickup and
+// target, where couriers wait rather than drive, are left out.
+func (t *Tracker) observeSpeed(ctx context.Context, prev store.Order, p geo.Point) {
+	conf := t.runtime.Config()
+	if !conf.Maps.TrafficProfiles.Enabled || prev.Current == nil || prev.SeenAt.IsZero() || !prev.PausedAt.IsZero() {
+		return
+	}
+	elapsed := time.Since(prev.SeenAt)
+	if elapsed <= 0 || elapsed > conf.Tracking.StaleAfter.Duration {
+		return
+	}
+	for _, place := range []*geo.Point{prev.Pickup, prev.Target} {
+		if place != nil && geo.Distance(p, *place) <= conf.Tracking.PickupRadius {
+			return
+		}
+	}
+	mode := prev.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+	cell := geo.Geohash(*prev.Current, conf.Maps.TrafficProfiles.Precision)
+	err := t.store.ObserveSpeed(ctx, cell, mode, routing.HourOfWeek(time.Now()), geo.Distance(*prev.Current, p), elapsed)
+	if err != nil {
+		log.Printf("failed to observe speed of order %s: %v", prev.ID, err)
+	}
+}
+
+// impliedSpeed is the speed in m/s the courier of prev must have travelled
+// at to reach p. The devices' own clocks are used when both fixes carry
+// them, since reports can be delayed and arrive in bursts.
+func impliedSpeed(prev store.Order, p geo.Point, tel store.Telemetry) (float64, bool) {
+	if prev.Current == nil || prev.SeenAt.IsZero() {
+		return 0, false
+	}
+	elapsed := time.Since(prev.SeenAt)
+	if prev.Telemetry != nil && prev.Telemetry.RecordedAt != nil && tel.RecordedAt != nil {
+		elapsed = tel.RecordedAt.Sub(*prev.Telemetry.RecordedAt)
+	}
+	// Fixes moments apart differ mostly by GPS jitter
+	elapsed = max(elapsed, time.Second)
+	return geo.Distance(*prev.Current, p) / elapsed.Seconds(), true
+}
+
+// EstimatePosition dead-reckons where the courier of order is at now,
+// moving from its last reported location toward its next stop at its last
+// reported speed, or, without one, at the speed its ETA implies. Providers
+// return no route geometry, so the courier is assumed to head straight for
+// the stop. It reports false when there is nothing to estimate from, and
+// stops extrapolating once the order is stale.
+func (t *Tracker) EstimatePosition(order store.Order, now time.Time) (geo.Point, bool) {
+	if order.Current == nil {
+		return geo.Point{}, false
+	}
+	next := order.Target
+	if order.Phase == store.PhasePickup && order.Pickup != nil {
+		next = order.Pickup
+	}
+	if next == nil || order.SeenAt.IsZero() {
+		return *order.Current, false
+	}
+
+	var speed float64
+	switch {
+	case order.Telemetry != nil && order.Telemetry.Speed != nil:
+		speed = *order.Telemetry.Speed
+	case order.Phase == store.PhasePickup && order.PickupETA > 0:
+		speed = geo.Distance(*order.Current, *next) / order.PickupETA.Seconds()
+	case order.ETA > 0:
+		speed = geo.Distance(*order.Current, *next) / order.ETA.Seconds()
+	}
+	elapsed := min(now.Sub(order.SeenAt), t.StaleAfter())
+	if speed <= 0 || elapsed <= 0 {
+		return *order.Current, false
+	}
+	return geo.Toward(*order.Current, *next, speed*elapsed.Seconds()), true
+}
+
+// Rejections returns how many courier locations were rejected as outliers.
+func (t *Tracker) Rejections() Rejections {
+	return Rejections{Inaccurate: t.inaccurate.Load(), Teleports: t.teleports.Load()}
+}
+
+// saveETA stores a newly calculated travel time of order, calculated at at,
+// along with its window, and records it in the history. Failing to store it
+// is logged only: the next update calculates it again.
+func (t *Tracker) saveETA(ctx context.Context, order store.Order, eta time.Duration, at time.Time) {
+	err := t.store.SaveETA(ctx, order.ID, eta, at)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	t.record(ctx, order.ID, store.Entry{Kind: store.KindETA, ETA: eta})
+
+	drift := order.ETADrift
+	if !order.ETAAt.IsZero() {
+		// Accurate predictions keep the arrival time where it was
+		moved := at.Add(eta).Sub(order.ETAAt.Add(order.ETA)).Abs()
+		drift = (3*drift + moved) / 4
+	}
+	low, high := t.etaWindow(eta, drift)
+	err = t.store.SaveETARange(ctx, order.ID, low, high, drift)
+	if err != nil {
+		log.Println(err)
+	}
+	t.checkSLA(ctx, order, at.Add(eta), at.Add(high))
+}
+
+// Deliver finalizes an order with its proof of arrival, stamping the time
+// and, unless given, the courier's last location. It returns
+// store.ErrDelivered if the order was delivered before. The order keeps its
+// history but takes no further courier locations.
+func (t *Tracker) Deliver(ctx context.Context, orderID string, d store.Delivery) (store.Delivery, error) {
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return d, err
+	}
+	d.At = time.Now()
+	if d.Location == nil {
+		d.Location = order.Current
+	}
+	err = t.store.MarkDelivered(ctx, orderID, d)
+	if err != nil {
+		return d, err
+	}
+	log.Printf("Order %s delivered", orderID)
+	t.record(ctx, orderID, store.Entry{Kind: store.KindDelivered, Point: d.Location})
+	t.changed(ctx, orderID)
+
+	// The arrival settles the SLA
+	t.checkSLA(ctx, order, d.At, d.At)
+	err = t.publish(ctx, publish.Event{Type: publish.EventDelivered, OrderID: orderID, DeliveredAt: &d.At, Location: d.Location, Metadata: order.Metadata})
+	if err != nil {
+		log.Printf("failed to publish delivery of order %s: %v", orderID, err)
+	}
+	return d, nil
+}
+
+// Pause stops calculating ETAs and notifying about an order, such as while
+// its courier is on a break or the order is on hold. Courier locations are
+// still stored, and the rest of its state is kept until Resume.
+func (t *Tracker) Pause(ctx context.Context, orderID string) (time.Time, error) {
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if order.Delivery != nil {
+		return time.Time{}, store.ErrDelivered
+	}
+	if !order.PausedAt.IsZero() {
+		return order.PausedAt, nil
+	}
+	at := time.Now()
+	err = t.store.SetPausedAt(ctx, orderID, at)
+	if err != nil {
+		return time.Time{}, err
+	}
+	log.Printf("Order %s paused", orderID)
+	t.record(ctx, orderID, store.Entry{Kind: store.KindPaused})
+	t.changed(ctx, orderID)
+	return at, nil
+}
+
+// Resume restarts tracking of a paused order. Its ETA is calculated again
+// from the courier's next location.
+func (t *Tracker) Resume(ctx context.Context, orderID string) error {
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if order.PausedAt.IsZero() {
+		return nil
+	}
+	err = t.store.SetPausedAt(ctx, orderID, time.Time{})
+	if err != nil {
+		return err
+	}
+	log.Printf("Order %s resumed", orderID)
+	t.record(ctx, orderID, store.Entry{Kind: store.KindResumed})
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// SetDeadline records when an order was promised by, and compares its
+// latest ETA, if any, to it right away.
+func (t *Tracker) SetDeadline(ctx context.Context, orderID string, deadline time.Time) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetDeadline(ctx, orderID, deadline)
+	if err != nil {
+		return err
+	}
+	defer t.changed(ctx, orderID)
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if !order.ETAAt.IsZero() {
+		t.checkSLA(ctx, order, order.ETAAt.Add(order.ETA), order.ETAAt.Add(max(order.ETAHigh, order.ETA)))
+	}
+	return nil
+}
+
+// checkSLA compares the projected arrival of order, and the latest it may
+// arrive, with its deadline, and announces the order becoming at risk or
+// breached. An order stays breached once its deadline has passed.
+func (t *Tracker) checkSLA(ctx context.Context, order store.Order, arrival, latest time.Time) {
+	if order.Deadline.IsZero() {
+		return
+	}
+	state, lateness := store.SLAOnTrack, time.Duration(0)
+	switch {
+	case arrival.After(order.Deadline):
+		state, lateness = store.SLABreached, arrival.Sub(order.Deadline)
+	case time.Now().After(order.Deadline):
+		state, lateness = store.SLABreached, time.Since(order.Deadline)
+	case latest.After(order.Deadline):
+		state, lateness = store.SLAAtRisk, latest.Sub(order.Deadline)
+	}
+	if state == order.SLA || (state == store.SLAOnTrack && order.SLA == "") {
+		return
+	}
+
+	err := t.store.SetSLA(ctx, order.ID, state)
+	if err != nil {
+		log.Printf("failed to set SLA state of order %s: %v", order.ID, err)
+		return
+	}
+	t.record(ctx, order.ID, store.Entry{Kind: store.KindSLA, SLA: state})
+	if state == store.SLAOnTrack {
+		return
+	}
+	log.Printf("Order %s is %s, %v late", order.ID, state, lateness.Round(time.Second))
+	event := publish.EventSLAAtRisk
+	if state == store.SLABreached {
+		event = publish.EventSLABreached
+	}
+	deadline := order.Deadline
+	err = t.publish(ctx, publish.Event{Type: event, OrderID: order.ID, ETA: max(time.Until(arrival), 0), Deadline: &deadline, Lateness: lateness, Metadata: order.Metadata})
+	if err != nil {
+		log.Printf("failed to publish SLA state of order %s: %v", order.ID, err)
+	}
+}
+
+// checkDeadlines breaches every order whose deadline passed while nothing
+// updated its ETA, such as when its courier went silent.
+func (t *Tracker) checkDeadlines(ctx context.Context) error {
+	now := time.Now()
+	var late []store.Order
+	err := t.store.ForEachOrder(ctx, func(o store.Order) error {
+		if !o.Deadline.IsZero() && now.After(o.Deadline) && o.SLA != store.SLABreached && o.Delivery == nil && o.PausedAt.IsZero() {
+			late = append(late, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, o := range late {
+		arrival := now
+		if projected := o.ETAAt.Add(o.ETA); !o.ETAAt.IsZero() && projected.After(now) {
+			arrival = projected
+		}
+		t.checkSLA(ctx, o, arrival, arrival)
+	}
+	return nil
+}
+
+// etaWindow bounds eta given how much recent predictions drifted. Couriers
+// are held up more often than they are early, so the window reaches twice
+// as far past the ETA as before it.
+func (t *Tracker) etaWindow(eta, drift time.Duration) (low, high time.Duration) {
+	conf := t.runtime.Config().Tracking
+	margin := max(time.Duration(float64(eta)*conf.ETASpread), conf.ETAMinSpread.Duration, drift)
+	return max(eta-margin/2, 0), eta + margin
+}
+
+// NewOrder describes an order registered ahead of its delivery.
+type NewOrder struct {
+	ID       string
+	Target   geo.Point
+	Mode     string
+	Metadata map[string]string
+	// DepartAt plans the delivery ahead; see Schedule.
+	DepartAt time.Time
+	// Deadline is when the order was promised by.
+	Deadline time.Time
+	// HandlingTime is added to the order's routed trip.
+	HandlingTime time.Duration
+	// PrepTime is how long from now the pickup needs to get the order
+	// ready; see SetPrepTime.
+	PrepTime time.Duration
+	// Preferences say how the customer wants to hear about the order.
+	Preferences *store.Preferences
+}
+
+// CreateOrder creates an order with its target, mode, metadata and
+// notification preferences, and returns it, or store.ErrExists if it was
+// created before. Unless orders are created implicitly, locations of an
+// order are refused until it is created.
+func (t *Tracker) CreateOrder(ctx context.Context, o NewOrder) (store.Order, error) {
+	err := t.store.CreateOrder(ctx, o.ID, time.Now())
+	if err != nil {
+		return store.Order{}, err
+	}
+	err = t.RegisterOrder(ctx, o)
+	if err != nil {
+		return store.Order{}, err
+	}
+	return t.store.GetOrder(ctx, o.ID)
+}
+
+// RegisterOrder stores an order's target, mode and metadata without
+// calculating a travel time, since its courier is not on the way yet.
+func (t *Tracker) RegisterOrder(ctx context.Context, o NewOrder) error {
+	err := t.store.SetLocation(ctx, o.ID, store.Target, o.Target)
+	if err != nil {
+		return err
+	}
+	t.record(ctx, o.ID, store.Entry{Kind: store.Target, Point: &o.Target})
+	if o.Mode != "" {
+		err = t.SetMode(ctx, o.ID, o.Mode)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Metadata != nil {
+		err = t.store.SetMetadata(ctx, o.ID, o.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+	if !o.DepartAt.IsZero() {
+		err = t.store.SetDepartAt(ctx, o.ID, o.DepartAt)
+		if err != nil {
+			return err
+		}
+	}
+	if !o.Deadline.IsZero() {
+		err = t.store.SetDeadline(ctx, o.ID, o.Deadline)
+		if err != nil {
+			return err
+		}
+	}
+	if o.HandlingTime > 0 {
+		err = t.store.SetHandlingTime(ctx, o.ID, o.HandlingTime)
+		if err != nil {
+			return err
+		}
+	}
+	if o.PrepTime > 0 {
+		err = t.SetPrepTime(ctx, o.ID, o.PrepTime)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Preferences != nil {
+		err = t.SetPreferences(ctx, o.ID, *o.Preferences)
+		if err != nil {
+			return err
+		}
+	}
+	t.changed(ctx, o.ID)
+	return nil
+}
+
+// Schedule plans an order's delivery to leave its pickup, or wherever the
+// courier is, at departAt. Until then its ETA is predicted for that
+// departure time and refreshed by the Scheduler. It returns the predicted
+// ETA, or 0 if the order has no origin yet.
+func (t *Tracker) Schedule(ctx context.Context, orderID string, departAt time.Time) (time.Duration, error) {
+	if err := t.known(ctx, orderID); err != nil {
+		return 0, err
+	}
+	err := t.store.SetDepartAt(ctx, orderID, departAt)
+	if err != nil {
+		return 0, err
+	}
+	defer t.changed(ctx, orderID)
+	order, err := t.store.GetOrder(ctx, orderID)
+	if err != nil {
+		return 0, err
+	}
+	if !departAt.After(time.Now()) || (order.Pickup == nil && order.Current == nil) || order.Target == nil {
+		return 0, nil
+	}
+	return t.scheduledETA(ctx, order)
+}
+
+// scheduledETA predicts the ETA of a delivery planned ahead: the time until
+// it departs plus the trip from the pickup, or the courier, to the target
+// at the departure time.
+func (t *Tracker) scheduledETA(ctx context.Context, order store.Order) (time.Duration, error) {
+	origin := plannedOrigin(order)
+	if origin == nil || order.Target == nil {
+		return 0, fmt.Errorf("%w: order %s needs a pickup or courier location and a target to be planned", ErrIncomplete, order.ID)
+	}
+	mode := order.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+
+	// A warmed route is as good a prediction, and free
+	travelTime, ok := t.store.CachedTravelTime(ctx, warmKey(order.ID, *origin, *order.Target, mode))
+	if !ok {
+		var err error
+		travelTime, err = t.provider(t.runtime.Settings(), mode).TravelTime(routing.WithDepartureTime(ctx, order.DepartAt), *origin, *order.Target, mode)
+		t.computed(err)
+		if err != nil {
+			log.Println("failed to calculate travel time")
+			return 0, fmt.Errorf("failed to calculate travel time: %v", err)
+		}
+	}
+	now := time.Now()
+	eta := order.DepartAt.Sub(now) + travelTime + t.handlingTime(order, mode)
+	t.saveETA(ctx, order, eta, now)
+	return eta, nil
+}
+
+// plannedOrigin is where a planned delivery sets off from: its pickup, or
+// else the courier.
+func plannedOrigin(order store.Order) *geo.Point {
+	if order.Pickup != nil {
+		return order.Pickup
+	}
+	return order.Current
+}
+
+// warmGrace is how long after departure a warmed route is kept for the
+// first live update.
+const warmGrace = 15 * time.Minute
+
+// warmKey is the cache key of the route warmed for an order; it changes
+// with the trip, so a moved pickup or target is warmed anew.
+func warmKey(orderID string, origin, destination geo.Point, mode string) string {
+	return fmt.Sprintf("eta-warm:%s:%.4f,%.4f:%.4f,%.4f:%s", orderID, origin.Lat, origin.Lng, destination.Lat, destination.Lng, mode)
+}
+
+// warm calculates and keeps the route of a planned delivery for its
+// departure time, unless it is kept already.
+func (t *Tracker) warm(ctx context.Context, order store.Order) {
+	mode := order.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+	origin := *plannedOrigin(order)
+	key := warmKey(order.ID, origin, *order.Target, mode)
+	if _, ok := t.store.CachedTravelTime(ctx, key); ok {
+		return
+	}
+	travelTime, err := t.provider(t.runtime.Settings(), mode).TravelTime(routing.WithDepartureTime(ctx, order.DepartAt), origin, *order.Target, mode)
+	if err != nil {
+		log.Printf("failed to warm route of planned order %s: %v", order.ID, err)
+		return
+	}
+	err = t.store.CacheTravelTime(ctx, key, travelTime, time.Until(order.DepartAt)+warmGrace)
+	if err != nil {
+		log.Printf("failed to keep warmed route of planned order %s: %v", order.ID, err)
+	}
+}
+
+// warmed returns the route warmed for order if this is its first live
+// update and the courier set off from where the route was planned from:
+// within the pickup radius of the pickup, or where it was waiting.
+func (t *Tracker) warmed(ctx context.Context, order store.Order, mode string) (time.Duration, bool) {
+	if order.DepartAt.IsZero() || !order.ETAAt.Before(order.DepartAt) {
+		return 0, false
+	}
+	origin := *order.Current
+	if order.Pickup != nil {
+		if geo.Distance(origin, *order.Pickup) > t.runtime.Config().Tracking.PickupRadius {
+			return 0, false
+		}
+		origin = *order.Pickup
+	}
+	return t.store.CachedTravelTime(ctx, warmKey(order.ID, origin, *order.Target, mode))
+}
+
+// Scheduler refreshes the predicted ETAs of deliveries planned ahead until
+// ctx is done.
+func (t *Tracker) Scheduler(ctx context.Context) error {
+	for {
+		interval := t.runtime.Config().Tracking.ScheduleInterval.Duration
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		if !t.leads(ctx, "scheduler", interval) {
+			continue
+		}
+		if err := t.refreshScheduled(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("scheduler: %v", err)
+		}
+	}
+}
+
+// refreshScheduled recalculates the ETA of every planned delivery whose
+// prediction is due, and publishes those whose arrival moved by a minute or
+// more. Predictions far ahead barely change, so they are due after a quarter
+// of the time left until departure, which comes down to every
+// schedule_interval as departure nears. Within warm_lead of departure, and
+// off-peak, the route is warmed first.
+func (t *Tracker) refreshScheduled(ctx context.Context) error {
+	now := time.Now()
+	conf := t.runtime.Config().Tracking
+	interval := conf.ScheduleInterval.Duration
+	warming := conf.WarmLead.Duration > 0 && conf.OffPeakAt(now)
+	var due, warm []store.Order
+	err := t.store.ForEachOrder(ctx, func(o store.Order) error {
+		if !o.DepartAt.After(now) || o.Target == nil || (o.Pickup == nil && o.Current == nil) || o.Delivery != nil || !o.PausedAt.IsZero() {
+			return nil
+		}
+		if warming && o.DepartAt.Sub(now) <= conf.WarmLead.Duration {
+			warm = append(warm, o)
+		}
+		if o.ETAAt.IsZero() || now.Sub(o.ETAAt) >= max(interval, o.DepartAt.Sub(now)/4) {
+			due = append(due, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, o := range warm {
+		t.warm(ctx, o)
+	}
+
+	for _, o := range due {
+		eta, err := t.scheduledETA(ctx, o)
+		if err != nil {
+			log.Printf("failed to refresh planned order %s: %v", o.ID, err)
+			continue
+		}
+		t.changed(ctx, o.ID)
+		moved := time.Now().Add(eta).Sub(o.ETAAt.Add(o.ETA))
+		if o.ETAAt.IsZero() || moved >= time.Minute || moved <= -time.Minute {
+			err = t.PublishTravelTime(ctx, o.ID, eta)
+			if err != nil {
+				log.Printf("failed to publish planned order %s: %v", o.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// advancePhase moves order on to its next phase for a location update of
+// kind to p: a pickup starts the pickup phase, which ends when the courier
+// gets there.
+func (t *Tracker) advancePhase(ctx context.Context, order *store.Order, kind string, p geo.Point) {
+	switch {
+	case kind == store.Pickup && order.Phase == "":
+		order.Phase = store.PhasePickup
+		t.changePhase(ctx, *order)
+	case kind == store.Current && order.Phase == store.PhasePickup && order.Pickup != nil &&
+		geo.Distance(p, *order.Pickup) <= t.runtime.Config().Tracking.PickupRadius:
+		order.Phase = store.PhaseDropoff
+		t.changePhase(ctx, *order)
+	}
+}
+
+// changePhase stores the phase of order and announces it. Failing to store
+// it is logged only: the next update retries the transition.
+func (t *Tracker) changePhase(ctx context.Context, order store.Order) {
+	err := t.store.SetPhase(ctx, order.ID, order.Phase)
+	if err != nil {
+		log.Printf("failed to set phase of order %s: %v", order.ID, err)
+		return
+	}
+	log.Printf("Order %s entered its %s phase", order.ID, order.Phase)
+	t.record(ctx, order.ID, store.Entry{Kind: store.KindPhase, Phase: order.Phase})
+	err = t.publish(ctx, publish.Event{Type: publish.EventPhase, OrderID: order.ID, ETA: order.ETA, Phase: order.Phase, Metadata: order.Metadata})
+	if err != nil {
+		log.Printf("failed to publish phase of order %s: %v", order.ID, err)
+	}
+}
+
+// SetGeofences replaces an order's own geofences. The courier's position is
+// checked against them from its next update on.
+func (t *Tracker) SetGeofences(ctx context.Context, orderID string, fences []geo.Fence) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetGeofences(ctx, orderID, fences)
+	if err != nil {
+		return err
+	}
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// checkDwell follows where the courier at p has stayed, and announces
+// once per stop that it has stayed longer than dwell_after mid-route, as
+// when broken down. Waiting at the pickup or target is not dwelling.
+func (t *Tracker) checkDwell(ctx context.Context, order store.Order, p geo.Point) {
+	conf := t.runtime.Config().Tracking
+	if conf.DwellAfter.Duration <= 0 || order.Target == nil {
+		return
+	}
+	now := time.Now()
+	stop := order.Stop
+	if stop == nil || geo.Distance(p, stop.Point) > conf.DwellRadius {
+		// On the move; any stop starts here
+		if err := t.store.SetStop(ctx, order.ID, store.Stop{Point: p, Since: now}); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	dwell := now.Sub(stop.Since)
+	if stop.Reported || dwell < conf.DwellAfter.Duration {
+		return
+	}
+	for _, place := range []*geo.Point{order.Pickup, order.Target} {
+		if place != nil && geo.Distance(stop.Point, *place) <= conf.PickupRadius {
+			return
+		}
+	}
+
+	stop.Reported = true
+	if err := t.store.SetStop(ctx, order.ID, *stop); err != nil {
+		log.Println(err)
+		return
+	}
+	t.record(ctx, order.ID, store.Entry{Kind: store.KindDwell, Point: &stop.Point})
+	err := t.publish(ctx, publish.Event{Type: publish.EventDwell, OrderID: order.ID, ETA: order.ETA, Location: &stop.Point, Dwell: dwell, Metadata: order.Metadata})
+	if err != nil {
+		log.Printf("failed to publish dwell of order %s: %v", order.ID, err)
+	}
+}
+
+// checkGeofences compares the geofences the courier at p is inside with
+// those it was inside before, and announces every fence entered or left.
+// Global fences come first; an order's own fence of the same name wins.
+func (t *Tracker) checkGeofences(ctx context.Context, order store.Order, p geo.Point) {
+	fences := map[string]geo.Fence{}
+	for _, f := range t.runtime.Config().Tracking.Geofences {
+		fences[f.Name] = f
+	}
+	for _, f := range order.Geofences {
+		fences[f.Name] = f
+	}
+
+	was := make(map[string]bool, len(order.Inside))
+	for _, name := range order.Inside {
+		was[name] = true
+	}
+	var inside []string
+	changed := false
+	for name, f := range fences {
+		in := f.Contains(p)
+		if in {
+			inside = append(inside, name)
+		}
+		if in != was[name] {
+			changed = true
+		}
+	}
+	for name := range was {
+		if _, ok := fences[name]; !ok {
+			// The fence was removed; leaving it is not worth announcing
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	sort.Strings(inside)
+	err := t.store.SetInside(ctx, order.ID, inside)
+	if err != nil {
+		// Without the new state, the next update would repeat the events
+		log.Printf("failed to store geofences of order %s: %v", order.ID, err)
+		return
+	}
+	announce := func(kind, event, name string) {
+		log.Printf("Order %s: %s %s", order.ID, event, name)
+		t.record(ctx, order.ID, store.Entry{Kind: kind, Point: &p, Geofence: name})
+		err := t.publish(ctx, publish.Event{Type: event, OrderID: order.ID, ETA: order.ETA, Geofence: name, Metadata: order.Metadata})
+		if err != nil {
+			log.Printf("failed to publish geofence event for order %s: %v", order.ID, err)
+		}
+	}
+	for _, name := range order.Inside {
+		if _, ok := fences[name]; ok && !fences[name].Contains(p) {
+			announce(store.KindGeofenceOut, publish.EventGeofenceExit, name)
+		}
+	}
+	for _, name := range inside {
+		if !was[name] {
+			announce(store.KindGeofenceIn, publish.EventGeofenceEnter, name)
+		}
+	}
+}
+
+// SetAlerts replaces an order's proximity alerts.
+func (t *Tracker) SetAlerts(ctx context.Context, orderID string, alerts []store.Alert) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetAlerts(ctx, orderID, alerts)
+	if err != nil {
+		return err
+	}
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// SetPreferences replaces an order's notification preferences. Their
+// thresholds become the order's alerts, so that notifiers hear about them.
+func (t *Tracker) SetPreferences(ctx context.Context, orderID string, p store.Preferences) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetPreferences(ctx, orderID, p)
+	if err != nil {
+		return err
+	}
+	defer t.changed(ctx, orderID)
+	if p.Thresholds != nil {
+		return t.store.SetAlerts(ctx, orderID, p.Thresholds)
+	}
+	return nil
+}
+
+// checkAlerts announces every alert of order that the courier has come
+// within, given its latest ETA, and that has not fired before. The store
+// decides which caller gets to announce an alert, so retried or concurrent
+// updates do not announce it twice.
+func (t *Tracker) checkAlerts(ctx context.Context, order store.Order, eta time.Duration) {
+	if len(order.Alerts) == 0 || order.Current == nil || order.Target == nil {
+		return
+	}
+	fired := make(map[string]bool, len(order.Fired))
+	for _, key := range order.Fired {
+		fired[key] = true
+	}
+	distance := geo.Distance(*order.Current, *order.Target)
+	for _, a := range order.Alerts {
+		key := a.Key()
+		if fired[key] || !(a.Within > 0 && eta <= a.Within || a.Distance > 0 && distance <= a.Distance) {
+			continue
+		}
+		marked, err := t.store.MarkAlertFired(ctx, order.ID, key, time.Now())
+		if err != nil {
+			log.Printf("failed to mark alert %s of order %s: %v", key, order.ID, err)
+			continue
+		}
+		if !marked {
+			continue
+		}
+		log.Printf("Order %s: courier within %s", order.ID, key)
+		t.record(ctx, order.ID, store.Entry{Kind: store.KindAlert, ETA: eta, Alert: key})
+		err = t.publish(ctx, publish.Event{Type: publish.EventProximity, OrderID: order.ID, ETA: eta, Alert: key, Metadata: order.Metadata})
+		if err != nil {
+			log.Printf("failed to publish alert %s of order %s: %v", key, order.ID, err)
+		}
+	}
+}
+
+// DriverUpdate is the outcome of a driver position report for the orders
+// the driver carries.
+type DriverUpdate struct {
+	DriverID string                   `json:"driver_id"`
+	ETAs     map[string]time.Duration `json:"etas"`
+	// Sequence is the order in which a driver with several orders should
+	// drop them off; the ETAs follow it.
+	Sequence []string `json:"sequence,omitempty"`
+	// Failed lists orders whose travel time could not be recalculated.
+	Failed []string `json:"failed,omitempty"`
+	// Degraded lists orders whose ETA could not be routed within the
+	// latency budget, and was estimated instead.
+	Degraded []string `json:"degraded,omitempty"`
+	// OffDuty is set when the position was not kept, the driver carrying
+	// no order or being outside its shift.
+	OffDuty bool `json:"off_duty,omitempty"`
+}
+
+// UpdateDriverLocation records a driver's position and moves every order it
+// carries along with it. An order that fails does not hold up the others.
+// Outliers are rejected with ErrInaccurate or ErrTeleport, as by
+// UpdateCurrent, before they reach the stored state.
+func (t *Tracker) UpdateDriverLocation(ctx context.Context, driverID string, p geo.Point, tel store.Telemetry) (DriverUpdate, error) {
+	conf := t.runtime.Config().Tracking
+	if conf.MaxAccuracy > 0 && tel.Accuracy > conf.MaxAccuracy {
+		t.inaccurate.Add(1)
+		log.Printf("Rejected location of driver %s: accuracy %.0fm", driverID, tel.Accuracy)
+		return DriverUpdate{}, ErrInaccurate
+	}
+	driver, err := t.store.GetDriver(ctx, driverID)
+	if err != nil {
+		return DriverUpdate{}, err
+	}
+	if conf.MaxSpeed > 0 {
+		prev := store.Order{Current: driver.Position, SeenAt: driver.SeenAt}
+		if speed, ok := impliedSpeed(prev, p, tel); ok && speed > conf.MaxSpeed {
+			t.teleports.Add(1)
+			log.Printf("Rejected location of driver %s: implied speed %.0fm/s", driverID, speed)
+			return DriverUpdate{}, ErrTeleport
+		}
+	}
+	if t.offDuty(ctx, driver) {
+		if driver.Position != nil {
+			err = t.store.ClearDriverPosition(ctx, driverID)
+		}
+		return DriverUpdate{DriverID: driverID, ETAs: map[string]time.Duration{}, OffDuty: true}, err
+	}
+	err = t.store.SetDriverPosition(ctx, driverID, p)
+	if err != nil {
+		return DriverUpdate{}, err
+	}
+	// Only orders under way are billed for the distance, and take on the
+	// telemetry of the driver's device, such as when it took the fix
+	carried, err := t.store.GetOrders(ctx, driver.Orders)
+	if err != nil {
+		log.Printf("failed to get orders of driver %s: %v", driverID, err)
+	}
+	for _, order := range carried {
+		if order.Delivery != nil || !order.PausedAt.IsZero() {
+			continue
+		}
+		if err := t.store.SetTelemetry(ctx, order.ID, tel); err != nil {
+			log.Printf("failed to set telemetry of order %s: %v", order.ID, err)
+		}
+		t.travelled(ctx, order.ID, driver.Position, p)
+	}
+
+	update := DriverUpdate{DriverID: driverID, ETAs: make(map[string]time.Duration, len(driver.Orders))}
+	if len(driver.Orders) > 1 {
+		t.updateRoute(ctx, &update, driver.Orders, p)
+		return update, nil
+	}
+	for _, orderID := range driver.Orders {
+		travelTime, err := t.UpdateLocation(ctx, orderID, store.Current, p)
+		if errors.Is(err, ErrPaused) || errors.Is(err, ErrIncomplete) && t.runtime.Config().Tracking.Lenient {
+			continue
+		}
+		if errors.Is(err, ErrDegraded) {
+			update.ETAs[orderID] = travelTime
+			update.Degraded = append(update.Degraded, orderID)
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to update order %s of driver %s: %v", orderID, driverID, err)
+			update.Failed = append(update.Failed, orderID)
+			continue
+		}
+		update.ETAs[orderID] = travelTime
+	}
+	return update, nil
+}
+
+// updateRoute moves the orders of a multi-drop driver to p and calculates
+// their ETAs along one sequence of stops, so that each ETA includes the
+// pickups and drop-offs before it rather than assuming a direct trip.
+// Orders still to be collected are picked up before they are dropped off.
+func (t *Tracker) updateRoute(ctx context.Context, update *DriverUpdate, orderIDs []string, p geo.Point) {
+	settings := t.runtime.Settings()
+	fail := func(orderID string, err error) {
+		log.Printf("failed to update order %s of driver %s: %v", orderID, update.DriverID, err)
+		update.Failed = append(update.Failed, orderID)
+	}
+
+	var orders []store.Order
+	recent := true
+	for _, id := range orderIDs {
+		err := t.store.SetLocation(ctx, id, store.Current, p)
+		if err != nil {
+			fail(id, err)
+			continue
+		}
+		defer t.changed(ctx, id)
+		t.record(ctx, id, store.Entry{Kind: store.Current, Point: &p})
+
+		order, err := t.store.GetOrder(ctx, id)
+		if err != nil {
+			fail(id, err)
+			continue
+		}
+		if order.Delivery != nil || !order.PausedAt.IsZero() {
+			// Dropped off already, in which case the driver's orders are
+			// out of date, or on hold
+			continue
+		}
+		t.advancePhase(ctx, &order, store.Current, p)
+		t.checkGeofences(ctx, order, p)
+		if order.Target == nil {
+			fail(id, fmt.Errorf("order %s has no target location", id))
+			continue
+		}
+		mode := order.Mode
+		if mode == "" {
+			mode = DefaultMode
+		}
+		if interval := t.debounceInterval(settings, mode); interval == 0 || order.ETAAt.IsZero() || time.Since(order.ETAAt) > interval {
+			recent = false
+		}
+		orders = append(orders, order)
+	}
+
+	// Skip recalculating when the whole route was worked out moments ago
+	if recent {
+		for _, order := range orders {
+			update.ETAs[order.ID] = max(order.ETA-time.Since(order.ETAAt), 0)
+			t.checkAlerts(ctx, order, update.ETAs[order.ID])
+		}
+		return
+	}
+
+	// Orders not yet collected are picked up before they are dropped off
+	type stop struct {
+		order  int
+		pickup bool
+	}
+	var (
+		stops  []stop
+		points []geo.Point
+		prior  []int
+	)
+	for i, order := range orders {
+		first := -1
+		if order.Phase == store.PhasePickup && order.Pickup != nil {
+			first = len(stops)
+			stops = append(stops, stop{order: i, pickup: true})
+			points = append(points, *order.Pickup)
+			prior = append(prior, -1)
+		}
+		stops = append(stops, stop{order: i})
+		points = append(points, *order.Target)
+		prior = append(prior, first)
+	}
+	sequence := routing.SequenceAfter(p, points, prior)
+	for _, order := range orders {
+		ctx = t.urgency(ctx, order)
+	}
+	from, elapsed := p, time.Duration(0)
+	for n, i := range sequence {
+		order := orders[stops[i].order]
+		mode := order.Mode
+		if mode == "" {
+			mode = DefaultMode
+		}
+		leg, err := t.provider(settings, mode).TravelTime(ctx, from, points[i], mode)
+		t.computed(err)
+		if err != nil {
+			// Every later stop depends on this leg
+			for _, j := range sequence[n:] {
+				if !stops[j].pickup {
+					fail(orders[stops[j].order].ID, fmt.Errorf("failed to calculate travel time: %v", err))
+				}
+			}
+			return
+		}
+		from = points[i]
+		if stops[i].pickup {
+			elapsed += leg
+			if err := t.store.SavePickupETA(ctx, order.ID, elapsed); err != nil {
+				log.Println(err)
+			}
+			// The courier waits at the pickup until the order is ready
+			if !order.ReadyAt.IsZero() {
+				elapsed = max(elapsed, time.Until(order.ReadyAt))
+			}
+			continue
+		}
+		// Handling one drop holds up the later ones
+		elapsed += leg + t.handlingTime(order, mode)
+
+		t.saveETA(ctx, order, elapsed, time.Now())
+		t.checkAlerts(ctx, order, elapsed)
+		update.ETAs[order.ID] = elapsed
+		update.Sequence = append(update.Sequence, order.ID)
+	}
+}
+
+// SaveDriver registers a driver or changes its status, orders and
+// metadata. Drivers left off duty are forgotten where they were.
+func (t *Tracker) SaveDriver(ctx context.Context, d store.Driver) error {
+	err := t.store.SaveDriver(ctx, d)
+	if err != nil {
+		return err
+	}
+	for _, orderID := range d.Orders {
+		err = t.store.SetOrderDriver(ctx, orderID, d.ID)
+		if err != nil {
+			return err
+		}
+		t.changed(ctx, orderID)
+	}
+	if t.offDuty(ctx, d) {
+		return t.store.ClearDriverPosition(ctx, d.ID)
+	}
+	return nil
+}
+
+// offDuty reports whether, with off-duty privacy on, the driver's position
+// is not to be kept: it carries no undelivered order, or is outside the
+// shift in its metadata. Drivers whose orders cannot be read are taken to
+// be on duty, so as not to lose track of deliveries.
+func (t *Tracker) offDuty(ctx context.Context, d store.Driver) bool {
+	conf := t.runtime.Config().Privacy
+	if !conf.OffDuty {
+		return false
+	}
+	if shift := d.Metadata[conf.ShiftKey]; shift != "" {
+		if on, err := config.InWindow(shift, time.Now()); err == nil && !on {
+			return true
+		}
+	}
+	orders, err := t.store.GetOrders(ctx, d.Orders)
+	if err != nil {
+		log.Printf("failed to get orders of driver %s: %v", d.ID, err)
+		return false
+	}
+	for _, o := range orders {
+		if o.Delivery == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// onDuty returns the drivers among drivers that are not off duty.
+func (t *Tracker) onDuty(ctx context.Context, drivers []store.Driver) []store.Driver {
+	kept := drivers[:0]
+	for _, d := range drivers {
+		if !t.offDuty(ctx, d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// travelled adds the distance from the courier's previous location, if
+// any, to p to the distance travelled with the order. Billing tolerates the
+// rare lost increment better than failing the location update over it.
+func (t *Tracker) travelled(ctx context.Context, orderID string, from *geo.Point, p geo.Point) {
+	if from == nil {
+		return
+	}
+	if err := t.store.AddDistance(ctx, orderID, geo.Distance(*from, p)); err != nil {
+		log.Printf("failed to add distance to order %s: %v", orderID, err)
+	}
+}
+
+// Driver returns a registered driver, without a position while off duty.
+func (t *Tracker) Driver(ctx context.Context, driverID string) (store.Driver, error) {
+	d, err := t.store.GetDriver(ctx, driverID)
+	if err == nil && d.Position != nil && t.offDuty(ctx, d) {
+		d.Position, d.SeenAt = nil, time.Time{}
+	}
+	return d, err
+}
+
+// provider returns the route provider for mode: the one configured for the
+// mode, or else the tenant's chain or the one selected in the runtime
+// settings, wrapped in the travel time cache when caching is enabled, run
+// on the worker pool if there is one, and shared between nearby orders
+// when coalescing is enabled.
+func (t *Tracker) provider(settings config.Settings, mode string) routing.Provider {
+	conf := t.runtime.Config()
+	modeConf := conf.Maps.Modes[mode]
+	var p routing.Provider = t.providers[routing.Google]
+	if m, ok := t.base(settings.Provider); ok {
+		p = m
+	}
+	if names := conf.Tenancy.Tenants[t.tenant].Providers; t.tenant != "" && len(names) > 0 {
+		var chain routing.Chain
+		for _, name := range names {
+			if m, ok := t.base(name); ok {
+				chain = append(chain, m)
+			}
+		}
+		p = chain
+	}
+	if m, ok := t.base(modeConf.Provider); ok {
+		p = m
+	}
+	if ttl := modeConf.CacheTTL.Duration; ttl > 0 {
+		p = routing.Cached{Next: p, Cache: t.travelTimes(), TTL: ttl}
+	} else if settings.Caching {
+		p = routing.Cached{Next: p, Cache: t.travelTimes(), TTL: conf.Cache.TTL.Duration}
+	}
+	if t.pool != nil {
+		p = t.pool.Wrap(p)
+	}
+	if window := conf.Cache.CoalesceWindow.Duration; window > 0 {
+		p = t.coalescer.Wrap(p, window, conf.Cache.CoalesceRadius)
+	}
+	return p
+}
+
+// metered returns the provider routes of mode are asked of, without the
+// caching and sharing of provider: the one configured for the mode, or else
+// the one selected in the runtime settings.
+func (t *Tracker) metered(settings config.Settings, mode string) (*routing.Metered, bool) {
+	name := settings.Provider
+	if p := t.runtime.Config().Maps.Modes[mode].Provider; p != "" {
+		name = p
+	}
+	m, ok := t.providers[name]
+	return m, ok
+}
+
+// base returns the named provider, with haversine estimates corrected by
+// the traffic profiles when they are enabled.
+func (t *Tracker) base(name string) (routing.Provider, bool) {
+	m, ok := t.providers[name]
+	if !ok {
+		return nil, false
+	}
+	if conf := t.runtime.Config().Maps.TrafficProfiles; name == routing.Haversine && conf.Enabled {
+		return routing.Profiled{Next: m, Profiles: t.store, Precision: conf.Precision, MinObserved: conf.MinObserved.Duration}, true
+	}
+	return m, true
+}
+
+// urgency marks ctx urgent for the worker pool if order is flagged high
+// priority in its metadata or is about to arrive.
+func (t *Tracker) urgency(ctx context.Context, order store.Order) context.Context {
+	conf := t.runtime.Config().Workers
+	remaining := max(order.ETA-time.Since(order.ETAAt), 0)
+	if strings.EqualFold(order.Metadata[conf.PriorityKey], "high") || (!order.ETAAt.IsZero() && remaining <= conf.UrgentWithin.Duration) {
+		return routing.WithUrgency(ctx)
+	}
+	return ctx
+}
+
+// handlingTime is what is added to the routed trip of order by mode: the
+// mode's or configured handling time, and the order's own.
+func (t *Tracker) handlingTime(order store.Order, mode string) time.Duration {
+	conf := t.runtime.Config()
+	d := conf.Tracking.HandlingTime.Duration
+	if m := conf.Maps.Modes[mode].HandlingTime.Duration; m > 0 {
+		d = m
+	}
+	return d + order.HandlingTime
+}
+
+// debounceInterval is how long after a recalculation the ETA of an order
+// travelling by mode is counted down instead, or 0 to always recalculate.
+func (t *Tracker) debounceInterval(settings config.Settings, mode string) time.Duration {
+	conf := t.runtime.Config()
+	if interval := conf.Maps.Modes[mode].RecomputeInterval.Duration; interval > 0 {
+		return interval
+	}
+	if !settings.Debouncing {
+		return 0
+	}
+	return conf.Cache.DebounceInterval.Duration
+}
+
+// Usage returns today's call counts of every provider.
+func (t *Tracker) Usage() map[string]routing.Usage {
+	usage := make(map[string]routing.Usage, len(t.providers))
+	for name, m := range t.providers {
+		usage[name] = m.Usage()
+	}
+	return usage
+}
+
+// Order returns the stored state of an order.
+func (t *Tracker) Order(ctx context.Context, orderID string) (store.Order, error) {
+	return t.store.GetOrder(ctx, orderID)
+}
+
+// Orders returns the orders among ids that exist, in the order of ids.
+func (t *Tracker) Orders(ctx context.Context, ids []string) ([]store.Order, error) {
+	return t.store.GetOrders(ctx, ids)
+}
+
+// Watch subscribes to changes of an order made through this tracker or,
+// while relaying, any other instance. See publish.Hub for the delivery
+// guarantees.
+func (t *Tracker) Watch(orderID string) (<-chan struct{}, func()) {
+	return t.hub.Subscribe(orderID)
+}
+
+// Relay makes Watch see the changes made by the other instances sharing b,
+// and them the changes made here, until ctx is done.
+func (t *Tracker) Relay(ctx context.Context, b publish.Backplane) error {
+	return t.hub.Relay(ctx, b)
+}
+
+// Close ends every Watch subscription.
+func (t *Tracker) Close() {
+	t.hub.Close()
+}
+
+// SetMode records the travel mode used for an order's future calculations.
+// Like locations, it and the other setters of orders return
+// store.ErrNotFound for orders never created, unless created implicitly.
+func (t *Tracker) SetMode(ctx context.Context, orderID, mode string) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetMode(ctx, orderID, mode)
+	if err != nil {
+		return err
+	}
+	t.record(ctx, orderID, store.Entry{Kind: store.KindMode, Mode: mode})
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// SetHandlingTime sets how much is added to an order's routed trip on top
+// of the configured handling time.
+func (t *Tracker) SetHandlingTime(ctx context.Context, orderID string, d time.Duration) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetHandlingTime(ctx, orderID, d)
+	if err != nil {
+		return err
+	}
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// SetPrepTime records that the pickup needs prep from now to get an order
+// ready. Couriers arriving earlier wait, which counts towards the ETA.
+func (t *Tracker) SetPrepTime(ctx context.Context, orderID string, prep time.Duration) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetReadyAt(ctx, orderID, time.Now().Add(prep))
+	if err != nil {
+		return err
+	}
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// SetMetadata replaces the free-form data attached to an order, which is
+// echoed in its events from then on.
+func (t *Tracker) SetMetadata(ctx context.Context, orderID string, metadata map[string]string) error {
+	if err := t.known(ctx, orderID); err != nil {
+		return err
+	}
+	err := t.store.SetMetadata(ctx, orderID, metadata)
+	if err != nil {
+		return err
+	}
+	t.changed(ctx, orderID)
+	return nil
+}
+
+// ForEachOrder calls fn for every stored order.
+func (t *Tracker) ForEachOrder(ctx context.Context, fn func(store.Order) error) error {
+	return t.store.ForEachOrder(ctx, fn)
+}
+
+// Fleet returns the undelivered orders and the drivers on duty last seen
+// inside the box.
+func (t *Tracker) Fleet(ctx context.Context, box geo.Box) ([]store.Order, []store.Driver, error) {
+	orders, err := t.store.OrdersWithin(ctx, box)
+	if err != nil {
+		return nil, nil, err
+	}
+	drivers, err := t.store.DriversWithin(ctx, box)
+	if err != nil {
+		return nil, nil, err
+	}
+	return orders, t.onDuty(ctx, drivers), nil
+}
+
+// OrderQuery filters orders in a search. Zero fields match everything.
+type OrderQuery struct {
+	// Near and Radius, in meters, match orders going near a point.
+	Near   *geo.Point
+	Radius float64
+	// Within matches orders whose courier is inside the polygon.
+	Within []geo.Point
+	Mode   string
+	Status string
+}
+
+// SearchOrders returns the orders matching q, sorted by ID. Geographic
+// filters are answered from the store's index; the rest are applied to
+// what it returns, or to every order without one.
+func (t *Tracker) SearchOrders(ctx context.Context, q OrderQuery) ([]store.Order, error) {
+	staleAfter := t.StaleAfter()
+	area := geo.Fence{Polygon: q.Within}
+	match := func(o store.Order) bool {
+		return (q.Near == nil || (o.Target != nil && geo.Distance(*q.Near, *o.Target) <= q.Radius)) &&
+			(len(q.Within) == 0 || (o.Current != nil && area.Contains(*o.Current))) &&
+			(q.Mode == "" || o.Mode == q.Mode) &&
+			(q.Status == "" || o.Status(staleAfter) == q.Status)
+	}
+
+	var candidates []store.Order
+	var err error
+	switch {
+	case q.Near != nil:
+		candidates, err = t.store.TargetsNear(ctx, *q.Near, q.Radius)
+	case len(q.Within) > 0:
+		candidates, err = t.store.OrdersWithin(ctx, geo.Bounds(q.Within))
+	default:
+		err = t.store.ForEachOrder(ctx, func(o store.Order) error {
+			candidates = append(candidates, o)
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	orders := []store.Order{}
+	for _, o := range candidates {
+		if match(o) {
+			orders = append(orders, o)
+		}
+	}
+	return orders, nil
+}
+
+// DeleteOrder forgets an order and its history.
+func (t *Tracker) DeleteOrder(ctx context.Context, orderID string) error {
+	err := t.store.DeleteOrder(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted order %s", orderID)
+	t.hub.Notify(orderID)
+	return nil
+}
+
+// History returns the recorded changes to an order, oldest first.
+func (t *Tracker) History(ctx context.Context, orderID string) ([]store.Entry, error) {
+	return t.store.History(ctx, orderID)
+}
+
+// record appends a change to the order's history, attributed to the caller
+// in ctx. The history is informational, so failing to write it is not an
+// error for the change itself.
+func (t *Tracker) record(ctx context.Context, orderID string, e store.Entry) {
+	e.Time = time.Now()
+	if p, ok := auth.FromContext(ctx); ok {
+		e.Actor = p.Subject
+	}
+	if err := t.store.AppendHistory(ctx, orderID, e); err != nil {
+		log.Printf("failed to record history of order %s: %v", orderID, err)
+	}
+}
+
+// PublishTravelTime sends an order's travel time to downstream consumers.
+func (t *Tracker) PublishTravelTime(ctx context.Context, orderID string, travelTime time.Duration) error {
+	e := publish.Event{Type: publish.EventETA, OrderID: orderID, ETA: travelTime}
+	if order, err := t.store.GetOrder(ctx, orderID); err == nil {
+		e.Arrived = t.Arrived(order)
+		e.ETALow, e.ETAHigh = Window(order, travelTime)
+		if order.Phase == store.PhasePickup {
+			e.PickupETA, e.DropoffETA = Legs(order, travelTime)
+		}
+		e.Weather = order.Weather
+		e.Cost = order.Cost
+		e.Metadata = order.Metadata
+		if order.Current != nil && order.Target != nil {
+			e.Distance = geo.Distance(*order.Current, *order.Target)
+		}
+	}
+	return t.publish(ctx, e)
+}
+
+// publish numbers e in the sequence of its order and sends it downstream.
+// changed counts a change to an order that publishes no event, so that
+// reads kept of its earlier state are not served again, and wakes its
+// watchers.
+func (t *Tracker) changed(ctx context.Context, orderID string) {
+	if err := t.store.Touch(ctx, orderID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("failed to count change of order %s: %v", orderID, err)
+	}
+	t.hub.Notify(orderID)
+}
+
+func (t *Tracker) publish(ctx context.Context, e publish.Event) error {
+	seq, err := t.store.NextSequence(ctx, e.OrderID)
+	switch {
+	case err == nil:
+		e.ID, e.Sequence = fmt.Sprintf("%s:%d", e.OrderID, seq), seq
+		// A new sequence is a change, to watchers and caches alike
+		t.hub.Notify(e.OrderID)
+	case !errors.Is(err, store.ErrNotFound):
+		return err
+	}
+	err = t.publisher.Publish(ctx, e)
+	if err != nil {
+		return err
+	}
+	t.published(ctx)
+	return nil
+}
+
+// Arrived reports whether the courier of order has reached the target: the
+// order was delivered, or the courier is past any pickup and within the
+// arrival radius of the target. A zero ETA alone does not tell, as routes
+// between nearby points may take no time at all.
+func (t *Tracker) Arrived(order store.Order) bool {
+	if order.Delivery != nil {
+		return true
+	}
+	if order.Current == nil || order.Target == nil || order.Phase == store.PhasePickup {
+		return false
+	}
+	return geo.Distance(*order.Current, *order.Target) <= t.runtime.Config().Tracking.ArrivalRadius
+}
+
+// Window returns the bounds of eta, a travel time of order that may have
+// counted down from the one last saved, as when debounced. It returns zeros
+// for orders without a saved window.
+func Window(order store.Order, eta time.Duration) (low, high time.Duration) {
+	if order.ETAHigh == 0 {
+		return 0, 0
+	}
+	shift := order.ETA - eta
+	return max(order.ETALow-shift, 0), max(order.ETAHigh-shift, 0)
+}
+
+// Legs splits eta, a travel time of order that may have counted down from
+// the one last saved, into the trip to the pickup and the rest: waiting
+// there for the order to be ready and going on to the target. Orders past
+// their pickup phase are all the way to the target.
+func Legs(order store.Order, eta time.Duration) (pickup, dropoff time.Duration) {
+	if order.Phase != store.PhasePickup {
+		return 0, eta
+	}
+	pickup = min(max(order.PickupETA-(order.ETA-eta), 0), eta)
+	return pickup, eta - pickup
+}
+
+// StaleAfter is how long a courier may go without reporting before its
+// order's ETA is stale.
+func (t *Tracker) StaleAfter() time.Duration {
+	return t.runtime.Config().Tracking.StaleAfter.Duration
+}
+
+// Watchdog checks for orders whose courier went silent, or whose deadline
+// passed, until ctx is done.
+func (t *Tracker) Watchdog(ctx context.Context) error {
+	for {
+		interval := t.runtime.Config().Tracking.WatchdogInterval.Duration
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		if !t.leads(ctx, "watchdog", interval) {
+			continue
+		}
+		if err := t.checkTracking(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("tracking watchdog: %v", err)
+		}
+		if err := t.checkDeadlines(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("deadline watchdog: %v", err)
+		}
+	}
+}
+
+// checkTracking reports tracking lost, once, for every order whose courier
+// has been silent for longer than StaleAfter, and notes the longest silence
+// of the orders under way.
+func (t *Tracker) checkTracking(ctx context.Context) error {
+	after := t.StaleAfter()
+	var lost []store.Order
+	var oldest time.Time
+	err := t.store.ForEachOrder(ctx, func(o store.Order) error {
+		if o.Stale(after) && o.LostAt.IsZero() {
+			lost = append(lost, o)
+		}
+		if seen, ok := activeSince(o, after); ok && (oldest.IsZero() || seen.Before(oldest)) {
+			oldest = seen
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if oldest.IsZero() {
+		t.oldestSeen.Store(0)
+	} else {
+		t.oldestSeen.Store(oldest.UnixNano())
+	}
+
+	for _, o := range lost {
+		marked, err := t.store.MarkTrackingLost(ctx, o.ID, time.Now())
+		if err != nil || !marked {
+			// Deleted, reported by another instance, or failing; the next
+			// round tries again if it still matters
+			continue
+		}
+		log.Printf("Lost tracking of order %s, last seen %v", o.ID, o.SeenAt)
+		t.record(ctx, o.ID, store.Entry{Kind: store.KindTrackingLost})
+		t.changed(ctx, o.ID)
+		seenAt := o.SeenAt
+		err = t.publish(ctx, publish.Event{Type: publish.EventTrackingLost, OrderID: o.ID, ETA: o.ETA, SeenAt: &seenAt, Metadata: o.Metadata})
+		if err != nil {
+			log.Printf("failed to publish tracking lost for order %s: %v", o.ID, err)
+		}
+	}
+	return nil
+}
+
+// leaseIntervals is how many intervals of a background job its lock lasts
+// without being extended, and so how long the other instances wait to take
+// over from one that stopped.
+const leaseIntervals = 3
+
+// leads reports whether this instance is the one to run the job, of those
+// sharing the store. Stores of a single instance need no lock.
+func (t *Tracker) leads(ctx context.Context, job string, interval time.Duration) bool {
+	l, ok := t.store.(store.Locker)
+	if !ok {
+		return true
+	}
+	held, err := l.Lock(ctx, job, t.owner, leaseIntervals*interval)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("%s: %v", job, err)
+		}
+		return false
+	}
+	return held
+}
@@ -0,0 +1,516 @@
+package tracking
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"location/internal/config"
+	"location/internal/geo"
+	"location/internal/publish"
+	"location/internal/routing"
+	"location/internal/store"
+	"location/internal/weather"
+)
+
+func TestCheckTrackingReportsLossOnce(t *testing.T) {
+	conf := config.Default()
+	conf.Tracking.StaleAfter = config.Duration{Duration: time.Millisecond}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	events := &publish.Capture{}
+	tracker := New(st, map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}, events, rt)
+	ctx := context.Background()
+	tracker.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1, Lng: 1}})
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 2, Lng: 2})
+	time.Sleep(5 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.checkTracking(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := events.Events()
+	if len(got) != 1 || got[0].Type != publish.EventTrackingLost || got[0].OrderID != "o1" || got[0].SeenAt == nil {
+		t.Fatalf("events = %+v", got)
+	}
+	if order, _ := st.GetOrder(ctx, "o1"); order.LostAt.IsZero() {
+		t.Error("order not marked lost")
+	}
+
+	// A new location resumes tracking, so a later silence is reported again
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 3, Lng: 3})
+	if order, _ := st.GetOrder(ctx, "o1"); !order.LostAt.IsZero() {
+		t.Error("new location did not clear the loss")
+	}
+	time.Sleep(5 * time.Millisecond)
+	tracker.checkTracking(ctx)
+	if n := len(events.Events()); n != 2 {
+		t.Errorf("got %d events after second silence, want 2", n)
+	}
+}
+
+func TestEstimatePosition(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := New(store.NewMemory(), nil, &publish.Capture{}, rt)
+	speed := 10.0
+	now := time.Now()
+	order := store.Order{
+		Current:   &geo.Point{Lat: 1.31, Lng: 103.80},
+		Target:    &geo.Point{Lat: 1.30, Lng: 103.80},
+		SeenAt:    now.Add(-30 * time.Second),
+		Telemetry: &store.Telemetry{Speed: &speed},
+	}
+
+	p, estimated := tracker.EstimatePosition(order, now)
+	if !estimated {
+		t.Fatal("no estimate")
+	}
+	if moved := geo.Distance(*order.Current, p); moved < 299 || moved > 301 {
+		t.Errorf("moved %.0fm, want 300m", moved)
+	}
+	if p.Lat >= 1.31 || p.Lng != 103.80 {
+		t.Errorf("estimate %v is not on the way to the target", p)
+	}
+
+	// Without a speed, the ETA sets the pace; and nobody overshoots
+	order.Telemetry = nil
+	order.ETA = 10 * time.Second
+	if p, _ := tracker.EstimatePosition(order, now); p != *order.Target {
+		t.Errorf("estimate %v overshot the target", p)
+	}
+}
+
+func TestRefreshScheduledPublishesChanges(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	events := &publish.Capture{}
+	provider := &routing.Scripted{Default: 10 * time.Minute}
+	tracker := New(st, map[string]routing.Provider{routing.Google: provider}, events, rt)
+	ctx := context.Background()
+	st.SetLocation(ctx, "o1", store.Target, geo.Point{Lat: 1, Lng: 1})
+	st.SetLocation(ctx, "o1", store.Pickup, geo.Point{Lat: 2, Lng: 2})
+	st.SetDepartAt(ctx, "o1", time.Now().Add(30*time.Minute))
+
+	// Never predicted: due, and published
+	if err := tracker.refreshScheduled(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(events.Events()); n != 1 {
+		t.Fatalf("got %d events, want 1", n)
+	}
+
+	// Just predicted: not due yet
+	tracker.refreshScheduled(ctx)
+	if n := len(provider.Calls()); n != 1 {
+		t.Errorf("got %d provider calls, want 1", n)
+	}
+
+	// Due again, with traffic predicted to be worse
+	order, _ := st.GetOrder(ctx, "o1")
+	st.SaveETA(ctx, "o1", order.ETA, order.ETAAt.Add(-10*time.Minute))
+	provider.Queue(routing.ScriptedResult{TravelTime: 25 * time.Minute})
+	tracker.refreshScheduled(ctx)
+	got := events.Events()
+	if len(got) != 2 || got[1].ETA < 50*time.Minute {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+func TestWarmedRouteAnswersFirstLiveUpdate(t *testing.T) {
+	conf := config.Default()
+	conf.Tracking.WarmLead = config.Duration{Duration: 30 * time.Minute}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	provider := &routing.Scripted{Default: 10 * time.Minute}
+	tracker := New(st, map[string]routing.Provider{routing.Google: provider}, &publish.Capture{}, rt)
+	ctx := context.Background()
+	pickup := geo.Point{Lat: 2, Lng: 2}
+	st.SetLocation(ctx, "o1", store.Target, geo.Point{Lat: 1, Lng: 1})
+	st.SetLocation(ctx, "o1", store.Pickup, pickup)
+	st.SetDepartAt(ctx, "o1", time.Now().Add(20*time.Minute))
+
+	// Warming and predicting the ETA take one call between them
+	provider.Queue(routing.ScriptedResult{TravelTime: 12 * time.Minute})
+	if err := tracker.refreshScheduled(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if calls := provider.Calls(); len(calls) != 1 || calls[0].Departure.IsZero() {
+		t.Fatalf("calls = %+v", calls)
+	}
+
+	// The courier sets off from the pickup, after the last prediction
+	order, _ := st.GetOrder(ctx, "o1")
+	st.SaveETA(ctx, "o1", order.ETA, time.Now().Add(-2*time.Minute))
+	st.SetDepartAt(ctx, "o1", time.Now().Add(-time.Minute))
+	eta, err := tracker.UpdateLocation(ctx, "o1", store.Current, pickup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eta != 12*time.Minute || len(provider.Calls()) != 1 {
+		t.Errorf("got %v after %d calls, want the warmed 12m", eta, len(provider.Calls()))
+	}
+
+	// Later updates are calculated live
+	eta, _ = tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.5, Lng: 1.5})
+	if eta != 10*time.Minute || len(provider.Calls()) != 2 {
+		t.Errorf("got %v after %d calls, want a live 10m", eta, len(provider.Calls()))
+	}
+}
+
+func TestStationaryCourierIsNotRouted(t *testing.T) {
+	conf := config.Default()
+	conf.Tracking.StationaryRadius = 30
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	provider := &routing.Scripted{Default: 10 * time.Minute}
+	tracker := New(st, map[string]routing.Provider{routing.Google: provider}, &publish.Capture{}, rt)
+	ctx := context.Background()
+	tracker.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1, Lng: 1}})
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.1, Lng: 1.1})
+
+	// About 15m away: parked, so counted down
+	eta, err := tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.1001, Lng: 1.1001})
+	if err != nil || eta > 10*time.Minute || len(provider.Calls()) != 1 {
+		t.Errorf("parked courier got %v, %v after %d calls", eta, err, len(provider.Calls()))
+	}
+
+	// Moving on, or a new target, routes again
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.11, Lng: 1.11})
+	if n := len(provider.Calls()); n != 2 {
+		t.Errorf("moving courier made %d calls, want 2", n)
+	}
+	tracker.UpdateLocation(ctx, "o1", store.Target, geo.Point{Lat: 1.2, Lng: 1.2})
+	if n := len(provider.Calls()); n != 3 {
+		t.Errorf("new target made %d calls, want 3", n)
+	}
+}
+
+func TestDwellReportedOncePerStop(t *testing.T) {
+	conf := config.Default()
+	conf.Tracking.DwellAfter = config.Duration{Duration: 10 * time.Minute}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	events := &publish.Capture{}
+	tracker := New(st, map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}, events, rt)
+	ctx := context.Background()
+	dwells := func() (n int) {
+		for _, e := range events.Events() {
+			if e.Type == publish.EventDwell {
+				n++
+			}
+		}
+		return n
+	}
+	stopped := geo.Point{Lat: 1.1, Lng: 1.1}
+	tracker.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1, Lng: 1}})
+	tracker.UpdateLocation(ctx, "o1", store.Current, stopped)
+	if n := dwells(); n != 0 {
+		t.Fatalf("got %d dwell events on arrival at the stop", n)
+	}
+
+	// A quarter hour later the courier is still there, give or take GPS
+	st.SetStop(ctx, "o1", store.Stop{Point: stopped, Since: time.Now().Add(-15 * time.Minute)})
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.1001, Lng: 1.1})
+	tracker.UpdateLocation(ctx, "o1", store.Current, stopped)
+	got := events.Events()
+	if n := dwells(); n != 1 {
+		t.Fatalf("got %d dwell events, want 1: %+v", n, got)
+	}
+	for _, e := range got {
+		if e.Type == publish.EventDwell && (*e.Location != stopped || e.Dwell < 15*time.Minute) {
+			t.Errorf("dwell event = %+v", e)
+		}
+	}
+
+	// Waiting that long at the target is not dwelling
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1, Lng: 1})
+	st.SetStop(ctx, "o1", store.Stop{Point: geo.Point{Lat: 1, Lng: 1}, Since: time.Now().Add(-15 * time.Minute)})
+	tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1, Lng: 1})
+	if n := dwells(); n != 1 {
+		t.Errorf("got %d dwell events after waiting at the target", n)
+	}
+}
+
+func TestCourierMovesTeachTrafficProfiles(t *testing.T) {
+	conf := config.Default()
+	conf.Maps.TrafficProfiles.Enabled = true
+	conf.Tracking.MaxSpeed = 0
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	tracker := New(st, map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}, &publish.Capture{}, rt)
+	ctx := context.Background()
+	from := geo.Point{Lat: 1.30, Lng: 103.80}
+	tracker.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1.40, Lng: 103.90}})
+	tracker.UpdateCurrent(ctx, "o1", from, store.Telemetry{})
+	tracker.UpdateCurrent(ctx, "o1", geo.Point{Lat: 1.31, Lng: 103.81}, store.Telemetry{})
+
+	meters, elapsed := st.Observed(ctx, geo.Geohash(from, 5), DefaultMode, routing.HourOfWeek(time.Now()))
+	if meters < 1000 || elapsed <= 0 {
+		t.Errorf("observed %.0fm in %v", meters, elapsed)
+	}
+}
+
+func TestCheckDeadlinesBreachesOnce(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	events := &publish.Capture{}
+	tracker := New(st, nil, events, rt)
+	ctx := context.Background()
+	st.SetLocation(ctx, "o1", store.Target, geo.Point{Lat: 1, Lng: 1})
+	st.SetDeadline(ctx, "o1", time.Now().Add(-5*time.Minute))
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.checkDeadlines(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := events.Events()
+	if len(got) != 1 || got[0].Type != publish.EventSLABreached || got[0].Lateness < 5*time.Minute {
+		t.Errorf("events = %+v", got)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	conf := config.Default()
+	conf.Tracking.Geofences = []geo.Fence{{Name: "central", Center: &geo.Point{Lat: 1.30, Lng: 103.80}, Radius: 1000}}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	tracker := New(st, nil, &publish.Capture{}, rt)
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Two trips on the first day, predicted 2m and 6m late, and one on the
+	// third day outside any zone, predicted on time
+	trips := []struct {
+		id       string
+		mode     string
+		target   geo.Point
+		start    time.Time
+		took     time.Duration
+		distance float64
+		late     time.Duration
+	}{
+		{"o1", "", geo.Point{Lat: 1.30, Lng: 103.80}, day.Add(9 * time.Hour), 20 * time.Minute, 2000, 2 * time.Minute},
+		{"o2", "bicycling", geo.Point{Lat: 1.30, Lng: 103.80}, day.Add(10 * time.Hour), 30 * time.Minute, 5000, 6 * time.Minute},
+		{"o3", "", geo.Point{Lat: 1.40, Lng: 103.90}, day.Add(50 * time.Hour), 40 * time.Minute, 4000, 0},
+	}
+	for _, trip := range trips {
+		st.SetLocation(ctx, trip.id, store.Target, trip.target)
+		if trip.mode != "" {
+			st.SetMode(ctx, trip.id, trip.mode)
+		}
+		st.AddDistance(ctx, trip.id, trip.distance)
+		st.AppendHistory(ctx, trip.id, store.Entry{Time: trip.start, Kind: store.Current})
+		st.AppendHistory(ctx, trip.id, store.Entry{Time: trip.start, Kind: store.KindETA, ETA: trip.took - trip.late})
+		st.MarkDelivered(ctx, trip.id, store.Delivery{At: trip.start.Add(trip.took)})
+	}
+
+	a, err := tracker.Analyze(ctx, day, day.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Days) != 3 || a.Days[0].Delivered != 2 || a.Days[1].Delivered != 0 || a.Days[2].Delivered != 1 {
+		t.Errorf("days = %+v", a.Days)
+	}
+	if a.ETAError.Samples != 3 || a.ETAError.Mean != 8*time.Minute/3 || a.ETAError.P95 != 6*time.Minute {
+		t.Errorf("eta error = %+v", a.ETAError)
+	}
+	want := []TripStats{
+		{Name: "bicycling", Trips: 1, Distance: 5000, Duration: 30 * time.Minute},
+		{Name: DefaultMode, Trips: 2, Distance: 3000, Duration: 30 * time.Minute},
+	}
+	if len(a.Modes) != 2 || a.Modes[0] != want[0] || a.Modes[1] != want[1] {
+		t.Errorf("modes = %+v", a.Modes)
+	}
+	if len(a.Zones) != 2 || a.Zones[0].Name != "" || a.Zones[0].Trips != 1 || a.Zones[1].Name != "central" || a.Zones[1].Duration != 25*time.Minute {
+		t.Errorf("zones = %+v", a.Zones)
+	}
+}
+
+func TestWeatherStretchesETA(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	tracker := New(st, map[string]routing.Provider{routing.Google: &routing.Scripted{Default: 10 * time.Minute}}, &publish.Capture{}, rt)
+	tracker.UseWeather(weather.Fixed(weather.Rain))
+	ctx := context.Background()
+	st.SetMode(ctx, "o1", "bicycling")
+	st.SetLocation(ctx, "o1", store.Target, geo.Point{Lat: 1, Lng: 1})
+
+	eta, err := tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 2, Lng: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order, _ := st.GetOrder(ctx, "o1"); eta != 12*time.Minute+30*time.Second || order.Weather != weather.Rain {
+		t.Errorf("in rain: eta %v, weather %q", eta, order.Weather)
+	}
+
+	// Clearing up drops both the adjustment and the flag
+	tracker.UseWeather(weather.Fixed(weather.Clear))
+	eta, _ = tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 2, Lng: 2})
+	if order, _ := st.GetOrder(ctx, "o1"); eta != 10*time.Minute || order.Weather != "" {
+		t.Errorf("when clear: eta %v, weather %q", eta, order.Weather)
+	}
+}
+
+func TestTenantBudgetFallsThroughChain(t *testing.T) {
+	conf := config.Default()
+	conf.Tenancy.Tenants = map[string]config.TenantConfig{
+		"acme": {DailyQuota: 2, Providers: []string{routing.Google, routing.Haversine}},
+	}
+	rt, err := config.NewRuntime(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	google := &routing.Scripted{Default: time.Minute}
+	providers := map[string]routing.Provider{routing.Google: google, routing.Haversine: routing.HaversineEstimate{}}
+	acme := New(store.NewMemory(), providers, &publish.Capture{}, rt)
+	acme.UseTenant("acme")
+	other := New(store.NewMemory(), providers, &publish.Capture{}, rt)
+
+	ctx := context.Background()
+	for _, tracker := range []*Tracker{acme, other} {
+		tracker.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1.30, Lng: 103.80}})
+		for i := 0; i < 3; i++ {
+			if _, err := tracker.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 1.35, Lng: 103.85}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if u := acme.Usage(); u[routing.Google].Calls != 2 || u[routing.Haversine].Calls != 1 {
+		t.Errorf("acme usage = %+v, want 2 Google calls and then Haversine", u)
+	}
+	if u := other.Usage(); u[routing.Google].Calls != 3 {
+		t.Errorf("other usage = %+v, want every call on Google", u)
+	}
+}
+
+// loopback is a backplane delivering every message to all subscribers.
+type loopback struct {
+	mu   sync.Mutex
+	subs []func(string)
+}
+
+func (l *loopback) PublishChange(ctx context.Context, message string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, fn := range l.subs {
+		fn(message)
+	}
+	return nil
+}
+
+func (l *loopback) SubscribeChanges(ctx context.Context, fn func(string)) error {
+	l.mu.Lock()
+	l.subs = append(l.subs, fn)
+	l.mu.Unlock()
+	<-ctx.Done()
+	return nil
+}
+
+func TestRelayWakesOtherInstances(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := store.NewMemory()
+	providers := map[string]routing.Provider{routing.Google: &routing.Scripted{Default: time.Minute}}
+	a := New(st, providers, &publish.Capture{}, rt)
+	b := New(st, providers, &publish.Capture{}, rt)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backplane := &loopback{}
+	go a.Relay(ctx, backplane)
+	go b.Relay(ctx, backplane)
+	for {
+		backplane.mu.Lock()
+		n := len(backplane.subs)
+		backplane.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	changes, stop := b.Watch("o1")
+	defer stop()
+	a.CreateOrder(ctx, NewOrder{ID: "o1", Target: geo.Point{Lat: 1, Lng: 1}})
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("change made on one instance did not reach the other")
+	}
+
+	own, stopOwn := a.Watch("o1")
+	defer stopOwn()
+	a.UpdateLocation(ctx, "o1", store.Current, geo.Point{Lat: 2, Lng: 2})
+	<-own
+	select {
+	case <-own:
+		t.Error("instance woken again by its own relayed change")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// sharedStore hands its one lock to the first owner asking for it.
+type sharedStore struct {
+	*store.Memory
+	holder string
+}
+
+func (s *sharedStore) Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	if s.holder == "" {
+		s.holder = owner
+	}
+	return s.holder == owner, nil
+}
+
+func TestOneInstanceLeadsJobs(t *testing.T) {
+	rt, err := config.NewRuntime(config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &sharedStore{Memory: store.NewMemory()}
+	a := New(st, nil, &publish.Capture{}, rt)
+	b := New(st, nil, &publish.Capture{}, rt)
+	ctx := context.Background()
+
+	if !a.leads(ctx, "watchdog", time.Minute) || b.leads(ctx, "watchdog", time.Minute) || !a.leads(ctx, "watchdog", time.Minute) {
+		t.Error("the lock did not stay with the first instance")
+	}
+	if !New(store.NewMemory(), nil, &publish.Capture{}, rt).leads(ctx, "watchdog", time.Minute) {
+		t.Error("an instance of its own did not lead")
+	}
+}
@@ -0,0 +1,77 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"location/internal/egress"
+	"location/internal/geo"
+)
+
+// OpenWeather asks the OpenWeatherMap current weather API for conditions.
+// The API key is looked up on every call so it can be rotated without a
+// restart.
+type OpenWeather struct {
+	apiKey  func() string
+	baseURL string
+	client  *http.Client
+}
+
+func NewOpenWeatherMap(apiKey func() string) *OpenWeather {
+	return &OpenWeather{
+		apiKey:  apiKey,
+		baseURL: "https://api.openweathermap.org/data/2.5/weather",
+		client:  egress.Client(egress.Weather, 5*time.Second),
+	}
+}
+
+func (o *OpenWeather) Conditions(ctx context.Context, p geo.Point) (string, error) {
+	key := o.apiKey()
+	if key == "" {
+		return "", fmt.Errorf("no OpenWeatherMap API key configured")
+	}
+	query := url.Values{
+		"lat":   {strconv.FormatFloat(p.Lat, 'f', -1, 64)},
+		"lon":   {strconv.FormatFloat(p.Lng, 'f', -1, 64)},
+		"appid": {key},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get weather: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get weather: %s", resp.Status)
+	}
+
+	var body struct {
+		Weather []struct {
+			Main string `json:"main"`
+		} `json:"weather"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode weather: %v", err)
+	}
+	// Several conditions may be reported at once; the worst one counts
+	conditions := Clear
+	for _, w := range body.Weather {
+		switch w.Main {
+		case "Snow":
+			conditions = Snow
+		case "Rain", "Drizzle", "Thunderstorm":
+			if conditions != Snow {
+				conditions = Rain
+			}
+		}
+	}
+	return conditions, nil
+}
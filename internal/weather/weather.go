@@ -0,0 +1,88 @@
+// Package weather looks up the conditions couriers are travelling in, so
+// that ETAs can allow for rain and snow.
+package weather
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"location/internal/geo"
+)
+
+// Conditions that affect travel times.
+const (
+	Clear = "clear"
+	Rain  = "rain"
+	Snow  = "snow"
+)
+
+// Provider reports the current conditions at a point.
+type Provider interface {
+	Conditions(ctx context.Context, p geo.Point) (string, error)
+}
+
+// Provider names accepted in configuration.
+const OpenWeatherMap = "openweathermap"
+
+// Known reports whether name is a supported provider.
+func Known(name string) bool {
+	return name == OpenWeatherMap
+}
+
+// Fixed reports the same conditions everywhere. It stands in for a real
+// provider in tests and local development.
+type Fixed string
+
+func (f Fixed) Conditions(ctx context.Context, p geo.Point) (string, error) {
+	return string(f), nil
+}
+
+// cellPrecision is the geohash length of the areas sharing cached
+// conditions, about 5km across.
+const cellPrecision = 5
+
+// Cached remembers the conditions in each area for TTL, since they change
+// slowly and every courier update would otherwise ask for them.
+type Cached struct {
+	Next Provider
+	TTL  time.Duration
+
+	mu    sync.Mutex
+	cells map[string]cached
+}
+
+type cached struct {
+	conditions string
+	expires    time.Time
+}
+
+func (c *Cached) Conditions(ctx context.Context, p geo.Point) (string, error) {
+	cell := geo.Geohash(p, cellPrecision)
+	c.mu.Lock()
+	if e, ok := c.cells[cell]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.conditions, nil
+	}
+	c.mu.Unlock()
+
+	conditions, err := c.Next.Conditions(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cells == nil {
+		c.cells = map[string]cached{}
+	}
+	// Drop expired areas so couriers crossing the country do not grow the
+	// cache forever
+	now := time.Now()
+	for k, e := range c.cells {
+		if now.After(e.expires) {
+			delete(c.cells, k)
+		}
+	}
+	c.cells[cell] = cached{conditions: conditions, expires: now.Add(c.TTL)}
+	return conditions, nil
+}
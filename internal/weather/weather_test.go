@@ -0,0 +1,37 @@
+package weather
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"location/internal/geo"
+)
+
+func TestOpenWeatherCached(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Query().Get("appid") != "key" || r.URL.Query().Get("lat") != "1.3" {
+			t.Errorf("query = %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`{"weather":[{"main":"Clouds"},{"main":"Drizzle"}]}`))
+	}))
+	defer srv.Close()
+	ow := NewOpenWeatherMap(func() string { return "key" })
+	ow.baseURL = srv.URL
+	c := &Cached{Next: ow, TTL: time.Minute}
+
+	// Couriers a few hundred meters apart share the lookup
+	for _, p := range []geo.Point{{Lat: 1.3, Lng: 103.8}, {Lat: 1.302, Lng: 103.801}} {
+		conditions, err := c.Conditions(context.Background(), p)
+		if err != nil || conditions != Rain {
+			t.Errorf("conditions = %q, %v", conditions, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+}